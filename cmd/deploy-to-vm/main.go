@@ -1,18 +1,25 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"log"
 	"os"
 
 	"deploy-to-vm/internal/config"
+	"deploy-to-vm/internal/docker"
+	"deploy-to-vm/internal/drift"
 	file_utils "deploy-to-vm/internal/file-utils"
 	deploy_to_vm_github "deploy-to-vm/internal/github"
+	"deploy-to-vm/internal/healthcheck"
+	"deploy-to-vm/internal/hooks"
 	"deploy-to-vm/internal/nginx"
 	"deploy-to-vm/internal/notification"
 	"deploy-to-vm/internal/pm2"
 	"deploy-to-vm/internal/router"
+	"deploy-to-vm/internal/systemd"
+	"deploy-to-vm/internal/target"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -30,6 +37,12 @@ func startServer(r *gin.Engine) error {
 func main() {
 	// set the log entry prefix
 	log.SetPrefix("[deploy-to-vm] ")
+
+	// Run a command instead of the server
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		os.Exit(verifyCommand(os.Args[2:]))
+	}
+
 	log.Println("Starting deploy-to-vm server...")
 
 	// Define command line flags
@@ -66,6 +79,11 @@ func main() {
 		log.Fatalf("Error creating assets directory: \"%v\"", err)
 	}
 
+	// Remove releases left half-prepared by an interrupted deployment
+	if cleanErr := file_utils.CleanStagingDirs(assetsDir); cleanErr != nil {
+		log.Fatalf("Error cleaning staging directories: \"%v\"", cleanErr)
+	}
+
 	// Create github client
 	githubClient, err := deploy_to_vm_github.SetupGithubClient()
 	if err != nil {
@@ -78,6 +96,18 @@ func main() {
 	// Create pm2 client
 	pm2Client := pm2.NewPm2Client(nil)
 
+	// Create docker client
+	dockerClient := docker.NewDockerClient(nil)
+
+	// Create systemd client
+	systemdClient := systemd.NewSystemdClient(nil)
+
+	// Create health check client
+	healthCheckClient := healthcheck.NewHealthCheckClient(nil)
+
+	// Create hook client
+	hookClient := hooks.NewHookClient(nil)
+
 	// Read secret token from environment variable
 	secretToken := os.Getenv("DEPLOY_TO_VM_SECRET_TOKEN")
 	if secretToken == "" {
@@ -92,12 +122,25 @@ func main() {
 		AssetsDir:          assetsDir,
 		ConfigClient:       configClient,
 		GithubClient:       githubClient,
-		NginxClient:        nginxClient,
 		NotificationClient: notificationClient,
-		Pm2Client:          pm2Client,
 		SecretToken:        secretToken,
+		Targets: target.NewDefaultRegistry(target.Clients{
+			Docker:      dockerClient,
+			Nginx:       nginxClient,
+			Pm2:         pm2Client,
+			Systemd:     systemdClient,
+			HealthCheck: healthCheckClient,
+		}),
+		HealthCheckClient: healthCheckClient,
+		HookClient:        hookClient,
 	})
 
+	// Check the site directories for drift in the background
+	driftChecker := drift.NewChecker(assetsDir, configClient, notificationClient)
+	if driftErr := driftChecker.Start(context.Background()); driftErr != nil {
+		log.Fatalf("Error starting drift checks: \"%v\"", driftErr)
+	}
+
 	// Start the server
 	if err := startServer(r); err != nil {
 		log.Fatalf("Error starting server: \"%v\"", err)
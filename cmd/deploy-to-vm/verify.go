@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"deploy-to-vm/internal/config"
+	"deploy-to-vm/internal/drift"
+	file_utils "deploy-to-vm/internal/file-utils"
+	"deploy-to-vm/internal/manifest"
+
+	"github.com/joho/godotenv"
+)
+
+// Loads the environment and the config the same way the server does and runs
+// the "verify" command
+func verifyCommand(args []string) int {
+	godotenv.Load()
+
+	configClient := &config.ConfigClient{}
+	if loadConfigErr := configClient.LoadConfig(); loadConfigErr != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: \"%v\"\n", loadConfigErr)
+		return 2
+	}
+
+	assetsDir := os.Getenv("DEPLOY_TO_VM_ASSETS_DIR")
+	if assetsDir == "" {
+		fmt.Fprintln(os.Stderr, "Environment variable DEPLOY_TO_VM_ASSETS_DIR is not set")
+		return 2
+	}
+
+	return runVerify(args, assetsDir, configClient, os.Stdout)
+}
+
+// Prints the differences of a verification, returning true if there are none
+func printVerifyResult(out io.Writer, title string, result *manifest.VerifyResult) bool {
+	if result.IsClean() {
+		fmt.Fprintf(out, "%s: OK\n", title)
+		return true
+	}
+
+	fmt.Fprintf(out, "%s: DRIFTED\n", title)
+	for _, file := range result.Modified {
+		fmt.Fprintf(out, "  modified: %s\n", file)
+	}
+	for _, file := range result.Added {
+		fmt.Fprintf(out, "  added:    %s\n", file)
+	}
+	for _, file := range result.Missing {
+		fmt.Fprintf(out, "  missing:  %s\n", file)
+	}
+	return false
+}
+
+// Verifies a release directory and the site directories of the targets of its
+// repository against the release manifest. It returns true if all match.
+func verifyRelease(out io.Writer, assetsDir string, repositoryConfig *config.DeployToVmConfigRepository, tag string) (bool, error) {
+	releaseDir := file_utils.ReleaseDirPath(assetsDir, repositoryConfig.Owner, repositoryConfig.Name, tag)
+	releaseManifest, readErr := manifest.Read(releaseDir)
+	if readErr != nil {
+		if errors.Is(readErr, os.ErrNotExist) {
+			return false, fmt.Errorf("Release manifest not found: %s", manifest.Path(releaseDir))
+		}
+		return false, readErr
+	}
+
+	releaseResult, verifyReleaseErr := releaseManifest.VerifyRelease(releaseDir)
+	if verifyReleaseErr != nil {
+		return false, verifyReleaseErr
+	}
+	clean := printVerifyResult(out, "Release "+releaseDir, releaseResult)
+
+	sites, verifySitesErr := drift.VerifySites(releaseManifest, repositoryConfig)
+	if verifySitesErr != nil {
+		return false, verifySitesErr
+	}
+	for _, site := range sites {
+		clean = printVerifyResult(out, "Site "+site.Dir, site.Site) && clean
+	}
+
+	return clean, nil
+}
+
+// Runs the "verify" command, which verifies release directories and the site
+// directories of their repositories against the release manifests. Without a
+// tag the active release is verified, and without a repository every
+// repository in the config is. It returns the exit code: 0 if everything
+// matches, 1 on drift and 2 on errors.
+func runVerify(args []string, assetsDir string, configClient config.ConfigClientInterface, out io.Writer) int {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	flags.SetOutput(out)
+	flags.Usage = func() {
+		fmt.Fprintln(out, "Usage: deploy-to-vm verify [<owner>/<repo> [<tag>]]")
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() > 2 {
+		flags.Usage()
+		return 2
+	}
+
+	// Select the repositories to verify
+	repositories := make([]*config.DeployToVmConfigRepository, 0)
+	if flags.NArg() == 0 {
+		if cfg := configClient.GetConfig(); cfg != nil {
+			for i := range cfg.Repositories {
+				repositories = append(repositories, &cfg.Repositories[i])
+			}
+		}
+	} else {
+		owner, repo, found := strings.Cut(flags.Arg(0), "/")
+		if !found || owner == "" || repo == "" {
+			flags.Usage()
+			return 2
+		}
+		repositoryConfig := configClient.GetRepository(repo, owner)
+		if repositoryConfig == nil {
+			fmt.Fprintf(out, "Repository not found in config: %s/%s\n", owner, repo)
+			return 2
+		}
+		repositories = append(repositories, repositoryConfig)
+	}
+
+	code := 0
+	for _, repositoryConfig := range repositories {
+		tag := flags.Arg(1)
+		if tag == "" {
+			activeTag, activeErr := file_utils.GetActiveRelease(assetsDir, repositoryConfig.Owner, repositoryConfig.Name)
+			if activeErr != nil {
+				fmt.Fprintf(out, "%v\n", activeErr)
+				code = 2
+				continue
+			}
+			if activeTag == "" {
+				fmt.Fprintf(out, "No active release for %s/%s\n", repositoryConfig.Owner, repositoryConfig.Name)
+				continue
+			}
+			tag = activeTag
+		}
+
+		clean, verifyErr := verifyRelease(out, assetsDir, repositoryConfig, tag)
+		if verifyErr != nil {
+			fmt.Fprintf(out, "%v\n", verifyErr)
+			code = 2
+			continue
+		}
+		if !clean && code == 0 {
+			code = 1
+		}
+	}
+	return code
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"deploy-to-vm/internal/config"
+	file_utils "deploy-to-vm/internal/file-utils"
+	"deploy-to-vm/internal/manifest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper to deploy a release with a manifest and return the config client
+func setupVerifyTest(t *testing.T) (string, string, *config.ConfigClient) {
+	assetsDir := t.TempDir()
+	siteDir := t.TempDir()
+
+	releaseDir := file_utils.ReleaseDirPath(assetsDir, "owner", "repo", "v1")
+	os.MkdirAll(releaseDir, 0755)
+	os.WriteFile(path.Join(releaseDir, "index.html"), []byte("index"), 0644)
+	files, _ := manifest.HashFiles(releaseDir)
+	manifest.Write(releaseDir, &manifest.Manifest{Version: manifest.Version, Tag: "v1", Files: files, CreatedAt: time.Now()})
+	file_utils.LinkReleaseAssetsToSiteDir(releaseDir, siteDir)
+
+	configClient := &config.ConfigClient{Config: &config.DeployToVmConfig{
+		Repositories: []config.DeployToVmConfigRepository{
+			{Name: "repo", Owner: "owner", TargetDir: siteDir},
+		},
+	}}
+	return assetsDir, siteDir, configClient
+}
+
+func TestRunVerify_Clean(t *testing.T) {
+	// Arrange: deploy a release
+	assetsDir, _, configClient := setupVerifyTest(t)
+	out := &bytes.Buffer{}
+
+	// Act: verify the release
+	code := runVerify([]string{"owner/repo", "v1"}, assetsDir, configClient, out)
+
+	// Assert: check if no drift is reported
+	assert.Equal(t, 0, code)
+	assert.NotContains(t, out.String(), "DRIFTED")
+}
+
+func TestRunVerify_Drift(t *testing.T) {
+	// Arrange: deploy a release and hotfix the site directory
+	assetsDir, siteDir, configClient := setupVerifyTest(t)
+	os.Remove(path.Join(siteDir, "index.html"))
+	os.WriteFile(path.Join(siteDir, "index.html"), []byte("hotfix"), 0644)
+	out := &bytes.Buffer{}
+
+	// Act: verify the release
+	code := runVerify([]string{"owner/repo", "v1"}, assetsDir, configClient, out)
+
+	// Assert: check if the modified file is reported
+	assert.Equal(t, 1, code)
+	assert.Contains(t, out.String(), "modified: index.html")
+}
+
+func TestRunVerify_InvalidArgs(t *testing.T) {
+	// Arrange: create an empty config
+	_, _, configClient := setupVerifyTest(t)
+	out := &bytes.Buffer{}
+
+	// Act: verify a repository without an owner
+	code := runVerify([]string{"repo"}, t.TempDir(), configClient, out)
+
+	// Assert: check if the usage is printed
+	assert.Equal(t, 2, code)
+	assert.Contains(t, out.String(), "Usage: deploy-to-vm verify [<owner>/<repo> [<tag>]]")
+}
+
+func TestRunVerify_ActiveRelease(t *testing.T) {
+	// Arrange: deploy a release and mark it as active
+	assetsDir, _, configClient := setupVerifyTest(t)
+	assert.NoError(t, file_utils.SetActiveRelease(assetsDir, "owner", "repo", "v1"))
+	out := &bytes.Buffer{}
+
+	// Act: verify every repository without a tag
+	code := runVerify([]string{}, assetsDir, configClient, out)
+
+	// Assert: check if the active release is verified
+	assert.Equal(t, 0, code)
+	assert.Contains(t, out.String(), "v1: OK")
+}
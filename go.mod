@@ -3,13 +3,18 @@ module deploy-to-vm
 go 1.24.2
 
 require (
+	github.com/ProtonMail/go-crypto v1.3.0
 	github.com/gin-gonic/gin v1.7.4
 	github.com/google/go-github/v71 v71.0.0
 	github.com/joho/godotenv v1.5.1
+	github.com/klauspost/compress v1.17.11
 	github.com/stretchr/testify v1.4.0
+	github.com/ulikunitz/xz v0.5.17
+	golang.org/x/crypto v0.33.0
 )
 
 require (
+	github.com/cloudflare/circl v1.6.1 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/gin-contrib/sse v0.1.0 // indirect
 	github.com/go-playground/locales v0.13.0 // indirect
@@ -24,7 +29,6 @@ require (
 	github.com/modern-go/reflect2 v0.0.0-20180701023420-4b7aa43c6742 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/ugorji/go/codec v1.1.7 // indirect
-	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9 // indirect
-	golang.org/x/sys v0.0.0-20200116001909-b77594299b42 // indirect
+	golang.org/x/sys v0.30.0 // indirect
 	gopkg.in/yaml.v2 v2.2.8 // indirect
 )
@@ -0,0 +1,109 @@
+package bluegreen
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"deploy-to-vm/internal/config"
+)
+
+const (
+	Color_Blue  = "blue"
+	Color_Green = "green"
+)
+
+// ActiveColorFileName is the name of the file in the directory of a
+// blue/green target that holds the color receiving the traffic
+const ActiveColorFileName = ".active-color"
+
+// Returns the color that does not receive the traffic. Blue is the first color
+// to be started.
+func OtherColor(color string) string {
+	if color == Color_Blue {
+		return Color_Green
+	}
+	return Color_Blue
+}
+
+// Returns the site directory of a color inside the directory of the target
+func SiteDir(dir string, color string) string {
+	return filepath.Join(dir, color)
+}
+
+// Returns the port a color listens on
+func Port(blueGreenConfig config.DeployToVmConfigBlueGreen, color string) int {
+	if color == Color_Green {
+		return blueGreenConfig.GreenPort
+	}
+	return blueGreenConfig.BluePort
+}
+
+// Checks that the ports of the colors are set and differ
+func ValidatePorts(blueGreenConfig config.DeployToVmConfigBlueGreen) error {
+	for _, port := range []int{blueGreenConfig.BluePort, blueGreenConfig.GreenPort} {
+		if port <= 0 || port > 65535 {
+			return fmt.Errorf("Invalid blue/green port: %d", port)
+		}
+	}
+	if blueGreenConfig.BluePort == blueGreenConfig.GreenPort {
+		return fmt.Errorf("The blue and green ports must differ, both are %d", blueGreenConfig.BluePort)
+	}
+	return nil
+}
+
+// Returns the color that receives the traffic, or an empty string if no color
+// was activated yet
+func GetActiveColor(dir string) (string, error) {
+	content, readErr := os.ReadFile(filepath.Join(dir, ActiveColorFileName))
+	if errors.Is(readErr, os.ErrNotExist) {
+		return "", nil
+	}
+	if readErr != nil {
+		return "", fmt.Errorf("Failed to read the active color: %w", readErr)
+	}
+
+	color := strings.TrimSpace(string(content))
+	if color != Color_Blue && color != Color_Green {
+		return "", fmt.Errorf("Invalid active color: %q", color)
+	}
+	return color, nil
+}
+
+// Records the color that receives the traffic
+func SetActiveColor(dir string, color string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("Failed to write the active color: %w", err)
+	}
+	activeColorFile := filepath.Join(dir, ActiveColorFileName)
+	tempFile := activeColorFile + ".tmp"
+	if err := os.WriteFile(tempFile, []byte(color+"\n"), 0644); err != nil {
+		return fmt.Errorf("Failed to write the active color: %w", err)
+	}
+	if err := os.Rename(tempFile, activeColorFile); err != nil {
+		os.Remove(tempFile)
+		return fmt.Errorf("Failed to write the active color: %w", err)
+	}
+	return nil
+}
+
+// Returns the site directory of the active color, or the directory itself if
+// no color was activated yet
+func ActiveSiteDir(dir string) (string, error) {
+	color, colorErr := GetActiveColor(dir)
+	if colorErr != nil || color == "" {
+		return dir, colorErr
+	}
+	return SiteDir(dir, color), nil
+}
+
+// Returns the site directory the release of a target is served from, which is
+// the directory of the active color for blue/green targets
+func TargetSiteDir(targetConfig *config.DeployToVmConfigTarget) (string, error) {
+	if !targetConfig.BlueGreen.Enabled || targetConfig.Dir == "" {
+		return targetConfig.Dir, nil
+	}
+	return ActiveSiteDir(targetConfig.Dir)
+}
@@ -0,0 +1,59 @@
+package bluegreen
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"deploy-to-vm/internal/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActiveColor(t *testing.T) {
+	dir := t.TempDir()
+
+	// Act/Assert: check if there is no active color before the first switch
+	color, err := GetActiveColor(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, "", color)
+	assert.Equal(t, Color_Blue, OtherColor(color))
+
+	// Act/Assert: check if the active color is recorded
+	assert.NoError(t, SetActiveColor(dir, Color_Green))
+	color, err = GetActiveColor(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, Color_Green, color)
+	assert.Equal(t, Color_Blue, OtherColor(color))
+
+	// Act/Assert: check if an invalid color fails
+	os.WriteFile(path.Join(dir, ActiveColorFileName), []byte("red\n"), 0644)
+	_, err = GetActiveColor(dir)
+	assert.Error(t, err)
+}
+
+func TestValidatePorts(t *testing.T) {
+	// Act/Assert: check valid and invalid ports
+	assert.NoError(t, ValidatePorts(config.DeployToVmConfigBlueGreen{BluePort: 3001, GreenPort: 3002}))
+	assert.Error(t, ValidatePorts(config.DeployToVmConfigBlueGreen{BluePort: 3001}))
+	assert.Error(t, ValidatePorts(config.DeployToVmConfigBlueGreen{BluePort: 3001, GreenPort: 3001}))
+	assert.Error(t, ValidatePorts(config.DeployToVmConfigBlueGreen{BluePort: 3001, GreenPort: 70000}))
+	assert.Equal(t, 3002, Port(config.DeployToVmConfigBlueGreen{BluePort: 3001, GreenPort: 3002}, Color_Green))
+}
+
+func TestTargetSiteDir(t *testing.T) {
+	dir := t.TempDir()
+	targetConfig := &config.DeployToVmConfigTarget{Dir: dir}
+
+	// Act/Assert: check if the target directory is the site without blue/green
+	SetActiveColor(dir, Color_Blue)
+	siteDir, err := TargetSiteDir(targetConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, dir, siteDir)
+
+	// Act/Assert: check if the directory of the active color is the site
+	targetConfig.BlueGreen.Enabled = true
+	siteDir, err = TargetSiteDir(targetConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, path.Join(dir, Color_Blue), siteDir)
+}
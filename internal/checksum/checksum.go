@@ -0,0 +1,198 @@
+package checksum
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// VerificationMode_Optional verifies assets that have a known checksum and
+	// lets the others pass. This is the default mode.
+	VerificationMode_Optional = "optional"
+	// VerificationMode_Required rejects any asset without a known checksum.
+	VerificationMode_Required = "required"
+)
+
+// VerifyResult describes which assets were verified against which source.
+type VerifyResult struct {
+	Verified   []string
+	Unverified []string
+}
+
+// Checks if the given file name is a checksums file shipped with a release,
+// e.g. "SHA256SUMS" or goreleaser's "checksums.txt" /
+// "<project>_<version>_checksums.txt"
+func IsChecksumsFile(name string) bool {
+	base := strings.ToLower(filepath.Base(name))
+	return base == "sha256sums" ||
+		base == "sha256sums.txt" ||
+		base == "checksums.txt" ||
+		strings.HasSuffix(base, "_checksums.txt")
+}
+
+// Parses a checksums file in the "sha256sum" format, which is also the format
+// used by goreleaser. Each line is "<hex digest>  <file name>" where the file
+// name can be prefixed with "*" for binary mode.
+func ParseChecksums(content []byte) (map[string]string, error) {
+	checksums := make(map[string]string)
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("Invalid checksum line: %q", line)
+		}
+
+		digest := strings.ToLower(fields[0])
+		if _, decodeErr := hex.DecodeString(digest); decodeErr != nil || len(digest) != sha256.Size*2 {
+			return nil, fmt.Errorf("Invalid sha256 digest for %q: %q", fields[1], fields[0])
+		}
+
+		name := strings.TrimPrefix(fields[1], "*")
+		checksums[filepath.Base(name)] = digest
+	}
+
+	if scanErr := scanner.Err(); scanErr != nil {
+		return nil, fmt.Errorf("Error while reading checksums: %v", scanErr)
+	}
+
+	return checksums, nil
+}
+
+// Calculates the hex encoded sha256 digest of a file
+func FileSHA256(path string) (string, error) {
+	file, openErr := os.Open(path)
+	if openErr != nil {
+		return "", openErr
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, copyErr := io.Copy(hash, file); copyErr != nil {
+		return "", copyErr
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Normalizes a GitHub asset digest (e.g. "sha256:abc...") to a plain hex
+// digest. Digests with other algorithms are ignored.
+func normalizeGithubDigest(digest string) string {
+	algorithm, value, found := strings.Cut(digest, ":")
+	if !found || strings.ToLower(algorithm) != "sha256" {
+		return ""
+	}
+	return strings.ToLower(value)
+}
+
+// Verifies the assets downloaded into the release directory. Expected
+// checksums are collected from checksums files found in the directory and
+// from the digests reported by GitHub for each asset (keyed by asset name).
+// Checksums files are removed after a successful verification so they never
+// reach the site directory.
+func VerifyReleaseDir(releaseDir string, githubDigests map[string]string, mode string) (*VerifyResult, error) {
+	if mode == "" {
+		mode = VerificationMode_Optional
+	}
+	if mode != VerificationMode_Optional && mode != VerificationMode_Required {
+		return nil, fmt.Errorf("Unknown checksum verification mode: %q", mode)
+	}
+
+	entries, readErr := os.ReadDir(releaseDir)
+	if readErr != nil {
+		return nil, fmt.Errorf("Error while reading the release directory: %v", readErr)
+	}
+
+	// Collect expected checksums from checksums files
+	checksumsFiles := make([]string, 0)
+	fromChecksumsFile := make(map[string]string)
+	for _, entry := range entries {
+		if entry.IsDir() || !IsChecksumsFile(entry.Name()) {
+			continue
+		}
+
+		checksumsFilePath := filepath.Join(releaseDir, entry.Name())
+		content, readFileErr := os.ReadFile(checksumsFilePath)
+		if readFileErr != nil {
+			return nil, fmt.Errorf("Error while reading checksums file: %v", readFileErr)
+		}
+
+		checksums, parseErr := ParseChecksums(content)
+		if parseErr != nil {
+			return nil, fmt.Errorf("Error while parsing checksums file %q: %v", entry.Name(), parseErr)
+		}
+
+		for name, digest := range checksums {
+			if existing, ok := fromChecksumsFile[name]; ok && existing != digest {
+				return nil, fmt.Errorf("Conflicting checksums for asset %q", name)
+			}
+			fromChecksumsFile[name] = digest
+		}
+		checksumsFiles = append(checksumsFiles, checksumsFilePath)
+	}
+
+	result := &VerifyResult{
+		Verified:   make([]string, 0),
+		Unverified: make([]string, 0),
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || IsChecksumsFile(entry.Name()) {
+			continue
+		}
+
+		expected := make([]string, 0, 2)
+		if digest := normalizeGithubDigest(githubDigests[entry.Name()]); digest != "" {
+			expected = append(expected, digest)
+		}
+		if digest, ok := fromChecksumsFile[entry.Name()]; ok {
+			expected = append(expected, digest)
+		}
+
+		if len(expected) == 0 {
+			if mode == VerificationMode_Required {
+				return nil, fmt.Errorf("No checksum found for asset %q", entry.Name())
+			}
+			log.Printf("No checksum found for asset, skipping verification: %s", entry.Name())
+			result.Unverified = append(result.Unverified, entry.Name())
+			continue
+		}
+
+		actual, hashErr := FileSHA256(filepath.Join(releaseDir, entry.Name()))
+		if hashErr != nil {
+			return nil, fmt.Errorf("Error while calculating checksum for asset %q: %v", entry.Name(), hashErr)
+		}
+
+		for _, digest := range expected {
+			if digest != actual {
+				return nil, fmt.Errorf("Checksum mismatch for asset %q: expected %s, got %s", entry.Name(), digest, actual)
+			}
+		}
+
+		log.Printf("Checksum verified for asset: %s", entry.Name())
+		result.Verified = append(result.Verified, entry.Name())
+	}
+
+	// Checksums files are not part of the site
+	for _, checksumsFilePath := range checksumsFiles {
+		if removeErr := os.Remove(checksumsFilePath); removeErr != nil && !errors.Is(removeErr, os.ErrNotExist) {
+			return nil, fmt.Errorf("Error while removing checksums file: %v", removeErr)
+		}
+	}
+
+	return result, nil
+}
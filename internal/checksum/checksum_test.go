@@ -0,0 +1,112 @@
+package checksum
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func sha256Hex(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestIsChecksumsFile(t *testing.T) {
+	assert.True(t, IsChecksumsFile("SHA256SUMS"))
+	assert.True(t, IsChecksumsFile("checksums.txt"))
+	assert.True(t, IsChecksumsFile("app_1.2.3_checksums.txt"))
+	assert.False(t, IsChecksumsFile("app.tar.gz"))
+	assert.False(t, IsChecksumsFile("checksums.txt.sig"))
+}
+
+func TestParseChecksums_Success(t *testing.T) {
+	// Arrange: create a checksums file content in sha256sum format
+	digest := sha256Hex([]byte("content"))
+	content := []byte(digest + "  app.tar.gz\n" + digest + " *dist/other.tar.gz\n\n# comment\n")
+
+	// Act: parse the checksums
+	checksums, err := ParseChecksums(content)
+
+	// Assert: check if both entries are parsed by their base name
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"app.tar.gz": digest, "other.tar.gz": digest}, checksums)
+}
+
+func TestParseChecksums_InvalidLine(t *testing.T) {
+	// Act: parse a malformed checksums file
+	_, err := ParseChecksums([]byte("not-a-digest app.tar.gz"))
+
+	// Assert: check if the error is returned
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Invalid sha256 digest")
+}
+
+func TestVerifyReleaseDir_ChecksumsFile_Success(t *testing.T) {
+	// Arrange: create an asset and a matching checksums file
+	releaseDir := t.TempDir()
+	content := []byte("asset content")
+	os.WriteFile(path.Join(releaseDir, "app.tar.gz"), content, 0644)
+	os.WriteFile(path.Join(releaseDir, "checksums.txt"), []byte(sha256Hex(content)+"  app.tar.gz\n"), 0644)
+
+	// Act: verify the release directory
+	result, err := VerifyReleaseDir(releaseDir, nil, VerificationMode_Required)
+
+	// Assert: check if the asset is verified and the checksums file is removed
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"app.tar.gz"}, result.Verified)
+	_, statErr := os.Stat(path.Join(releaseDir, "checksums.txt"))
+	assert.True(t, os.IsNotExist(statErr), "Expected checksums file to be removed")
+}
+
+func TestVerifyReleaseDir_GithubDigest_Mismatch(t *testing.T) {
+	// Arrange: create an asset with a wrong GitHub digest
+	releaseDir := t.TempDir()
+	os.WriteFile(path.Join(releaseDir, "app.tar.gz"), []byte("asset content"), 0644)
+	digests := map[string]string{"app.tar.gz": "sha256:" + sha256Hex([]byte("other content"))}
+
+	// Act: verify the release directory
+	_, err := VerifyReleaseDir(releaseDir, digests, VerificationMode_Optional)
+
+	// Assert: check if the mismatch is reported
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Checksum mismatch for asset \"app.tar.gz\"")
+}
+
+func TestVerifyReleaseDir_Optional_MissingChecksum(t *testing.T) {
+	// Arrange: create an asset without any checksum
+	releaseDir := t.TempDir()
+	os.WriteFile(path.Join(releaseDir, "app.tar.gz"), []byte("asset content"), 0644)
+
+	// Act: verify the release directory in optional mode
+	result, err := VerifyReleaseDir(releaseDir, nil, "")
+
+	// Assert: check if the asset is reported as unverified
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"app.tar.gz"}, result.Unverified)
+}
+
+func TestVerifyReleaseDir_Required_MissingChecksum(t *testing.T) {
+	// Arrange: create an asset without any checksum
+	releaseDir := t.TempDir()
+	os.WriteFile(path.Join(releaseDir, "app.tar.gz"), []byte("asset content"), 0644)
+
+	// Act: verify the release directory in required mode
+	_, err := VerifyReleaseDir(releaseDir, nil, VerificationMode_Required)
+
+	// Assert: check if the error is returned
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "No checksum found for asset \"app.tar.gz\"")
+}
+
+func TestVerifyReleaseDir_UnknownMode(t *testing.T) {
+	// Act: verify the release directory with an unknown mode
+	_, err := VerifyReleaseDir(t.TempDir(), nil, "sometimes")
+
+	// Assert: check if the error is returned
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Unknown checksum verification mode")
+}
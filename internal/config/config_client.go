@@ -2,17 +2,482 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"time"
 )
 
+type DeployToVmConfigVerification struct {
+	// Checksum verification mode for downloaded assets: "optional" (default)
+	// or "required"
+	Checksum string `json:"checksum"`
+	// Signature verification mode for downloaded assets: "optional" (default)
+	// or "required"
+	Signature string `json:"signature"`
+	// Path to the OpenPGP keyring used to verify ".asc" signatures
+	KeyringFile string `json:"keyringFile"`
+	// Minisign or base64 encoded ed25519 public keys used to verify ".sig"
+	// signatures
+	PublicKeys []string `json:"publicKeys"`
+}
+
+type DeployToVmConfigLimits struct {
+	// Maximum combined size of the downloaded release assets in bytes
+	MaxDownloadSize int64 `json:"maxDownloadSize"`
+	// Maximum combined size of the files extracted from archives in bytes
+	MaxExtractedSize int64 `json:"maxExtractedSize"`
+	// Maximum number of files extracted from archives
+	MaxFileCount int `json:"maxFileCount"`
+}
+
+type DeployToVmConfigExtraction struct {
+	// Octal umask applied to the modes of extracted files, e.g. "0027".
+	// Defaults to "0022".
+	Umask string `json:"umask"`
+	// Owner and group (names or numeric ids) applied to the extracted tree
+	Owner string `json:"owner"`
+	Group string `json:"group"`
+	// File name patterns of archives that are deployed as they are instead of
+	// being extracted, e.g. "*.js.gz" for precompressed assets
+	SkipArchives []string `json:"skipArchives"`
+	// Number of leading path components removed from archive entries, e.g. 1
+	// for tarballs wrapping everything in a "dist/" folder
+	StripComponents int `json:"stripComponents"`
+	// Subdirectory of the release that becomes the site contents, e.g.
+	// "build". Defaults to the whole release.
+	RootDir string `json:"rootDir"`
+}
+
+// DefaultUmask is applied to extracted files when no umask is configured
+const DefaultUmask os.FileMode = 0022
+
+// GetUmask parses the configured umask, falling back to DefaultUmask
+func (e DeployToVmConfigExtraction) GetUmask() (os.FileMode, error) {
+	if e.Umask == "" {
+		return DefaultUmask, nil
+	}
+
+	umask, parseErr := strconv.ParseUint(e.Umask, 8, 32)
+	if parseErr != nil || umask > 0777 {
+		return 0, fmt.Errorf("Invalid umask: %q", e.Umask)
+	}
+	return os.FileMode(umask), nil
+}
+
+type DeployToVmConfigSync struct {
+	// How release assets reach the site directory: "replace" (default)
+	// removes every file and links the release again, "sync" only changes
+	// the files that differ
+	Mode string `json:"mode"`
+	// Paths relative to the site directory that are never touched in "sync"
+	// mode, e.g. "uploads/" or ".env"
+	ProtectedPaths []string `json:"protectedPaths"`
+	// How files are placed in the site directory: "auto" (default) hard links
+	// and falls back when the site is on another filesystem, "hardlink",
+	// "copy" (reflink where available) or "symlink"
+	LinkStrategy string `json:"linkStrategy"`
+	// Strategy used by "auto" when hard links are not possible, "copy"
+	// (default) or "symlink"
+	LinkFallback string `json:"linkFallback"`
+}
+
+type DeployToVmConfigStorage struct {
+	// Stores extracted files in a content-addressed store shared by all
+	// releases, so unchanged files between tags take no extra space
+	Deduplicate bool `json:"deduplicate"`
+	// Allows the "hardlink" and "symlink" link strategies with Deduplicate.
+	// The site files then share their data with the store, so editing one in
+	// place changes every release that contains the same file. Without it,
+	// "auto" copies the files and the other strategies are rejected.
+	AllowSharedSiteFiles bool `json:"allowSharedSiteFiles"`
+	// Number of release directories kept after a deployment, including the
+	// deployed one. Older releases are pruned. 0 keeps every release.
+	KeepReleases int `json:"keepReleases"`
+}
+
+type DeployToVmConfigDrift struct {
+	// What a deployment does when the site directory was changed since the
+	// active release was deployed: "allow" (default) deploys without checking,
+	// "warn" deploys and reports the drift, "block" refuses to deploy
+	Policy string `json:"policy"`
+	// How often the site directory is checked for drift in the background,
+	// e.g. "15m". The background check is disabled when empty.
+	CheckInterval string `json:"checkInterval"`
+}
+
+// Returns the interval of the background drift check, or 0 if it is disabled
+func (d DeployToVmConfigDrift) GetCheckInterval() (time.Duration, error) {
+	if d.CheckInterval == "" {
+		return 0, nil
+	}
+
+	interval, parseErr := time.ParseDuration(d.CheckInterval)
+	if parseErr != nil || interval <= 0 {
+		return 0, fmt.Errorf("Invalid drift check interval: %q", d.CheckInterval)
+	}
+	return interval, nil
+}
+
+type DeployToVmConfigNginx struct {
+	// VhostFile is the path of a server block in the release, relative to the
+	// release directory. It is installed into SitesEnabledDir and tested
+	// before nginx is reloaded.
+	VhostFile string `json:"vhostFile"`
+	// SitesEnabledDir defaults to "/etc/nginx/sites-enabled"
+	SitesEnabledDir string `json:"sitesEnabledDir"`
+	// VhostName is the file name of the installed server block, defaulting to
+	// "<repo>.conf"
+	VhostName string `json:"vhostName"`
+}
+
+type DeployToVmConfigPm2 struct {
+	// ProcessName is the name of the pm2 process, replacing the legacy
+	// "targetProcessName" setting
+	ProcessName string `json:"processName"`
+	// EcosystemFile is the ecosystem file in the site directory the process is
+	// started from when it is not running yet
+	EcosystemFile string `json:"ecosystemFile"`
+	// Script, Cwd, Env and Instances start the process when the release does
+	// not ship an ecosystem file. Cwd defaults to the site directory.
+	Script    string            `json:"script"`
+	Cwd       string            `json:"cwd"`
+	Env       map[string]string `json:"env"`
+	Instances int               `json:"instances"`
+	// How long to wait for the process to come online, e.g. "30s"
+	Timeout string `json:"timeout"`
+}
+
+// DefaultPm2Timeout is how long to wait for a process to come online if the
+// config does not set a timeout
+const DefaultPm2Timeout = 30 * time.Second
+
+// Returns the time to wait for the process to come online
+func (p DeployToVmConfigPm2) GetTimeout() (time.Duration, error) {
+	if p.Timeout == "" {
+		return DefaultPm2Timeout, nil
+	}
+
+	timeout, parseErr := time.ParseDuration(p.Timeout)
+	if parseErr != nil || timeout <= 0 {
+		return 0, fmt.Errorf("Invalid pm2 timeout: %q", p.Timeout)
+	}
+	return timeout, nil
+}
+
+type DeployToVmConfigSystemd struct {
+	// Unit is the systemd unit of the service, e.g. "api.service"
+	Unit string `json:"unit"`
+	// Action is how the unit picks up a new release: "restart" (default),
+	// "reload" or "try-reload-or-restart"
+	Action string `json:"action"`
+	// How long to wait for the unit to become active again, e.g. "30s"
+	Timeout string `json:"timeout"`
+}
+
+// DefaultSystemdTimeout is how long to wait for a unit to become active if the
+// config does not set a timeout
+const DefaultSystemdTimeout = 30 * time.Second
+
+// Returns the time to wait for the unit to become active
+func (s DeployToVmConfigSystemd) GetTimeout() (time.Duration, error) {
+	if s.Timeout == "" {
+		return DefaultSystemdTimeout, nil
+	}
+
+	timeout, parseErr := time.ParseDuration(s.Timeout)
+	if parseErr != nil || timeout <= 0 {
+		return 0, fmt.Errorf("Invalid systemd timeout: %q", s.Timeout)
+	}
+	return timeout, nil
+}
+
+type DeployToVmConfigDockerCompose struct {
+	// Project is the compose project name, defaulting to the repository name
+	Project string `json:"project"`
+	// ComposeFile is the compose file in the site directory, defaulting to the
+	// file names docker compose looks for
+	ComposeFile string `json:"composeFile"`
+	// ImageArchives are file name patterns (see filepath.Match) of image
+	// tarballs in the release to "docker load". They are never extracted.
+	ImageArchives []string `json:"imageArchives"`
+	// TagVariable is the variable that holds the release tag in the compose
+	// file, defaulting to "IMAGE_TAG"
+	TagVariable string `json:"tagVariable"`
+	// How long loading an image archive or starting the project may take,
+	// e.g. "5m"
+	Timeout string `json:"timeout"`
+}
+
+// DefaultDockerComposeTimeout is how long a docker command may run if the
+// config does not set a timeout
+const DefaultDockerComposeTimeout = 10 * time.Minute
+
+// Returns the time a docker command of the target may run
+func (d DeployToVmConfigDockerCompose) GetTimeout() (time.Duration, error) {
+	if d.Timeout == "" {
+		return DefaultDockerComposeTimeout, nil
+	}
+
+	timeout, parseErr := time.ParseDuration(d.Timeout)
+	if parseErr != nil || timeout <= 0 {
+		return 0, fmt.Errorf("Invalid docker compose timeout: %q", d.Timeout)
+	}
+	return timeout, nil
+}
+
+type DeployToVmConfigHealthCheck struct {
+	// URL is requested after a target is reloaded. The health check is
+	// disabled when empty.
+	URL string `json:"url"`
+	// ExpectedStatus is the status code of a healthy response, defaulting to 200
+	ExpectedStatus int `json:"expectedStatus"`
+	// BodyContains is a text the response body must contain
+	BodyContains string `json:"bodyContains"`
+	// JsonPath is a dot-separated path into a JSON response body, e.g.
+	// "status" or "checks.0.state", whose value must equal JsonValue
+	JsonPath  string `json:"jsonPath"`
+	JsonValue string `json:"jsonValue"`
+	// How long a single request may take, e.g. "5s"
+	Timeout string `json:"timeout"`
+	// Retries is how often a failed check is repeated before the deployment
+	// fails
+	Retries int `json:"retries"`
+	// How long to wait between the checks, e.g. "2s"
+	Interval string `json:"interval"`
+}
+
+const (
+	// DefaultHealthCheckTimeout is how long a health check request may take if
+	// the config does not set a timeout
+	DefaultHealthCheckTimeout = 5 * time.Second
+	// DefaultHealthCheckInterval is how long to wait between the health checks
+	// if the config does not set an interval
+	DefaultHealthCheckInterval = 2 * time.Second
+)
+
+// Returns the time a single health check request may take
+func (h DeployToVmConfigHealthCheck) GetTimeout() (time.Duration, error) {
+	if h.Timeout == "" {
+		return DefaultHealthCheckTimeout, nil
+	}
+
+	timeout, parseErr := time.ParseDuration(h.Timeout)
+	if parseErr != nil || timeout <= 0 {
+		return 0, fmt.Errorf("Invalid health check timeout: %q", h.Timeout)
+	}
+	return timeout, nil
+}
+
+// Returns the time to wait between the health checks
+func (h DeployToVmConfigHealthCheck) GetInterval() (time.Duration, error) {
+	if h.Interval == "" {
+		return DefaultHealthCheckInterval, nil
+	}
+
+	interval, parseErr := time.ParseDuration(h.Interval)
+	if parseErr != nil || interval < 0 {
+		return 0, fmt.Errorf("Invalid health check interval: %q", h.Interval)
+	}
+	return interval, nil
+}
+
+// Returns how often a failed check is repeated
+func (h DeployToVmConfigHealthCheck) GetRetries() (int, error) {
+	if h.Retries < 0 {
+		return 0, fmt.Errorf("Invalid health check retries: %d", h.Retries)
+	}
+	return h.Retries, nil
+}
+
+// Returns the status code of a healthy response
+func (h DeployToVmConfigHealthCheck) GetExpectedStatus() int {
+	if h.ExpectedStatus == 0 {
+		return 200
+	}
+	return h.ExpectedStatus
+}
+
+type DeployToVmConfigBlueGreen struct {
+	// Enabled runs pm2 and systemd targets as two colors, "blue" and "green".
+	// A release is started as the inactive color and receives the traffic once
+	// it is healthy.
+	Enabled   bool `json:"enabled"`
+	BluePort  int  `json:"bluePort"`
+	GreenPort int  `json:"greenPort"`
+	// UpstreamFile is the nginx config file the upstream of the active color
+	// is written to, e.g. "/etc/nginx/conf.d/app-upstream.conf"
+	UpstreamFile string `json:"upstreamFile"`
+	// UpstreamName is the name of the upstream, defaulting to the repository
+	// name
+	UpstreamName string `json:"upstreamName"`
+	// HealthPath is requested on the port of the new color before the traffic
+	// is switched, e.g. "/health". The criteria of the health check apply.
+	HealthPath string `json:"healthPath"`
+	// How long the previous color keeps running after the traffic is
+	// switched, e.g. "30s"
+	DrainPeriod string `json:"drainPeriod"`
+}
+
+// DefaultDrainPeriod is how long the previous color keeps running if the
+// config does not set a drain period
+const DefaultDrainPeriod = 30 * time.Second
+
+// Returns how long the previous color keeps running after the switch
+func (b DeployToVmConfigBlueGreen) GetDrainPeriod() (time.Duration, error) {
+	if b.DrainPeriod == "" {
+		return DefaultDrainPeriod, nil
+	}
+
+	drainPeriod, parseErr := time.ParseDuration(b.DrainPeriod)
+	if parseErr != nil || drainPeriod < 0 {
+		return 0, fmt.Errorf("Invalid drain period: %q", b.DrainPeriod)
+	}
+	return drainPeriod, nil
+}
+
+type DeployToVmConfigHook struct {
+	// Command is a script in the release, e.g. "scripts/migrate.sh", or a
+	// command on the PATH, e.g. "npm"
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+	// Dir is the working directory relative to the root directory of the
+	// release, defaulting to the root directory
+	Dir string `json:"dir"`
+	// Env are variables added to the environment of the hook
+	Env map[string]string `json:"env"`
+	// How long the hook may run, e.g. "5m"
+	Timeout string `json:"timeout"`
+}
+
+// DefaultHookTimeout is how long a hook may run if the config does not set a
+// timeout
+const DefaultHookTimeout = 5 * time.Minute
+
+// Returns how long the hook may run
+func (h DeployToVmConfigHook) GetTimeout() (time.Duration, error) {
+	if h.Timeout == "" {
+		return DefaultHookTimeout, nil
+	}
+
+	timeout, parseErr := time.ParseDuration(h.Timeout)
+	if parseErr != nil || timeout <= 0 {
+		return 0, fmt.Errorf("Invalid hook timeout: %q", h.Timeout)
+	}
+	return timeout, nil
+}
+
+type DeployToVmConfigHooks struct {
+	// PreActivate hooks run before the release is linked. A failing hook
+	// aborts the deployment.
+	PreActivate []DeployToVmConfigHook `json:"preActivate"`
+	// PostActivate hooks run after the release is linked, before the service
+	// is reloaded
+	PostActivate []DeployToVmConfigHook `json:"postActivate"`
+	// PostReload hooks run after the service is reloaded and healthy
+	PostReload []DeployToVmConfigHook `json:"postReload"`
+	// OnFailure hooks run after a failed deployment was rolled back
+	OnFailure []DeployToVmConfigHook `json:"onFailure"`
+}
+
+// Returns all hooks
+func (h DeployToVmConfigHooks) All() []DeployToVmConfigHook {
+	all := make([]DeployToVmConfigHook, 0)
+	all = append(all, h.PreActivate...)
+	all = append(all, h.PostActivate...)
+	all = append(all, h.PostReload...)
+	return append(all, h.OnFailure...)
+}
+
+type DeployToVmConfigRunAs struct {
+	// User (name or numeric id) the pm2, docker compose and hook commands of
+	// the repository run as. Extracted files are owned by it unless the
+	// extraction sets an owner. systemctl keeps running as the user of the
+	// server, so systemd units set their user with "User=".
+	User string `json:"user"`
+	// Group (name or numeric id) the commands run as. Defaults to the primary
+	// group of the user.
+	Group string `json:"group"`
+}
+
+type DeployToVmConfigTarget struct {
+	// Name identifies the target in logs and responses, defaulting to its type
+	Name string `json:"name"`
+	// Type is the target type, e.g. "nginx" or "pm2"
+	Type string `json:"type"`
+	// Dir is the site directory of the target
+	Dir string `json:"dir"`
+	// RootDir is the subdirectory of the release that is linked to Dir
+	RootDir string `json:"rootDir"`
+	// Include are path patterns, relative to RootDir, of the files that are
+	// linked to Dir. All files are linked when empty.
+	Include       []string                      `json:"include"`
+	Nginx         DeployToVmConfigNginx         `json:"nginx"`
+	Pm2           DeployToVmConfigPm2           `json:"pm2"`
+	Systemd       DeployToVmConfigSystemd       `json:"systemd"`
+	DockerCompose DeployToVmConfigDockerCompose `json:"dockerCompose"`
+	HealthCheck   DeployToVmConfigHealthCheck   `json:"healthCheck"`
+	BlueGreen     DeployToVmConfigBlueGreen     `json:"blueGreen"`
+	Hooks         DeployToVmConfigHooks         `json:"hooks"`
+}
+
 type DeployToVmConfigRepository struct {
-	Name              string `json:"name"`
-	Owner             string `json:"owner"`
-	SourceType        string `json:"sourceType"`
-	TargetDir         string `json:"targetDir"`
-	TargetProcessName string `json:"targetProcessName"`
-	TargetType        string `json:"targetType"`
+	Name              string                        `json:"name"`
+	Owner             string                        `json:"owner"`
+	SourceType        string                        `json:"sourceType"`
+	TargetDir         string                        `json:"targetDir"`
+	TargetProcessName string                        `json:"targetProcessName"`
+	TargetType        string                        `json:"targetType"`
+	Verification      DeployToVmConfigVerification  `json:"verification"`
+	Limits            DeployToVmConfigLimits        `json:"limits"`
+	Extraction        DeployToVmConfigExtraction    `json:"extraction"`
+	Sync              DeployToVmConfigSync          `json:"sync"`
+	Storage           DeployToVmConfigStorage       `json:"storage"`
+	Drift             DeployToVmConfigDrift         `json:"drift"`
+	Nginx             DeployToVmConfigNginx         `json:"nginx"`
+	Pm2               DeployToVmConfigPm2           `json:"pm2"`
+	Systemd           DeployToVmConfigSystemd       `json:"systemd"`
+	DockerCompose     DeployToVmConfigDockerCompose `json:"dockerCompose"`
+	HealthCheck       DeployToVmConfigHealthCheck   `json:"healthCheck"`
+	BlueGreen         DeployToVmConfigBlueGreen     `json:"blueGreen"`
+	Hooks             DeployToVmConfigHooks         `json:"hooks"`
+	RunAs             DeployToVmConfigRunAs         `json:"runAs"`
+	// Targets are deployed in order. When empty, the repository has a single
+	// target given by TargetType and TargetDir.
+	Targets []DeployToVmConfigTarget `json:"targets"`
+}
+
+// Returns the targets of the repository in the order they are deployed
+func (r *DeployToVmConfigRepository) GetTargets() []DeployToVmConfigTarget {
+	targets := make([]DeployToVmConfigTarget, 0, len(r.Targets))
+	if len(r.Targets) == 0 {
+		pm2Config := r.Pm2
+		if pm2Config.ProcessName == "" {
+			pm2Config.ProcessName = r.TargetProcessName
+		}
+		targets = append(targets, DeployToVmConfigTarget{
+			Type:          r.TargetType,
+			Dir:           r.TargetDir,
+			RootDir:       r.Extraction.RootDir,
+			Nginx:         r.Nginx,
+			Pm2:           pm2Config,
+			Systemd:       r.Systemd,
+			DockerCompose: r.DockerCompose,
+			HealthCheck:   r.HealthCheck,
+			BlueGreen:     r.BlueGreen,
+			Hooks:         r.Hooks,
+		})
+	} else {
+		targets = append(targets, r.Targets...)
+	}
+
+	for i := range targets {
+		if targets[i].Name == "" {
+			targets[i].Name = targets[i].Type
+		}
+	}
+	return targets
 }
 
 type DeployToVmConfig struct {
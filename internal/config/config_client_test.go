@@ -4,6 +4,7 @@ import (
 	"os"
 	"path"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -196,3 +197,193 @@ func TestLoadConfig_InvalidJSON(t *testing.T) {
 	assert.Error(t, loadErr, "Expected error when config file contains invalid JSON")
 	assert.Contains(t, loadErr.Error(), "invalid character", "Expected JSON parsing error")
 }
+
+func TestGetUmask(t *testing.T) {
+	// Act/Assert: check the default umask
+	umask, err := DeployToVmConfigExtraction{}.GetUmask()
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultUmask, umask)
+
+	// Act/Assert: check a configured umask
+	umask, err = DeployToVmConfigExtraction{Umask: "0027"}.GetUmask()
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0027), umask)
+
+	// Act/Assert: check an invalid umask
+	_, err = DeployToVmConfigExtraction{Umask: "0999"}.GetUmask()
+	assert.Error(t, err)
+}
+
+func TestGetCheckInterval(t *testing.T) {
+	// Act/Assert: check that periodic checks are disabled by default
+	interval, err := DeployToVmConfigDrift{}.GetCheckInterval()
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), interval)
+
+	// Act/Assert: check a configured interval
+	interval, err = DeployToVmConfigDrift{CheckInterval: "15m"}.GetCheckInterval()
+	assert.NoError(t, err)
+	assert.Equal(t, 15*time.Minute, interval)
+
+	// Act/Assert: check an invalid interval
+	_, err = DeployToVmConfigDrift{CheckInterval: "often"}.GetCheckInterval()
+	assert.Error(t, err)
+}
+
+func TestGetTimeout(t *testing.T) {
+	// Act/Assert: check the default timeout
+	timeout, err := DeployToVmConfigSystemd{}.GetTimeout()
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultSystemdTimeout, timeout)
+
+	// Act/Assert: check a configured timeout
+	timeout, err = DeployToVmConfigSystemd{Timeout: "1m"}.GetTimeout()
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, timeout)
+
+	// Act/Assert: check an invalid timeout
+	_, err = DeployToVmConfigSystemd{Timeout: "-5s"}.GetTimeout()
+	assert.Error(t, err)
+}
+
+func TestGetTimeout_Pm2(t *testing.T) {
+	// Act/Assert: check the default timeout
+	timeout, err := DeployToVmConfigPm2{}.GetTimeout()
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultPm2Timeout, timeout)
+
+	// Act/Assert: check a configured timeout
+	timeout, err = DeployToVmConfigPm2{Timeout: "45s"}.GetTimeout()
+	assert.NoError(t, err)
+	assert.Equal(t, 45*time.Second, timeout)
+
+	// Act/Assert: check an invalid timeout
+	_, err = DeployToVmConfigPm2{Timeout: "soon"}.GetTimeout()
+	assert.Error(t, err)
+}
+
+func TestGetTimeout_DockerCompose(t *testing.T) {
+	// Act/Assert: check the default timeout
+	timeout, err := DeployToVmConfigDockerCompose{}.GetTimeout()
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultDockerComposeTimeout, timeout)
+
+	// Act/Assert: check a configured timeout
+	timeout, err = DeployToVmConfigDockerCompose{Timeout: "20m"}.GetTimeout()
+	assert.NoError(t, err)
+	assert.Equal(t, 20*time.Minute, timeout)
+
+	// Act/Assert: check an invalid timeout
+	_, err = DeployToVmConfigDockerCompose{Timeout: "0s"}.GetTimeout()
+	assert.Error(t, err)
+}
+
+func TestHealthCheckDefaults(t *testing.T) {
+	// Act/Assert: check the defaults
+	healthCheck := DeployToVmConfigHealthCheck{URL: "http://localhost/health"}
+	timeout, timeoutErr := healthCheck.GetTimeout()
+	interval, intervalErr := healthCheck.GetInterval()
+	assert.NoError(t, timeoutErr)
+	assert.NoError(t, intervalErr)
+	assert.Equal(t, DefaultHealthCheckTimeout, timeout)
+	assert.Equal(t, DefaultHealthCheckInterval, interval)
+	assert.Equal(t, 200, healthCheck.GetExpectedStatus())
+	retries, retriesErr := healthCheck.GetRetries()
+	assert.NoError(t, retriesErr)
+	assert.Equal(t, 0, retries)
+
+	// Act/Assert: check configured values
+	healthCheck = DeployToVmConfigHealthCheck{Timeout: "10s", Interval: "0s", ExpectedStatus: 204}
+	timeout, _ = healthCheck.GetTimeout()
+	interval, _ = healthCheck.GetInterval()
+	assert.Equal(t, 10*time.Second, timeout)
+	assert.Equal(t, time.Duration(0), interval)
+	assert.Equal(t, 204, healthCheck.GetExpectedStatus())
+	retries, _ = DeployToVmConfigHealthCheck{Retries: 3}.GetRetries()
+	assert.Equal(t, 3, retries)
+
+	// Act/Assert: check invalid values
+	_, timeoutErr = DeployToVmConfigHealthCheck{Timeout: "0s"}.GetTimeout()
+	_, intervalErr = DeployToVmConfigHealthCheck{Interval: "often"}.GetInterval()
+	_, retriesErr = DeployToVmConfigHealthCheck{Retries: -1}.GetRetries()
+	assert.Error(t, timeoutErr)
+	assert.Error(t, intervalErr)
+	assert.Error(t, retriesErr)
+}
+
+func TestGetDrainPeriod(t *testing.T) {
+	// Act/Assert: check the default drain period
+	drainPeriod, err := DeployToVmConfigBlueGreen{}.GetDrainPeriod()
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultDrainPeriod, drainPeriod)
+
+	// Act/Assert: check if the previous color can be stopped at once
+	drainPeriod, err = DeployToVmConfigBlueGreen{DrainPeriod: "0s"}.GetDrainPeriod()
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), drainPeriod)
+
+	// Act/Assert: check an invalid drain period
+	_, err = DeployToVmConfigBlueGreen{DrainPeriod: "-1m"}.GetDrainPeriod()
+	assert.Error(t, err)
+}
+
+func TestHooks(t *testing.T) {
+	// Act/Assert: check the default and an invalid hook timeout
+	timeout, err := DeployToVmConfigHook{}.GetTimeout()
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultHookTimeout, timeout)
+	_, err = DeployToVmConfigHook{Timeout: "0"}.GetTimeout()
+	assert.Error(t, err)
+
+	// Act/Assert: check if all hooks are listed in lifecycle order
+	hooks := DeployToVmConfigHooks{
+		PreActivate: []DeployToVmConfigHook{{Command: "migrate"}},
+		PostReload:  []DeployToVmConfigHook{{Command: "warmup"}},
+		OnFailure:   []DeployToVmConfigHook{{Command: "notify"}},
+	}
+	assert.Equal(t, []DeployToVmConfigHook{{Command: "migrate"}, {Command: "warmup"}, {Command: "notify"}}, hooks.All())
+}
+
+func TestGetTargets_LegacyTarget(t *testing.T) {
+	// Arrange: create a repository with a single legacy target
+	repositoryConfig := &DeployToVmConfigRepository{
+		TargetDir:         "/var/www/app",
+		TargetType:        "pm2",
+		TargetProcessName: "app",
+		Extraction:        DeployToVmConfigExtraction{RootDir: "dist"},
+		HealthCheck:       DeployToVmConfigHealthCheck{URL: "http://localhost:3000/health"},
+	}
+
+	// Act: get the targets
+	targets := repositoryConfig.GetTargets()
+
+	// Assert: check if the legacy settings make up the target
+	assert.Equal(t, []DeployToVmConfigTarget{{
+		Name:        "pm2",
+		Type:        "pm2",
+		Dir:         "/var/www/app",
+		RootDir:     "dist",
+		Pm2:         DeployToVmConfigPm2{ProcessName: "app"},
+		HealthCheck: DeployToVmConfigHealthCheck{URL: "http://localhost:3000/health"},
+	}}, targets)
+}
+
+func TestGetTargets_MultipleTargets(t *testing.T) {
+	// Arrange: create a repository with two targets, one of them named
+	repositoryConfig := &DeployToVmConfigRepository{
+		TargetDir: "/var/www/ignored",
+		Targets: []DeployToVmConfigTarget{
+			{Name: "frontend", Type: "nginx", Dir: "/var/www/app"},
+			{Type: "pm2", Dir: "/srv/api"},
+		},
+	}
+
+	// Act: get the targets
+	targets := repositoryConfig.GetTargets()
+
+	// Assert: check if the targets keep their order and get default names
+	assert.Len(t, targets, 2)
+	assert.Equal(t, "frontend", targets[0].Name)
+	assert.Equal(t, "pm2", targets[1].Name)
+	assert.Equal(t, "", repositoryConfig.Targets[1].Name, "Expected the config not to be changed")
+}
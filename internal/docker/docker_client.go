@@ -0,0 +1,196 @@
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	deploy_to_vm_exec "deploy-to-vm/internal/exec"
+)
+
+// DefaultComposeFiles are the compose file names looked up in a release when
+// the config does not name one, in the order docker compose prefers them
+var DefaultComposeFiles = []string{"compose.yaml", "compose.yml", "docker-compose.yaml", "docker-compose.yml"}
+
+// DefaultTagVariable is the variable that holds the release tag in the env
+// file passed to docker compose, e.g. "image: app:${IMAGE_TAG}"
+const DefaultTagVariable = "IMAGE_TAG"
+
+// variableNamePattern is the grammar of variable names in compose env files
+var variableNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// DockerClient is a struct that represents a client for deploying container
+// based apps with the docker installation in the VM.
+type DockerClient struct {
+	ExecClient deploy_to_vm_exec.ExecClientInterface
+}
+
+// DockerClientInterface is an interface that defines the methods for the
+// DockerClient struct, so it can be mocked in unit tests.
+type DockerClientInterface interface {
+	ComposeUp(options ComposeUpOptions) error
+	WithLogSink(sink deploy_to_vm_exec.LogSink) DockerClientInterface
+	WithCredential(credential *deploy_to_vm_exec.Credential) DockerClientInterface
+}
+
+// ComposeUpOptions describes a release to start with docker compose.
+type ComposeUpOptions struct {
+	// Project is the compose project name
+	Project string
+	// ComposeFile is the path of the compose file
+	ComposeFile string
+	// EnvFile is the path of the env file with the release tag, see WriteEnvFile
+	EnvFile string
+	// ImageArchives are image tarballs to "docker load" before starting
+	ImageArchives []string
+	// Timeout is how long each docker command may run, the default timeout of
+	// the exec client applies if it is zero
+	Timeout time.Duration
+}
+
+// Returns the path of the compose file in a directory. A configured file name
+// must exist, otherwise the first of DefaultComposeFiles that exists is used.
+func FindComposeFile(dir string, composeFile string) (string, error) {
+	candidates := DefaultComposeFiles
+	if composeFile != "" {
+		candidates = []string{composeFile}
+	}
+
+	for _, candidate := range candidates {
+		candidatePath := filepath.Join(dir, candidate)
+		if !strings.HasPrefix(candidatePath, filepath.Clean(dir)+string(os.PathSeparator)) {
+			return "", fmt.Errorf("Compose file is outside of the release: %q", candidate)
+		}
+		if info, statErr := os.Stat(candidatePath); statErr == nil && info.Mode().IsRegular() {
+			return candidatePath, nil
+		}
+	}
+	return "", fmt.Errorf("Compose file not found in the release, tried: %s", strings.Join(candidates, ", "))
+}
+
+// Returns the image tarballs in a directory that match one of the patterns
+// (see filepath.Match)
+func FindImageArchives(dir string, patterns []string) ([]string, error) {
+	archives := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, pattern := range patterns {
+		matches, globErr := filepath.Glob(filepath.Join(dir, pattern))
+		if globErr != nil {
+			return nil, fmt.Errorf("Invalid image archive pattern %q: %v", pattern, globErr)
+		}
+		for _, match := range matches {
+			if !seen[match] {
+				seen[match] = true
+				archives = append(archives, match)
+			}
+		}
+	}
+	sort.Strings(archives)
+	return archives, nil
+}
+
+// Checks that the tag variable is a valid variable name and that the release
+// tag can be written to the env file as a single-quoted value
+func ValidateTagVariable(tagVariable string, tag string) error {
+	if tagVariable != "" && !variableNamePattern.MatchString(tagVariable) {
+		return fmt.Errorf("Invalid compose tag variable: %q", tagVariable)
+	}
+	if strings.ContainsAny(tag, "\n\r'") {
+		return fmt.Errorf("Release tag cannot contain line breaks or single quotes: %q", tag)
+	}
+	return nil
+}
+
+// Writes an env file for docker compose that sets the tag variable to the
+// release tag. The tag is single-quoted, so compose reads it literally
+// without interpolating "$" or cutting it off at a "#".
+func WriteEnvFile(envFile string, tagVariable string, tag string) error {
+	if validateErr := ValidateTagVariable(tagVariable, tag); validateErr != nil {
+		return validateErr
+	}
+	if tagVariable == "" {
+		tagVariable = DefaultTagVariable
+	}
+
+	if err := os.MkdirAll(filepath.Dir(envFile), 0755); err != nil {
+		return fmt.Errorf("Error while creating the env file directory: %v", err)
+	}
+	if err := os.WriteFile(envFile, []byte(fmt.Sprintf("%s='%s'\n", tagVariable, tag)), 0644); err != nil {
+		return fmt.Errorf("Error while writing the env file: %v", err)
+	}
+	return nil
+}
+
+// Runs a docker command and returns its output in the error if it fails
+func (c *DockerClient) run(timeout time.Duration, args ...string) error {
+	command := c.ExecClient.Command("docker", args...)
+	if timeout > 0 {
+		command = command.WithTimeout(timeout)
+	}
+	out, err := command.CombinedOutput()
+	if err != nil {
+		log.Printf("Error running \"docker %s\": %v", strings.Join(args, " "), string(out))
+		return fmt.Errorf("Error running docker %s: %v\n%s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Loads the image tarballs of a release and starts its compose project in the
+// background
+func (c *DockerClient) ComposeUp(options ComposeUpOptions) error {
+	if options.Project == "" || options.ComposeFile == "" {
+		return errors.New("Compose project and compose file cannot be empty")
+	}
+
+	for _, imageArchive := range options.ImageArchives {
+		if err := c.run(options.Timeout, "load", "--input", imageArchive); err != nil {
+			return err
+		}
+		log.Printf("Loaded docker image archive \"%s\"", imageArchive)
+	}
+
+	args := []string{"compose", "--project-name", options.Project, "--file", options.ComposeFile}
+	if options.EnvFile != "" {
+		args = append(args, "--env-file", options.EnvFile)
+	}
+	args = append(args, "up", "--detach", "--wait")
+	if err := c.run(options.Timeout, args...); err != nil {
+		return err
+	}
+
+	log.Printf("Started docker compose project \"%s\"", options.Project)
+	return nil
+}
+
+// Returns a copy of the client that streams the output of its commands into
+// the log sink
+func (c *DockerClient) WithLogSink(sink deploy_to_vm_exec.LogSink) DockerClientInterface {
+	client := *c
+	client.ExecClient = deploy_to_vm_exec.WithLogSink(c.ExecClient, sink)
+	return &client
+}
+
+// Returns a copy of the client that runs its commands as the user of the
+// credential
+func (c *DockerClient) WithCredential(credential *deploy_to_vm_exec.Credential) DockerClientInterface {
+	client := *c
+	client.ExecClient = deploy_to_vm_exec.WithCredential(c.ExecClient, credential)
+	return &client
+}
+
+func NewDockerClient(execClient deploy_to_vm_exec.ExecClientInterface) *DockerClient {
+	// If execClient is nil, create a new ExecClient instance
+	if execClient == nil {
+		execClient = &deploy_to_vm_exec.ExecClient{}
+	}
+
+	return &DockerClient{
+		ExecClient: execClient,
+	}
+}
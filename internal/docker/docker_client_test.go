@@ -0,0 +1,223 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	deploy_to_vm_exec "deploy-to-vm/internal/exec"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type MockExecCommand struct {
+	CombinedOutputFunc func() ([]byte, error)
+	WithTimeoutFunc    func(timeout time.Duration)
+}
+
+func (m *MockExecCommand) WithContext(ctx context.Context) deploy_to_vm_exec.ExecCommandInterface {
+	return m
+}
+
+func (m *MockExecCommand) WithTimeout(timeout time.Duration) deploy_to_vm_exec.ExecCommandInterface {
+	if m.WithTimeoutFunc != nil {
+		m.WithTimeoutFunc(timeout)
+	}
+	return m
+}
+
+func (m *MockExecCommand) WithDir(dir string) deploy_to_vm_exec.ExecCommandInterface {
+	return m
+}
+
+func (m *MockExecCommand) WithEnv(env ...string) deploy_to_vm_exec.ExecCommandInterface {
+	return m
+}
+
+func (m *MockExecCommand) WithLogSink(sink deploy_to_vm_exec.LogSink) deploy_to_vm_exec.ExecCommandInterface {
+	return m
+}
+
+func (m *MockExecCommand) WithCredential(credential *deploy_to_vm_exec.Credential) deploy_to_vm_exec.ExecCommandInterface {
+	return m
+}
+
+func (m *MockExecCommand) CombinedOutput() ([]byte, error) {
+	return m.CombinedOutputFunc()
+}
+
+func (m *MockExecCommand) Run() (*deploy_to_vm_exec.Result, error) {
+	output, err := m.CombinedOutputFunc()
+	return &deploy_to_vm_exec.Result{Stdout: output}, err
+}
+
+// MockExecClient records every command it runs with its timeout and fails
+// the commands whose first argument is in Errors
+type MockExecClient struct {
+	Commands []string
+	Timeouts []time.Duration
+	Errors   map[string]string
+}
+
+func (m *MockExecClient) Command(name string, arg ...string) deploy_to_vm_exec.ExecCommandInterface {
+	m.Commands = append(m.Commands, name+" "+strings.Join(arg, " "))
+	m.Timeouts = append(m.Timeouts, 0)
+	index := len(m.Timeouts) - 1
+	return &MockExecCommand{
+		WithTimeoutFunc: func(timeout time.Duration) {
+			m.Timeouts[index] = timeout
+		},
+		CombinedOutputFunc: func() ([]byte, error) {
+			if output, ok := m.Errors[arg[0]]; ok {
+				return []byte(output), errors.New("exit status 1")
+			}
+			return nil, nil
+		},
+	}
+}
+
+func TestDockerClient_ComposeUp_Success(t *testing.T) {
+	// Arrange: create an instance of DockerClient with the mock ExecClient
+	mockExecClient := &MockExecClient{}
+	dockerClient := NewDockerClient(mockExecClient)
+
+	// Act: start a release with an image archive
+	err := dockerClient.ComposeUp(ComposeUpOptions{
+		Project:       "app",
+		ComposeFile:   "/srv/app/compose.yaml",
+		EnvFile:       "/assets/app/v1/.deploy-to-vm/compose.env",
+		ImageArchives: []string{"/assets/app/v1/app.image.tar"},
+	})
+
+	// Assert: check if the image is loaded before the project is started
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"docker load --input /assets/app/v1/app.image.tar",
+		"docker compose --project-name app --file /srv/app/compose.yaml --env-file /assets/app/v1/.deploy-to-vm/compose.env up --detach --wait",
+	}, mockExecClient.Commands)
+}
+
+func TestDockerClient_ComposeUp_Timeout(t *testing.T) {
+	// Arrange: create an instance of DockerClient with the mock ExecClient
+	mockExecClient := &MockExecClient{}
+	dockerClient := NewDockerClient(mockExecClient)
+
+	// Act: start a release with a timeout
+	err := dockerClient.ComposeUp(ComposeUpOptions{
+		Project:       "app",
+		ComposeFile:   "/srv/app/compose.yaml",
+		ImageArchives: []string{"/assets/app/v1/app.image.tar"},
+		Timeout:       20 * time.Minute,
+	})
+
+	// Assert: check if every docker command gets the timeout
+	assert.NoError(t, err)
+	assert.Equal(t, []time.Duration{20 * time.Minute, 20 * time.Minute}, mockExecClient.Timeouts)
+}
+
+func TestDockerClient_ComposeUp_LoadError(t *testing.T) {
+	// Arrange: create a mock ExecClient that fails to load images
+	mockExecClient := &MockExecClient{Errors: map[string]string{"load": "invalid tar header"}}
+	dockerClient := NewDockerClient(mockExecClient)
+
+	// Act: start a release with an image archive
+	err := dockerClient.ComposeUp(ComposeUpOptions{
+		Project:       "app",
+		ComposeFile:   "/srv/app/compose.yaml",
+		ImageArchives: []string{"/assets/app/v1/app.image.tar"},
+	})
+
+	// Assert: check if the output is part of the error and compose is not run
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid tar header")
+	assert.Len(t, mockExecClient.Commands, 1)
+}
+
+func TestDockerClient_ComposeUp_ComposeError(t *testing.T) {
+	// Arrange: create a mock ExecClient that fails to start the project
+	mockExecClient := &MockExecClient{Errors: map[string]string{"compose": "container app-web-1 is unhealthy"}}
+	dockerClient := NewDockerClient(mockExecClient)
+
+	// Act: start a release
+	err := dockerClient.ComposeUp(ComposeUpOptions{Project: "app", ComposeFile: "/srv/app/compose.yaml"})
+
+	// Assert: check if the output is part of the error
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "container app-web-1 is unhealthy")
+}
+
+func TestDockerClient_ComposeUp_EmptyParams(t *testing.T) {
+	// Act: start a release without a project
+	err := NewDockerClient(&MockExecClient{}).ComposeUp(ComposeUpOptions{ComposeFile: "/srv/app/compose.yaml"})
+
+	// Assert: check if there was an error
+	assert.Error(t, err)
+}
+
+func TestFindComposeFile(t *testing.T) {
+	// Arrange: create a directory with a docker-compose.yml file
+	dir := t.TempDir()
+	os.WriteFile(path.Join(dir, "docker-compose.yml"), []byte("services: {}"), 0644)
+	os.WriteFile(path.Join(dir, "compose.prod.yaml"), []byte("services: {}"), 0644)
+
+	// Act/Assert: check if the default file names are looked up
+	composeFile, err := FindComposeFile(dir, "")
+	assert.NoError(t, err)
+	assert.Equal(t, path.Join(dir, "docker-compose.yml"), composeFile)
+
+	// Act/Assert: check if a configured file name is used
+	composeFile, err = FindComposeFile(dir, "compose.prod.yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, path.Join(dir, "compose.prod.yaml"), composeFile)
+
+	// Act/Assert: check if missing files and files outside of the directory fail
+	_, err = FindComposeFile(dir, "compose.yaml")
+	assert.Error(t, err)
+	_, err = FindComposeFile(dir, "../compose.yaml")
+	assert.Error(t, err)
+}
+
+func TestFindImageArchives(t *testing.T) {
+	// Arrange: create a directory with two image archives and a site archive
+	dir := t.TempDir()
+	os.WriteFile(path.Join(dir, "web.image.tar"), []byte("web"), 0644)
+	os.WriteFile(path.Join(dir, "api.image.tar"), []byte("api"), 0644)
+	os.WriteFile(path.Join(dir, "site.tar"), []byte("site"), 0644)
+
+	// Act: find the image archives
+	archives, err := FindImageArchives(dir, []string{"*.image.tar", "api.*"})
+
+	// Assert: check if every archive is found once
+	assert.NoError(t, err)
+	assert.Equal(t, []string{path.Join(dir, "api.image.tar"), path.Join(dir, "web.image.tar")}, archives)
+}
+
+func TestWriteEnvFile(t *testing.T) {
+	envFile := path.Join(t.TempDir(), ".deploy-to-vm", "compose.env")
+
+	// Act: write the env file with the default tag variable
+	err := WriteEnvFile(envFile, "", "v1.2.3")
+
+	// Assert: check if the tag is written
+	assert.NoError(t, err)
+	data, _ := os.ReadFile(envFile)
+	assert.Equal(t, "IMAGE_TAG='v1.2.3'\n", string(data))
+
+	// Act: write a tag with characters compose would interpolate
+	err = WriteEnvFile(envFile, "APP_TAG", "v1$HOME #1")
+
+	// Assert: check if the tag is quoted
+	assert.NoError(t, err)
+	data, _ = os.ReadFile(envFile)
+	assert.Equal(t, "APP_TAG='v1$HOME #1'\n", string(data))
+
+	// Act/Assert: check if tags cannot inject variables or escape the quotes
+	assert.Error(t, WriteEnvFile(envFile, "", "v1\nOTHER=1"))
+	assert.Error(t, WriteEnvFile(envFile, "", "v1'\\''"))
+	assert.Error(t, WriteEnvFile(envFile, "OTHER=1\nIMAGE_TAG", "v1"))
+	assert.Error(t, WriteEnvFile(envFile, "1TAG", "v1"))
+}
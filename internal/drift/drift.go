@@ -0,0 +1,253 @@
+package drift
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"deploy-to-vm/internal/bluegreen"
+	"deploy-to-vm/internal/config"
+	file_utils "deploy-to-vm/internal/file-utils"
+	"deploy-to-vm/internal/manifest"
+	"deploy-to-vm/internal/notification"
+)
+
+const (
+	// Policy_Allow deploys over a drifted site without checking it. This is
+	// the default policy.
+	Policy_Allow = "allow"
+	// Policy_Warn deploys over a drifted site and reports the drift
+	Policy_Warn = "warn"
+	// Policy_Block refuses to deploy over a drifted site
+	Policy_Block = "block"
+)
+
+// SiteReport describes the drift of the site directory of a target.
+type SiteReport struct {
+	Target string                 `json:"target"`
+	Dir    string                 `json:"dir"`
+	Site   *manifest.VerifyResult `json:"site"`
+}
+
+// Report describes the drift of the site directories of a repository from
+// its active release.
+type Report struct {
+	Owner string        `json:"owner"`
+	Repo  string        `json:"repo"`
+	Tag   string        `json:"tag"`
+	Sites []*SiteReport `json:"sites"`
+}
+
+// Checks if a site directory differs from the active release
+func (r *Report) IsDrifted() bool {
+	for _, site := range r.Sites {
+		if !site.Site.IsClean() {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the drifted files, one per line. With several targets, each file is
+// followed by its target.
+func (r *Report) Details() string {
+	lines := make([]string, 0)
+	for _, site := range r.Sites {
+		suffix := ""
+		if len(r.Sites) > 1 {
+			suffix = " (" + site.Target + ")"
+		}
+		for _, file := range site.Site.Modified {
+			lines = append(lines, "modified: "+file+suffix)
+		}
+		for _, file := range site.Site.Added {
+			lines = append(lines, "added: "+file+suffix)
+		}
+		for _, file := range site.Site.Missing {
+			lines = append(lines, "missing: "+file+suffix)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Returns the policy for drifted sites, defaulting to Policy_Allow
+func GetPolicy(driftConfig config.DeployToVmConfigDrift) (string, error) {
+	switch driftConfig.Policy {
+	case "":
+		return Policy_Allow, nil
+	case Policy_Allow, Policy_Warn, Policy_Block:
+		return driftConfig.Policy, nil
+	default:
+		return "", fmt.Errorf("Unknown drift policy: %q", driftConfig.Policy)
+	}
+}
+
+// Verifies the site directories of the targets of a repository against a
+// release manifest, using the root directory and included files the release
+// was deployed with. Targets without a site directory are skipped.
+func VerifySites(releaseManifest *manifest.Manifest, repositoryConfig *config.DeployToVmConfigRepository) ([]*SiteReport, error) {
+	targetConfigs := repositoryConfig.GetTargets()
+	sites := make([]*SiteReport, 0, len(targetConfigs))
+	for i := range targetConfigs {
+		targetConfig := &targetConfigs[i]
+		if targetConfig.Dir == "" {
+			continue
+		}
+
+		siteDir, siteDirErr := bluegreen.TargetSiteDir(targetConfig)
+		if siteDirErr != nil {
+			return nil, siteDirErr
+		}
+		manifestTarget := releaseManifest.GetTarget(targetConfig.Name)
+		if manifestTarget == nil {
+			// The manifest was written before targets were recorded
+			manifestTarget = &manifest.Target{Name: targetConfig.Name, RootDir: targetConfig.RootDir, Include: targetConfig.Include}
+		}
+		siteResult, verifyErr := releaseManifest.VerifyTargetSite(*manifestTarget, siteDir, repositoryConfig.Sync.ProtectedPaths)
+		if verifyErr != nil {
+			return nil, verifyErr
+		}
+		sites = append(sites, &SiteReport{Target: targetConfig.Name, Dir: siteDir, Site: siteResult})
+	}
+	return sites, nil
+}
+
+// Compares the site directories of a repository with the manifest of its
+// active release. It returns nil if there is nothing to compare with, i.e. the
+// repository has no site directory, no release was activated yet or the
+// active release has no manifest.
+func Check(assetsDir string, repositoryConfig *config.DeployToVmConfigRepository) (*Report, error) {
+	hasSiteDir := false
+	for _, targetConfig := range repositoryConfig.GetTargets() {
+		hasSiteDir = hasSiteDir || targetConfig.Dir != ""
+	}
+	if !hasSiteDir {
+		return nil, nil
+	}
+
+	tag, activeErr := file_utils.GetActiveRelease(assetsDir, repositoryConfig.Owner, repositoryConfig.Name)
+	if activeErr != nil {
+		return nil, activeErr
+	}
+	if tag == "" {
+		return nil, nil
+	}
+
+	releaseDir := file_utils.ReleaseDirPath(assetsDir, repositoryConfig.Owner, repositoryConfig.Name, tag)
+	releaseManifest, readErr := manifest.Read(releaseDir)
+	if errors.Is(readErr, os.ErrNotExist) {
+		log.Printf("Active release %s of %s/%s has no manifest, skipping drift check", tag, repositoryConfig.Owner, repositoryConfig.Name)
+		return nil, nil
+	}
+	if readErr != nil {
+		return nil, readErr
+	}
+
+	sites, verifyErr := VerifySites(releaseManifest, repositoryConfig)
+	if verifyErr != nil {
+		return nil, verifyErr
+	}
+
+	return &Report{
+		Owner: repositoryConfig.Owner,
+		Repo:  repositoryConfig.Name,
+		Tag:   tag,
+		Sites: sites,
+	}, nil
+}
+
+// Checker periodically checks the site directories for drift and sends a
+// notification when the drift of a site changes.
+type Checker struct {
+	AssetsDir          string
+	ConfigClient       config.ConfigClientInterface
+	NotificationClient notification.NotificationClientInterface
+
+	mu sync.Mutex
+	// lastDetails holds the last reported drift per repository, so the same
+	// drift is only notified once
+	lastDetails map[string]string
+}
+
+func NewChecker(assetsDir string, configClient config.ConfigClientInterface, notificationClient notification.NotificationClientInterface) *Checker {
+	return &Checker{
+		AssetsDir:          assetsDir,
+		ConfigClient:       configClient,
+		NotificationClient: notificationClient,
+		lastDetails:        make(map[string]string),
+	}
+}
+
+// Checks a repository for drift and notifies about new drift
+func (c *Checker) CheckRepository(repositoryConfig *config.DeployToVmConfigRepository) (*Report, error) {
+	report, checkErr := Check(c.AssetsDir, repositoryConfig)
+	if checkErr != nil || report == nil {
+		return report, checkErr
+	}
+
+	key := repositoryConfig.Owner + "/" + repositoryConfig.Name
+	details := ""
+	if report.IsDrifted() {
+		details = report.Tag + "\n" + report.Details()
+	}
+
+	c.mu.Lock()
+	changed := c.lastDetails[key] != details
+	c.lastDetails[key] = details
+	c.mu.Unlock()
+
+	if !report.IsDrifted() || !changed {
+		return report, nil
+	}
+
+	log.Printf("Site directory of %s drifted from the active release %s:\n%s", key, report.Tag, report.Details())
+	if c.NotificationClient != nil {
+		message := fmt.Sprintf("Site directory drifted from the active release: `repo:%s` `tag:%s`\\n\\n```\\n%s\\n```", repositoryConfig.Name, report.Tag, strings.ReplaceAll(report.Details(), "\n", "\\n"))
+		if notifyErr := c.NotificationClient.Notify(message); notifyErr != nil {
+			log.Printf("Failed to send notification: \"%v\"", notifyErr)
+		}
+	}
+	return report, nil
+}
+
+// Starts a background check for every repository with a check interval. The
+// checks stop when the context is cancelled.
+func (c *Checker) Start(ctx context.Context) error {
+	cfg := c.ConfigClient.GetConfig()
+	if cfg == nil {
+		return nil
+	}
+
+	for i := range cfg.Repositories {
+		repositoryConfig := &cfg.Repositories[i]
+		interval, intervalErr := repositoryConfig.Drift.GetCheckInterval()
+		if intervalErr != nil {
+			return fmt.Errorf("Invalid drift config for %s/%s: %v", repositoryConfig.Owner, repositoryConfig.Name, intervalErr)
+		}
+		if interval == 0 {
+			continue
+		}
+
+		log.Printf("Checking %s/%s for drift every %s", repositoryConfig.Owner, repositoryConfig.Name, interval)
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					if _, checkErr := c.CheckRepository(repositoryConfig); checkErr != nil {
+						log.Printf("Failed to check %s/%s for drift: \"%v\"", repositoryConfig.Owner, repositoryConfig.Name, checkErr)
+					}
+				}
+			}
+		}()
+	}
+	return nil
+}
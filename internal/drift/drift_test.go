@@ -0,0 +1,141 @@
+package drift
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"deploy-to-vm/internal/config"
+	file_utils "deploy-to-vm/internal/file-utils"
+	"deploy-to-vm/internal/manifest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type MockNotificationClient struct {
+	Messages []string
+}
+
+func (m *MockNotificationClient) LoadWebhookUrl() error {
+	return nil
+}
+
+func (m *MockNotificationClient) Notify(message string) error {
+	m.Messages = append(m.Messages, message)
+	return nil
+}
+
+// Helper to deploy an active release with a manifest and return its repository
+// config
+func setupDriftTest(t *testing.T) (string, *config.DeployToVmConfigRepository) {
+	assetsDir := t.TempDir()
+	siteDir := t.TempDir()
+
+	releaseDir := file_utils.ReleaseDirPath(assetsDir, "owner", "repo", "v1")
+	os.MkdirAll(releaseDir, 0755)
+	os.WriteFile(path.Join(releaseDir, "index.html"), []byte("index"), 0644)
+	files, _ := manifest.HashFiles(releaseDir)
+	manifest.Write(releaseDir, &manifest.Manifest{Version: manifest.Version, Tag: "v1", Files: files, CreatedAt: time.Now()})
+	file_utils.LinkReleaseAssetsToSiteDir(releaseDir, siteDir)
+	file_utils.SetActiveRelease(assetsDir, "owner", "repo", "v1")
+
+	return assetsDir, &config.DeployToVmConfigRepository{Name: "repo", Owner: "owner", TargetDir: siteDir}
+}
+
+func TestCheck_NoActiveRelease(t *testing.T) {
+	// Arrange: create a repository that was never deployed
+	repositoryConfig := &config.DeployToVmConfigRepository{Name: "repo", Owner: "owner", TargetDir: t.TempDir()}
+
+	// Act: check the repository for drift
+	report, err := Check(t.TempDir(), repositoryConfig)
+
+	// Assert: check if there is nothing to report
+	assert.NoError(t, err)
+	assert.Nil(t, report)
+}
+
+func TestCheck_Clean(t *testing.T) {
+	// Arrange: deploy a release
+	assetsDir, repositoryConfig := setupDriftTest(t)
+
+	// Act: check the repository for drift
+	report, err := Check(assetsDir, repositoryConfig)
+
+	// Assert: check if the site matches the active release
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", report.Tag)
+	assert.False(t, report.IsDrifted())
+}
+
+func TestCheck_Drifted(t *testing.T) {
+	// Arrange: deploy a release and edit the site by hand
+	assetsDir, repositoryConfig := setupDriftTest(t)
+	os.Remove(path.Join(repositoryConfig.TargetDir, "index.html"))
+	os.WriteFile(path.Join(repositoryConfig.TargetDir, "index.html"), []byte("hotfix"), 0644)
+	os.WriteFile(path.Join(repositoryConfig.TargetDir, "debug.php"), []byte("debug"), 0644)
+
+	// Act: check the repository for drift
+	report, err := Check(assetsDir, repositoryConfig)
+
+	// Assert: check if the drift is reported
+	assert.NoError(t, err)
+	assert.True(t, report.IsDrifted())
+	assert.Equal(t, "modified: index.html\nadded: debug.php", report.Details())
+}
+
+func TestCheck_MultipleTargets(t *testing.T) {
+	// Arrange: deploy a release to two targets and edit one of them by hand
+	assetsDir, repositoryConfig := setupDriftTest(t)
+	otherSiteDir := t.TempDir()
+	releaseDir := file_utils.ReleaseDirPath(assetsDir, "owner", "repo", "v1")
+	file_utils.LinkReleaseAssetsToSiteDir(releaseDir, otherSiteDir)
+	os.WriteFile(path.Join(otherSiteDir, "debug.php"), []byte("debug"), 0644)
+	repositoryConfig.Targets = []config.DeployToVmConfigTarget{
+		{Name: "web", Type: "nginx", Dir: repositoryConfig.TargetDir},
+		{Name: "admin", Type: "nginx", Dir: otherSiteDir},
+	}
+
+	// Act: check the repository for drift
+	report, err := Check(assetsDir, repositoryConfig)
+
+	// Assert: check if the drift of the second target is reported
+	assert.NoError(t, err)
+	assert.Len(t, report.Sites, 2)
+	assert.True(t, report.IsDrifted())
+	assert.Equal(t, "added: debug.php (admin)", report.Details())
+}
+
+func TestGetPolicy(t *testing.T) {
+	policy, err := GetPolicy(config.DeployToVmConfigDrift{})
+	assert.NoError(t, err)
+	assert.Equal(t, Policy_Allow, policy)
+
+	policy, err = GetPolicy(config.DeployToVmConfigDrift{Policy: "block"})
+	assert.NoError(t, err)
+	assert.Equal(t, Policy_Block, policy)
+
+	_, err = GetPolicy(config.DeployToVmConfigDrift{Policy: "ignore"})
+	assert.Error(t, err)
+}
+
+func TestChecker_CheckRepository_NotifiesOnce(t *testing.T) {
+	// Arrange: deploy a release and edit the site by hand
+	assetsDir, repositoryConfig := setupDriftTest(t)
+	os.WriteFile(path.Join(repositoryConfig.TargetDir, "debug.php"), []byte("debug"), 0644)
+	notificationClient := &MockNotificationClient{}
+	checker := NewChecker(assetsDir, &config.ConfigClient{}, notificationClient)
+
+	// Act: check the repository twice, then change the drift
+	_, firstErr := checker.CheckRepository(repositoryConfig)
+	_, secondErr := checker.CheckRepository(repositoryConfig)
+	os.WriteFile(path.Join(repositoryConfig.TargetDir, "shell.php"), []byte("shell"), 0644)
+	_, thirdErr := checker.CheckRepository(repositoryConfig)
+
+	// Assert: check if the same drift is only notified once
+	assert.NoError(t, firstErr)
+	assert.NoError(t, secondErr)
+	assert.NoError(t, thirdErr)
+	assert.Len(t, notificationClient.Messages, 2)
+	assert.Contains(t, notificationClient.Messages[1], "added: shell.php")
+}
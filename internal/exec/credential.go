@@ -0,0 +1,138 @@
+package exec
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+)
+
+// Credential is a user, other than the one of the server, that commands run
+// as.
+type Credential struct {
+	Uid    uint32
+	Gid    uint32
+	Groups []uint32
+	// Username and HomeDir are passed to the command in USER, LOGNAME and
+	// HOME, so tools like pm2 use the files of the user instead of the ones
+	// of the server
+	Username string
+	HomeDir  string
+}
+
+// Returns the variables that describe the user to a command
+func (c *Credential) env() []string {
+	return []string{"HOME=" + c.HomeDir, "USER=" + c.Username, "LOGNAME=" + c.Username}
+}
+
+// Returns whether the credential is the user and group of the server, so
+// commands run as it without switching
+func (c *Credential) isCurrent() bool {
+	return int(c.Uid) == os.Getuid() && int(c.Gid) == os.Getgid()
+}
+
+// Checks that commands can run as the credential. Switching to another user
+// needs root.
+func (c *Credential) Check() error {
+	if c == nil || c.isCurrent() {
+		return nil
+	}
+	return checkSetCredential(c)
+}
+
+// Parses a numeric id of a user or group
+func parseId(id string) (uint32, error) {
+	parsed, parseErr := strconv.ParseUint(id, 10, 32)
+	if parseErr != nil {
+		return 0, fmt.Errorf("Invalid id %q: %v", id, parseErr)
+	}
+	return uint32(parsed), nil
+}
+
+// Resolves a user and a group, given as names or numeric ids, to the
+// credential commands run with. The group defaults to the primary group of
+// the user, and the user keeps its supplementary groups. Returns nil if no
+// user is given.
+func LookupCredential(userName string, groupName string) (*Credential, error) {
+	if userName == "" {
+		if groupName != "" {
+			return nil, fmt.Errorf("A group to run as requires a user")
+		}
+		return nil, nil
+	}
+
+	var u *user.User
+	var lookupErr error
+	if _, parseErr := strconv.Atoi(userName); parseErr == nil {
+		u, lookupErr = user.LookupId(userName)
+	} else {
+		u, lookupErr = user.Lookup(userName)
+	}
+	if lookupErr != nil {
+		return nil, fmt.Errorf("Failed to look up user %q: %w", userName, lookupErr)
+	}
+
+	uid, uidErr := parseId(u.Uid)
+	if uidErr != nil {
+		return nil, uidErr
+	}
+	gid, gidErr := parseId(u.Gid)
+	if gidErr != nil {
+		return nil, gidErr
+	}
+	if groupName != "" {
+		var g *user.Group
+		if _, parseErr := strconv.Atoi(groupName); parseErr == nil {
+			g, lookupErr = user.LookupGroupId(groupName)
+		} else {
+			g, lookupErr = user.LookupGroup(groupName)
+		}
+		if lookupErr != nil {
+			return nil, fmt.Errorf("Failed to look up group %q: %w", groupName, lookupErr)
+		}
+		if gid, gidErr = parseId(g.Gid); gidErr != nil {
+			return nil, gidErr
+		}
+	}
+
+	groupIds, groupsErr := u.GroupIds()
+	if groupsErr != nil {
+		return nil, fmt.Errorf("Failed to look up the groups of user %q: %w", userName, groupsErr)
+	}
+	groups := make([]uint32, 0, len(groupIds))
+	for _, groupId := range groupIds {
+		id, idErr := parseId(groupId)
+		if idErr != nil {
+			return nil, idErr
+		}
+		groups = append(groups, id)
+	}
+
+	return &Credential{
+		Uid:      uid,
+		Gid:      gid,
+		Groups:   groups,
+		Username: u.Username,
+		HomeDir:  u.HomeDir,
+	}, nil
+}
+
+// CredentialExecClient is an ExecClientInterface that runs every command of
+// ExecClient as Credential.
+type CredentialExecClient struct {
+	ExecClient ExecClientInterface
+	Credential *Credential
+}
+
+func (c *CredentialExecClient) Command(command string, args ...string) ExecCommandInterface {
+	return c.ExecClient.Command(command, args...).WithCredential(c.Credential)
+}
+
+// Returns an ExecClient that runs its commands as the credential, or the
+// ExecClient itself if the credential is nil
+func WithCredential(execClient ExecClientInterface, credential *Credential) ExecClientInterface {
+	if credential == nil {
+		return execClient
+	}
+	return &CredentialExecClient{ExecClient: execClient, Credential: credential}
+}
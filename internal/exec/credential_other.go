@@ -0,0 +1,17 @@
+//go:build !unix
+
+package exec
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// Credentials of child processes are only supported on unix
+func checkSetCredential(credential *Credential) error {
+	return errors.New("Running commands as another user is not supported on this platform")
+}
+
+func setCredential(cmd *exec.Cmd, credential *Credential) error {
+	return checkSetCredential(credential)
+}
@@ -0,0 +1,105 @@
+package exec
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookupCredential(t *testing.T) {
+	// Arrange: get the user that runs the tests
+	current, _ := user.Current()
+	uid, _ := strconv.Atoi(current.Uid)
+	gid, _ := strconv.Atoi(current.Gid)
+
+	// Act: look up the user by name and by id
+	byName, byNameErr := LookupCredential(current.Username, "")
+	byId, byIdErr := LookupCredential(current.Uid, current.Gid)
+
+	// Assert: check if both resolve to the user and its primary group
+	assert.NoError(t, byNameErr)
+	assert.NoError(t, byIdErr)
+	assert.Equal(t, uint32(uid), byName.Uid)
+	assert.Equal(t, uint32(gid), byName.Gid)
+	assert.Equal(t, current.HomeDir, byName.HomeDir)
+	assert.Equal(t, byName, byId)
+}
+
+func TestLookupCredential_Invalid(t *testing.T) {
+	// Act/Assert: check if no user resolves to no credential
+	credential, err := LookupCredential("", "")
+	assert.NoError(t, err)
+	assert.Nil(t, credential)
+
+	// Act/Assert: check if invalid users and groups fail
+	_, err = LookupCredential("", "www-data")
+	assert.EqualError(t, err, "A group to run as requires a user")
+	_, err = LookupCredential("deploy-to-vm-missing-user", "")
+	assert.Error(t, err)
+	current, _ := user.Current()
+	_, err = LookupCredential(current.Username, "deploy-to-vm-missing-group")
+	assert.Error(t, err)
+}
+
+func TestWithCredential(t *testing.T) {
+	// Arrange: create a fake ExecClient that runs as a user
+	credential := &Credential{Uid: 1001, Gid: 1001, Username: "app", HomeDir: "/home/app"}
+	fakeExecClient := &FakeExecClient{}
+
+	// Act: run a command
+	WithCredential(fakeExecClient, credential).Command("pm2", "jlist").CombinedOutput()
+
+	// Assert: check if the command runs with the credential
+	assert.Equal(t, credential, fakeExecClient.Commands()[0].Credential)
+	assert.Equal(t, fakeExecClient, WithCredential(fakeExecClient, nil))
+}
+
+func TestExecCommand_WithCredential(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Changing the credential of a command requires root")
+	}
+
+	// Arrange: create a credential of a user without a password entry
+	credential := &Credential{Uid: 65534, Gid: 65534, Groups: []uint32{}, Username: "nobody", HomeDir: "/nonexistent"}
+
+	// Act: print the ids and the variables of the user
+	result, err := (&ExecClient{}).Command("sh", "-c", "id -u; id -g; echo $HOME $USER").WithCredential(credential).Run()
+
+	// Assert: check if the command runs as the user
+	assert.NoError(t, err)
+	assert.Equal(t, "65534\n65534\n/nonexistent nobody\n", string(result.Stdout))
+}
+
+func TestExecCommand_WithCredential_CurrentUser(t *testing.T) {
+	// Arrange: create the credential of the user that runs the tests
+	current, _ := user.Current()
+	credential, _ := LookupCredential(current.Username, "")
+
+	// Act: print the id of the user
+	result, err := (&ExecClient{}).Command("id", "-u").WithCredential(credential).Run()
+
+	// Assert: check if the command runs without switching, which works
+	// without root
+	assert.NoError(t, err)
+	assert.Equal(t, current.Uid+"\n", string(result.Stdout))
+	assert.NoError(t, credential.Check())
+}
+
+func TestCredential_Check_NotRoot(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("Root can switch to any user")
+	}
+
+	// Arrange: create the credential of another user
+	credential := &Credential{Uid: uint32(os.Getuid() + 1), Gid: uint32(os.Getgid()), Username: "app"}
+
+	// Act: run a command as the user
+	_, err := (&ExecClient{}).Command("true").WithCredential(credential).Run()
+
+	// Assert: check if switching fails with a clear error
+	assert.EqualError(t, err, "Running commands as user app requires deploy-to-vm to run as root")
+	assert.EqualError(t, credential.Check(), "Running commands as user app requires deploy-to-vm to run as root")
+}
@@ -0,0 +1,35 @@
+//go:build unix
+
+package exec
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// Checks that the server can switch to the user of the credential, which
+// needs root as the supplementary groups are set too
+func checkSetCredential(credential *Credential) error {
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("Running commands as user %s requires deploy-to-vm to run as root", credential.Username)
+	}
+	return nil
+}
+
+// Runs the command as the user of the credential
+func setCredential(cmd *exec.Cmd, credential *Credential) error {
+	if err := checkSetCredential(credential); err != nil {
+		return err
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = &syscall.Credential{
+		Uid:    credential.Uid,
+		Gid:    credential.Gid,
+		Groups: credential.Groups,
+	}
+	return nil
+}
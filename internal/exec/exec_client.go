@@ -1,19 +1,16 @@
 package exec
 
-import (
-	"os/exec"
-)
-
 type ExecClient struct{}
 
 type ExecClientInterface interface {
 	Command(command string, args ...string) ExecCommandInterface
 }
 
+// Returns a command that runs when one of its output methods is called. The
+// command can be configured with the With* methods before.
 func (execClient *ExecClient) Command(command string, args ...string) ExecCommandInterface {
-	cmd := exec.Command(command, args...)
-
 	return &ExecCommand{
-		cmd: cmd,
+		name: command,
+		args: args,
 	}
 }
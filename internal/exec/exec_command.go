@@ -1,22 +1,178 @@
 package exec
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"os"
 	"os/exec"
+	"sync"
+	"time"
 )
 
+// DefaultTimeout is how long a command may run if it has neither a timeout
+// nor a context with a deadline, so a hung command can't block forever
+const DefaultTimeout = 10 * time.Minute
+
+// killGracePeriod is how long the output of a killed command is waited for,
+// in case a process outside of its process group still holds it open
+const killGracePeriod = 5 * time.Second
+
+// Result is the outcome of a command that ran.
+type Result struct {
+	Stdout []byte
+	Stderr []byte
+	// ExitCode is the exit code of the command, or -1 if it was killed or did
+	// not start
+	ExitCode int
+}
+
 type ExecCommand struct {
-	cmd *exec.Cmd
+	name       string
+	args       []string
+	ctx        context.Context
+	timeout    time.Duration
+	dir        string
+	env        []string
+	sink       LogSink
+	credential *Credential
 }
 
+// ExecCommandInterface is a command that is configured with the With* methods
+// and run with CombinedOutput or Run.
 type ExecCommandInterface interface {
+	// WithContext kills the command when the context is done
+	WithContext(ctx context.Context) ExecCommandInterface
+	// WithTimeout kills the command if it runs longer than the timeout
+	WithTimeout(timeout time.Duration) ExecCommandInterface
+	// WithDir sets the working directory of the command
+	WithDir(dir string) ExecCommandInterface
+	// WithEnv adds "KEY=value" variables to the environment inherited from
+	// the server
+	WithEnv(env ...string) ExecCommandInterface
+	// WithLogSink streams the output into the log sink line by line while the
+	// command runs
+	WithLogSink(sink LogSink) ExecCommandInterface
+	// WithCredential runs the command as another user
+	WithCredential(credential *Credential) ExecCommandInterface
+	// CombinedOutput runs the command and returns its stdout and stderr
+	// interleaved
 	CombinedOutput() ([]byte, error)
+	// Run runs the command and returns its stdout, stderr and exit code
+	Run() (*Result, error)
+}
+
+func (execCmd *ExecCommand) WithContext(ctx context.Context) ExecCommandInterface {
+	execCmd.ctx = ctx
+	return execCmd
+}
+
+func (execCmd *ExecCommand) WithTimeout(timeout time.Duration) ExecCommandInterface {
+	execCmd.timeout = timeout
+	return execCmd
+}
+
+func (execCmd *ExecCommand) WithDir(dir string) ExecCommandInterface {
+	execCmd.dir = dir
+	return execCmd
+}
+
+func (execCmd *ExecCommand) WithEnv(env ...string) ExecCommandInterface {
+	execCmd.env = append(execCmd.env, env...)
+	return execCmd
+}
+
+func (execCmd *ExecCommand) WithLogSink(sink LogSink) ExecCommandInterface {
+	execCmd.sink = sink
+	return execCmd
+}
+
+func (execCmd *ExecCommand) WithCredential(credential *Credential) ExecCommandInterface {
+	execCmd.credential = credential
+	return execCmd
+}
+
+// Runs the command with the given outputs. The command and every process it
+// starts are killed when the timeout or the context is over.
+func (execCmd *ExecCommand) run(stdout io.Writer, stderr io.Writer) (int, error) {
+	ctx := execCmd.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	timeout := execCmd.timeout
+	if _, hasDeadline := ctx.Deadline(); timeout <= 0 && !hasDeadline {
+		timeout = DefaultTimeout
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, execCmd.name, execCmd.args...)
+	cmd.Dir = execCmd.dir
+	env := execCmd.env
+	if execCmd.credential != nil {
+		// The variables of the command take precedence over the ones of the user
+		env = append(execCmd.credential.env(), env...)
+	}
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if execCmd.sink != nil {
+		mutex := &sync.Mutex{}
+		stdoutWriter := &lineWriter{mutex: mutex, output: stdout, sink: execCmd.sink, stream: Stream_Stdout}
+		stderrWriter := &lineWriter{mutex: mutex, output: stderr, sink: execCmd.sink, stream: Stream_Stderr}
+		defer stdoutWriter.flush()
+		defer stderrWriter.flush()
+		cmd.Stdout = stdoutWriter
+		cmd.Stderr = stderrWriter
+	}
+	cmd.WaitDelay = killGracePeriod
+	setProcessGroup(cmd)
+	// Commands that run as the user of the server don't switch, which would
+	// need root
+	if execCmd.credential != nil && !execCmd.credential.isCurrent() {
+		if err := setCredential(cmd, execCmd.credential); err != nil {
+			return -1, err
+		}
+	}
+
+	runErr := cmd.Run()
+	exitCode := -1
+	if cmd.ProcessState != nil {
+		exitCode = cmd.ProcessState.ExitCode()
+	}
+	if runErr != nil && ctx.Err() != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) && timeout > 0 {
+			return exitCode, fmt.Errorf("Command %s timed out after %s: %w", execCmd.name, timeout, ctx.Err())
+		}
+		return exitCode, fmt.Errorf("Command %s was canceled: %w", execCmd.name, ctx.Err())
+	}
+	return exitCode, runErr
 }
 
 func (execCmd *ExecCommand) CombinedOutput() ([]byte, error) {
-	if execCmd.cmd == nil {
+	if execCmd.name == "" {
 		log.Println("ExecCommand is nil, cannot execute command")
 		return nil, nil
 	}
-	return execCmd.cmd.CombinedOutput()
+	var output bytes.Buffer
+	_, err := execCmd.run(&output, &output)
+	return output.Bytes(), err
+}
+
+func (execCmd *ExecCommand) Run() (*Result, error) {
+	if execCmd.name == "" {
+		log.Println("ExecCommand is nil, cannot execute command")
+		return &Result{ExitCode: -1}, nil
+	}
+	var stdout, stderr bytes.Buffer
+	exitCode, err := execCmd.run(&stdout, &stderr)
+	return &Result{Stdout: stdout.Bytes(), Stderr: stderr.Bytes(), ExitCode: exitCode}, err
 }
@@ -1,21 +1,24 @@
 package exec
 
 import (
-	"os/exec"
+	"context"
+	"errors"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
 func TestExecCommand_CombinedOutput_NilCmd(t *testing.T) {
-	execCmd := &ExecCommand{cmd: nil}
+	execCmd := &ExecCommand{}
 	output, err := execCmd.CombinedOutput()
 	assert.Nil(t, output)
 	assert.NoError(t, err)
 }
 
 func TestExecCommand_CombinedOutput_Success(t *testing.T) {
-	execCommand := exec.Command("echo", "test-output")
+	execCommand := &ExecCommand{name: "echo", args: []string{"test-output"}}
 
 	output, err := execCommand.CombinedOutput()
 	assert.NoError(t, err)
@@ -23,10 +26,109 @@ func TestExecCommand_CombinedOutput_Success(t *testing.T) {
 }
 
 func TestExecCommand_CombinedOutput_Error(t *testing.T) {
-	cmd := exec.Command("cat", "non-existent-file.txt")
-	execCommand := &ExecCommand{cmd: cmd}
+	execCommand := &ExecCommand{name: "cat", args: []string{"non-existent-file.txt"}}
 
 	output, err := execCommand.CombinedOutput()
 	assert.Error(t, err)
 	assert.Contains(t, string(output), "cat: non-existent-file.txt: No such file or directory")
 }
+
+func TestExecCommand_Run_SeparateOutputs(t *testing.T) {
+	// Act: run a command that writes to stdout and stderr and fails
+	result, err := (&ExecClient{}).Command("sh", "-c", "echo out; echo err >&2; exit 3").Run()
+
+	// Assert: check if the outputs and the exit code are kept apart
+	assert.Error(t, err)
+	assert.Equal(t, "out\n", string(result.Stdout))
+	assert.Equal(t, "err\n", string(result.Stderr))
+	assert.Equal(t, 3, result.ExitCode)
+}
+
+func TestExecCommand_WithDirAndEnv(t *testing.T) {
+	// Arrange: create a working directory
+	dir := t.TempDir()
+
+	// Act: print the working directory and a variable
+	result, err := (&ExecClient{}).Command("sh", "-c", "pwd; echo $GREETING").WithDir(dir).WithEnv("GREETING=hello").Run()
+
+	// Assert: check if the command runs in the directory with the variable
+	assert.NoError(t, err)
+	assert.Equal(t, dir+"\nhello\n", string(result.Stdout))
+	assert.Equal(t, 0, result.ExitCode)
+}
+
+func TestExecCommand_WithTimeout_KillsProcessGroup(t *testing.T) {
+	// Act: run a command whose child process would keep the output open
+	start := time.Now()
+	result, err := (&ExecClient{}).Command("sh", "-c", "sleep 10 & wait").WithTimeout(100 * time.Millisecond).Run()
+
+	// Assert: check if the command and its child are killed at the timeout
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.Contains(t, err.Error(), "timed out after 100ms")
+	assert.Equal(t, -1, result.ExitCode)
+	assert.Less(t, int64(time.Since(start)), int64(killGracePeriod))
+}
+
+func TestExecCommand_WithContext(t *testing.T) {
+	// Arrange: create a context that is canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Act: run a command with the context
+	_, err := (&ExecClient{}).Command("sleep", "10").WithContext(ctx).CombinedOutput()
+
+	// Assert: check if the command is canceled
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+// MockLogSink records the lines it receives with the time since the command
+// started
+type MockLogSink struct {
+	mutex    sync.Mutex
+	start    time.Time
+	Lines    []string
+	Received []time.Duration
+}
+
+func (m *MockLogSink) WriteLine(stream string, line string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.Lines = append(m.Lines, stream+": "+line)
+	m.Received = append(m.Received, time.Since(m.start))
+}
+
+func TestExecCommand_WithLogSink(t *testing.T) {
+	// Arrange: create a log sink
+	sink := &MockLogSink{start: time.Now()}
+
+	// Act: run a command that writes a line, waits and writes more lines
+	result, err := (&ExecClient{}).Command("sh", "-c", "echo migrating; sleep 0.5; echo done; sleep 0.1; printf 'warning' >&2").WithLogSink(sink).Run()
+
+	// Assert: check if the lines are streamed while the command runs and the
+	// outputs are still returned
+	assert.NoError(t, err)
+	assert.Equal(t, "migrating\ndone\n", string(result.Stdout))
+	assert.Equal(t, "warning", string(result.Stderr))
+	assert.Equal(t, []string{"stdout: migrating", "stdout: done", "stderr: warning"}, sink.Lines)
+	assert.Less(t, int64(sink.Received[0]), int64(400*time.Millisecond))
+}
+
+func TestWithLogSink(t *testing.T) {
+	// Arrange: create a fake ExecClient that streams into a log sink
+	sink := &MockLogSink{}
+	fakeExecClient := &FakeExecClient{
+		Handler: func(command *FakeCommand) FakeResult {
+			return FakeResult{Stdout: "[PM2] Reloading app\n[PM2] Done\n"}
+		},
+	}
+
+	// Act: run a command
+	output, err := WithLogSink(fakeExecClient, sink).Command("pm2", "reload", "app").CombinedOutput()
+
+	// Assert: check if the output is streamed and returned
+	assert.NoError(t, err)
+	assert.Equal(t, "[PM2] Reloading app\n[PM2] Done\n", string(output))
+	assert.Equal(t, []string{"stdout: [PM2] Reloading app", "stdout: [PM2] Done"}, sink.Lines)
+	assert.Equal(t, fakeExecClient, WithLogSink(fakeExecClient, nil))
+}
@@ -0,0 +1,155 @@
+package exec
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeExecClient is an ExecClientInterface for unit tests. It runs no
+// processes but records the commands and answers them with Handler.
+type FakeExecClient struct {
+	// Handler returns the result of a command. Commands succeed without output
+	// if it is nil.
+	Handler  func(command *FakeCommand) FakeResult
+	mutex    sync.Mutex
+	commands []*FakeCommand
+}
+
+// FakeResult is the result of a fake command. A command with a non-zero exit
+// code fails with "exit status <code>" unless Err is set.
+type FakeResult struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	Err      error
+}
+
+// FakeCommand is a command of a FakeExecClient with the settings it was run
+// with.
+type FakeCommand struct {
+	Name       string
+	Args       []string
+	Context    context.Context
+	Timeout    time.Duration
+	Dir        string
+	Env        []string
+	Sink       LogSink
+	Credential *Credential
+	client     *FakeExecClient
+}
+
+// FakeExitError is the error of a fake command with a non-zero exit code.
+type FakeExitError struct {
+	Code int
+}
+
+func (e *FakeExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+func (f *FakeExecClient) Command(command string, args ...string) ExecCommandInterface {
+	return &FakeCommand{
+		Name:   command,
+		Args:   args,
+		client: f,
+	}
+}
+
+// Returns the commands that ran in order
+func (f *FakeExecClient) Commands() []*FakeCommand {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]*FakeCommand{}, f.commands...)
+}
+
+// Returns the command lines that ran in order, e.g. "pm2 reload app"
+func (f *FakeExecClient) CommandLines() []string {
+	lines := make([]string, 0)
+	for _, command := range f.Commands() {
+		lines = append(lines, command.String())
+	}
+	return lines
+}
+
+// Returns the command line, e.g. "pm2 reload app"
+func (c *FakeCommand) String() string {
+	return strings.TrimSpace(c.Name + " " + strings.Join(c.Args, " "))
+}
+
+func (c *FakeCommand) WithContext(ctx context.Context) ExecCommandInterface {
+	c.Context = ctx
+	return c
+}
+
+func (c *FakeCommand) WithTimeout(timeout time.Duration) ExecCommandInterface {
+	c.Timeout = timeout
+	return c
+}
+
+func (c *FakeCommand) WithDir(dir string) ExecCommandInterface {
+	c.Dir = dir
+	return c
+}
+
+func (c *FakeCommand) WithEnv(env ...string) ExecCommandInterface {
+	c.Env = append(c.Env, env...)
+	return c
+}
+
+func (c *FakeCommand) WithLogSink(sink LogSink) ExecCommandInterface {
+	c.Sink = sink
+	return c
+}
+
+func (c *FakeCommand) WithCredential(credential *Credential) ExecCommandInterface {
+	c.Credential = credential
+	return c
+}
+
+// Passes the lines of a fake output to the log sink
+func (c *FakeCommand) writeLines(stream string, output string) {
+	if c.Sink == nil || output == "" {
+		return
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(output, "\n"), "\n") {
+		c.Sink.WriteLine(stream, line)
+	}
+}
+
+// Records the command and returns its result
+func (c *FakeCommand) run() (FakeResult, error) {
+	c.client.mutex.Lock()
+	c.client.commands = append(c.client.commands, c)
+	c.client.mutex.Unlock()
+
+	result := FakeResult{}
+	if c.client.Handler != nil {
+		result = c.client.Handler(c)
+	}
+	c.writeLines(Stream_Stdout, result.Stdout)
+	c.writeLines(Stream_Stderr, result.Stderr)
+	if result.Err != nil {
+		return result, result.Err
+	}
+	if result.ExitCode != 0 {
+		return result, &FakeExitError{Code: result.ExitCode}
+	}
+	return result, nil
+}
+
+func (c *FakeCommand) CombinedOutput() ([]byte, error) {
+	result, err := c.run()
+	output := []byte(result.Stdout + result.Stderr)
+	if len(output) == 0 {
+		return nil, err
+	}
+	return output, err
+}
+
+func (c *FakeCommand) Run() (*Result, error) {
+	result, err := c.run()
+	return &Result{Stdout: []byte(result.Stdout), Stderr: []byte(result.Stderr), ExitCode: result.ExitCode}, err
+}
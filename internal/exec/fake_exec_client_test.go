@@ -0,0 +1,54 @@
+package exec
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakeExecClient(t *testing.T) {
+	// Arrange: create a fake that fails "pm2 reload"
+	fakeExecClient := &FakeExecClient{
+		Handler: func(command *FakeCommand) FakeResult {
+			if command.String() == "pm2 reload app" {
+				return FakeResult{Stderr: "[PM2][ERROR] Process app not found\n", ExitCode: 1}
+			}
+			return FakeResult{Stdout: "ok"}
+		},
+	}
+
+	// Act: run two commands
+	output, listErr := fakeExecClient.Command("pm2", "jlist").WithTimeout(time.Minute).CombinedOutput()
+	result, reloadErr := fakeExecClient.Command("pm2", "reload", "app").WithDir("/srv/app").WithEnv("A=1").Run()
+
+	// Assert: check if the commands are recorded with their settings and
+	// answered by the handler
+	assert.NoError(t, listErr)
+	assert.Equal(t, "ok", string(output))
+	assert.EqualError(t, reloadErr, "exit status 1")
+	assert.Equal(t, 1, result.ExitCode)
+	assert.Equal(t, "[PM2][ERROR] Process app not found\n", string(result.Stderr))
+	assert.Equal(t, []string{"pm2 jlist", "pm2 reload app"}, fakeExecClient.CommandLines())
+	commands := fakeExecClient.Commands()
+	assert.Equal(t, time.Minute, commands[0].Timeout)
+	assert.Equal(t, "/srv/app", commands[1].Dir)
+	assert.Equal(t, []string{"A=1"}, commands[1].Env)
+}
+
+func TestFakeExecClient_Err(t *testing.T) {
+	// Arrange: create a fake where commands can't be started
+	fakeExecClient := &FakeExecClient{
+		Handler: func(command *FakeCommand) FakeResult {
+			return FakeResult{Err: errors.New("executable file not found in $PATH")}
+		},
+	}
+
+	// Act: run a command
+	output, err := fakeExecClient.Command("pm2", "save").CombinedOutput()
+
+	// Assert: check if the error is returned
+	assert.Nil(t, output)
+	assert.EqualError(t, err, "executable file not found in $PATH")
+}
@@ -0,0 +1,94 @@
+package exec
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+)
+
+const (
+	Stream_Stdout = "stdout"
+	Stream_Stderr = "stderr"
+)
+
+// maxLineLength is the length at which a line without a newline is passed to
+// the log sink anyway, so a command can't grow it without limit
+const maxLineLength = 64 * 1024
+
+// LogSink receives the output of commands line by line while they run, e.g.
+// the log of a deployment.
+type LogSink interface {
+	// WriteLine receives a line without its newline from Stream_Stdout or
+	// Stream_Stderr
+	WriteLine(stream string, line string)
+}
+
+// lineWriter writes the output of a command to output and passes each
+// complete line to the log sink. The writers of stdout and stderr share the
+// mutex, as they are written concurrently and may share the output.
+type lineWriter struct {
+	mutex   *sync.Mutex
+	output  io.Writer
+	sink    LogSink
+	stream  string
+	partial []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if _, err := w.output.Write(p); err != nil {
+		return 0, err
+	}
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.writeLine(w.partial[:i])
+		w.partial = w.partial[i+1:]
+	}
+	if len(w.partial) >= maxLineLength {
+		w.writeLine(w.partial)
+		w.partial = nil
+	}
+	return len(p), nil
+}
+
+func (w *lineWriter) writeLine(line []byte) {
+	w.sink.WriteLine(w.stream, strings.TrimSuffix(string(line), "\r"))
+}
+
+// Passes the last line to the log sink if the output did not end with a
+// newline
+func (w *lineWriter) flush() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if len(w.partial) > 0 {
+		w.writeLine(w.partial)
+		w.partial = nil
+	}
+}
+
+// LogSinkExecClient is an ExecClientInterface that streams the output of
+// every command of ExecClient into Sink.
+type LogSinkExecClient struct {
+	ExecClient ExecClientInterface
+	Sink       LogSink
+}
+
+func (c *LogSinkExecClient) Command(command string, args ...string) ExecCommandInterface {
+	return c.ExecClient.Command(command, args...).WithLogSink(c.Sink)
+}
+
+// Returns an ExecClient that streams the output of its commands into the log
+// sink, or the ExecClient itself if the log sink is nil
+func WithLogSink(execClient ExecClientInterface, sink LogSink) ExecClientInterface {
+	if sink == nil {
+		return execClient
+	}
+	return &LogSinkExecClient{ExecClient: execClient, Sink: sink}
+}
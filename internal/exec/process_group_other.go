@@ -0,0 +1,11 @@
+//go:build !unix
+
+package exec
+
+import (
+	"os/exec"
+)
+
+// Process groups are only supported on unix, so only the command itself is
+// killed when it is canceled
+func setProcessGroup(cmd *exec.Cmd) {}
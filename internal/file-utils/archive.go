@@ -0,0 +1,399 @@
+package file_utils
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// tarMagicOffset is the offset of the "ustar" magic in a tar header
+const tarMagicOffset = 257
+
+// maxZipSymlinkTargetLength limits how much of a zip symlink entry is read as
+// its target
+const maxZipSymlinkTargetLength = 4096
+
+// ArchiveFormat describes an archive format that can be extracted from a
+// release. Compressed formats hold either a tarball or a single file.
+type ArchiveFormat struct {
+	// Name of the format, e.g. "gzip"
+	Name string
+	// Extensions are the file name suffixes of the format, e.g. ".tar.gz"
+	Extensions []string
+	// Magic are the bytes identifying the format at MagicOffset
+	Magic       []byte
+	MagicOffset int
+
+	extract func(filePath string, targetDir string, state *extractState, result *ExtractResult) error
+}
+
+// archiveFormats is the registry of the supported archive formats
+var archiveFormats []*ArchiveFormat
+
+// Adds an archive format to the registry
+func registerArchiveFormat(format *ArchiveFormat) {
+	archiveFormats = append(archiveFormats, format)
+}
+
+func init() {
+	registerArchiveFormat(&ArchiveFormat{
+		Name:       "zip",
+		Extensions: []string{".zip"},
+		Magic:      []byte("PK\x03\x04"),
+		extract:    extractZipFile,
+	})
+	registerArchiveFormat(&ArchiveFormat{
+		Name:        "tar",
+		Extensions:  []string{".tar"},
+		Magic:       []byte("ustar"),
+		MagicOffset: tarMagicOffset,
+		extract:     extractTarFile,
+	})
+	registerArchiveFormat(&ArchiveFormat{
+		Name:       "gzip",
+		Extensions: []string{".tar.gz", ".tgz", ".gz"},
+		Magic:      []byte{0x1f, 0x8b},
+		extract: compressedExtractor(func(r io.Reader) (io.ReadCloser, error) {
+			gzr, err := gzip.NewReader(r)
+			if err != nil {
+				return nil, fmt.Errorf("Error while creating gzip reader: %v", err)
+			}
+			return gzr, nil
+		}),
+	})
+	registerArchiveFormat(&ArchiveFormat{
+		Name:       "bzip2",
+		Extensions: []string{".tar.bz2", ".tbz2", ".tbz", ".bz2"},
+		Magic:      []byte("BZh"),
+		extract: compressedExtractor(func(r io.Reader) (io.ReadCloser, error) {
+			return io.NopCloser(bzip2.NewReader(r)), nil
+		}),
+	})
+	registerArchiveFormat(&ArchiveFormat{
+		Name:       "xz",
+		Extensions: []string{".tar.xz", ".txz", ".xz"},
+		Magic:      []byte{0xfd, '7', 'z', 'X', 'Z', 0x00},
+		extract: compressedExtractor(func(r io.Reader) (io.ReadCloser, error) {
+			xzr, err := xz.NewReader(r)
+			if err != nil {
+				return nil, fmt.Errorf("Error while creating xz reader: %v", err)
+			}
+			return io.NopCloser(xzr), nil
+		}),
+	})
+	registerArchiveFormat(&ArchiveFormat{
+		Name:       "zstd",
+		Extensions: []string{".tar.zst", ".tzst", ".zst"},
+		Magic:      []byte{0x28, 0xb5, 0x2f, 0xfd},
+		extract: compressedExtractor(func(r io.Reader) (io.ReadCloser, error) {
+			zr, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, fmt.Errorf("Error while creating zstd reader: %v", err)
+			}
+			return zr.IOReadCloser(), nil
+		}),
+	})
+}
+
+// Returns the archive format and the matched extension for a file name, using
+// the longest matching extension
+func formatByExtension(name string) (*ArchiveFormat, string) {
+	lowerName := strings.ToLower(name)
+
+	var matchedFormat *ArchiveFormat
+	matchedExtension := ""
+	for _, format := range archiveFormats {
+		for _, extension := range format.Extensions {
+			if strings.HasSuffix(lowerName, extension) && len(extension) > len(matchedExtension) {
+				matchedFormat = format
+				matchedExtension = extension
+			}
+		}
+	}
+
+	return matchedFormat, matchedExtension
+}
+
+// Returns the archive format identified by the magic bytes at the start of a
+// file
+func formatByMagic(head []byte) *ArchiveFormat {
+	for _, format := range archiveFormats {
+		end := format.MagicOffset + len(format.Magic)
+		if len(head) >= end && bytes.Equal(head[format.MagicOffset:end], format.Magic) {
+			return format
+		}
+	}
+	return nil
+}
+
+// Checks if a compression extension says the content is a tarball
+func isTarballExtension(extension string) bool {
+	switch extension {
+	case ".tgz", ".tbz", ".tbz2", ".txz", ".tzst":
+		return true
+	}
+	return strings.HasPrefix(extension, ".tar.")
+}
+
+// Checks if the decompressed content starts with a tar header
+func isTarStream(head []byte) bool {
+	end := tarMagicOffset + len("ustar")
+	return len(head) >= end && string(head[tarMagicOffset:end]) == "ustar"
+}
+
+// Detects the archive format of a file. A file is only treated as an archive
+// when its extension is one of the registered ones; its magic bytes then
+// decide the actual format, so e.g. a plain tarball named ".tar.gz" is still
+// extracted correctly. Returns nil for files that are not archives.
+func DetectArchiveFormat(filePath string) (*ArchiveFormat, error) {
+	formatFromExtension, _ := formatByExtension(filepath.Base(filePath))
+	if formatFromExtension == nil {
+		return nil, nil
+	}
+
+	file, openErr := os.Open(filePath)
+	if openErr != nil {
+		return nil, fmt.Errorf("Error while opening the file: %v", openErr)
+	}
+	defer file.Close()
+
+	head := make([]byte, tarMagicOffset+len("ustar"))
+	n, readErr := io.ReadFull(file, head)
+	if readErr != nil && readErr != io.ErrUnexpectedEOF && readErr != io.EOF {
+		return nil, fmt.Errorf("Error while reading the file: %v", readErr)
+	}
+
+	if formatFromMagic := formatByMagic(head[:n]); formatFromMagic != nil {
+		return formatFromMagic, nil
+	}
+
+	// Let the reader of the expected format report the corrupted content
+	return formatFromExtension, nil
+}
+
+// Checks if an archive matches one of the patterns of archives to keep
+func isSkippedArchive(filePath string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := filepath.Match(pattern, filepath.Base(filePath)); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns an iterator over the entries of a tar stream
+func tarEntryIterator(r io.Reader) archiveEntryIterator {
+	tr := tar.NewReader(r)
+	return func() (*tar.Header, io.Reader, error) {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil, nil, io.EOF
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("Error while reading the tar file: %v", err)
+		}
+		return header, tr, nil
+	}
+}
+
+// Extracts a plain tar file into the target directory
+func extractTarFile(filePath string, targetDir string, state *extractState, result *ExtractResult) error {
+	file, openErr := os.Open(filePath)
+	if openErr != nil {
+		return fmt.Errorf("Error while opening the file: %v", openErr)
+	}
+	defer file.Close()
+
+	return extractEntries(filepath.Base(filePath), tarEntryIterator(file), targetDir, state, result)
+}
+
+// Returns an extractor for a compressed file. The decompressed content is
+// extracted as a tarball when the file name says so (e.g. ".tar.gz") or when
+// it starts with a tar header, otherwise it is written as a single file named
+// after the archive without its compression extension. The single file gets
+// the modification time of the gzip header, or else that of the archive.
+func compressedExtractor(decompress func(r io.Reader) (io.ReadCloser, error)) func(string, string, *extractState, *ExtractResult) error {
+	return func(filePath string, targetDir string, state *extractState, result *ExtractResult) error {
+		file, openErr := os.Open(filePath)
+		if openErr != nil {
+			return fmt.Errorf("Error while opening the file: %v", openErr)
+		}
+		defer file.Close()
+
+		decompressed, decompressErr := decompress(file)
+		if decompressErr != nil {
+			return decompressErr
+		}
+		defer decompressed.Close()
+
+		archiveName := filepath.Base(filePath)
+		_, extension := formatByExtension(archiveName)
+
+		reader := bufio.NewReaderSize(decompressed, tarMagicOffset+len("ustar"))
+		head, _ := reader.Peek(tarMagicOffset + len("ustar"))
+		if isTarballExtension(extension) || isTarStream(head) {
+			return extractEntries(archiveName, tarEntryIterator(reader), targetDir, state, result)
+		}
+
+		// Single compressed file
+		name := archiveName[:len(archiveName)-len(extension)]
+		if extension == "" {
+			name = strings.TrimSuffix(archiveName, filepath.Ext(archiveName))
+		}
+		var modTime time.Time
+		if gzr, ok := decompressed.(*gzip.Reader); ok {
+			modTime = gzr.Header.ModTime
+		}
+		if modTime.IsZero() {
+			if info, statErr := file.Stat(); statErr == nil {
+				modTime = info.ModTime()
+			}
+		}
+		return extractSingleFile(archiveName, name, modTime, reader, targetDir, state, result)
+	}
+}
+
+// Writes the content of a single-file archive into the target directory
+func extractSingleFile(archiveName string, name string, modTime time.Time, content io.Reader, targetDir string, state *extractState, result *ExtractResult) error {
+	cleanedName, cleanErr := cleanEntryName(name)
+	if cleanErr != nil {
+		return fmt.Errorf("Invalid file name for %s: %v", archiveName, cleanErr)
+	}
+
+	state.fileCount++
+	if state.options.MaxFileCount > 0 && state.fileCount > state.options.MaxFileCount {
+		return fmt.Errorf("%w: more than %d files", ErrLimitExceeded, state.options.MaxFileCount)
+	}
+
+	// The decompressed size is unknown upfront, so read at most one byte more
+	// than the remaining budget to detect oversized content
+	if state.options.MaxExtractedSize > 0 {
+		content = io.LimitReader(content, state.options.MaxExtractedSize-state.extractedSize+1)
+	}
+
+	target := filepath.Join(targetDir, cleanedName)
+	if err := removeExistingEntry(target); err != nil {
+		return fmt.Errorf("remove existing file: %w", err)
+	}
+	outFile, createErr := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if createErr != nil {
+		return fmt.Errorf("create file: %w", createErr)
+	}
+	written, copyErr := io.Copy(outFile, content)
+	outFile.Close()
+	if copyErr != nil {
+		return fmt.Errorf("Error while decompressing %s: %v", archiveName, copyErr)
+	}
+
+	state.extractedSize += written
+	if state.options.MaxExtractedSize > 0 && state.extractedSize > state.options.MaxExtractedSize {
+		os.Remove(target)
+		return fmt.Errorf("%w: more than %d bytes", ErrLimitExceeded, state.options.MaxExtractedSize)
+	}
+
+	if err := os.Chmod(target, 0644&^state.options.Umask); err != nil {
+		return fmt.Errorf("chmod file: %w", err)
+	}
+	if err := restoreTimes(target, &tar.Header{ModTime: modTime}); err != nil {
+		return fmt.Errorf("restore file times: %w", err)
+	}
+
+	result.Files = append(result.Files, cleanedName)
+	log.Printf("Decompressed file: %s", cleanedName)
+	return nil
+}
+
+// Returns the tar header describing a zip entry
+func zipEntryHeader(f *zip.File) *tar.Header {
+	mode := f.Mode()
+	header := &tar.Header{
+		Name:    f.Name,
+		Mode:    int64(mode.Perm()),
+		ModTime: f.Modified,
+		Size:    int64(f.UncompressedSize64),
+	}
+
+	switch {
+	case mode.IsDir():
+		header.Typeflag = tar.TypeDir
+	case mode&os.ModeSymlink != 0:
+		header.Typeflag = tar.TypeSymlink
+	case mode&os.ModeCharDevice != 0:
+		header.Typeflag = tar.TypeChar
+	case mode&os.ModeDevice != 0:
+		header.Typeflag = tar.TypeBlock
+	case mode&os.ModeNamedPipe != 0:
+		header.Typeflag = tar.TypeFifo
+	case mode.IsRegular():
+		header.Typeflag = tar.TypeReg
+	default:
+		// e.g. sockets, which are rejected as unsupported
+		header.Typeflag = '?'
+	}
+
+	return header
+}
+
+// Extracts a zip file into the target directory
+func extractZipFile(filePath string, targetDir string, state *extractState, result *ExtractResult) error {
+	reader, openErr := zip.OpenReader(filePath)
+	if openErr != nil {
+		return fmt.Errorf("Error while opening the zip file: %v", openErr)
+	}
+	defer reader.Close()
+
+	index := 0
+	var current io.ReadCloser
+	defer func() {
+		if current != nil {
+			current.Close()
+		}
+	}()
+
+	next := func() (*tar.Header, io.Reader, error) {
+		if current != nil {
+			current.Close()
+			current = nil
+		}
+		if index >= len(reader.File) {
+			return nil, nil, io.EOF
+		}
+
+		f := reader.File[index]
+		index++
+
+		header := zipEntryHeader(f)
+		content, openEntryErr := f.Open()
+		if openEntryErr != nil {
+			return nil, nil, fmt.Errorf("Error while reading the zip file: %v", openEntryErr)
+		}
+		current = content
+
+		// Symlink targets are stored as the content of the entry
+		if header.Typeflag == tar.TypeSymlink {
+			linkname, readErr := io.ReadAll(io.LimitReader(content, maxZipSymlinkTargetLength))
+			if readErr != nil {
+				return nil, nil, fmt.Errorf("Error while reading the zip file: %v", readErr)
+			}
+			header.Linkname = string(linkname)
+			header.Size = 0
+		}
+
+		return header, content, nil
+	}
+
+	return extractEntries(filepath.Base(filePath), next, targetDir, state, result)
+}
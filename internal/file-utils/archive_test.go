@@ -0,0 +1,238 @@
+package file_utils
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/ulikunitz/xz"
+)
+
+// Helper to create a tar stream with one file inside
+func createTestTar(t *testing.T, fileName string, content []byte) []byte {
+	buffer := &bytes.Buffer{}
+	tw := tar.NewWriter(buffer)
+	assert.NoError(t, tw.WriteHeader(&tar.Header{Name: fileName, Mode: 0644, Size: int64(len(content))}))
+	_, err := tw.Write(content)
+	assert.NoError(t, err)
+	assert.NoError(t, tw.Close())
+	return buffer.Bytes()
+}
+
+// Helper to create a zip file with the given files
+func createTestZip(t *testing.T, zipPath string, files map[string][]byte) {
+	f, err := os.Create(zipPath)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	defer zw.Close()
+
+	for name, content := range files {
+		w, createErr := zw.Create(name)
+		assert.NoError(t, createErr)
+		_, err = w.Write(content)
+		assert.NoError(t, err)
+	}
+}
+
+func TestExtractArchivesInDir_Zip(t *testing.T) {
+	tempDir := setupFileUtilsTest(t)
+	releaseDir := path.Join(tempDir, "release")
+	os.MkdirAll(releaseDir, 0755)
+
+	// Arrange: create a zip file with a nested file and an escaping entry
+	createTestZip(t, path.Join(releaseDir, "site.zip"), map[string][]byte{
+		"assets/app.js":  []byte("console.log('hi')"),
+		"../outside.txt": []byte("outside"),
+	})
+
+	// Act: extract files
+	result, err := ExtractArchivesInDir(releaseDir, ExtractOptions{})
+
+	// Assert: check if the safe entry is extracted and the escaping one is rejected
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"assets/app.js"}, result.Files)
+	assert.Len(t, result.Rejected, 1)
+	data, readErr := os.ReadFile(path.Join(releaseDir, "assets", "app.js"))
+	assert.NoError(t, readErr)
+	assert.Equal(t, []byte("console.log('hi')"), data)
+	_, statErr := os.Stat(path.Join(releaseDir, "site.zip"))
+	assert.True(t, os.IsNotExist(statErr), "Expected zip file to be removed after extraction")
+}
+
+func TestExtractArchivesInDir_Tar(t *testing.T) {
+	releaseDir := setupFileUtilsTest(t)
+
+	// Arrange: create a plain tar file
+	os.WriteFile(path.Join(releaseDir, "site.tar"), createTestTar(t, "index.html", []byte("<html></html>")), 0644)
+
+	// Act: extract files
+	result, err := ExtractArchivesInDir(releaseDir, ExtractOptions{})
+
+	// Assert: check if the file is extracted
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"index.html"}, result.Files)
+}
+
+func TestExtractArchivesInDir_TarXz(t *testing.T) {
+	releaseDir := setupFileUtilsTest(t)
+
+	// Arrange: create a tar.xz file
+	buffer := &bytes.Buffer{}
+	xw, err := xz.NewWriter(buffer)
+	assert.NoError(t, err)
+	_, err = xw.Write(createTestTar(t, "index.html", []byte("<html></html>")))
+	assert.NoError(t, err)
+	assert.NoError(t, xw.Close())
+	os.WriteFile(path.Join(releaseDir, "site.tar.xz"), buffer.Bytes(), 0644)
+
+	// Act: extract files
+	result, err := ExtractArchivesInDir(releaseDir, ExtractOptions{})
+
+	// Assert: check if the file is extracted
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"index.html"}, result.Files)
+}
+
+func TestExtractArchivesInDir_TarZst(t *testing.T) {
+	releaseDir := setupFileUtilsTest(t)
+
+	// Arrange: create a tar.zst file
+	buffer := &bytes.Buffer{}
+	zw, err := zstd.NewWriter(buffer)
+	assert.NoError(t, err)
+	_, err = zw.Write(createTestTar(t, "index.html", []byte("<html></html>")))
+	assert.NoError(t, err)
+	assert.NoError(t, zw.Close())
+	os.WriteFile(path.Join(releaseDir, "site.tar.zst"), buffer.Bytes(), 0644)
+
+	// Act: extract files
+	result, err := ExtractArchivesInDir(releaseDir, ExtractOptions{})
+
+	// Assert: check if the file is extracted
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"index.html"}, result.Files)
+}
+
+func TestExtractArchivesInDir_SingleFileGz(t *testing.T) {
+	releaseDir := setupFileUtilsTest(t)
+
+	// Arrange: create a gzip file that is not a tarball
+	buffer := &bytes.Buffer{}
+	gw := gzip.NewWriter(buffer)
+	gw.Write([]byte("plain content"))
+	gw.Close()
+	os.WriteFile(path.Join(releaseDir, "notes.txt.gz"), buffer.Bytes(), 0644)
+
+	// Act: extract files
+	result, err := ExtractArchivesInDir(releaseDir, ExtractOptions{})
+
+	// Assert: check if the file is decompressed without the ".gz" extension
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"notes.txt"}, result.Files)
+	data, readErr := os.ReadFile(path.Join(releaseDir, "notes.txt"))
+	assert.NoError(t, readErr)
+	assert.Equal(t, []byte("plain content"), data)
+}
+
+func TestExtractArchivesInDir_SingleFileGz_RestoresModTime(t *testing.T) {
+	releaseDir := setupFileUtilsTest(t)
+
+	// Arrange: create a gzip file with a modification time in its header
+	modTime := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	buffer := &bytes.Buffer{}
+	gw := gzip.NewWriter(buffer)
+	gw.ModTime = modTime
+	gw.Write([]byte("plain content"))
+	gw.Close()
+	os.WriteFile(path.Join(releaseDir, "notes.txt.gz"), buffer.Bytes(), 0644)
+
+	// Act: extract files
+	_, err := ExtractArchivesInDir(releaseDir, ExtractOptions{})
+
+	// Assert: check if the file has the modification time of the header
+	assert.NoError(t, err)
+	info, statErr := os.Stat(path.Join(releaseDir, "notes.txt"))
+	assert.NoError(t, statErr)
+	assert.True(t, modTime.Equal(info.ModTime()), "Expected %v, got %v", modTime, info.ModTime())
+}
+
+func TestExtractArchivesInDir_SingleFileXz_KeepsArchiveModTime(t *testing.T) {
+	releaseDir := setupFileUtilsTest(t)
+
+	// Arrange: create an xz file, which has no modification time of its own
+	modTime := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	buffer := &bytes.Buffer{}
+	xw, err := xz.NewWriter(buffer)
+	assert.NoError(t, err)
+	xw.Write([]byte("plain content"))
+	assert.NoError(t, xw.Close())
+	os.WriteFile(path.Join(releaseDir, "notes.txt.xz"), buffer.Bytes(), 0644)
+	os.Chtimes(path.Join(releaseDir, "notes.txt.xz"), modTime, modTime)
+
+	// Act: extract files
+	_, err = ExtractArchivesInDir(releaseDir, ExtractOptions{})
+
+	// Assert: check if the file has the modification time of the archive
+	assert.NoError(t, err)
+	info, statErr := os.Stat(path.Join(releaseDir, "notes.txt"))
+	assert.NoError(t, statErr)
+	assert.True(t, modTime.Equal(info.ModTime()), "Expected %v, got %v", modTime, info.ModTime())
+}
+
+func TestExtractArchivesInDir_SkipArchives(t *testing.T) {
+	releaseDir := setupFileUtilsTest(t)
+
+	// Arrange: create an archive that should be kept as is
+	createTestTarGz(t, path.Join(releaseDir, "backup.tar.gz"), "hello.txt", []byte("hello"))
+
+	// Act: extract files while skipping the archive
+	_, err := ExtractArchivesInDir(releaseDir, ExtractOptions{SkipArchives: []string{"backup.*"}})
+
+	// Assert: check if the archive is kept and not extracted
+	assert.NoError(t, err)
+	_, statErr := os.Stat(path.Join(releaseDir, "backup.tar.gz"))
+	assert.NoError(t, statErr, "Expected skipped archive to remain")
+	_, statErr = os.Stat(path.Join(releaseDir, "hello.txt"))
+	assert.True(t, os.IsNotExist(statErr), "Expected skipped archive not to be extracted")
+}
+
+func TestDetectArchiveFormat(t *testing.T) {
+	releaseDir := setupFileUtilsTest(t)
+
+	// Arrange: create a zip file with a misleading extension and a plain file
+	createTestZip(t, path.Join(releaseDir, "site.tar.gz"), map[string][]byte{"index.html": []byte("hi")})
+	os.WriteFile(path.Join(releaseDir, "readme.md"), []byte("readme"), 0644)
+
+	// Act: detect the formats
+	zipFormat, zipErr := DetectArchiveFormat(path.Join(releaseDir, "site.tar.gz"))
+	plainFormat, plainErr := DetectArchiveFormat(path.Join(releaseDir, "readme.md"))
+
+	// Assert: check if magic bytes win over the extension and plain files are not archives
+	assert.NoError(t, zipErr)
+	assert.Equal(t, "zip", zipFormat.Name)
+	assert.NoError(t, plainErr)
+	assert.Nil(t, plainFormat)
+}
+
+func TestExtractArchivesInDir_Zip_MaxExtractedSize(t *testing.T) {
+	releaseDir := setupFileUtilsTest(t)
+
+	// Arrange: create a zip file larger than the limit
+	createTestZip(t, path.Join(releaseDir, "site.zip"), map[string][]byte{"big.bin": bytes.Repeat([]byte("a"), 1024)})
+
+	// Act: extract files with a small limit
+	_, err := ExtractArchivesInDir(releaseDir, ExtractOptions{MaxExtractedSize: 100})
+
+	// Assert: check if the limit error is returned
+	assert.True(t, errors.Is(err, ErrLimitExceeded), "Expected ErrLimitExceeded, got %v", err)
+}
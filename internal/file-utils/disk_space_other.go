@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd || dragonfly)
+
+package file_utils
+
+import "errors"
+
+// Reading the available disk space is only supported on systems with statfs
+func GetAvailableDiskSpace(path string) (uint64, error) {
+	return 0, errors.New("Failed to read filesystem stats: not supported on this platform")
+}
@@ -0,0 +1,18 @@
+//go:build linux || darwin || freebsd || dragonfly
+
+package file_utils
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// Returns the number of bytes available to unprivileged users on the
+// filesystem containing the given path
+func GetAvailableDiskSpace(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, fmt.Errorf("Failed to read filesystem stats: %w", err)
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
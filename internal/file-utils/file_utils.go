@@ -2,17 +2,47 @@ package file_utils
 
 import (
 	"archive/tar"
-	"compress/gzip"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"os/user"
 	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// ErrLimitExceeded is returned when extracting archives would exceed one of
+// the limits in ExtractOptions.
+var ErrLimitExceeded = errors.New("extraction limit exceeded")
+
+// ExtractOptions configures how archives are extracted. Zero values mean no
+// limit.
+type ExtractOptions struct {
+	// MaxExtractedSize is the maximum total size of the extracted files in bytes
+	MaxExtractedSize int64
+	// MaxFileCount is the maximum number of extracted files
+	MaxFileCount int
+	// Umask is removed from the permission bits of extracted files and
+	// directories
+	Umask os.FileMode
+	// Owner and Group (names or numeric ids) are applied to the extracted tree
+	// when set
+	Owner string
+	Group string
+	// SkipArchives are file name patterns (see filepath.Match) of archives
+	// that are kept as they are instead of being extracted
+	SkipArchives []string
+	// StripComponents is the number of leading path components removed from
+	// the names of archive entries, like tar's --strip-components. Entries
+	// with no components left are skipped.
+	StripComponents int
+}
+
 // Checks if a directory exists and creates it if it doesn't
 func CreateDirIfIsNotExist(path string) error {
 	if path == "" {
@@ -31,25 +61,143 @@ func CreateDirIfIsNotExist(path string) error {
 	return nil
 }
 
-// Checks if a release directory exists and creates it if it doesn't
-func CreateReleaseDirIfIsNotExist(assetsDir string, owner string, repo string, tag string) (string, error) {
+// StagingDirName is the name of the directory inside the assets directory where
+// releases are prepared before they are renamed into place. GitHub owners
+// can't start with a dot, so it never collides with a release directory.
+const StagingDirName = ".staging"
+
+// MetadataDirName is the name of the directory inside a release directory that
+// holds deploy-to-vm metadata such as the release manifest. It is never linked
+// into the site directory.
+const MetadataDirName = ".deploy-to-vm"
+
+// Checks if a directory is the metadata directory of the given release
+// directory
+func isMetadataDir(releaseDir string, dir string) bool {
+	return filepath.Clean(dir) == filepath.Join(releaseDir, MetadataDirName)
+}
+
+// Returns the path of the release directory for a tag
+func ReleaseDirPath(assetsDir string, owner string, repo string, tag string) string {
+	return path.Join(assetsDir, owner, repo, tag)
+}
+
+// ActiveReleaseFileName is the name of the file in a repository directory that
+// holds the tag of the release currently linked to the site directory. Git
+// tags can't start with a dot, so it never collides with a release directory.
+const ActiveReleaseFileName = ".active"
+
+// Records the tag of the release that is linked to the site directory
+func SetActiveRelease(assetsDir string, owner string, repo string, tag string) error {
+	activeReleaseFile := path.Join(assetsDir, owner, repo, ActiveReleaseFileName)
+	if err := os.MkdirAll(path.Dir(activeReleaseFile), 0755); err != nil {
+		return fmt.Errorf("Failed to write the active release: %w", err)
+	}
+	tempFile := activeReleaseFile + ".tmp"
+	if err := os.WriteFile(tempFile, []byte(tag+"\n"), 0644); err != nil {
+		return fmt.Errorf("Failed to write the active release: %w", err)
+	}
+	if err := os.Rename(tempFile, activeReleaseFile); err != nil {
+		os.Remove(tempFile)
+		return fmt.Errorf("Failed to write the active release: %w", err)
+	}
+	return nil
+}
+
+// Returns the tag of the release that is linked to the site directory, or an
+// empty string if no release was activated yet
+func GetActiveRelease(assetsDir string, owner string, repo string) (string, error) {
+	content, readErr := os.ReadFile(path.Join(assetsDir, owner, repo, ActiveReleaseFileName))
+	if os.IsNotExist(readErr) {
+		return "", nil
+	}
+	if readErr != nil {
+		return "", fmt.Errorf("Failed to read the active release: %w", readErr)
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+// Creates an empty staging directory to prepare a release in. It is on the same
+// filesystem as the release directories, so it can be renamed into place.
+func CreateStagingDir(assetsDir string, owner string, repo string, tag string) (string, error) {
 	if assetsDir == "" || owner == "" || repo == "" || tag == "" {
 		return "", errors.New("Assets directory, owner, repo, or tag cannot be empty")
 	}
 
-	releaseDirPath := path.Join(assetsDir, owner, repo, tag)
+	stagingRoot := path.Join(assetsDir, StagingDirName)
+	if err := os.MkdirAll(stagingRoot, 0755); err != nil {
+		return "", fmt.Errorf("Failed to create staging directory: %w", err)
+	}
 
-	// If releaseDir exists, clear the content
-	if _, err := os.Stat(releaseDirPath); err == nil {
-		log.Println("Release directory exists, clearing content...")
-		err := os.RemoveAll(releaseDirPath)
-		if err != nil {
-			return releaseDirPath, fmt.Errorf("error clearing release directory: %v", err.Error())
+	stagingDir, err := os.MkdirTemp(stagingRoot, fmt.Sprintf("%s-%s-%s-", owner, repo, strings.ReplaceAll(tag, "/", "_")))
+	if err != nil {
+		return "", fmt.Errorf("Failed to create staging directory: %w", err)
+	}
+	if err := os.Chmod(stagingDir, 0755); err != nil {
+		os.RemoveAll(stagingDir)
+		return "", fmt.Errorf("Failed to create staging directory: %w", err)
+	}
+
+	log.Printf("Created staging directory: \"%s\"", stagingDir)
+	return stagingDir, nil
+}
+
+// Renames a fully prepared staging directory into place as the release
+// directory. An existing release directory for the same tag is only removed
+// once the new one is in place.
+func PromoteStagingDir(stagingDir string, releaseDir string) error {
+	if err := os.MkdirAll(path.Dir(releaseDir), os.ModePerm); err != nil {
+		return fmt.Errorf("Failed to create repository directory: %w", err)
+	}
+
+	replacedDir := ""
+	if _, err := os.Lstat(releaseDir); err == nil {
+		replacedDir = stagingDir + ".replaced"
+		if err := os.Rename(releaseDir, replacedDir); err != nil {
+			return fmt.Errorf("Failed to move the existing release directory aside: %w", err)
+		}
+	}
+
+	if err := os.Rename(stagingDir, releaseDir); err != nil {
+		// Put the existing release back
+		if replacedDir != "" {
+			if restoreErr := os.Rename(replacedDir, releaseDir); restoreErr != nil {
+				log.Printf("Failed to restore the release directory \"%s\": %v", releaseDir, restoreErr)
+			}
 		}
+		return fmt.Errorf("Failed to move the staging directory into place: %w", err)
 	}
 
-	createDirErr := CreateDirIfIsNotExist(releaseDirPath)
-	return releaseDirPath, createDirErr
+	if replacedDir != "" {
+		if err := os.RemoveAll(replacedDir); err != nil {
+			log.Printf("Failed to remove the replaced release directory \"%s\": %v", replacedDir, err)
+		}
+	}
+
+	log.Printf("Release directory is in place: \"%s\"", releaseDir)
+	return nil
+}
+
+// Removes the staging directories left behind by interrupted deployments. It
+// must only be called when no deployment is running, e.g. at startup.
+func CleanStagingDirs(assetsDir string) error {
+	stagingRoot := path.Join(assetsDir, StagingDirName)
+	entries, readErr := os.ReadDir(stagingRoot)
+	if os.IsNotExist(readErr) {
+		return nil
+	}
+	if readErr != nil {
+		return fmt.Errorf("Failed to read staging directory: %w", readErr)
+	}
+
+	for _, entry := range entries {
+		entryPath := path.Join(stagingRoot, entry.Name())
+		if err := os.RemoveAll(entryPath); err != nil {
+			return fmt.Errorf("Failed to remove stale staging directory: %w", err)
+		}
+		log.Printf("Removed stale staging directory: \"%s\"", entryPath)
+	}
+	return nil
 }
 
 // Read files in a directory recursively
@@ -71,9 +219,448 @@ func ReadFilesInDir(dir string) ([]string, error) {
 	return files, readErr
 }
 
+// RejectedEntry is an archive entry that was not extracted because it is
+// unsafe, e.g. it points outside of the release directory or is a device file.
+type RejectedEntry struct {
+	Archive string `json:"archive"`
+	Name    string `json:"name"`
+	Reason  string `json:"reason"`
+}
+
+// ExtractResult describes the outcome of extracting the archives in a
+// directory.
+type ExtractResult struct {
+	// Files contains the paths of files that are not archives and the names of
+	// the entries extracted from archives
+	Files []string
+	// Rejected contains the archive entries that were skipped
+	Rejected []RejectedEntry
+}
+
+// extractState keeps track of the limits across all archives in a directory
+type extractState struct {
+	options       ExtractOptions
+	extractedSize int64
+	fileCount     int
+}
+
+// extractedDir is a directory entry whose mode and mtime are applied after all
+// entries of its archive are extracted, since extracting files into the
+// directory changes its mtime and a read-only mode would prevent extraction
+type extractedDir struct {
+	path    string
+	mode    os.FileMode
+	modTime time.Time
+}
+
+// Returns the permission bits of an archive entry masked by the umask. Entries
+// without any permission bits get the given default mode.
+func entryMode(header *tar.Header, defaultMode os.FileMode, umask os.FileMode) os.FileMode {
+	mode := os.FileMode(header.Mode).Perm()
+	if mode == 0 {
+		mode = defaultMode
+	}
+	return mode &^ umask
+}
+
+// Restores the access and modification times of an extracted entry
+func restoreTimes(target string, header *tar.Header) error {
+	if header.ModTime.IsZero() {
+		return nil
+	}
+	accessTime := header.AccessTime
+	if accessTime.IsZero() {
+		accessTime = header.ModTime
+	}
+	return os.Chtimes(target, accessTime, header.ModTime)
+}
+
+// Resolves an owner and group, given as names or numeric ids, to a uid and
+// gid. Empty values resolve to -1, which leaves the ownership unchanged.
+func LookupOwnership(owner string, group string) (int, int, error) {
+	uid, gid := -1, -1
+
+	if owner != "" {
+		if id, parseErr := strconv.Atoi(owner); parseErr == nil {
+			uid = id
+		} else {
+			u, lookupErr := user.Lookup(owner)
+			if lookupErr != nil {
+				return -1, -1, fmt.Errorf("Failed to look up user %q: %w", owner, lookupErr)
+			}
+			uid, _ = strconv.Atoi(u.Uid)
+		}
+	}
+
+	if group != "" {
+		if id, parseErr := strconv.Atoi(group); parseErr == nil {
+			gid = id
+		} else {
+			g, lookupErr := user.LookupGroup(group)
+			if lookupErr != nil {
+				return -1, -1, fmt.Errorf("Failed to look up group %q: %w", group, lookupErr)
+			}
+			gid, _ = strconv.Atoi(g.Gid)
+		}
+	}
+
+	return uid, gid, nil
+}
+
+// Changes the owner and group of every entry in a directory tree, including
+// the directory itself. Symlinks are changed themselves, not their targets.
+func ChownTree(root string, uid int, gid int) error {
+	if uid == -1 && gid == -1 {
+		return nil
+	}
+
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if chownErr := os.Lchown(path, uid, gid); chownErr != nil {
+			return fmt.Errorf("Failed to change owner of %s: %w", path, chownErr)
+		}
+		return nil
+	})
+}
+
+// maxSymlinkFollows limits how many symlinks are followed while resolving a
+// path, to protect against symlink loops
+const maxSymlinkFollows = 40
+
+// Cleans the name of an archive entry. Absolute paths and paths escaping the
+// root are rejected.
+func cleanEntryName(name string) (string, error) {
+	if filepath.IsAbs(name) || strings.HasPrefix(name, "/") {
+		return "", errors.New("absolute path")
+	}
+
+	cleaned := filepath.Clean(filepath.FromSlash(name))
+	if cleaned == "." {
+		return "", errors.New("empty path")
+	}
+	if cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+		return "", errors.New("path escapes the release directory")
+	}
+
+	return cleaned, nil
+}
+
+// Removes the given number of leading components from a cleaned entry name. It
+// returns false if no component is left.
+func stripComponents(cleanedName string, count int) (string, bool) {
+	if count <= 0 {
+		return cleanedName, true
+	}
+
+	components := strings.Split(cleanedName, string(filepath.Separator))
+	if len(components) <= count {
+		return "", false
+	}
+	return filepath.Join(components[count:]...), true
+}
+
+// Resolves a path relative to the root directory the way the filesystem would,
+// following the symlinks that already exist on disk. It returns the physical
+// path relative to root and fails if the path leaves the root at any point.
+// Components that don't exist yet are treated as directories.
+func resolveInRoot(root string, name string) (string, error) {
+	if filepath.IsAbs(name) {
+		return "", errors.New("absolute path")
+	}
+
+	remaining := strings.Split(filepath.ToSlash(name), "/")
+	current := make([]string, 0)
+	follows := 0
+	for len(remaining) > 0 {
+		component := remaining[0]
+		remaining = remaining[1:]
+
+		switch component {
+		case "", ".":
+			continue
+		case "..":
+			if len(current) == 0 {
+				return "", errors.New("path escapes the release directory")
+			}
+			current = current[:len(current)-1]
+			continue
+		}
+
+		candidate := filepath.Join(root, filepath.Join(current...), component)
+		info, lstatErr := os.Lstat(candidate)
+		if lstatErr != nil || info.Mode()&os.ModeSymlink == 0 {
+			current = append(current, component)
+			continue
+		}
+
+		follows++
+		if follows > maxSymlinkFollows {
+			return "", errors.New("too many levels of symlinks")
+		}
+		linkname, readlinkErr := os.Readlink(candidate)
+		if readlinkErr != nil {
+			return "", readlinkErr
+		}
+		if filepath.IsAbs(linkname) {
+			return "", errors.New("absolute symlink target")
+		}
+		remaining = append(strings.Split(filepath.ToSlash(linkname), "/"), remaining...)
+	}
+
+	if len(current) == 0 {
+		return ".", nil
+	}
+	return filepath.Join(current...), nil
+}
+
+// Resolves where an archive entry has to be written. The parent directory is
+// resolved physically so entries are never written through a symlink that
+// leaves the root.
+func resolveEntryTarget(root string, cleanedName string) (string, string, error) {
+	parent, resolveErr := resolveInRoot(root, filepath.Dir(cleanedName))
+	if resolveErr != nil {
+		return "", "", resolveErr
+	}
+	return filepath.Join(root, parent, filepath.Base(cleanedName)), parent, nil
+}
+
+// Removes an existing non-directory entry at the target path, so extraction
+// never writes through a symlink left by an earlier entry
+func removeExistingEntry(target string) error {
+	info, lstatErr := os.Lstat(target)
+	if lstatErr != nil {
+		if os.IsNotExist(lstatErr) {
+			return nil
+		}
+		return lstatErr
+	}
+	if info.IsDir() {
+		return fmt.Errorf("a directory already exists at %s", target)
+	}
+	return os.Remove(target)
+}
+
+// archiveEntryIterator returns the next entry of an archive, described as a tar
+// header, together with a reader for its content. It returns io.EOF after the
+// last entry.
+type archiveEntryIterator func() (*tar.Header, io.Reader, error)
+
+// Extracts the entries of an archive into the target directory. All archive
+// formats go through this function so they share the same safety guarantees.
+func extractEntries(archiveName string, next archiveEntryIterator, targetDir string, state *extractState, result *ExtractResult) error {
+	reject := func(name string, reason string) {
+		log.Printf("Rejected archive entry \"%s\" in %s: %s", name, archiveName, reason)
+		result.Rejected = append(result.Rejected, RejectedEntry{
+			Archive: archiveName,
+			Name:    name,
+			Reason:  reason,
+		})
+	}
+
+	dirs := make([]extractedDir, 0)
+
+	for {
+		header, content, err := next()
+		if err == io.EOF {
+			break // End of archive
+		}
+
+		if err != nil {
+			return err
+		}
+
+		// Skip the archive root itself, e.g. "./"
+		if header.Typeflag == tar.TypeDir && filepath.Clean(header.Name) == "." {
+			continue
+		}
+
+		cleanedName, cleanErr := cleanEntryName(header.Name)
+		if cleanErr != nil {
+			reject(header.Name, cleanErr.Error())
+			continue
+		}
+		cleanedName, hasName := stripComponents(cleanedName, state.options.StripComponents)
+		if !hasName {
+			continue
+		}
+		if strings.SplitN(cleanedName, string(filepath.Separator), 2)[0] == MetadataDirName {
+			reject(header.Name, "reserved path")
+			continue
+		}
+
+		target, targetParent, resolveErr := resolveEntryTarget(targetDir, cleanedName)
+		if resolveErr != nil {
+			reject(header.Name, resolveErr.Error())
+			continue
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return fmt.Errorf("mkdir for directory: %w", err)
+			}
+			dirs = append(dirs, extractedDir{
+				path:    target,
+				mode:    entryMode(header, 0755, state.options.Umask),
+				modTime: header.ModTime,
+			})
+			// directories are not listed as extracted files
+			continue
+		case tar.TypeReg:
+			state.fileCount++
+			if state.options.MaxFileCount > 0 && state.fileCount > state.options.MaxFileCount {
+				return fmt.Errorf("%w: more than %d files", ErrLimitExceeded, state.options.MaxFileCount)
+			}
+			state.extractedSize += header.Size
+			if state.options.MaxExtractedSize > 0 && state.extractedSize > state.options.MaxExtractedSize {
+				return fmt.Errorf("%w: more than %d bytes", ErrLimitExceeded, state.options.MaxExtractedSize)
+			}
+
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return fmt.Errorf("mkdir for file: %w", err)
+			}
+			if err := removeExistingEntry(target); err != nil {
+				return fmt.Errorf("remove existing file: %w", err)
+			}
+			outFile, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+			if err != nil {
+				return fmt.Errorf("create file: %w", err)
+			}
+			if _, err := io.Copy(outFile, io.LimitReader(content, header.Size)); err != nil {
+				outFile.Close()
+				return fmt.Errorf("copy file: %w", err)
+			}
+			outFile.Close()
+
+			// Chmod is not affected by the process umask, unlike OpenFile
+			if err := os.Chmod(target, entryMode(header, 0644, state.options.Umask)); err != nil {
+				return fmt.Errorf("chmod file: %w", err)
+			}
+			if err := restoreTimes(target, header); err != nil {
+				return fmt.Errorf("restore file times: %w", err)
+			}
+		case tar.TypeSymlink:
+			// The symlink is written with the physical target it resolves to, so
+			// entries extracted later can't redirect it outside of the root
+			if header.Linkname == "" {
+				reject(header.Name, "empty symlink target")
+				continue
+			}
+			if filepath.IsAbs(header.Linkname) {
+				reject(header.Name, "absolute symlink target")
+				continue
+			}
+			linkTarget, resolveLinkErr := resolveInRoot(targetDir, filepath.ToSlash(targetParent)+"/"+filepath.ToSlash(header.Linkname))
+			if resolveLinkErr != nil {
+				reject(header.Name, "symlink target: "+resolveLinkErr.Error())
+				continue
+			}
+			linkname, relErr := filepath.Rel(filepath.Join(targetDir, targetParent), filepath.Join(targetDir, linkTarget))
+			if relErr != nil {
+				reject(header.Name, "symlink target: "+relErr.Error())
+				continue
+			}
+
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return fmt.Errorf("mkdir for symlink: %w", err)
+			}
+			if err := removeExistingEntry(target); err != nil {
+				return fmt.Errorf("remove existing file: %w", err)
+			}
+			if err := os.Symlink(linkname, target); err != nil {
+				return fmt.Errorf("create symlink: %w", err)
+			}
+		case tar.TypeLink:
+			cleanedLinkname, cleanLinkErr := cleanEntryName(header.Linkname)
+			if cleanLinkErr != nil {
+				reject(header.Name, "hardlink target: "+cleanLinkErr.Error())
+				continue
+			}
+			cleanedLinkname, hasLinkname := stripComponents(cleanedLinkname, state.options.StripComponents)
+			if !hasLinkname {
+				reject(header.Name, "hardlink target is stripped")
+				continue
+			}
+			linkTarget, resolveLinkErr := resolveInRoot(targetDir, cleanedLinkname)
+			if resolveLinkErr != nil {
+				reject(header.Name, "hardlink target: "+resolveLinkErr.Error())
+				continue
+			}
+			linkTargetPath := filepath.Join(targetDir, linkTarget)
+			linkTargetInfo, lstatErr := os.Lstat(linkTargetPath)
+			if lstatErr != nil || !linkTargetInfo.Mode().IsRegular() {
+				reject(header.Name, "hardlink target is not an extracted regular file")
+				continue
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return fmt.Errorf("mkdir for hardlink: %w", err)
+			}
+			if err := removeExistingEntry(target); err != nil {
+				return fmt.Errorf("remove existing file: %w", err)
+			}
+			if err := os.Link(linkTargetPath, target); err != nil {
+				return fmt.Errorf("create hardlink: %w", err)
+			}
+		case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+			reject(header.Name, "device and fifo files are not allowed")
+			continue
+		default:
+			reject(header.Name, fmt.Sprintf("unsupported entry type %q", string(header.Typeflag)))
+			continue
+		}
+
+		entryName := filepath.ToSlash(cleanedName)
+		result.Files = append(result.Files, entryName)
+		// Log the extracted file
+		log.Printf("Extracted file: %s", entryName)
+	}
+
+	// Apply directory modes and times, innermost directories first
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Chmod(dirs[i].path, dirs[i].mode); err != nil {
+			return fmt.Errorf("chmod directory: %w", err)
+		}
+		if !dirs[i].modTime.IsZero() {
+			if err := os.Chtimes(dirs[i].path, dirs[i].modTime, dirs[i].modTime); err != nil {
+				return fmt.Errorf("restore directory times: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
 // Untar gz files in a directory. It reads all files in the directory, checks if
-// they are tar files, and extracts them.
+// they are archives, and extracts them. See ExtractArchivesInDir.
 func UntarGzFilesInDir(dir string) ([]string, error) {
+	result, err := ExtractArchivesInDir(dir, ExtractOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return result.Files, nil
+}
+
+// Extracts the archives in a directory with the given options. Archives are
+// detected by their extension and magic bytes (see DetectArchiveFormat) and
+// removed after extraction. Other files are left untouched.
+//
+// Every entry is confined to the directory of its archive: entries with
+// absolute paths or paths escaping the directory, symlinks and hardlinks
+// pointing outside of it and device files are skipped and reported in the
+// result. Extraction stops with ErrLimitExceeded as soon as one of the
+// configured limits is exceeded.
+//
+// File and directory modes are kept (masked by the umask) and modification
+// times are restored, so that servers relying on them (e.g. nginx ETags) see
+// stable values across deployments. Symlink times are not restored.
+func ExtractArchivesInDir(dir string, options ExtractOptions) (*ExtractResult, error) {
+	uid, gid, lookupErr := LookupOwnership(options.Owner, options.Group)
+	if lookupErr != nil {
+		return nil, lookupErr
+	}
+
 	// Read files in the directory recursively
 	files, readErr := ReadFilesInDir(dir)
 	if readErr != nil {
@@ -82,92 +669,123 @@ func UntarGzFilesInDir(dir string) ([]string, error) {
 
 	log.Printf("Found files in the directory: \n- %v", strings.Join(files, "\n- "))
 
-	processedFiles := make([]string, 0)
+	result := &ExtractResult{
+		Files:    make([]string, 0),
+		Rejected: make([]RejectedEntry, 0),
+	}
+	state := &extractState{options: options}
 
 	// Iterate through each file
 	for _, filePath := range files {
-		if filepath.Ext(filePath) != ".gz" {
-			processedFiles = append(processedFiles, filePath)
-			log.Printf("Skipping non-tar file: %v", filePath)
+		if isSkippedArchive(filePath, options.SkipArchives) {
+			result.Files = append(result.Files, filePath)
+			log.Printf("Skipping archive configured to stay unextracted: %v", filePath)
+			continue
+		}
+
+		format, detectErr := DetectArchiveFormat(filePath)
+		if detectErr != nil {
+			return nil, detectErr
+		}
+		if format == nil {
+			result.Files = append(result.Files, filePath)
+			log.Printf("Skipping non-archive file: %v", filePath)
 			continue
 		}
 
 		// Get target folder for the extracted files
 		targetDir := filepath.Dir(filePath)
 
-		log.Println("Processing tar file:", filePath)
+		log.Printf("Processing %s archive: %s", format.Name, filePath)
 
-		file, openErr := os.Open(filePath)
-		if openErr != nil {
-			return nil, fmt.Errorf("Error while opening the file: %v", openErr)
+		if extractErr := format.extract(filePath, targetDir, state, result); extractErr != nil {
+			return nil, extractErr
 		}
 
-		gzr, newReaderErr := gzip.NewReader(file)
-		if newReaderErr != nil {
-			return nil, fmt.Errorf("Error while creating gzip reader: %v", newReaderErr)
+		// Remove the original archive after extraction
+		removeErr := os.Remove(filePath)
+		if removeErr != nil {
+			return nil, fmt.Errorf("Error while removing the original archive: %v", removeErr)
+		} else {
+			log.Printf("Removed original archive: %s", filePath)
 		}
+	}
 
-		tr := tar.NewReader(gzr)
-		for {
-			header, err := tr.Next()
-			if err == io.EOF {
-				break // End of tar archive
-			}
+	// Apply the configured ownership to the extracted tree
+	if chownErr := ChownTree(dir, uid, gid); chownErr != nil {
+		return nil, chownErr
+	}
 
-			if err != nil {
-				return nil, fmt.Errorf("Error while reading the tar file: %v", err)
-			}
+	return result, nil
+}
 
-			switch header.Typeflag {
-			case tar.TypeDir:
-				// skip directories
-				continue
-			case tar.TypeReg:
-				target := filepath.Join(targetDir, header.Name)
-				if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
-					return nil, fmt.Errorf("mkdir for file: %w", err)
-				}
-				outFile, err := os.Create(target)
-				if err != nil {
-					return nil, fmt.Errorf("create file: %w", err)
-				}
-				if _, err := io.Copy(outFile, tr); err != nil {
-					outFile.Close()
-					return nil, fmt.Errorf("copy file: %w", err)
-				}
-				outFile.Close()
-			}
+// Returns the directory inside the release directory whose contents become the
+// site. An empty rootDir selects the release directory itself. The root
+// directory must not leave the release directory, also through symlinks.
+func ResolveReleaseRootDir(releaseDir string, rootDir string) (string, error) {
+	if rootDir == "" {
+		return releaseDir, nil
+	}
 
-			processedFiles = append(processedFiles, header.Name)
-			// Log the extracted file
-			log.Printf("Extracted file: %s", header.Name)
-		}
+	cleanedRootDir, cleanErr := cleanEntryName(rootDir)
+	if cleanErr != nil {
+		return "", fmt.Errorf("Invalid root directory %q: %v", rootDir, cleanErr)
+	}
+	resolvedRootDir, resolveErr := resolveInRoot(releaseDir, cleanedRootDir)
+	if resolveErr != nil {
+		return "", fmt.Errorf("Invalid root directory %q: %v", rootDir, resolveErr)
+	}
 
-		// Remove the original gz file after extraction
-		removeErr := os.Remove(filePath)
-		if removeErr != nil {
-			return nil, fmt.Errorf("Error while removing the original gz file: %v", removeErr)
-		} else {
-			log.Printf("Removed original gz file: %s", filePath)
-		}
+	rootDirPath := filepath.Join(releaseDir, resolvedRootDir)
+	info, statErr := os.Stat(rootDirPath)
+	if statErr != nil {
+		return "", fmt.Errorf("Root directory %q not found in the release: %v", rootDir, statErr)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("Root directory %q is not a directory", rootDir)
 	}
 
-	return processedFiles, nil
+	return rootDirPath, nil
 }
 
-// Link release assets to site directory
+// Link release assets to site directory. See
+// LinkReleaseAssetsToSiteDirWithOptions.
 func LinkReleaseAssetsToSiteDir(releaseDir string, siteDir string) error {
+	_, err := LinkReleaseAssetsToSiteDirWithOptions(releaseDir, siteDir, LinkOptions{})
+	return err
+}
+
+// Link release assets to site directory using the configured link strategy. It
+// returns the strategy that was used.
+func LinkReleaseAssetsToSiteDirWithOptions(releaseDir string, siteDir string, options LinkOptions) (string, error) {
+	linker, linkerErr := newAssetLinker(options)
+	if linkerErr != nil {
+		return "", linkerErr
+	}
+
 	// Read files in release directory recursively
 	filesInReleaseDir, readReleaseDirErr := ReadFilesInDir(releaseDir)
 	if readReleaseDirErr != nil {
-		return fmt.Errorf("Error while reading the release directory: %v", readReleaseDirErr)
+		return "", fmt.Errorf("Error while reading the release directory: %v", readReleaseDirErr)
 	}
+	metadataDir := filepath.Join(releaseDir, MetadataDirName) + string(filepath.Separator)
+	releaseAssets := make([]string, 0, len(filesInReleaseDir))
+	for _, file := range filesInReleaseDir {
+		if strings.HasPrefix(filepath.Clean(file), metadataDir) {
+			continue
+		}
+		if relPath, relErr := filepath.Rel(releaseDir, file); relErr == nil && !options.includes(relPath) {
+			continue
+		}
+		releaseAssets = append(releaseAssets, file)
+	}
+	filesInReleaseDir = releaseAssets
 	log.Printf("Found files in the release directory: \n- %v", strings.Join(filesInReleaseDir, "\n- "))
 
 	// Read files in site directory
 	filesInSiteDir, readSiteDirErr := ReadFilesInDir(siteDir)
 	if readSiteDirErr != nil {
-		return fmt.Errorf("Error while reading the site directory: %v", readSiteDirErr)
+		return "", fmt.Errorf("Error while reading the site directory: %v", readSiteDirErr)
 	}
 	log.Printf("Found files in the site directory: \n- %v", strings.Join(filesInSiteDir, "\n- "))
 
@@ -175,7 +793,7 @@ func LinkReleaseAssetsToSiteDir(releaseDir string, siteDir string) error {
 	for _, file := range filesInSiteDir {
 		removeErr := os.Remove(file)
 		if removeErr != nil {
-			return fmt.Errorf("Error while removing the file from the site directory: %v", removeErr)
+			return "", fmt.Errorf("Error while removing the file from the site directory: %v", removeErr)
 		}
 	}
 	log.Printf("Removed files in the site directory")
@@ -184,7 +802,7 @@ func LinkReleaseAssetsToSiteDir(releaseDir string, siteDir string) error {
 	for _, file := range filesInReleaseDir {
 		filePathRelativeToReleaseDir, relErr := filepath.Rel(releaseDir, file)
 		if relErr != nil {
-			return fmt.Errorf("Error while calculating the relative path for the asset: %v", relErr)
+			return "", fmt.Errorf("Error while calculating the relative path for the asset: %v", relErr)
 		}
 
 		// Generate new file path in site directory
@@ -192,19 +810,76 @@ func LinkReleaseAssetsToSiteDir(releaseDir string, siteDir string) error {
 
 		// Make sure the parent dir for file is created in site directory
 		parentDir, _ := path.Split(filePathInSiteDir)
-		mkdirErr := os.MkdirAll(parentDir, os.ModePerm)
+		mkdirErr := linker.mkdirAll(parentDir)
 		if mkdirErr != nil {
-			return fmt.Errorf("Error while creating the parent directory for asset: %v", mkdirErr)
+			return "", fmt.Errorf("Error while creating the parent directory for asset: %v", mkdirErr)
 		}
 
 		// Link release asset to site directory
-		linkErr := os.Link(file, filePathInSiteDir)
+		linkErr := linker.link(file, filePathInSiteDir)
 		if linkErr != nil {
-			return fmt.Errorf("Error while linking the asset: %v", linkErr)
+			return "", fmt.Errorf("Error while linking the asset: %v", linkErr)
 		}
 
 		log.Printf("File is linked: %v", file)
 	}
 
-	return nil
+	log.Printf("Release assets are linked with strategy: %s", linker.usedStrategy())
+	return linker.usedStrategy(), nil
+}
+
+// Removes the oldest release directories of a repository so that at most keep
+// releases remain. The current release is never removed. It returns the paths
+// of the removed release directories.
+func PruneReleaseDirs(assetsDir string, owner string, repo string, keep int, currentTag string) ([]string, error) {
+	removed := make([]string, 0)
+	if keep <= 0 {
+		return removed, nil
+	}
+
+	repoDir := path.Join(assetsDir, owner, repo)
+	entries, readErr := os.ReadDir(repoDir)
+	if readErr != nil {
+		return nil, fmt.Errorf("Error while reading the repository directory: %v", readErr)
+	}
+
+	type releaseDir struct {
+		name    string
+		modTime time.Time
+	}
+	releaseDirs := make([]releaseDir, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		info, infoErr := entry.Info()
+		if infoErr != nil {
+			return nil, fmt.Errorf("Error while reading the release directory: %v", infoErr)
+		}
+		releaseDirs = append(releaseDirs, releaseDir{name: entry.Name(), modTime: info.ModTime()})
+	}
+
+	// Newest releases first
+	sort.Slice(releaseDirs, func(i, j int) bool {
+		return releaseDirs[i].modTime.After(releaseDirs[j].modTime)
+	})
+
+	kept := 0
+	for _, dir := range releaseDirs {
+		if dir.name == currentTag || kept < keep-1 {
+			if dir.name != currentTag {
+				kept++
+			}
+			continue
+		}
+
+		dirPath := path.Join(repoDir, dir.name)
+		if removeErr := os.RemoveAll(dirPath); removeErr != nil {
+			return removed, fmt.Errorf("Error while removing the release directory: %v", removeErr)
+		}
+		log.Printf("Pruned release directory: %s", dirPath)
+		removed = append(removed, dirPath)
+	}
+
+	return removed, nil
 }
@@ -3,9 +3,13 @@ package file_utils
 import (
 	"archive/tar"
 	"compress/gzip"
+	"errors"
 	"os"
 	"path"
+	"strconv"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -41,6 +45,32 @@ func createTestTarGz(t *testing.T, tarGzPath, fileName string, content []byte) {
 	assert.NoError(t, err)
 }
 
+// Helper to create a tar.gz file with the given entries. Regular file entries
+// get their content from the contents map.
+func createTestTarGzWithEntries(t *testing.T, tarGzPath string, headers []*tar.Header, contents map[string][]byte) {
+	f, err := os.Create(tarGzPath)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	gw := gzip.NewWriter(f)
+	defer gw.Close()
+
+	tw := tar.NewWriter(gw)
+	defer tw.Close()
+
+	for _, hdr := range headers {
+		content := contents[hdr.Name]
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len(content))
+		}
+		assert.NoError(t, tw.WriteHeader(hdr))
+		if len(content) > 0 {
+			_, err = tw.Write(content)
+			assert.NoError(t, err)
+		}
+	}
+}
+
 func TestCreateDirIfIsNotExist_EmptyPath(t *testing.T) {
 	// act: try to create a directory with an empty path
 	createDirErr := CreateDirIfIsNotExist("")
@@ -93,117 +123,6 @@ func TestCreateDirIfIsNotExist_Success(t *testing.T) {
 	}
 }
 
-func TestCreateReleaseDirIfIsNotExist_EmptyParams(t *testing.T) {
-	tempDir := setupFileUtilsTest(t)
-
-	// arrange: define the directory path for the test
-	owner := "test-owner"
-	repo := "test-repo"
-	tag := "v1.0.0"
-
-	// act/assert: try to create a release directory with empty tag parameter
-	_, emptyTagErr := CreateReleaseDirIfIsNotExist(tempDir, owner, repo, "")
-	assert.Error(t, emptyTagErr, "Should return an error for empty tag parameter")
-
-	// act/assert: try to create a release directory with empty repo parameter
-	_, emptyRepoErr := CreateReleaseDirIfIsNotExist(tempDir, owner, "", tag)
-	assert.Error(t, emptyRepoErr, "Should return an error for empty repo parameter")
-
-	// act/assert: try to create a release directory with empty owner parameter
-	_, emptyOwnerErr := CreateReleaseDirIfIsNotExist(tempDir, "", repo, tag)
-	assert.Error(t, emptyOwnerErr, "Should return an error for empty owner parameter")
-
-	// act/assert: try to create a release directory with empty assetsDir parameter
-	_, assetsDirErr := CreateReleaseDirIfIsNotExist("", owner, repo, tag)
-	assert.Error(t, assetsDirErr, "Should return an error for empty assetsDir parameter")
-
-	// act/assert: try to create a release directory with empty parameters
-	_, emptyParamsErr := CreateReleaseDirIfIsNotExist("", "", "", "")
-	assert.Error(t, emptyParamsErr, "Should return an error for empty parameters")
-}
-
-func TestCreateReleaseDirIfIsNotExist_ClearContent(t *testing.T) {
-	tempDir := setupFileUtilsTest(t)
-
-	// Arrange: define the directory path for the test
-	owner := "test-owner"
-	repo := "test-repo"
-	tag := "v1.0.0"
-	dirPath := path.Join(tempDir, owner, repo, tag)
-
-	// Arrange: create the release dir
-	err := os.MkdirAll(dirPath, 0755)
-	assert.NoError(t, err, "Expected no error creating initial directory")
-
-	// Arrange: create a file in the directory
-	filePath := path.Join(dirPath, "testfile.txt")
-	err = os.WriteFile(filePath, []byte("content"), 0644)
-	assert.NoError(t, err, "Expected no error creating test file")
-
-	// Act: call CreateReleaseDirIfIsNotExist to clear the content
-	CreateReleaseDirIfIsNotExist(tempDir, owner, repo, tag)
-
-	// Assert: check if the directory was cleared
-	if _, statErr := os.Stat(filePath); !os.IsNotExist(statErr) {
-		t.Fatalf("Expected content to be cleared but file still exists")
-	}
-}
-
-func TestCreateReleaseDirIfIsNotExist_ClearContentError(t *testing.T) {
-	tempDir := setupFileUtilsTest(t)
-
-	// Arrange: define the directory path for the test
-	owner := "test-owner"
-	repo := "test-repo"
-	tag := "v1.0.0"
-	dirPath := path.Join(tempDir, owner, repo, tag)
-
-	// Arrange: create the release dir that cannot be modified
-	err := os.MkdirAll(dirPath, 0755) // no permissions
-	assert.NoError(t, err, "Expected no error creating initial directory")
-
-	// Arrange: create a file in the directory
-	filePath := path.Join(dirPath, "testfile.txt")
-	err = os.WriteFile(filePath, []byte("content"), 0644)
-	assert.NoError(t, err, "Expected no error creating test file")
-
-	os.Chmod(dirPath, 0444) // set directory to read-only
-
-	// Act: call CreateReleaseDirIfIsNotExist to clear the content
-	_, err = CreateReleaseDirIfIsNotExist(tempDir, owner, repo, tag)
-
-	assert.Error(t, err, "Expected error when trying to clear content of read-only directory")
-	assert.Contains(t, err.Error(), "error clearing release directory")
-
-	t.Cleanup(func() {
-		os.Chmod(dirPath, 0755) // restore permissions for cleanup
-	})
-}
-
-func TestCreateReleaseDirIfIsNotExist_Success(t *testing.T) {
-	tempDir := setupFileUtilsTest(t)
-
-	// arrange: define the directory path for the test
-	owner := "test-owner"
-	repo := "test-repo"
-	tag := "v1.0.0"
-	dirPath := path.Join(tempDir, owner, repo, tag)
-
-	// act: create the release directory
-	releaseDir, createDirErr := CreateReleaseDirIfIsNotExist(tempDir, owner, repo, tag)
-
-	// assert: check if the release directory path is correct
-	assert.Equal(t, dirPath, releaseDir, "Expected release directory path to match")
-
-	// assert: check if the error is nil
-	assert.NoError(t, createDirErr, "Expected no error when creating release directory")
-
-	// assert: check if directory was created
-	if _, statErr := os.Stat(dirPath); os.IsNotExist(statErr) {
-		t.Fatalf("Expected directory to be created, but it does not exist")
-	}
-}
-
 func TestReadFilesInDir_EmptyDir(t *testing.T) {
 	tempDir := setupFileUtilsTest(t)
 
@@ -482,3 +401,424 @@ func TestLinkReleaseAssetsToSiteDir_ErrorOnInvalidSiteDir(t *testing.T) {
 	// Assert: error due to invalid site dir
 	assert.Error(t, linkErr, "Expected error for invalid site directory")
 }
+
+func TestExtractArchivesInDir_MaxExtractedSize(t *testing.T) {
+	tempDir := setupFileUtilsTest(t)
+
+	// Arrange: create a tar.gz file with a file larger than the limit
+	tarGzPath := path.Join(tempDir, "test.tar.gz")
+	createTestTarGz(t, tarGzPath, "hello.txt", []byte("hello world"))
+
+	// Act: extract files with a size limit
+	_, err := ExtractArchivesInDir(tempDir, ExtractOptions{MaxExtractedSize: 5})
+
+	// Assert: check if the limit error is returned and nothing is extracted
+	assert.True(t, errors.Is(err, ErrLimitExceeded), "Expected limit error, got %v", err)
+	_, statErr := os.Stat(path.Join(tempDir, "hello.txt"))
+	assert.True(t, os.IsNotExist(statErr), "Expected file not to be extracted")
+}
+
+func TestExtractArchivesInDir_MaxFileCount(t *testing.T) {
+	tempDir := setupFileUtilsTest(t)
+
+	// Arrange: create two tar.gz files with one file each
+	createTestTarGz(t, path.Join(tempDir, "first.tar.gz"), "first.txt", []byte("first"))
+	createTestTarGz(t, path.Join(tempDir, "second.tar.gz"), "second.txt", []byte("second"))
+
+	// Act: extract files with a file count limit
+	_, err := ExtractArchivesInDir(tempDir, ExtractOptions{MaxFileCount: 1})
+
+	// Assert: check if the limit error is returned
+	assert.True(t, errors.Is(err, ErrLimitExceeded), "Expected limit error, got %v", err)
+}
+
+func TestGetAvailableDiskSpace_Success(t *testing.T) {
+	// Act: read the available disk space of a temporary directory
+	available, err := GetAvailableDiskSpace(setupFileUtilsTest(t))
+
+	// Assert: check if the available disk space is reported
+	assert.NoError(t, err)
+	assert.Greater(t, available, uint64(0))
+}
+
+func TestGetAvailableDiskSpace_NonExistentDir(t *testing.T) {
+	// Act: read the available disk space of a non-existent directory
+	_, err := GetAvailableDiskSpace("/non/existent/dir")
+
+	// Assert: check if the error is returned
+	assert.Error(t, err)
+}
+
+func TestExtractArchivesInDir_RejectsPathTraversal(t *testing.T) {
+	tempDir := setupFileUtilsTest(t)
+	releaseDir := path.Join(tempDir, "release")
+	os.MkdirAll(releaseDir, 0755)
+
+	// Arrange: create a tar.gz file with entries escaping the release directory
+	createTestTarGzWithEntries(t, path.Join(releaseDir, "evil.tar.gz"), []*tar.Header{
+		{Name: "../outside.txt", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "/etc/absolute.txt", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "inside.txt", Typeflag: tar.TypeReg, Mode: 0644},
+	}, map[string][]byte{
+		"../outside.txt":    []byte("outside"),
+		"/etc/absolute.txt": []byte("absolute"),
+		"inside.txt":        []byte("inside"),
+	})
+
+	// Act: extract files
+	result, err := ExtractArchivesInDir(releaseDir, ExtractOptions{})
+
+	// Assert: check if only the safe entry is extracted and the others are reported
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"inside.txt"}, result.Files)
+	assert.Len(t, result.Rejected, 2)
+	_, statErr := os.Stat(path.Join(tempDir, "outside.txt"))
+	assert.True(t, os.IsNotExist(statErr), "Expected file outside of the release directory not to be created")
+}
+
+func TestExtractArchivesInDir_Symlinks(t *testing.T) {
+	tempDir := setupFileUtilsTest(t)
+	releaseDir := path.Join(tempDir, "release")
+	os.MkdirAll(releaseDir, 0755)
+
+	// Arrange: create a tar.gz file with safe and unsafe symlinks
+	createTestTarGzWithEntries(t, path.Join(releaseDir, "links.tar.gz"), []*tar.Header{
+		{Name: "dist/index.html", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "index.html", Typeflag: tar.TypeSymlink, Linkname: "dist/index.html"},
+		{Name: "passwd", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"},
+		{Name: "parent", Typeflag: tar.TypeSymlink, Linkname: "../"},
+		{Name: "parent/escaped.txt", Typeflag: tar.TypeReg, Mode: 0644},
+	}, map[string][]byte{
+		"dist/index.html":    []byte("index"),
+		"parent/escaped.txt": []byte("escaped"),
+	})
+
+	// Act: extract files
+	result, err := ExtractArchivesInDir(releaseDir, ExtractOptions{})
+
+	// Assert: check if the safe symlink is created
+	assert.NoError(t, err)
+	data, readErr := os.ReadFile(path.Join(releaseDir, "index.html"))
+	assert.NoError(t, readErr)
+	assert.Equal(t, "index", string(data))
+
+	// Assert: check if the unsafe symlinks are rejected and nothing is written outside
+	assert.ElementsMatch(t, []string{"dist/index.html", "index.html", "parent/escaped.txt"}, result.Files)
+	assert.Len(t, result.Rejected, 2)
+	_, lstatErr := os.Lstat(path.Join(releaseDir, "passwd"))
+	assert.True(t, os.IsNotExist(lstatErr), "Expected absolute symlink not to be created")
+	_, statErr := os.Stat(path.Join(tempDir, "escaped.txt"))
+	assert.True(t, os.IsNotExist(statErr), "Expected file not to be written through a symlink")
+}
+
+func TestExtractArchivesInDir_RejectsChainedSymlinkEscape(t *testing.T) {
+	tempDir := setupFileUtilsTest(t)
+	releaseDir := path.Join(tempDir, "release")
+	os.MkdirAll(releaseDir, 0755)
+
+	// Arrange: create symlinks that only escape when resolved on disk
+	createTestTarGzWithEntries(t, path.Join(releaseDir, "chain.tar.gz"), []*tar.Header{
+		{Name: "self", Typeflag: tar.TypeSymlink, Linkname: "."},
+		{Name: "self/up", Typeflag: tar.TypeSymlink, Linkname: ".."},
+		{Name: "self/up/escaped.txt", Typeflag: tar.TypeReg, Mode: 0644},
+	}, map[string][]byte{
+		"self/up/escaped.txt": []byte("escaped"),
+	})
+
+	// Act: extract files
+	result, err := ExtractArchivesInDir(releaseDir, ExtractOptions{})
+
+	// Assert: check if the escaping symlink is rejected
+	assert.NoError(t, err)
+	assert.Equal(t, "self/up", result.Rejected[0].Name)
+	_, statErr := os.Stat(path.Join(tempDir, "escaped.txt"))
+	assert.True(t, os.IsNotExist(statErr), "Expected file not to be written outside of the release directory")
+}
+
+func TestExtractArchivesInDir_HardlinksAndDevices(t *testing.T) {
+	tempDir := setupFileUtilsTest(t)
+	releaseDir := path.Join(tempDir, "release")
+	os.MkdirAll(releaseDir, 0755)
+
+	// Arrange: create a tar.gz file with hardlinks and a device file
+	createTestTarGzWithEntries(t, path.Join(releaseDir, "links.tar.gz"), []*tar.Header{
+		{Name: "file.txt", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "hardlink.txt", Typeflag: tar.TypeLink, Linkname: "file.txt"},
+		{Name: "outside-hardlink.txt", Typeflag: tar.TypeLink, Linkname: "../secret.txt"},
+		{Name: "null", Typeflag: tar.TypeChar, Devmajor: 1, Devminor: 3},
+	}, map[string][]byte{
+		"file.txt": []byte("content"),
+	})
+
+	// Act: extract files
+	result, err := ExtractArchivesInDir(releaseDir, ExtractOptions{})
+
+	// Assert: check if the safe hardlink is created
+	assert.NoError(t, err)
+	data, readErr := os.ReadFile(path.Join(releaseDir, "hardlink.txt"))
+	assert.NoError(t, readErr)
+	assert.Equal(t, "content", string(data))
+
+	// Assert: check if the unsafe entries are rejected
+	assert.Equal(t, []string{"file.txt", "hardlink.txt"}, result.Files)
+	rejectedNames := make([]string, 0)
+	for _, rejected := range result.Rejected {
+		rejectedNames = append(rejectedNames, rejected.Name)
+	}
+	assert.Equal(t, []string{"outside-hardlink.txt", "null"}, rejectedNames)
+}
+
+func TestExtractArchivesInDir_PreservesModesAndTimes(t *testing.T) {
+	tempDir := setupFileUtilsTest(t)
+	releaseDir := path.Join(tempDir, "release")
+	os.MkdirAll(releaseDir, 0755)
+
+	// Arrange: create a tar.gz file with an executable, a directory and a symlink
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	createTestTarGzWithEntries(t, path.Join(releaseDir, "app.tar.gz"), []*tar.Header{
+		{Name: "bin/", Typeflag: tar.TypeDir, Mode: 0777, ModTime: modTime},
+		{Name: "bin/server", Typeflag: tar.TypeReg, Mode: 0777, ModTime: modTime},
+		{Name: "server", Typeflag: tar.TypeSymlink, Linkname: "bin/server"},
+	}, map[string][]byte{
+		"bin/server": []byte("#!/bin/sh"),
+	})
+
+	// Act: extract files with a umask
+	_, err := ExtractArchivesInDir(releaseDir, ExtractOptions{Umask: 0027})
+
+	// Assert: check if the modes are masked by the umask
+	assert.NoError(t, err)
+	fileInfo, _ := os.Stat(path.Join(releaseDir, "bin/server"))
+	assert.Equal(t, os.FileMode(0750), fileInfo.Mode().Perm())
+	dirInfo, _ := os.Stat(path.Join(releaseDir, "bin"))
+	assert.Equal(t, os.FileMode(0750), dirInfo.Mode().Perm())
+
+	// Assert: check if the modification times are restored
+	assert.True(t, modTime.Equal(fileInfo.ModTime()), "Expected file mtime to be restored, got %v", fileInfo.ModTime())
+	assert.True(t, modTime.Equal(dirInfo.ModTime()), "Expected directory mtime to be restored, got %v", dirInfo.ModTime())
+
+	// Assert: check if the symlink is created
+	linkname, readlinkErr := os.Readlink(path.Join(releaseDir, "server"))
+	assert.NoError(t, readlinkErr)
+	assert.Equal(t, "bin/server", linkname)
+}
+
+func TestExtractArchivesInDir_AppliesOwnership(t *testing.T) {
+	tempDir := setupFileUtilsTest(t)
+
+	// Arrange: create a tar.gz file with a single file inside
+	createTestTarGz(t, path.Join(tempDir, "test.tar.gz"), "hello.txt", []byte("hello world"))
+
+	// Act: extract files with the current user as owner
+	uid, gid := os.Getuid(), os.Getgid()
+	_, err := ExtractArchivesInDir(tempDir, ExtractOptions{Owner: strconv.Itoa(uid), Group: strconv.Itoa(gid)})
+
+	// Assert: check if the ownership is applied
+	assert.NoError(t, err)
+	info, _ := os.Stat(path.Join(tempDir, "hello.txt"))
+	stat := info.Sys().(*syscall.Stat_t)
+	assert.Equal(t, uint32(uid), stat.Uid)
+	assert.Equal(t, uint32(gid), stat.Gid)
+}
+
+func TestLookupOwnership_UnknownUser(t *testing.T) {
+	// Act: look up a user that does not exist
+	_, _, err := LookupOwnership("deploy-to-vm-unknown-user", "")
+
+	// Assert: check if the error is returned
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Failed to look up user")
+}
+
+func TestExtractArchivesInDir_StripComponents(t *testing.T) {
+	releaseDir := setupFileUtilsTest(t)
+
+	// Arrange: create a tar.gz file wrapping everything in a top-level folder
+	createTestTarGzWithEntries(t, path.Join(releaseDir, "app.tar.gz"), []*tar.Header{
+		{Name: "app-v1.2.3/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "app-v1.2.3/index.html", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "app-v1.2.3/assets/app.js", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "app-v1.2.3/index-copy.html", Typeflag: tar.TypeLink, Linkname: "app-v1.2.3/index.html"},
+	}, map[string][]byte{
+		"app-v1.2.3/index.html":    []byte("<html></html>"),
+		"app-v1.2.3/assets/app.js": []byte("console.log('hi')"),
+	})
+
+	// Act: extract files stripping the top-level folder
+	result, err := ExtractArchivesInDir(releaseDir, ExtractOptions{StripComponents: 1})
+
+	// Assert: check if the files are extracted without the top-level folder
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"index.html", "assets/app.js", "index-copy.html"}, result.Files)
+	_, statErr := os.Stat(path.Join(releaseDir, "assets", "app.js"))
+	assert.NoError(t, statErr)
+	_, statErr = os.Stat(path.Join(releaseDir, "app-v1.2.3"))
+	assert.True(t, os.IsNotExist(statErr), "Expected top-level folder not to be created")
+}
+
+func TestResolveReleaseRootDir(t *testing.T) {
+	releaseDir := setupFileUtilsTest(t)
+
+	// Arrange: create a build directory, a file and a symlink leaving the release
+	os.MkdirAll(path.Join(releaseDir, "dist", "build"), 0755)
+	os.WriteFile(path.Join(releaseDir, "readme.md"), []byte("readme"), 0644)
+	os.Symlink("..", path.Join(releaseDir, "up"))
+
+	// Act: resolve several root directories
+	emptyRootDir, emptyErr := ResolveReleaseRootDir(releaseDir, "")
+	buildRootDir, buildErr := ResolveReleaseRootDir(releaseDir, "dist/build/")
+	_, fileErr := ResolveReleaseRootDir(releaseDir, "readme.md")
+	_, missingErr := ResolveReleaseRootDir(releaseDir, "missing")
+	_, escapeErr := ResolveReleaseRootDir(releaseDir, "../")
+	_, symlinkErr := ResolveReleaseRootDir(releaseDir, "up")
+
+	// Assert: check if only directories inside the release are accepted
+	assert.NoError(t, emptyErr)
+	assert.Equal(t, releaseDir, emptyRootDir)
+	assert.NoError(t, buildErr)
+	assert.Equal(t, path.Join(releaseDir, "dist", "build"), buildRootDir)
+	assert.Error(t, fileErr)
+	assert.Error(t, missingErr)
+	assert.Error(t, escapeErr)
+	assert.Error(t, symlinkErr)
+}
+
+func TestPruneReleaseDirs(t *testing.T) {
+	assetsDir := setupFileUtilsTest(t)
+
+	// Arrange: create four releases, the oldest one being the current release
+	now := time.Now()
+	for i, tag := range []string{"v1", "v2", "v3", "v4"} {
+		releaseDir := path.Join(assetsDir, "owner", "repo", tag)
+		os.MkdirAll(releaseDir, 0755)
+		modTime := now.Add(time.Duration(i) * time.Hour)
+		os.Chtimes(releaseDir, modTime, modTime)
+	}
+
+	// Act: keep two releases
+	removed, err := PruneReleaseDirs(assetsDir, "owner", "repo", 2, "v1")
+
+	// Assert: check if the current and the newest release are kept
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		path.Join(assetsDir, "owner", "repo", "v2"),
+		path.Join(assetsDir, "owner", "repo", "v3"),
+	}, removed)
+	entries, _ := os.ReadDir(path.Join(assetsDir, "owner", "repo"))
+	assert.Len(t, entries, 2)
+}
+
+func TestCreateStagingDir_Success(t *testing.T) {
+	assetsDir := setupFileUtilsTest(t)
+
+	// Act: create two staging directories for the same tag
+	firstStagingDir, firstErr := CreateStagingDir(assetsDir, "owner", "repo", "release/v1")
+	secondStagingDir, secondErr := CreateStagingDir(assetsDir, "owner", "repo", "release/v1")
+
+	// Assert: check if both are distinct directories in the staging root
+	assert.NoError(t, firstErr)
+	assert.NoError(t, secondErr)
+	assert.NotEqual(t, firstStagingDir, secondStagingDir)
+	assert.Equal(t, path.Join(assetsDir, StagingDirName), path.Dir(firstStagingDir))
+	info, statErr := os.Stat(firstStagingDir)
+	assert.NoError(t, statErr)
+	assert.True(t, info.IsDir())
+}
+
+func TestCreateStagingDir_EmptyParams(t *testing.T) {
+	// Act: create a staging directory without a tag
+	_, err := CreateStagingDir(setupFileUtilsTest(t), "owner", "repo", "")
+
+	// Assert: check if the error is returned
+	assert.Error(t, err)
+}
+
+func TestPromoteStagingDir_ReplacesExistingRelease(t *testing.T) {
+	assetsDir := setupFileUtilsTest(t)
+
+	// Arrange: create an existing release and a staging directory for the same tag
+	releaseDir := ReleaseDirPath(assetsDir, "owner", "repo", "v1")
+	os.MkdirAll(releaseDir, 0755)
+	os.WriteFile(path.Join(releaseDir, "old.txt"), []byte("old"), 0644)
+	stagingDir, _ := CreateStagingDir(assetsDir, "owner", "repo", "v1")
+	os.WriteFile(path.Join(stagingDir, "new.txt"), []byte("new"), 0644)
+
+	// Act: promote the staging directory
+	err := PromoteStagingDir(stagingDir, releaseDir)
+
+	// Assert: check if the release is replaced and nothing is left in staging
+	assert.NoError(t, err)
+	_, newErr := os.Stat(path.Join(releaseDir, "new.txt"))
+	assert.NoError(t, newErr)
+	_, oldErr := os.Stat(path.Join(releaseDir, "old.txt"))
+	assert.True(t, os.IsNotExist(oldErr), "Expected the old release to be replaced")
+	entries, _ := os.ReadDir(path.Join(assetsDir, StagingDirName))
+	assert.Empty(t, entries)
+}
+
+func TestCleanStagingDirs(t *testing.T) {
+	assetsDir := setupFileUtilsTest(t)
+
+	// Arrange: create a stale staging directory with a partial release
+	stagingDir, _ := CreateStagingDir(assetsDir, "owner", "repo", "v1")
+	os.WriteFile(path.Join(stagingDir, "partial.tar.gz"), []byte("partial"), 0644)
+
+	// Act: clean the staging directories
+	err := CleanStagingDirs(assetsDir)
+
+	// Assert: check if the stale staging directory is removed
+	assert.NoError(t, err)
+	_, statErr := os.Stat(stagingDir)
+	assert.True(t, os.IsNotExist(statErr), "Expected stale staging directory to be removed")
+}
+
+func TestCleanStagingDirs_NoStagingDir(t *testing.T) {
+	// Act: clean the staging directories of a fresh assets directory
+	err := CleanStagingDirs(setupFileUtilsTest(t))
+
+	// Assert: no error
+	assert.NoError(t, err)
+}
+
+func TestExtractArchivesInDir_RejectsMetadataDir(t *testing.T) {
+	releaseDir := setupFileUtilsTest(t)
+
+	// Arrange: create a tar.gz file with an entry in the reserved metadata directory
+	createTestTarGzWithEntries(t, path.Join(releaseDir, "app.tar.gz"), []*tar.Header{
+		{Name: MetadataDirName + "/manifest.json", Typeflag: tar.TypeReg, Mode: 0644},
+	}, map[string][]byte{
+		MetadataDirName + "/manifest.json": []byte("{}"),
+	})
+
+	// Act: extract files
+	result, err := ExtractArchivesInDir(releaseDir, ExtractOptions{})
+
+	// Assert: check if the entry is rejected
+	assert.NoError(t, err)
+	assert.Empty(t, result.Files)
+	assert.Equal(t, "reserved path", result.Rejected[0].Reason)
+}
+
+func TestSetActiveRelease_Success(t *testing.T) {
+	assetsDir := setupFileUtilsTest(t)
+
+	// Act: activate two releases one after the other
+	firstErr := SetActiveRelease(assetsDir, "owner", "repo", "v1")
+	secondErr := SetActiveRelease(assetsDir, "owner", "repo", "v2")
+	tag, getErr := GetActiveRelease(assetsDir, "owner", "repo")
+
+	// Assert: check if the last release is active
+	assert.NoError(t, firstErr)
+	assert.NoError(t, secondErr)
+	assert.NoError(t, getErr)
+	assert.Equal(t, "v2", tag)
+}
+
+func TestGetActiveRelease_NoActiveRelease(t *testing.T) {
+	// Act: get the active release of a repository that was never deployed
+	tag, err := GetActiveRelease(setupFileUtilsTest(t), "owner", "repo")
+
+	// Assert: no error and no tag
+	assert.NoError(t, err)
+	assert.Equal(t, "", tag)
+}
@@ -0,0 +1,220 @@
+package file_utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+const (
+	// LinkStrategy_Auto hard links release assets and falls back when the
+	// release and the site directory are on different filesystems. This is the
+	// default strategy.
+	LinkStrategy_Auto = "auto"
+	// LinkStrategy_Hardlink always hard links release assets
+	LinkStrategy_Hardlink = "hardlink"
+	// LinkStrategy_Copy copies release assets, sharing the data with a reflink
+	// where the filesystem supports it
+	LinkStrategy_Copy = "copy"
+	// LinkStrategy_Symlink creates symlinks to release assets
+	LinkStrategy_Symlink = "symlink"
+	// LinkStrategy_Reflink is reported when LinkStrategy_Copy could clone the
+	// data of every asset
+	LinkStrategy_Reflink = "reflink"
+)
+
+// LinkOptions configures how release assets are placed in the site directory.
+type LinkOptions struct {
+	// Strategy is one of "auto" (default), "hardlink", "copy" or "symlink"
+	Strategy string
+	// Fallback is the strategy used by "auto" when hard links are not
+	// possible, either "copy" (default) or "symlink"
+	Fallback string
+	// Include are path patterns, in the format of protected paths (see
+	// IsProtectedPath), of the release files to link. All files are linked
+	// when empty.
+	Include []string
+	// Owner is the user and group that copied assets and the directories
+	// created for them are given to. They keep the user of the server when
+	// nil. Hard links and symlinks always keep the owner of the release file.
+	Owner *FileOwner
+}
+
+// FileOwner is the numeric user and group of a file.
+type FileOwner struct {
+	Uid int
+	Gid int
+}
+
+// Checks if a release file, given by its path relative to the release
+// directory, is linked with the options
+func (o LinkOptions) includes(relPath string) bool {
+	return len(o.Include) == 0 || IsProtectedPath(filepath.ToSlash(relPath), o.Include)
+}
+
+// assetLinker places release assets in the site directory and keeps track of
+// the strategy that was used. Once hard links fail across filesystems, the
+// fallback is used for the remaining assets.
+type assetLinker struct {
+	strategy string
+	fallback string
+	owner    *FileOwner
+	// copied and reflinked count the assets placed by LinkStrategy_Copy
+	copied    int
+	reflinked int
+}
+
+func newAssetLinker(options LinkOptions) (*assetLinker, error) {
+	strategy := options.Strategy
+	if strategy == "" {
+		strategy = LinkStrategy_Auto
+	}
+	switch strategy {
+	case LinkStrategy_Auto, LinkStrategy_Hardlink, LinkStrategy_Copy, LinkStrategy_Symlink:
+	default:
+		return nil, fmt.Errorf("Unknown link strategy: %q", options.Strategy)
+	}
+
+	fallback := options.Fallback
+	if fallback == "" {
+		fallback = LinkStrategy_Copy
+	}
+	if fallback != LinkStrategy_Copy && fallback != LinkStrategy_Symlink {
+		return nil, fmt.Errorf("Unknown link fallback: %q", options.Fallback)
+	}
+
+	return &assetLinker{strategy: strategy, fallback: fallback, owner: options.Owner}, nil
+}
+
+// Returns the strategy that was used to place the assets
+func (l *assetLinker) usedStrategy() string {
+	switch l.strategy {
+	case LinkStrategy_Auto:
+		return LinkStrategy_Hardlink
+	case LinkStrategy_Copy:
+		if l.reflinked > 0 && l.reflinked == l.copied {
+			return LinkStrategy_Reflink
+		}
+	}
+	return l.strategy
+}
+
+// Places a release asset at the given path in the site directory
+func (l *assetLinker) link(releaseFile string, siteFile string) error {
+	switch l.strategy {
+	case LinkStrategy_Auto, LinkStrategy_Hardlink:
+		linkErr := os.Link(releaseFile, siteFile)
+		if linkErr == nil || l.strategy == LinkStrategy_Hardlink || !errors.Is(linkErr, syscall.EXDEV) {
+			return linkErr
+		}
+		log.Printf("Release and site directory are on different filesystems, falling back to %s", l.fallback)
+		l.strategy = l.fallback
+		return l.link(releaseFile, siteFile)
+	case LinkStrategy_Symlink:
+		absReleaseFile, absErr := filepath.Abs(releaseFile)
+		if absErr != nil {
+			return absErr
+		}
+		return os.Symlink(absReleaseFile, siteFile)
+	default:
+		reflinked, copyErr := copyFile(releaseFile, siteFile)
+		if copyErr != nil {
+			return copyErr
+		}
+		if l.owner != nil {
+			if chownErr := os.Lchown(siteFile, l.owner.Uid, l.owner.Gid); chownErr != nil {
+				return chownErr
+			}
+		}
+		l.copied++
+		if reflinked {
+			l.reflinked++
+		}
+		return nil
+	}
+}
+
+// Creates a directory together with its parents like os.MkdirAll and gives
+// the directories it created to the owner of the linker
+func (l *assetLinker) mkdirAll(dir string) error {
+	created := make([]string, 0)
+	if l.owner != nil {
+		for parent := filepath.Clean(dir); ; parent = filepath.Dir(parent) {
+			if _, lstatErr := os.Lstat(parent); !os.IsNotExist(lstatErr) {
+				break
+			}
+			created = append(created, parent)
+			if filepath.Dir(parent) == parent {
+				break
+			}
+		}
+	}
+
+	if mkdirErr := os.MkdirAll(dir, os.ModePerm); mkdirErr != nil {
+		return mkdirErr
+	}
+	for _, createdDir := range created {
+		if chownErr := os.Lchown(createdDir, l.owner.Uid, l.owner.Gid); chownErr != nil {
+			return chownErr
+		}
+	}
+	return nil
+}
+
+// Checks if a site file is a symlink created for the release file
+func isSymlinkTo(siteFile string, releaseFile string) bool {
+	linkname, readlinkErr := os.Readlink(siteFile)
+	if readlinkErr != nil {
+		return false
+	}
+	absReleaseFile, absErr := filepath.Abs(releaseFile)
+	return absErr == nil && linkname == absReleaseFile
+}
+
+// Copies a file with its mode and mtime. The data is shared with a reflink
+// where possible, which is reported by the returned flag.
+func copyFile(src string, dst string) (bool, error) {
+	info, lstatErr := os.Lstat(src)
+	if lstatErr != nil {
+		return false, lstatErr
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		linkname, readlinkErr := os.Readlink(src)
+		if readlinkErr != nil {
+			return false, readlinkErr
+		}
+		return false, os.Symlink(linkname, dst)
+	}
+
+	srcFile, openErr := os.Open(src)
+	if openErr != nil {
+		return false, openErr
+	}
+	defer srcFile.Close()
+
+	dstFile, createErr := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if createErr != nil {
+		return false, createErr
+	}
+
+	reflinked := reflinkFile(srcFile, dstFile) == nil
+	if !reflinked {
+		if _, copyErr := io.Copy(dstFile, srcFile); copyErr != nil {
+			dstFile.Close()
+			return false, copyErr
+		}
+	}
+	if closeErr := dstFile.Close(); closeErr != nil {
+		return false, closeErr
+	}
+
+	// OpenFile is affected by the process umask
+	if chmodErr := os.Chmod(dst, info.Mode().Perm()); chmodErr != nil {
+		return false, chmodErr
+	}
+	return reflinked, os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
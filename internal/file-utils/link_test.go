@@ -0,0 +1,214 @@
+package file_utils
+
+import (
+	"os"
+	"path"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper to create a temporary directory on another filesystem than the given
+// directory, skipping the test if there is none
+func tempDirOnOtherFilesystem(t *testing.T, dir string) string {
+	var dirStat syscall.Stat_t
+	assert.NoError(t, syscall.Stat(dir, &dirStat))
+
+	for _, candidate := range []string{"/dev/shm", "/run", "/var/tmp"} {
+		var candidateStat syscall.Stat_t
+		if syscall.Stat(candidate, &candidateStat) != nil || candidateStat.Dev == dirStat.Dev {
+			continue
+		}
+		otherDir, err := os.MkdirTemp(candidate, "deploy-to-vm-test-")
+		if err != nil {
+			continue
+		}
+		t.Cleanup(func() { os.RemoveAll(otherDir) })
+		return otherDir
+	}
+
+	t.Skip("No directory on another filesystem found")
+	return ""
+}
+
+func TestLinkReleaseAssetsToSiteDirWithOptions_Copy(t *testing.T) {
+	releaseDir := setupFileUtilsTest(t)
+	siteDir := setupFileUtilsTest(t)
+
+	// Arrange: create a release file
+	releaseFile := path.Join(releaseDir, "index.html")
+	os.WriteFile(releaseFile, []byte("index"), 0640)
+
+	// Act: copy the release assets
+	strategy, err := LinkReleaseAssetsToSiteDirWithOptions(releaseDir, siteDir, LinkOptions{Strategy: LinkStrategy_Copy})
+
+	// Assert: check if the file is copied with its mode instead of linked
+	assert.NoError(t, err)
+	assert.Contains(t, []string{LinkStrategy_Copy, LinkStrategy_Reflink}, strategy)
+	releaseInfo, _ := os.Stat(releaseFile)
+	siteInfo, statErr := os.Stat(path.Join(siteDir, "index.html"))
+	assert.NoError(t, statErr)
+	assert.False(t, os.SameFile(releaseInfo, siteInfo), "Expected the file to be copied")
+	assert.Equal(t, os.FileMode(0640), siteInfo.Mode().Perm())
+	assert.Equal(t, releaseInfo.ModTime(), siteInfo.ModTime())
+}
+
+func TestLinkReleaseAssetsToSiteDirWithOptions_CopyAppliesOwner(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Changing the owner of a file requires root")
+	}
+	releaseDir := setupFileUtilsTest(t)
+	siteDir := setupFileUtilsTest(t)
+
+	// Arrange: create a release file in a subdirectory
+	os.MkdirAll(path.Join(releaseDir, "assets"), 0755)
+	os.WriteFile(path.Join(releaseDir, "assets", "app.js"), []byte("app"), 0644)
+
+	// Act: copy the release assets for another user
+	_, err := LinkReleaseAssetsToSiteDirWithOptions(releaseDir, siteDir, LinkOptions{Strategy: LinkStrategy_Copy, Owner: &FileOwner{Uid: 1001, Gid: 1002}})
+
+	// Assert: check if the copied file and the created directory are owned by
+	// the user while the release file keeps its owner
+	assert.NoError(t, err)
+	for _, siteFile := range []string{path.Join(siteDir, "assets"), path.Join(siteDir, "assets", "app.js")} {
+		info, statErr := os.Lstat(siteFile)
+		assert.NoError(t, statErr)
+		stat := info.Sys().(*syscall.Stat_t)
+		assert.Equal(t, uint32(1001), stat.Uid, siteFile)
+		assert.Equal(t, uint32(1002), stat.Gid, siteFile)
+	}
+	releaseInfo, _ := os.Stat(path.Join(releaseDir, "assets", "app.js"))
+	assert.Equal(t, uint32(os.Geteuid()), releaseInfo.Sys().(*syscall.Stat_t).Uid)
+	siteDirInfo, _ := os.Stat(siteDir)
+	assert.Equal(t, uint32(os.Geteuid()), siteDirInfo.Sys().(*syscall.Stat_t).Uid, "Expected the existing site directory to keep its owner")
+}
+
+func TestSyncReleaseAssetsToSiteDir_CopyAppliesOwner(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Changing the owner of a file requires root")
+	}
+	releaseDir := setupFileUtilsTest(t)
+	siteDir := setupFileUtilsTest(t)
+
+	// Arrange: create a release file in a subdirectory
+	os.MkdirAll(path.Join(releaseDir, "assets"), 0755)
+	os.WriteFile(path.Join(releaseDir, "assets", "app.js"), []byte("app"), 0644)
+
+	// Act: sync the release assets for another user
+	options := SyncOptions{Link: LinkOptions{Strategy: LinkStrategy_Copy, Owner: &FileOwner{Uid: 1001, Gid: 1002}}}
+	_, err := SyncReleaseAssetsToSiteDir(releaseDir, siteDir, options)
+
+	// Assert: check if the copied file and the created directory are owned by
+	// the user
+	assert.NoError(t, err)
+	for _, siteFile := range []string{path.Join(siteDir, "assets"), path.Join(siteDir, "assets", "app.js")} {
+		info, statErr := os.Lstat(siteFile)
+		assert.NoError(t, statErr)
+		stat := info.Sys().(*syscall.Stat_t)
+		assert.Equal(t, uint32(1001), stat.Uid, siteFile)
+		assert.Equal(t, uint32(1002), stat.Gid, siteFile)
+	}
+}
+
+func TestLinkReleaseAssetsToSiteDirWithOptions_Symlink(t *testing.T) {
+	releaseDir := setupFileUtilsTest(t)
+	siteDir := setupFileUtilsTest(t)
+
+	// Arrange: create a release file
+	os.WriteFile(path.Join(releaseDir, "index.html"), []byte("index"), 0644)
+
+	// Act: symlink the release assets
+	strategy, err := LinkReleaseAssetsToSiteDirWithOptions(releaseDir, siteDir, LinkOptions{Strategy: LinkStrategy_Symlink})
+
+	// Assert: check if the site file is a symlink to the release file
+	assert.NoError(t, err)
+	assert.Equal(t, LinkStrategy_Symlink, strategy)
+	linkname, readlinkErr := os.Readlink(path.Join(siteDir, "index.html"))
+	assert.NoError(t, readlinkErr)
+	assert.Equal(t, path.Join(releaseDir, "index.html"), linkname)
+}
+
+func TestLinkReleaseAssetsToSiteDirWithOptions_UnknownStrategy(t *testing.T) {
+	// Act: link with an unknown strategy
+	_, err := LinkReleaseAssetsToSiteDirWithOptions(t.TempDir(), t.TempDir(), LinkOptions{Strategy: "teleport"})
+
+	// Assert: check if the error is returned
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Unknown link strategy")
+}
+
+func TestLinkReleaseAssetsToSiteDirWithOptions_CrossFilesystemFallback(t *testing.T) {
+	releaseDir := setupFileUtilsTest(t)
+	siteDir := tempDirOnOtherFilesystem(t, releaseDir)
+
+	// Arrange: create a release file
+	os.WriteFile(path.Join(releaseDir, "index.html"), []byte("index"), 0644)
+
+	// Act: link the release assets across filesystems
+	strategy, err := LinkReleaseAssetsToSiteDirWithOptions(releaseDir, siteDir, LinkOptions{Fallback: LinkStrategy_Symlink})
+
+	// Assert: check if the fallback strategy is used
+	assert.NoError(t, err)
+	assert.Equal(t, LinkStrategy_Symlink, strategy)
+	data, readErr := os.ReadFile(path.Join(siteDir, "index.html"))
+	assert.NoError(t, readErr)
+	assert.Equal(t, []byte("index"), data)
+}
+
+func TestSyncReleaseAssetsToSiteDir_SymlinkStrategy_Unchanged(t *testing.T) {
+	releaseDir := setupFileUtilsTest(t)
+	siteDir := setupFileUtilsTest(t)
+
+	// Arrange: create a release file and sync it once with symlinks
+	os.WriteFile(path.Join(releaseDir, "index.html"), []byte("index"), 0644)
+	options := SyncOptions{Link: LinkOptions{Strategy: LinkStrategy_Symlink}}
+	_, firstErr := SyncReleaseAssetsToSiteDir(releaseDir, siteDir, options)
+	assert.NoError(t, firstErr)
+
+	// Act: sync the same release again
+	result, err := SyncReleaseAssetsToSiteDir(releaseDir, siteDir, options)
+
+	// Assert: check if the symlinked file is recognized as unchanged
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Unchanged)
+	assert.Empty(t, result.Updated)
+	assert.Equal(t, LinkStrategy_Symlink, result.Strategy)
+}
+
+func TestLinkReleaseAssetsToSiteDirWithOptions_Include(t *testing.T) {
+	releaseDir := setupFileUtilsTest(t)
+	siteDir := setupFileUtilsTest(t)
+
+	// Arrange: create a release with frontend and server files
+	os.MkdirAll(path.Join(releaseDir, "assets"), 0755)
+	os.WriteFile(path.Join(releaseDir, "index.html"), []byte("index"), 0644)
+	os.WriteFile(path.Join(releaseDir, "assets", "app.js"), []byte("app"), 0644)
+	os.WriteFile(path.Join(releaseDir, "server.js"), []byte("server"), 0644)
+
+	// Act: link only the frontend files
+	_, err := LinkReleaseAssetsToSiteDirWithOptions(releaseDir, siteDir, LinkOptions{Include: []string{"*.html", "assets/"}})
+
+	// Assert: check if the other files are not linked
+	assert.NoError(t, err)
+	siteFiles, _ := ReadFilesInDir(siteDir)
+	assert.ElementsMatch(t, []string{path.Join(siteDir, "index.html"), path.Join(siteDir, "assets", "app.js")}, siteFiles)
+}
+
+func TestSyncReleaseAssetsToSiteDir_Include(t *testing.T) {
+	releaseDir := setupFileUtilsTest(t)
+	siteDir := setupFileUtilsTest(t)
+
+	// Arrange: create a release and a site with a file that is no longer included
+	os.WriteFile(path.Join(releaseDir, "index.html"), []byte("index"), 0644)
+	os.WriteFile(path.Join(releaseDir, "server.js"), []byte("server"), 0644)
+	os.WriteFile(path.Join(siteDir, "server.js"), []byte("server"), 0644)
+
+	// Act: sync only the html files
+	result, err := SyncReleaseAssetsToSiteDir(releaseDir, siteDir, SyncOptions{Link: LinkOptions{Include: []string{"*.html"}}})
+
+	// Assert: check if the excluded file is removed from the site
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"index.html"}, result.Added)
+	assert.Equal(t, []string{"server.js"}, result.Removed)
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package file_utils
+
+import (
+	"errors"
+	"os"
+)
+
+// Reflinks are only supported on Linux
+func reflinkFile(src *os.File, dst *os.File) error {
+	return errors.New("reflinks are not supported on this platform")
+}
@@ -0,0 +1,309 @@
+package file_utils
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"deploy-to-vm/internal/checksum"
+)
+
+const (
+	// SyncMode_Replace removes every file in the site directory and links all
+	// release assets again. This is the default mode.
+	SyncMode_Replace = "replace"
+	// SyncMode_Sync only adds, replaces and removes the files that differ
+	// between the release and the site directory.
+	SyncMode_Sync = "sync"
+)
+
+// SyncOptions configures how a release is synced into the site directory.
+type SyncOptions struct {
+	// ProtectedPaths are paths relative to the site directory that are never
+	// added, replaced or removed, e.g. "uploads/" or ".env". Patterns are
+	// matched with filepath.Match and a directory protects everything in it.
+	ProtectedPaths []string
+	// Link configures how release assets are placed in the site directory
+	Link LinkOptions
+}
+
+// SyncResult describes the changes made to the site directory. Paths are
+// relative to the site directory.
+type SyncResult struct {
+	Added       []string `json:"added"`
+	Updated     []string `json:"updated"`
+	Removed     []string `json:"removed"`
+	Unchanged   int      `json:"unchanged"`
+	RemovedDirs []string `json:"removedDirs"`
+	// Strategy is the link strategy that was used for the added and updated
+	// files
+	Strategy string `json:"strategy"`
+}
+
+// Checks if a path relative to the site directory is protected
+func IsProtectedPath(relPath string, protectedPaths []string) bool {
+	relPath = filepath.ToSlash(relPath)
+	for _, protectedPath := range protectedPaths {
+		pattern := strings.TrimSuffix(filepath.ToSlash(filepath.Clean(protectedPath)), "/")
+		if pattern == "" || pattern == "." {
+			continue
+		}
+		if relPath == pattern || strings.HasPrefix(relPath, pattern+"/") {
+			return true
+		}
+		if matched, _ := filepath.Match(pattern, relPath); matched {
+			return true
+		}
+		// A pattern matching a parent directory protects its contents
+		for dir := filepath.Dir(relPath); dir != "."; dir = filepath.Dir(dir) {
+			if matched, _ := filepath.Match(pattern, filepath.ToSlash(dir)); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Checks if a site file already has the content of a release file. Hard links
+// to the same file are detected without hashing.
+func hasSameContent(releaseFile string, releaseInfo os.FileInfo, siteFile string, siteInfo os.FileInfo) (bool, error) {
+	if os.SameFile(releaseInfo, siteInfo) || isSymlinkTo(siteFile, releaseFile) {
+		return true, nil
+	}
+	if !releaseInfo.Mode().IsRegular() || !siteInfo.Mode().IsRegular() {
+		return false, nil
+	}
+	if releaseInfo.Size() != siteInfo.Size() {
+		return false, nil
+	}
+
+	releaseHash, releaseHashErr := checksum.FileSHA256(releaseFile)
+	if releaseHashErr != nil {
+		return false, releaseHashErr
+	}
+	siteHash, siteHashErr := checksum.FileSHA256(siteFile)
+	if siteHashErr != nil {
+		return false, siteHashErr
+	}
+	return releaseHash == siteHash, nil
+}
+
+// Links a release file into the site directory, replacing the existing entry
+// atomically with a rename
+func replaceWithLink(linker *assetLinker, releaseFile string, siteFile string) error {
+	tempFile := filepath.Join(filepath.Dir(siteFile), fmt.Sprintf(".%s.deploy-to-vm-tmp", filepath.Base(siteFile)))
+	if err := removeExistingEntry(tempFile); err != nil {
+		return err
+	}
+	if err := linker.link(releaseFile, tempFile); err != nil {
+		return err
+	}
+	if err := os.Rename(tempFile, siteFile); err != nil {
+		os.Remove(tempFile)
+		return err
+	}
+	return nil
+}
+
+// Syncs the release assets into the site directory. Files are compared by
+// content, so unchanged files are left as they are, changed files are replaced
+// atomically and files that are not part of the release are removed together
+// with the directories that become empty. Protected paths are never touched.
+func SyncReleaseAssetsToSiteDir(releaseDir string, siteDir string, options SyncOptions) (*SyncResult, error) {
+	linker, linkerErr := newAssetLinker(options.Link)
+	if linkerErr != nil {
+		return nil, linkerErr
+	}
+
+	result := &SyncResult{
+		Added:       make([]string, 0),
+		Updated:     make([]string, 0),
+		Removed:     make([]string, 0),
+		RemovedDirs: make([]string, 0),
+	}
+
+	// Add or replace the files of the release
+	releaseFiles := make(map[string]bool)
+	walkReleaseErr := filepath.Walk(releaseDir, func(releaseFile string, releaseInfo os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if releaseInfo.IsDir() {
+			if isMetadataDir(releaseDir, releaseFile) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		relPath, relErr := filepath.Rel(releaseDir, releaseFile)
+		if relErr != nil {
+			return fmt.Errorf("Error while calculating the relative path for the asset: %v", relErr)
+		}
+		if !options.Link.includes(relPath) {
+			return nil
+		}
+		releaseFiles[relPath] = true
+
+		if IsProtectedPath(relPath, options.ProtectedPaths) {
+			log.Printf("Skipping protected path: %s", relPath)
+			return nil
+		}
+
+		if err := removeSiteFileParents(siteDir, relPath, options.ProtectedPaths, result); err != nil {
+			return err
+		}
+
+		siteFile := filepath.Join(siteDir, relPath)
+		siteInfo, lstatErr := os.Lstat(siteFile)
+		if lstatErr != nil && !os.IsNotExist(lstatErr) {
+			return fmt.Errorf("Error while reading the site file: %v", lstatErr)
+		}
+
+		if lstatErr == nil && siteInfo.IsDir() {
+			// A directory is replaced by a file, unless it contains protected paths
+			if err := removeSiteDir(siteDir, relPath, options.ProtectedPaths, result); err != nil {
+				return err
+			}
+			siteInfo, lstatErr = nil, os.ErrNotExist
+		}
+
+		if lstatErr == nil {
+			sameContent, compareErr := hasSameContent(releaseFile, releaseInfo, siteFile, siteInfo)
+			if compareErr != nil {
+				return fmt.Errorf("Error while comparing the asset: %v", compareErr)
+			}
+			if sameContent {
+				result.Unchanged++
+				return nil
+			}
+		}
+
+		if err := linker.mkdirAll(filepath.Dir(siteFile)); err != nil {
+			return fmt.Errorf("Error while creating the parent directory for asset: %v", err)
+		}
+		if err := replaceWithLink(linker, releaseFile, siteFile); err != nil {
+			return fmt.Errorf("Error while linking the asset: %v", err)
+		}
+
+		if lstatErr == nil {
+			result.Updated = append(result.Updated, filepath.ToSlash(relPath))
+			log.Printf("File is updated: %s", relPath)
+		} else {
+			result.Added = append(result.Added, filepath.ToSlash(relPath))
+			log.Printf("File is added: %s", relPath)
+		}
+		return nil
+	})
+	if walkReleaseErr != nil {
+		return nil, fmt.Errorf("Error while syncing the release directory: %v", walkReleaseErr)
+	}
+
+	// Remove stale files and collect the directories of the site
+	siteDirs := make([]string, 0)
+	walkSiteErr := filepath.Walk(siteDir, func(siteFile string, siteInfo os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, relErr := filepath.Rel(siteDir, siteFile)
+		if relErr != nil {
+			return relErr
+		}
+		if relPath == "." {
+			return nil
+		}
+		if IsProtectedPath(relPath, options.ProtectedPaths) {
+			if siteInfo.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if siteInfo.IsDir() {
+			siteDirs = append(siteDirs, relPath)
+			return nil
+		}
+		if releaseFiles[relPath] {
+			return nil
+		}
+
+		if removeErr := os.Remove(siteFile); removeErr != nil {
+			return fmt.Errorf("Error while removing the file from the site directory: %v", removeErr)
+		}
+		result.Removed = append(result.Removed, filepath.ToSlash(relPath))
+		log.Printf("File is removed: %s", relPath)
+		return nil
+	})
+	if walkSiteErr != nil {
+		return nil, fmt.Errorf("Error while syncing the site directory: %v", walkSiteErr)
+	}
+
+	result.Strategy = linker.usedStrategy()
+
+	// Remove the directories that became empty, innermost directories first
+	sort.Sort(sort.Reverse(sort.StringSlice(siteDirs)))
+	for _, relPath := range siteDirs {
+		entries, readErr := os.ReadDir(filepath.Join(siteDir, relPath))
+		if readErr != nil {
+			return nil, fmt.Errorf("Error while reading the site directory: %v", readErr)
+		}
+		if len(entries) > 0 {
+			continue
+		}
+		if removeErr := os.Remove(filepath.Join(siteDir, relPath)); removeErr != nil {
+			return nil, fmt.Errorf("Error while removing the empty directory from the site directory: %v", removeErr)
+		}
+		result.RemovedDirs = append(result.RemovedDirs, filepath.ToSlash(relPath))
+		log.Printf("Empty directory is removed: %s", relPath)
+	}
+
+	return result, nil
+}
+
+// Removes a directory of the site that is replaced by a file of the release
+func removeSiteDir(siteDir string, relPath string, protectedPaths []string, result *SyncResult) error {
+	hasProtectedPaths := false
+	filepath.Walk(filepath.Join(siteDir, relPath), func(siteFile string, _ os.FileInfo, _ error) error {
+		if fileRelPath, relErr := filepath.Rel(siteDir, siteFile); relErr == nil && IsProtectedPath(fileRelPath, protectedPaths) {
+			hasProtectedPaths = true
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	if hasProtectedPaths {
+		return fmt.Errorf("Cannot replace directory %q with a file since it contains protected paths", relPath)
+	}
+
+	if err := os.RemoveAll(filepath.Join(siteDir, relPath)); err != nil {
+		return fmt.Errorf("Error while removing the directory from the site directory: %v", err)
+	}
+	result.RemovedDirs = append(result.RemovedDirs, filepath.ToSlash(relPath))
+	return nil
+}
+
+// Removes the files of the site that are in place of a parent directory of a
+// release file
+func removeSiteFileParents(siteDir string, relPath string, protectedPaths []string, result *SyncResult) error {
+	parents := make([]string, 0)
+	for dir := filepath.Dir(relPath); dir != "."; dir = filepath.Dir(dir) {
+		parents = append([]string{dir}, parents...)
+	}
+
+	for _, parent := range parents {
+		info, lstatErr := os.Lstat(filepath.Join(siteDir, parent))
+		if lstatErr != nil || info.IsDir() {
+			continue
+		}
+		if IsProtectedPath(parent, protectedPaths) {
+			return fmt.Errorf("Cannot replace protected path %q with a directory", parent)
+		}
+		if err := os.Remove(filepath.Join(siteDir, parent)); err != nil {
+			return fmt.Errorf("Error while removing the file from the site directory: %v", err)
+		}
+		result.Removed = append(result.Removed, filepath.ToSlash(parent))
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package file_utils
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyncReleaseAssetsToSiteDir_Success(t *testing.T) {
+	releaseDir := setupFileUtilsTest(t)
+	siteDir := setupFileUtilsTest(t)
+
+	// Arrange: create a release with an unchanged, a changed and a new file
+	os.MkdirAll(path.Join(releaseDir, "assets"), 0755)
+	os.WriteFile(path.Join(releaseDir, "index.html"), []byte("same"), 0644)
+	os.WriteFile(path.Join(releaseDir, "assets", "app.js"), []byte("new content"), 0644)
+	os.WriteFile(path.Join(releaseDir, "assets", "new.css"), []byte("css"), 0644)
+
+	// Arrange: create a site from an older release with a stale file and directory
+	os.MkdirAll(path.Join(siteDir, "assets"), 0755)
+	os.MkdirAll(path.Join(siteDir, "old", "nested"), 0755)
+	os.WriteFile(path.Join(siteDir, "index.html"), []byte("same"), 0644)
+	os.WriteFile(path.Join(siteDir, "assets", "app.js"), []byte("old content"), 0644)
+	os.WriteFile(path.Join(siteDir, "old", "nested", "stale.js"), []byte("stale"), 0644)
+
+	// Act: sync the release into the site directory
+	result, err := SyncReleaseAssetsToSiteDir(releaseDir, siteDir, SyncOptions{})
+
+	// Assert: check if only the differences are applied
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"assets/new.css"}, result.Added)
+	assert.Equal(t, []string{"assets/app.js"}, result.Updated)
+	assert.Equal(t, []string{"old/nested/stale.js"}, result.Removed)
+	assert.Equal(t, []string{"old/nested", "old"}, result.RemovedDirs)
+	assert.Equal(t, 1, result.Unchanged)
+
+	data, readErr := os.ReadFile(path.Join(siteDir, "assets", "app.js"))
+	assert.NoError(t, readErr)
+	assert.Equal(t, []byte("new content"), data)
+	_, statErr := os.Stat(path.Join(siteDir, "old"))
+	assert.True(t, os.IsNotExist(statErr), "Expected empty directories to be removed")
+}
+
+func TestSyncReleaseAssetsToSiteDir_ProtectedPaths(t *testing.T) {
+	releaseDir := setupFileUtilsTest(t)
+	siteDir := setupFileUtilsTest(t)
+
+	// Arrange: create a release that ships its own .env file
+	os.WriteFile(path.Join(releaseDir, "index.html"), []byte("index"), 0644)
+	os.WriteFile(path.Join(releaseDir, ".env"), []byte("RELEASE=1"), 0644)
+
+	// Arrange: create a site with uploads and a local .env file
+	os.MkdirAll(path.Join(siteDir, "uploads", "2024"), 0755)
+	os.WriteFile(path.Join(siteDir, "uploads", "2024", "photo.jpg"), []byte("photo"), 0644)
+	os.MkdirAll(path.Join(siteDir, "uploads", "empty"), 0755)
+	os.WriteFile(path.Join(siteDir, ".env"), []byte("LOCAL=1"), 0644)
+
+	// Act: sync the release into the site directory
+	result, err := SyncReleaseAssetsToSiteDir(releaseDir, siteDir, SyncOptions{ProtectedPaths: []string{"uploads/", ".env"}})
+
+	// Assert: check if protected paths are not touched
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"index.html"}, result.Added)
+	assert.Empty(t, result.Removed)
+	data, readErr := os.ReadFile(path.Join(siteDir, ".env"))
+	assert.NoError(t, readErr)
+	assert.Equal(t, []byte("LOCAL=1"), data)
+	_, statErr := os.Stat(path.Join(siteDir, "uploads", "2024", "photo.jpg"))
+	assert.NoError(t, statErr)
+	_, statErr = os.Stat(path.Join(siteDir, "uploads", "empty"))
+	assert.NoError(t, statErr, "Expected empty protected directories to remain")
+}
+
+func TestSyncReleaseAssetsToSiteDir_ReplacesFileWithDirectory(t *testing.T) {
+	releaseDir := setupFileUtilsTest(t)
+	siteDir := setupFileUtilsTest(t)
+
+	// Arrange: create a release where "docs" is a directory and a site where it is a file
+	os.MkdirAll(path.Join(releaseDir, "docs"), 0755)
+	os.WriteFile(path.Join(releaseDir, "docs", "index.html"), []byte("docs"), 0644)
+	os.WriteFile(path.Join(siteDir, "docs"), []byte("file"), 0644)
+
+	// Act: sync the release into the site directory
+	result, err := SyncReleaseAssetsToSiteDir(releaseDir, siteDir, SyncOptions{})
+
+	// Assert: check if the file is replaced by the directory
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"docs/index.html"}, result.Added)
+	assert.Equal(t, []string{"docs"}, result.Removed)
+}
+
+func TestIsProtectedPath(t *testing.T) {
+	protectedPaths := []string{"uploads/", ".env", "*.log", "cache/*"}
+
+	assert.True(t, IsProtectedPath("uploads", protectedPaths))
+	assert.True(t, IsProtectedPath("uploads/a/b.jpg", protectedPaths))
+	assert.True(t, IsProtectedPath(".env", protectedPaths))
+	assert.True(t, IsProtectedPath("error.log", protectedPaths))
+	assert.True(t, IsProtectedPath("cache/a/b", protectedPaths))
+	assert.False(t, IsProtectedPath("uploads.txt", protectedPaths))
+	assert.False(t, IsProtectedPath("config/.env.example", protectedPaths))
+}
@@ -1,6 +1,7 @@
 package github
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -18,8 +19,13 @@ const (
 	DownloadAsset_Success DownloadAssetStatusCode = iota
 	DownloadAsset_UnknownError
 	DownloadAsset_NoAssetsFound
+	DownloadAsset_SizeLimitExceeded
 )
 
+// ErrSizeLimitExceeded is returned when a downloaded asset is larger than the
+// allowed size.
+var ErrSizeLimitExceeded = errors.New("size limit exceeded")
+
 // HttpClient is an interface that defines the Do method for making HTTP
 // requests. This allows for easier testing and mocking of HTTP requests in
 // unit tests. The interface can be implemented by any struct that has a Do
@@ -41,13 +47,19 @@ type GithubClient struct {
 // GithubClient in unit tests. The interface can be implemented by any struct
 // that has the same methods as the GithubClient struct.
 type GithubClientInterface interface {
-	DownloadAsset(url string, outputPath string) error
-	DownloadAssets(assets []*github.ReleaseAsset, releaseDir string) (DownloadAssetStatusCode, error)
+	DownloadAsset(url string, outputPath string, maxSize int64) error
+	DownloadAssets(assets []*github.ReleaseAsset, releaseDir string, maxTotalSize int64) (DownloadAssetStatusCode, error)
+	GetTagCommit(owner string, repo string, tag string) (string, error)
 }
 
+// ApiBaseUrl is the base URL of the GitHub REST API.
+const ApiBaseUrl = "https://api.github.com"
+
 // DownloadAsset is a method of the GithubClient struct that downloads an asset
-// from a given URL and saves it to a specified output path.
-func (c *GithubClient) DownloadAsset(url string, outputPath string) error {
+// from a given URL and saves it to a specified output path. If maxSize is
+// greater than zero, downloads larger than maxSize bytes fail with
+// ErrSizeLimitExceeded.
+func (c *GithubClient) DownloadAsset(url string, outputPath string, maxSize int64) error {
 	// create a new HTTP request
 	req, createRequestErr := http.NewRequest("GET", url, nil)
 	if createRequestErr != nil {
@@ -74,6 +86,11 @@ func (c *GithubClient) DownloadAsset(url string, outputPath string) error {
 		return fmt.Errorf("Error downloading asset, status code: %v", res.StatusCode)
 	}
 
+	// check the announced size before writing anything
+	if maxSize > 0 && res.ContentLength > maxSize {
+		return fmt.Errorf("Error downloading asset, %w: %d bytes > %d bytes", ErrSizeLimitExceeded, res.ContentLength, maxSize)
+	}
+
 	// create the output file
 	outputFile, createFileErr := os.Create(outputPath)
 	if createFileErr != nil {
@@ -81,32 +98,167 @@ func (c *GithubClient) DownloadAsset(url string, outputPath string) error {
 	}
 	defer outputFile.Close()
 
-	// copy the response body to the output file
-	_, writeToFileErr := io.Copy(outputFile, res.Body)
+	// copy the response body to the output file, reading at most one byte
+	// more than allowed to detect oversized bodies
+	var body io.Reader = res.Body
+	if maxSize > 0 {
+		body = io.LimitReader(res.Body, maxSize+1)
+	}
+	written, writeToFileErr := io.Copy(outputFile, body)
 	if writeToFileErr != nil {
 		return errors.New("Error writing to output file:" + writeToFileErr.Error())
 	}
+	if maxSize > 0 && written > maxSize {
+		return fmt.Errorf("Error downloading asset, %w: more than %d bytes", ErrSizeLimitExceeded, maxSize)
+	}
 
 	log.Printf("Asset downloaded successfully to: \"%s\"", outputPath)
 	return nil
 }
 
-func (c *GithubClient) DownloadAssets(assets []*github.ReleaseAsset, releaseDir string) (DownloadAssetStatusCode, error) {
+// DownloadAssets downloads all assets of a release into the release directory.
+// If maxTotalSize is greater than zero, the combined size of the downloads is
+// limited to maxTotalSize bytes.
+func (c *GithubClient) DownloadAssets(assets []*github.ReleaseAsset, releaseDir string, maxTotalSize int64) (DownloadAssetStatusCode, error) {
 	if len(assets) == 0 {
 		return DownloadAsset_NoAssetsFound, errors.New("No assets found for release")
 	}
 
+	if maxTotalSize > 0 && TotalAssetSize(assets) > maxTotalSize {
+		return DownloadAsset_SizeLimitExceeded, fmt.Errorf("Error downloading assets, %w: %d bytes > %d bytes", ErrSizeLimitExceeded, TotalAssetSize(assets), maxTotalSize)
+	}
+
+	remainingSize := maxTotalSize
 	for _, asset := range assets {
+		if maxTotalSize > 0 && remainingSize <= 0 {
+			return DownloadAsset_SizeLimitExceeded, fmt.Errorf("Error downloading assets, %w: more than %d bytes", ErrSizeLimitExceeded, maxTotalSize)
+		}
+
 		assetPath := path.Join(releaseDir, *asset.Name)
-		err := c.DownloadAsset(*asset.URL, assetPath)
+		err := c.DownloadAsset(*asset.URL, assetPath, remainingSize)
 		if err != nil {
+			if errors.Is(err, ErrSizeLimitExceeded) {
+				return DownloadAsset_SizeLimitExceeded, fmt.Errorf("Error downloading asset: %w", err)
+			}
 			return DownloadAsset_UnknownError, errors.New("Error downloading asset: " + err.Error())
 		}
+
+		if maxTotalSize > 0 {
+			info, statErr := os.Stat(assetPath)
+			if statErr != nil {
+				return DownloadAsset_UnknownError, errors.New("Error reading downloaded asset: " + statErr.Error())
+			}
+			remainingSize -= info.Size()
+		}
 	}
 
 	return DownloadAsset_Success, nil
 }
 
+// TotalAssetSize returns the combined size of the release assets as reported
+// by GitHub.
+func TotalAssetSize(assets []*github.ReleaseAsset) int64 {
+	var total int64
+	for _, asset := range assets {
+		total += int64(asset.GetSize())
+	}
+	return total
+}
+
+// releaseEventAssetDigests is a minimal view of the release event payload. The
+// "digest" field of release assets is not exposed by go-github yet, so it is
+// read from the raw payload instead.
+type releaseEventAssetDigests struct {
+	Release struct {
+		Assets []struct {
+			Name   string `json:"name"`
+			Digest string `json:"digest"`
+		} `json:"assets"`
+	} `json:"release"`
+}
+
+// ParseReleaseAssetDigests returns the digests (e.g. "sha256:...") reported
+// by GitHub for the release assets in a release event payload, keyed by asset
+// name. Assets without a digest are omitted.
+func ParseReleaseAssetDigests(payload []byte) (map[string]string, error) {
+	var event releaseEventAssetDigests
+	if unmarshalErr := json.Unmarshal(payload, &event); unmarshalErr != nil {
+		return nil, errors.New("Error parsing release event payload: " + unmarshalErr.Error())
+	}
+
+	digests := make(map[string]string)
+	for _, asset := range event.Release.Assets {
+		if asset.Name != "" && asset.Digest != "" {
+			digests[asset.Name] = asset.Digest
+		}
+	}
+
+	return digests, nil
+}
+
+// gitObject is the object a git reference or an annotated tag points to.
+type gitObject struct {
+	Type string `json:"type"`
+	Sha  string `json:"sha"`
+}
+
+// getGitObject requests a git reference or an annotated tag from the GitHub
+// API and returns the object it points to.
+func (c *GithubClient) getGitObject(url string) (*gitObject, error) {
+	req, createRequestErr := http.NewRequest("GET", url, nil)
+	if createRequestErr != nil {
+		return nil, errors.New("Error creating request:" + createRequestErr.Error())
+	}
+	req.Header.Set("Authorization", "Bearer "+c.AccessToken)
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	res, requestErr := c.HttpClient.Do(req)
+	if requestErr != nil {
+		return nil, errors.New("Error requesting git object: " + requestErr.Error())
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Error requesting git object, status code: %v", res.StatusCode)
+	}
+
+	var body struct {
+		Object gitObject `json:"object"`
+	}
+	if decodeErr := json.NewDecoder(res.Body).Decode(&body); decodeErr != nil {
+		return nil, errors.New("Error parsing git object: " + decodeErr.Error())
+	}
+	if body.Object.Sha == "" {
+		return nil, errors.New("Error parsing git object: missing sha")
+	}
+
+	return &body.Object, nil
+}
+
+// GetTagCommit resolves a tag of a repository to the SHA of the commit it
+// points to. Annotated tags are peeled to their commit.
+func (c *GithubClient) GetTagCommit(owner string, repo string, tag string) (string, error) {
+	object, refErr := c.getGitObject(fmt.Sprintf("%s/repos/%s/%s/git/ref/tags/%s", ApiBaseUrl, owner, repo, tag))
+	if refErr != nil {
+		return "", refErr
+	}
+
+	// annotated tags point to a tag object, which points to the commit
+	for object.Type == "tag" {
+		tagObject, tagErr := c.getGitObject(fmt.Sprintf("%s/repos/%s/%s/git/tags/%s", ApiBaseUrl, owner, repo, object.Sha))
+		if tagErr != nil {
+			return "", tagErr
+		}
+		object = tagObject
+	}
+
+	if object.Type != "commit" {
+		return "", fmt.Errorf("Error resolving tag %s, it points to a %s", tag, object.Type)
+	}
+
+	return object.Sha, nil
+}
+
 func SetupGithubClient() (*GithubClient, error) {
 	githubAccessToken := os.Getenv("DEPLOY_TO_VM_GITHUB_ACCESS_TOKEN")
 	if githubAccessToken == "" {
@@ -71,7 +71,7 @@ func TestDownloadAsset_Success(t *testing.T) {
 	var testFilePath = path.Join(tempDir, "output.txt")
 
 	// act: download the asset
-	downloadErr := client.DownloadAsset("https://example.com/asset", testFilePath)
+	downloadErr := client.DownloadAsset("https://example.com/asset", testFilePath, 0)
 
 	// assert: check if the file was created
 	assert.NoError(t, downloadErr, "Expected no error")
@@ -98,7 +98,7 @@ func TestDownloadAssets_NoAssetsFound(t *testing.T) {
 	var testAssets []*github.ReleaseAsset
 
 	// act: download the assets
-	code, downloadErr := client.DownloadAssets(testAssets, tempDir)
+	code, downloadErr := client.DownloadAssets(testAssets, tempDir, 0)
 
 	// assert: check if the error is as expected
 	assert.Error(t, downloadErr, "Expected an error when no assets are found")
@@ -139,7 +139,7 @@ func TestDownloadAssets_Single(t *testing.T) {
 	}
 
 	// act: download the asset
-	code, downloadErr := client.DownloadAssets(testAssets[:], tempDir)
+	code, downloadErr := client.DownloadAssets(testAssets[:], tempDir, 0)
 
 	// assert: check if the file was create
 	assert.NoError(t, downloadErr, "Expected no error")
@@ -192,7 +192,7 @@ func TestDownloadAssets_Multiple(t *testing.T) {
 	}
 
 	// act: download the asset
-	code, downloadErr := client.DownloadAssets(testAssets[:], tempDir)
+	code, downloadErr := client.DownloadAssets(testAssets[:], tempDir, 0)
 
 	// assert: check if the file was created
 	assert.NoError(t, downloadErr, "Expected no error")
@@ -240,7 +240,7 @@ func TestDownloadAssets_Error(t *testing.T) {
 	}
 
 	// Act: attempt to download the assets
-	code, err := client.DownloadAssets(testAssets, "/invalid/path")
+	code, err := client.DownloadAssets(testAssets, "/invalid/path", 0)
 
 	// Assert: check if the error is as expected
 	assert.Error(t, err, "Expected an error when downloading assets")
@@ -266,7 +266,7 @@ func TestDownloadAsset_NewRequest_Error(t *testing.T) {
 	}
 
 	// Act: attempt to download an asset with an invalid URL
-	err := client.DownloadAsset(":://", "output.txt")
+	err := client.DownloadAsset(":://", "output.txt", 0)
 
 	// Assert: check if the error is as expected
 	assert.Error(t, err, "Expected an error when creating request")
@@ -291,7 +291,7 @@ func TestDownloadAsset_HttpClientDo_Error(t *testing.T) {
 	}
 
 	// Act: attempt to download an asset
-	err := client.DownloadAsset("https://example.com/asset", "output.txt")
+	err := client.DownloadAsset("https://example.com/asset", "output.txt", 0)
 
 	// Assert: check if the error is as expected
 	assert.Error(t, err, "Expected an error when downloading asset")
@@ -319,7 +319,7 @@ func TestDownloadAsset_ResponseStatusNotOK(t *testing.T) {
 	}
 
 	// Act: attempt to download an asset
-	err := client.DownloadAsset("https://example.com/asset", "output.txt")
+	err := client.DownloadAsset("https://example.com/asset", "output.txt", 0)
 
 	// Assert: check if the error is as expected
 	assert.Error(t, err, "Expected an error when response status is not OK")
@@ -347,7 +347,7 @@ func TestDownloadAsset_CreateFile_Error(t *testing.T) {
 	}
 
 	// Act: attempt to download an asset to a directory that cannot be created
-	err := client.DownloadAsset("https://example.com/asset", "/invalid/path/output.txt")
+	err := client.DownloadAsset("https://example.com/asset", "/invalid/path/output.txt", 0)
 
 	// Assert: check if the error is as expected
 	assert.Error(t, err, "Expected an error when creating file")
@@ -381,7 +381,7 @@ func TestDownloadAssest_WriteFile_Error(t *testing.T) {
 	}
 
 	// Act: attempt to download an asset
-	err := client.DownloadAsset("https://example.com/asset", path.Join(tempDir, "output.txt"))
+	err := client.DownloadAsset("https://example.com/asset", path.Join(tempDir, "output.txt"), 0)
 
 	// Assert: check if the error is as expected
 	assert.Error(t, err, "Expected an error when writing to file")
@@ -412,3 +412,160 @@ func TestSetupGithubClient_Success(t *testing.T) {
 	assert.NotNil(t, client, "Expected client to be set up successfully")
 	assert.NoError(t, err, "Expected no error when setting up Github client")
 }
+
+func TestParseReleaseAssetDigests_Success(t *testing.T) {
+	// arrange: create a release event payload with and without digests
+	payload := []byte(`{"release":{"assets":[{"name":"app.tar.gz","digest":"sha256:abc"},{"name":"no-digest.txt"}]}}`)
+
+	// act: parse the digests
+	digests, err := ParseReleaseAssetDigests(payload)
+
+	// assert: check if only assets with a digest are returned
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"app.tar.gz": "sha256:abc"}, digests)
+}
+
+func TestParseReleaseAssetDigests_InvalidPayload(t *testing.T) {
+	// act: parse an invalid payload
+	_, err := ParseReleaseAssetDigests([]byte("{invalid"))
+
+	// assert: check if the error is returned
+	assert.Error(t, err)
+}
+
+func TestDownloadAsset_SizeLimitExceeded(t *testing.T) {
+	// Arrange: get test helpers
+	accessToken, tempDir := setupGithubClientTest(t)
+
+	// Arrange: create a mock HTTP client that returns a body without content length
+	mockHttpClient := &MockHttpClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode:    http.StatusOK,
+				ContentLength: -1,
+				Body:          io.NopCloser(bytes.NewBufferString("0123456789")),
+			}, nil
+		},
+	}
+
+	// Arrange: create a Github client with the mock HTTP client
+	client := &GithubClient{
+		HttpClient:  mockHttpClient,
+		AccessToken: accessToken,
+	}
+
+	// Act: download the asset with a limit smaller than the body
+	err := client.DownloadAsset("https://example.com/asset", path.Join(tempDir, "output.txt"), 5)
+
+	// Assert: check if the size limit error is returned
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, ErrSizeLimitExceeded), "Expected size limit error, got %v", err)
+}
+
+func TestDownloadAssets_DeclaredSizeLimitExceeded(t *testing.T) {
+	// Arrange: get test helpers
+	accessToken, tempDir := setupGithubClientTest(t)
+
+	// Arrange: create a Github client that must not be called
+	client := &GithubClient{
+		HttpClient: &MockHttpClient{
+			DoFunc: func(req *http.Request) (*http.Response, error) {
+				t.Fatal("Expected no request to be made")
+				return nil, nil
+			},
+		},
+		AccessToken: accessToken,
+	}
+
+	// Arrange: define assets larger than the limit
+	testAssets := []*github.ReleaseAsset{
+		{
+			Name: github.Ptr("test-asset.txt"),
+			URL:  github.Ptr("https://example.com/test-asset.txt"),
+			Size: github.Ptr(100),
+		},
+	}
+
+	// Act: attempt to download the assets
+	code, err := client.DownloadAssets(testAssets, tempDir, 10)
+
+	// Assert: check if the size limit error is returned
+	assert.Error(t, err)
+	assert.Equal(t, DownloadAsset_SizeLimitExceeded, code)
+}
+
+func TestGetTagCommit_LightweightTag(t *testing.T) {
+	// arrange: create a Github client that returns a ref to a commit
+	accessToken, _ := setupGithubClientTest(t)
+	var requestedUrls []string
+	client := &GithubClient{
+		AccessToken: accessToken,
+		HttpClient: &MockHttpClient{
+			DoFunc: func(req *http.Request) (*http.Response, error) {
+				requestedUrls = append(requestedUrls, req.URL.String())
+				assert.Equal(t, "Bearer "+accessToken, req.Header.Get("Authorization"))
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(bytes.NewBufferString(`{"ref":"refs/tags/v1.0.0","object":{"type":"commit","sha":"0123abcd"}}`)),
+				}, nil
+			},
+		},
+	}
+
+	// act: resolve the tag
+	commit, err := client.GetTagCommit("cemreyavuz", "deploy-to-vm", "v1.0.0")
+
+	// assert: the commit of the ref is returned
+	assert.NoError(t, err)
+	assert.Equal(t, "0123abcd", commit)
+	assert.Equal(t, []string{"https://api.github.com/repos/cemreyavuz/deploy-to-vm/git/ref/tags/v1.0.0"}, requestedUrls)
+}
+
+func TestGetTagCommit_AnnotatedTag(t *testing.T) {
+	// arrange: create a Github client that returns a ref to a tag object
+	accessToken, _ := setupGithubClientTest(t)
+	responses := map[string]string{
+		"https://api.github.com/repos/cemreyavuz/deploy-to-vm/git/ref/tags/v1.0.0": `{"object":{"type":"tag","sha":"4567cdef"}}`,
+		"https://api.github.com/repos/cemreyavuz/deploy-to-vm/git/tags/4567cdef":   `{"tag":"v1.0.0","object":{"type":"commit","sha":"0123abcd"}}`,
+	}
+	client := &GithubClient{
+		AccessToken: accessToken,
+		HttpClient: &MockHttpClient{
+			DoFunc: func(req *http.Request) (*http.Response, error) {
+				body, ok := responses[req.URL.String()]
+				if !ok {
+					return &http.Response{StatusCode: http.StatusNotFound, Body: io.NopCloser(bytes.NewBufferString(""))}, nil
+				}
+				return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString(body))}, nil
+			},
+		},
+	}
+
+	// act: resolve the tag
+	commit, err := client.GetTagCommit("cemreyavuz", "deploy-to-vm", "v1.0.0")
+
+	// assert: the tag is peeled to its commit
+	assert.NoError(t, err)
+	assert.Equal(t, "0123abcd", commit)
+}
+
+func TestGetTagCommit_NotFound(t *testing.T) {
+	// arrange: create a Github client that does not find the tag
+	accessToken, _ := setupGithubClientTest(t)
+	client := &GithubClient{
+		AccessToken: accessToken,
+		HttpClient: &MockHttpClient{
+			DoFunc: func(req *http.Request) (*http.Response, error) {
+				return &http.Response{StatusCode: http.StatusNotFound, Body: io.NopCloser(bytes.NewBufferString(""))}, nil
+			},
+		},
+	}
+
+	// act: resolve the tag
+	commit, err := client.GetTagCommit("cemreyavuz", "deploy-to-vm", "v1.0.0")
+
+	// assert: an error is returned
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "status code: 404")
+	assert.Equal(t, "", commit)
+}
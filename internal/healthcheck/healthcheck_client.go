@@ -0,0 +1,154 @@
+package healthcheck
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"deploy-to-vm/internal/config"
+)
+
+// maxBodySize is the part of a response body that is checked
+const maxBodySize = 1 << 20
+
+// HealthCheckClient is a struct that represents a client for checking that a
+// deployed app responds as expected.
+type HealthCheckClient struct {
+	HttpClient *http.Client
+	// Sleep waits between the checks, replaced in unit tests
+	Sleep func(d time.Duration)
+}
+
+// HealthCheckClientInterface is an interface that defines the methods for the
+// HealthCheckClient struct, so it can be mocked in unit tests.
+type HealthCheckClientInterface interface {
+	Check(checkConfig config.DeployToVmConfigHealthCheck) error
+}
+
+// Returns the value at a dot-separated path of a decoded JSON document. Array
+// elements are addressed by their index.
+func lookupJsonPath(document interface{}, jsonPath string) (interface{}, bool) {
+	value := document
+	for _, key := range strings.Split(jsonPath, ".") {
+		switch node := value.(type) {
+		case map[string]interface{}:
+			child, ok := node[key]
+			if !ok {
+				return nil, false
+			}
+			value = child
+		case []interface{}:
+			index, indexErr := strconv.Atoi(key)
+			if indexErr != nil || index < 0 || index >= len(node) {
+				return nil, false
+			}
+			value = node[index]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// Formats a JSON value for comparison, strings without quotes
+func formatJsonValue(value interface{}) string {
+	if text, ok := value.(string); ok {
+		return text
+	}
+	encoded, _ := json.Marshal(value)
+	return string(encoded)
+}
+
+// Requests the URL once and checks the response
+func (c *HealthCheckClient) checkOnce(checkConfig config.DeployToVmConfigHealthCheck, timeout time.Duration) error {
+	httpClient := *c.HttpClient
+	httpClient.Timeout = timeout
+	response, requestErr := httpClient.Get(checkConfig.URL)
+	if requestErr != nil {
+		return fmt.Errorf("Error while requesting %s: %v", checkConfig.URL, requestErr)
+	}
+	defer response.Body.Close()
+
+	body, readErr := io.ReadAll(io.LimitReader(response.Body, maxBodySize))
+	if readErr != nil {
+		return fmt.Errorf("Error while reading the response of %s: %v", checkConfig.URL, readErr)
+	}
+
+	if response.StatusCode != checkConfig.GetExpectedStatus() {
+		return fmt.Errorf("%s responded with status %d instead of %d", checkConfig.URL, response.StatusCode, checkConfig.GetExpectedStatus())
+	}
+	if checkConfig.BodyContains != "" && !strings.Contains(string(body), checkConfig.BodyContains) {
+		return fmt.Errorf("The response of %s does not contain %q", checkConfig.URL, checkConfig.BodyContains)
+	}
+	if checkConfig.JsonPath != "" {
+		var document interface{}
+		if err := json.Unmarshal(body, &document); err != nil {
+			return fmt.Errorf("The response of %s is not JSON: %v", checkConfig.URL, err)
+		}
+		value, found := lookupJsonPath(document, checkConfig.JsonPath)
+		if !found {
+			return fmt.Errorf("The response of %s has no value at %q", checkConfig.URL, checkConfig.JsonPath)
+		}
+		if formatJsonValue(value) != checkConfig.JsonValue {
+			return fmt.Errorf("The response of %s has %q at %q instead of %q", checkConfig.URL, formatJsonValue(value), checkConfig.JsonPath, checkConfig.JsonValue)
+		}
+	}
+	return nil
+}
+
+// Checks that the URL of the config responds as expected. A failed check is
+// repeated up to the configured number of retries, and the error of the last
+// check is returned.
+func (c *HealthCheckClient) Check(checkConfig config.DeployToVmConfigHealthCheck) error {
+	if checkConfig.URL == "" {
+		return fmt.Errorf("Health check URL cannot be empty")
+	}
+	timeout, timeoutErr := checkConfig.GetTimeout()
+	if timeoutErr != nil {
+		return timeoutErr
+	}
+	interval, intervalErr := checkConfig.GetInterval()
+	if intervalErr != nil {
+		return intervalErr
+	}
+	retries, retriesErr := checkConfig.GetRetries()
+	if retriesErr != nil {
+		return retriesErr
+	}
+
+	var checkErr error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			c.Sleep(interval)
+		}
+		checkErr = c.checkOnce(checkConfig, timeout)
+		if checkErr == nil {
+			log.Printf("Health check of %s passed", checkConfig.URL)
+			return nil
+		}
+		log.Printf("Health check %d of %d failed: \"%v\"", attempt+1, retries+1, checkErr)
+	}
+	return checkErr
+}
+
+func NewHealthCheckClient(httpClient *http.Client) *HealthCheckClient {
+	// If httpClient is nil, use a client that does not follow redirects, so
+	// the status code of the health check URL itself is checked
+	if httpClient == nil {
+		httpClient = &http.Client{
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+	}
+
+	return &HealthCheckClient{
+		HttpClient: httpClient,
+		Sleep:      time.Sleep,
+	}
+}
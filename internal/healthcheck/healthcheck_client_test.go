@@ -0,0 +1,177 @@
+package healthcheck
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"deploy-to-vm/internal/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper to create a health check client that records its sleeps and a server
+// that answers the requests with the responses in order, repeating the last
+func setupHealthCheckTest(t *testing.T, statuses []int, bodies []string) (*HealthCheckClient, string, *[]time.Duration) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i := requests
+		if i >= len(statuses) {
+			i = len(statuses) - 1
+		}
+		requests++
+		w.WriteHeader(statuses[i])
+		w.Write([]byte(bodies[i]))
+	}))
+	t.Cleanup(server.Close)
+
+	sleeps := make([]time.Duration, 0)
+	healthCheckClient := NewHealthCheckClient(nil)
+	healthCheckClient.Sleep = func(d time.Duration) {
+		sleeps = append(sleeps, d)
+	}
+	return healthCheckClient, server.URL, &sleeps
+}
+
+func TestHealthCheckClient_Check_Success(t *testing.T) {
+	// Arrange: create a server that answers with a JSON status
+	healthCheckClient, url, sleeps := setupHealthCheckTest(t, []int{200}, []string{`{"status":"ok","build":{"version":"v1.2.0","checks":[{"ok":true}]}}`})
+
+	// Act: check the status code, body and JSON values
+	err := healthCheckClient.Check(config.DeployToVmConfigHealthCheck{
+		URL:          url,
+		BodyContains: `"status":"ok"`,
+		JsonPath:     "build.version",
+		JsonValue:    "v1.2.0",
+	})
+
+	// Assert: check if the check passed on the first try
+	assert.NoError(t, err)
+	assert.Empty(t, *sleeps)
+
+	// Act/Assert: check if non-string values and array indexes are matched
+	err = healthCheckClient.Check(config.DeployToVmConfigHealthCheck{URL: url, JsonPath: "build.checks.0.ok", JsonValue: "true"})
+	assert.NoError(t, err)
+}
+
+func TestHealthCheckClient_Check_Retries(t *testing.T) {
+	// Arrange: create a server that answers with 502 twice before the app is up
+	healthCheckClient, url, sleeps := setupHealthCheckTest(t, []int{502, 502, 200}, []string{"Bad Gateway", "Bad Gateway", "ok"})
+
+	// Act: check with three retries
+	err := healthCheckClient.Check(config.DeployToVmConfigHealthCheck{URL: url, Retries: 3, Interval: "1s"})
+
+	// Assert: check if the check passed after waiting twice
+	assert.NoError(t, err)
+	assert.Equal(t, []time.Duration{time.Second, time.Second}, *sleeps)
+}
+
+func TestHealthCheckClient_Check_Failures(t *testing.T) {
+	testCases := []struct {
+		name          string
+		status        int
+		body          string
+		checkConfig   config.DeployToVmConfigHealthCheck
+		expectedError string
+	}{
+		{
+			name:          "unexpected status",
+			status:        502,
+			body:          "Bad Gateway",
+			expectedError: "responded with status 502 instead of 200",
+		},
+		{
+			name:          "configured status",
+			status:        200,
+			checkConfig:   config.DeployToVmConfigHealthCheck{ExpectedStatus: 204},
+			expectedError: "responded with status 200 instead of 204",
+		},
+		{
+			name:          "missing body text",
+			status:        200,
+			body:          "maintenance",
+			checkConfig:   config.DeployToVmConfigHealthCheck{BodyContains: "ok"},
+			expectedError: `does not contain "ok"`,
+		},
+		{
+			name:          "no JSON",
+			status:        200,
+			body:          "ok",
+			checkConfig:   config.DeployToVmConfigHealthCheck{JsonPath: "status", JsonValue: "ok"},
+			expectedError: "is not JSON",
+		},
+		{
+			name:          "missing JSON path",
+			status:        200,
+			body:          `{"checks":[]}`,
+			checkConfig:   config.DeployToVmConfigHealthCheck{JsonPath: "checks.0.ok", JsonValue: "true"},
+			expectedError: `has no value at "checks.0.ok"`,
+		},
+		{
+			name:          "unexpected JSON value",
+			status:        200,
+			body:          `{"status":"degraded"}`,
+			checkConfig:   config.DeployToVmConfigHealthCheck{JsonPath: "status", JsonValue: "ok"},
+			expectedError: `has "degraded" at "status" instead of "ok"`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			// Arrange: create a server with the response of the test case
+			healthCheckClient, url, sleeps := setupHealthCheckTest(t, []int{testCase.status}, []string{testCase.body})
+			testCase.checkConfig.URL = url
+			testCase.checkConfig.Retries = 1
+
+			// Act: check the server
+			err := healthCheckClient.Check(testCase.checkConfig)
+
+			// Assert: check if the check failed after the retry
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), testCase.expectedError)
+			assert.Equal(t, []time.Duration{config.DefaultHealthCheckInterval}, *sleeps)
+		})
+	}
+}
+
+func TestHealthCheckClient_Check_InvalidConfig(t *testing.T) {
+	healthCheckClient := NewHealthCheckClient(nil)
+
+	// Act/Assert: check if a missing URL and invalid durations fail
+	assert.Error(t, healthCheckClient.Check(config.DeployToVmConfigHealthCheck{}))
+	assert.Error(t, healthCheckClient.Check(config.DeployToVmConfigHealthCheck{URL: "http://localhost", Timeout: "soon"}))
+	assert.Error(t, healthCheckClient.Check(config.DeployToVmConfigHealthCheck{URL: "http://localhost", Interval: "-1s"}))
+}
+
+func TestHealthCheckClient_Check_NegativeRetries(t *testing.T) {
+	// Arrange: create a server that is down
+	healthCheckClient, url, sleeps := setupHealthCheckTest(t, []int{502}, []string{"Bad Gateway"})
+
+	// Act: check with negative retries
+	err := healthCheckClient.Check(config.DeployToVmConfigHealthCheck{URL: url, Retries: -1})
+
+	// Assert: check if the check fails instead of passing without a request
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Invalid health check retries")
+	assert.Empty(t, *sleeps)
+}
+
+func TestHealthCheckClient_Check_DoesNotFollowRedirects(t *testing.T) {
+	// Arrange: create a server that redirects to a login page
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			w.Write([]byte("login"))
+			return
+		}
+		http.Redirect(w, r, "/login", http.StatusFound)
+	}))
+	defer server.Close()
+
+	// Act: check the health URL
+	err := NewHealthCheckClient(nil).Check(config.DeployToVmConfigHealthCheck{URL: server.URL + "/health"})
+
+	// Assert: check if the redirect is the response that is checked
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "responded with status 302 instead of 200")
+}
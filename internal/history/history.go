@@ -0,0 +1,178 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DirName is the name of the deployment history inside the assets directory.
+// GitHub owners can't start with a dot, so it never collides with a release
+// directory.
+const DirName = ".history"
+
+// MaxRecords is the number of deployments kept in the history of a
+// repository. Older records are removed when a deployment is recorded.
+const MaxRecords = 100
+
+const (
+	Status_Succeeded = "succeeded"
+	Status_Failed    = "failed"
+)
+
+// Stream_Deploy is the stream of the lines deploy-to-vm writes itself, next to
+// the stdout and stderr of commands
+const Stream_Deploy = "deploy"
+
+// Line is a line of the log of a deployment.
+type Line struct {
+	Time time.Time `json:"time"`
+	// Stream is exec.Stream_Stdout, exec.Stream_Stderr or Stream_Deploy
+	Stream string `json:"stream"`
+	Text   string `json:"text"`
+}
+
+// Log collects the output of the commands of a deployment as it arrives. Each
+// line is written to the log of the server right away, so long running
+// commands can be followed, and kept for the history record.
+type Log struct {
+	prefix string
+	mutex  sync.Mutex
+	lines  []Line
+}
+
+func NewLog(owner string, repo string, tag string) *Log {
+	return &Log{prefix: fmt.Sprintf("%s/%s@%s", owner, repo, tag)}
+}
+
+// Records a line of the output of a command
+func (l *Log) WriteLine(stream string, line string) {
+	l.mutex.Lock()
+	l.lines = append(l.lines, Line{Time: time.Now().UTC(), Stream: stream, Text: line})
+	l.mutex.Unlock()
+	log.Printf("[%s %s] %s", l.prefix, stream, line)
+}
+
+// Records a line about the deployment itself
+func (l *Log) Printf(format string, args ...interface{}) {
+	l.WriteLine(Stream_Deploy, fmt.Sprintf(format, args...))
+}
+
+// Returns the lines recorded so far
+func (l *Log) Lines() []Line {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return append([]Line{}, l.lines...)
+}
+
+// Record is a deployment in the history of a repository.
+type Record struct {
+	Owner       string    `json:"owner"`
+	Repo        string    `json:"repo"`
+	Tag         string    `json:"tag"`
+	PreviousTag string    `json:"previousTag,omitempty"`
+	Status      string    `json:"status"`
+	Error       string    `json:"error,omitempty"`
+	StartedAt   time.Time `json:"startedAt"`
+	FinishedAt  time.Time `json:"finishedAt"`
+	// Log is the transcript of the commands of the deployment
+	Log []Line `json:"log"`
+}
+
+// Returns the directory of the history of a repository
+func Dir(assetsDir string, owner string, repo string) string {
+	return filepath.Join(assetsDir, DirName, owner, repo)
+}
+
+// Writes a record to the history of its repository and removes the records
+// beyond MaxRecords. Records are named after their start time, so they sort
+// in the order they were deployed. Returns the path of the record.
+func Write(assetsDir string, record *Record) (string, error) {
+	dir := Dir(assetsDir, record.Owner, record.Repo)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("Error while creating the history directory: %v", err)
+	}
+
+	content, marshalErr := json.MarshalIndent(record, "", "  ")
+	if marshalErr != nil {
+		return "", fmt.Errorf("Error while encoding the history record: %v", marshalErr)
+	}
+	name := fmt.Sprintf("%s-%s.json", record.StartedAt.UTC().Format("20060102T150405.000000000Z"), strings.ReplaceAll(record.Tag, "/", "_"))
+	file := filepath.Join(dir, name)
+	tempFile := file + ".tmp"
+	if err := os.WriteFile(tempFile, content, 0644); err != nil {
+		return "", fmt.Errorf("Error while writing the history record: %v", err)
+	}
+	if err := os.Rename(tempFile, file); err != nil {
+		os.Remove(tempFile)
+		return "", fmt.Errorf("Error while writing the history record: %v", err)
+	}
+
+	if pruneErr := prune(dir); pruneErr != nil {
+		log.Printf("Failed to prune the deployment history: \"%v\"", pruneErr)
+	}
+	return file, nil
+}
+
+// Returns the records of a repository, newest first
+func List(assetsDir string, owner string, repo string) ([]*Record, error) {
+	files, listErr := recordFiles(Dir(assetsDir, owner, repo))
+	if listErr != nil {
+		return nil, listErr
+	}
+
+	records := make([]*Record, 0, len(files))
+	for i := len(files) - 1; i >= 0; i-- {
+		content, readErr := os.ReadFile(files[i])
+		if readErr != nil {
+			return nil, fmt.Errorf("Error while reading the history record: %v", readErr)
+		}
+		record := &Record{}
+		if err := json.Unmarshal(content, record); err != nil {
+			return nil, fmt.Errorf("Error while decoding the history record %q: %v", filepath.Base(files[i]), err)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// Returns the record files of a history directory, oldest first
+func recordFiles(dir string) ([]string, error) {
+	entries, readErr := os.ReadDir(dir)
+	if os.IsNotExist(readErr) {
+		return []string{}, nil
+	}
+	if readErr != nil {
+		return nil, fmt.Errorf("Error while reading the history directory: %v", readErr)
+	}
+
+	files := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && strings.HasSuffix(entry.Name(), ".json") {
+			files = append(files, filepath.Join(dir, entry.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Removes the oldest records beyond MaxRecords
+func prune(dir string) error {
+	files, listErr := recordFiles(dir)
+	if listErr != nil {
+		return listErr
+	}
+	for len(files) > MaxRecords {
+		if err := os.Remove(files[0]); err != nil {
+			return fmt.Errorf("Error while removing the history record: %v", err)
+		}
+		files = files[1:]
+	}
+	return nil
+}
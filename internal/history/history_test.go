@@ -0,0 +1,79 @@
+package history
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLog(t *testing.T) {
+	// Arrange: create the log of a deployment
+	deploymentLog := NewLog("cemreyavuz", "deploy-to-vm", "v1")
+
+	// Act: write lines of a command and of the deployment
+	deploymentLog.WriteLine("stdout", "migrating")
+	deploymentLog.Printf("Deployed release %s", "v1")
+
+	// Assert: check if the lines are kept in order with their time
+	lines := deploymentLog.Lines()
+	assert.Len(t, lines, 2)
+	assert.Equal(t, Line{Time: lines[0].Time, Stream: "stdout", Text: "migrating"}, lines[0])
+	assert.Equal(t, Line{Time: lines[1].Time, Stream: Stream_Deploy, Text: "Deployed release v1"}, lines[1])
+	assert.False(t, lines[0].Time.IsZero())
+	assert.False(t, lines[1].Time.Before(lines[0].Time))
+}
+
+func TestWriteAndList(t *testing.T) {
+	assetsDir := t.TempDir()
+	startedAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	// Act: record two deployments
+	firstFile, firstErr := Write(assetsDir, &Record{Owner: "cemreyavuz", Repo: "deploy-to-vm", Tag: "v1", Status: Status_Succeeded, StartedAt: startedAt})
+	_, secondErr := Write(assetsDir, &Record{
+		Owner:       "cemreyavuz",
+		Repo:        "deploy-to-vm",
+		Tag:         "release/v2",
+		PreviousTag: "v1",
+		Status:      Status_Failed,
+		Error:       "Failed to reload the nginx target",
+		StartedAt:   startedAt.Add(time.Minute),
+		Log:         []Line{{Time: startedAt.Add(time.Minute), Stream: "stderr", Text: "nginx: [emerg] unexpected \"}\""}},
+	})
+
+	// Assert: check if the records are listed newest first
+	assert.NoError(t, firstErr)
+	assert.NoError(t, secondErr)
+	assert.FileExists(t, firstFile)
+	records, listErr := List(assetsDir, "cemreyavuz", "deploy-to-vm")
+	assert.NoError(t, listErr)
+	assert.Len(t, records, 2)
+	assert.Equal(t, "release/v2", records[0].Tag)
+	assert.Equal(t, Status_Failed, records[0].Status)
+	assert.Equal(t, "nginx: [emerg] unexpected \"}\"", records[0].Log[0].Text)
+	assert.Equal(t, "v1", records[1].Tag)
+}
+
+func TestList_NoHistory(t *testing.T) {
+	records, err := List(t.TempDir(), "cemreyavuz", "deploy-to-vm")
+	assert.NoError(t, err)
+	assert.Empty(t, records)
+}
+
+func TestWrite_PrunesOldRecords(t *testing.T) {
+	assetsDir := t.TempDir()
+	startedAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	// Act: record more deployments than are kept
+	for i := 0; i < MaxRecords+2; i++ {
+		Write(assetsDir, &Record{Owner: "cemreyavuz", Repo: "deploy-to-vm", Tag: "v1", StartedAt: startedAt.Add(time.Duration(i) * time.Second)})
+	}
+
+	// Assert: check if the oldest records are removed
+	entries, _ := os.ReadDir(Dir(assetsDir, "cemreyavuz", "deploy-to-vm"))
+	assert.Len(t, entries, MaxRecords)
+	records, _ := List(assetsDir, "cemreyavuz", "deploy-to-vm")
+	assert.Equal(t, startedAt.Add(time.Duration(MaxRecords+1)*time.Second), records[0].StartedAt)
+	assert.Equal(t, startedAt.Add(2*time.Second), records[MaxRecords-1].StartedAt)
+}
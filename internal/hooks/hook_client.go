@@ -0,0 +1,164 @@
+package hooks
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"deploy-to-vm/internal/config"
+	deploy_to_vm_exec "deploy-to-vm/internal/exec"
+)
+
+const (
+	Hook_PreActivate  = "preActivate"
+	Hook_PostActivate = "postActivate"
+	Hook_PostReload   = "postReload"
+	Hook_OnFailure    = "onFailure"
+)
+
+// maxOutputLines is the number of output lines of a failed hook in its error
+const maxOutputLines = 20
+
+// HookClient is a struct that represents a client for running the lifecycle
+// hooks of a deployment.
+type HookClient struct {
+	ExecClient deploy_to_vm_exec.ExecClientInterface
+}
+
+// HookClientInterface is an interface that defines the methods for the
+// HookClient struct, so it can be mocked in unit tests.
+type HookClientInterface interface {
+	Run(name string, hookConfigs []config.DeployToVmConfigHook, rootDir string, env map[string]string) error
+	WithLogSink(sink deploy_to_vm_exec.LogSink) HookClientInterface
+	WithCredential(credential *deploy_to_vm_exec.Credential) HookClientInterface
+}
+
+// Returns the path of a file in the root directory, rejecting paths outside
+// of it
+func pathInRootDir(rootDir string, file string) (string, error) {
+	filePath := filepath.Join(rootDir, file)
+	if filePath != filepath.Clean(rootDir) && !strings.HasPrefix(filePath, filepath.Clean(rootDir)+string(os.PathSeparator)) {
+		return "", fmt.Errorf("Path is outside of the release: %q", file)
+	}
+	return filePath, nil
+}
+
+// Returns the command and working directory of a hook. Commands with a slash
+// are scripts in the release, other commands are looked up on the PATH.
+func resolve(hookConfig config.DeployToVmConfigHook, rootDir string) (string, string, error) {
+	if hookConfig.Command == "" {
+		return "", "", fmt.Errorf("Hook command cannot be empty")
+	}
+	command := hookConfig.Command
+	if strings.ContainsRune(command, '/') {
+		scriptPath, pathErr := pathInRootDir(rootDir, command)
+		if pathErr != nil {
+			return "", "", pathErr
+		}
+		command = scriptPath
+	}
+	dir, dirErr := pathInRootDir(rootDir, hookConfig.Dir)
+	if dirErr != nil {
+		return "", "", dirErr
+	}
+	return command, dir, nil
+}
+
+// Checks that a hook can run in a release before anything is deployed
+func Validate(hookConfig config.DeployToVmConfigHook, rootDir string) error {
+	command, dir, resolveErr := resolve(hookConfig, rootDir)
+	if resolveErr != nil {
+		return resolveErr
+	}
+	if _, timeoutErr := hookConfig.GetTimeout(); timeoutErr != nil {
+		return timeoutErr
+	}
+	if strings.ContainsRune(hookConfig.Command, '/') {
+		if info, statErr := os.Stat(command); statErr != nil || !info.Mode().IsRegular() {
+			return fmt.Errorf("Hook script not found in the release: %q", hookConfig.Command)
+		}
+	}
+	if info, statErr := os.Stat(dir); statErr != nil || !info.IsDir() {
+		return fmt.Errorf("Hook directory not found in the release: %q", hookConfig.Dir)
+	}
+	return nil
+}
+
+// Returns the last lines of the output of a hook
+func tail(output []byte) string {
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if len(lines) > maxOutputLines {
+		lines = lines[len(lines)-maxOutputLines:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Runs the hooks in order in the root directory of a release and stops at the
+// first hook that fails. The variables of env describe the deployment and are
+// passed to every hook together with its own variables.
+func (c *HookClient) Run(name string, hookConfigs []config.DeployToVmConfigHook, rootDir string, env map[string]string) error {
+	for i, hookConfig := range hookConfigs {
+		command, dir, resolveErr := resolve(hookConfig, rootDir)
+		if resolveErr != nil {
+			return resolveErr
+		}
+		timeout, timeoutErr := hookConfig.GetTimeout()
+		if timeoutErr != nil {
+			return timeoutErr
+		}
+
+		hookEnv := make([]string, 0, len(env)+len(hookConfig.Env)+1)
+		for key, value := range env {
+			hookEnv = append(hookEnv, key+"="+value)
+		}
+		for key, value := range hookConfig.Env {
+			hookEnv = append(hookEnv, key+"="+value)
+		}
+		hookEnv = append(hookEnv, "DEPLOY_TO_VM_HOOK="+name)
+		sort.Strings(hookEnv)
+
+		description := fmt.Sprintf("%s hook %d (%s)", name, i+1, hookConfig.Command)
+		log.Printf("Running %s", description)
+		out, err := c.ExecClient.Command(command, hookConfig.Args...).
+			WithDir(dir).
+			WithEnv(hookEnv...).
+			WithTimeout(timeout).
+			CombinedOutput()
+		if err != nil {
+			log.Printf("Error running %s: %v", description, string(out))
+			return fmt.Errorf("Error running %s: %v\n%s", description, err, tail(out))
+		}
+		log.Printf("Ran %s", description)
+	}
+	return nil
+}
+
+// Returns a copy of the client that streams the output of its commands into
+// the log sink
+func (c *HookClient) WithLogSink(sink deploy_to_vm_exec.LogSink) HookClientInterface {
+	client := *c
+	client.ExecClient = deploy_to_vm_exec.WithLogSink(c.ExecClient, sink)
+	return &client
+}
+
+// Returns a copy of the client that runs its commands as the user of the
+// credential
+func (c *HookClient) WithCredential(credential *deploy_to_vm_exec.Credential) HookClientInterface {
+	client := *c
+	client.ExecClient = deploy_to_vm_exec.WithCredential(c.ExecClient, credential)
+	return &client
+}
+
+func NewHookClient(execClient deploy_to_vm_exec.ExecClientInterface) *HookClient {
+	// If execClient is nil, create a new ExecClient instance
+	if execClient == nil {
+		execClient = &deploy_to_vm_exec.ExecClient{}
+	}
+
+	return &HookClient{
+		ExecClient: execClient,
+	}
+}
@@ -0,0 +1,129 @@
+package hooks
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"deploy-to-vm/internal/config"
+	deploy_to_vm_exec "deploy-to-vm/internal/exec"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Returns a fake ExecClient that fails the commands in errors with their
+// output
+func newHookFakeExecClient(errors map[string]string) *deploy_to_vm_exec.FakeExecClient {
+	return &deploy_to_vm_exec.FakeExecClient{
+		Handler: func(command *deploy_to_vm_exec.FakeCommand) deploy_to_vm_exec.FakeResult {
+			if output, ok := errors[command.String()]; ok {
+				return deploy_to_vm_exec.FakeResult{Stderr: output, ExitCode: 1}
+			}
+			return deploy_to_vm_exec.FakeResult{}
+		},
+	}
+}
+
+func TestHookClient_Run_Success(t *testing.T) {
+	// Arrange: create a release with a migration script
+	rootDir := t.TempDir()
+	os.MkdirAll(path.Join(rootDir, "scripts"), 0755)
+	os.WriteFile(path.Join(rootDir, "scripts", "migrate.sh"), []byte("#!/bin/sh\n"), 0755)
+	fakeExecClient := newHookFakeExecClient(nil)
+	hookClient := NewHookClient(fakeExecClient)
+
+	// Act: run a script in the release and a command on the PATH
+	err := hookClient.Run(Hook_PreActivate, []config.DeployToVmConfigHook{
+		{Command: "scripts/migrate.sh", Args: []string{"--up"}, Timeout: "1m", Env: map[string]string{"DATABASE": "app"}},
+		{Command: "npm", Args: []string{"run", "warmup"}, Dir: "scripts"},
+	}, rootDir, map[string]string{"DEPLOY_TO_VM_TAG": "v1"})
+
+	// Assert: check if the hooks run in order with their options
+	assert.NoError(t, err)
+	assert.Equal(t, []string{path.Join(rootDir, "scripts", "migrate.sh") + " --up", "npm run warmup"}, fakeExecClient.CommandLines())
+	commands := fakeExecClient.Commands()
+	assert.Equal(t, rootDir, commands[0].Dir)
+	assert.Equal(t, []string{"DATABASE=app", "DEPLOY_TO_VM_HOOK=preActivate", "DEPLOY_TO_VM_TAG=v1"}, commands[0].Env)
+	assert.Equal(t, time.Minute, commands[0].Timeout)
+	assert.Equal(t, path.Join(rootDir, "scripts"), commands[1].Dir)
+	assert.Equal(t, config.DefaultHookTimeout, commands[1].Timeout)
+}
+
+func TestHookClient_Run_StopsAtFirstFailure(t *testing.T) {
+	// Arrange: create a fake ExecClient where the first hook fails
+	fakeExecClient := newHookFakeExecClient(map[string]string{"npm run migrate": "relation \"users\" already exists"})
+	hookClient := NewHookClient(fakeExecClient)
+
+	// Act: run two hooks
+	err := hookClient.Run(Hook_PreActivate, []config.DeployToVmConfigHook{
+		{Command: "npm", Args: []string{"run", "migrate"}},
+		{Command: "npm", Args: []string{"run", "warmup"}},
+	}, t.TempDir(), nil)
+
+	// Assert: check if the output is part of the error and the second hook is
+	// not run
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "preActivate hook 1 (npm)")
+	assert.Contains(t, err.Error(), "relation \"users\" already exists")
+	assert.Len(t, fakeExecClient.Commands(), 1)
+}
+
+func TestValidate(t *testing.T) {
+	// Arrange: create a release with a script
+	rootDir := t.TempDir()
+	os.WriteFile(path.Join(rootDir, "warmup.sh"), []byte("#!/bin/sh\n"), 0755)
+
+	// Act/Assert: check valid hooks
+	assert.NoError(t, Validate(config.DeployToVmConfigHook{Command: "./warmup.sh"}, rootDir))
+	assert.NoError(t, Validate(config.DeployToVmConfigHook{Command: "npm"}, rootDir))
+
+	// Act/Assert: check invalid hooks
+	assert.Error(t, Validate(config.DeployToVmConfigHook{}, rootDir))
+	assert.Error(t, Validate(config.DeployToVmConfigHook{Command: "./migrate.sh"}, rootDir))
+	assert.Error(t, Validate(config.DeployToVmConfigHook{Command: "../warmup.sh"}, rootDir))
+	assert.Error(t, Validate(config.DeployToVmConfigHook{Command: "npm", Dir: "missing"}, rootDir))
+	assert.Error(t, Validate(config.DeployToVmConfigHook{Command: "npm", Timeout: "forever"}, rootDir))
+}
+
+func TestHookClient_Run_RealCommand(t *testing.T) {
+	// Arrange: create a release with a script that prints the deployment
+	rootDir := t.TempDir()
+	os.WriteFile(path.Join(rootDir, "hook.sh"), []byte("#!/bin/sh\necho \"$DEPLOY_TO_VM_HOOK $DEPLOY_TO_VM_TAG\" > hook.out\n"), 0755)
+
+	// Act: run the script
+	err := NewHookClient(nil).Run(Hook_PostReload, []config.DeployToVmConfigHook{{Command: "./hook.sh"}}, rootDir, map[string]string{"DEPLOY_TO_VM_TAG": "v2"})
+
+	// Assert: check if the script ran in the release with the variables
+	assert.NoError(t, err)
+	out, _ := os.ReadFile(path.Join(rootDir, "hook.out"))
+	assert.Equal(t, "postReload v2\n", string(out))
+}
+
+// MockLogSink records the lines it receives
+type MockLogSink struct {
+	Lines []string
+}
+
+func (m *MockLogSink) WriteLine(stream string, line string) {
+	m.Lines = append(m.Lines, stream+": "+line)
+}
+
+func TestHookClient_WithLogSink(t *testing.T) {
+	// Arrange: create a fake ExecClient where the hook prints its progress
+	fakeExecClient := &deploy_to_vm_exec.FakeExecClient{
+		Handler: func(command *deploy_to_vm_exec.FakeCommand) deploy_to_vm_exec.FakeResult {
+			return deploy_to_vm_exec.FakeResult{Stdout: "Migrating 1/2\nMigrating 2/2\n"}
+		},
+	}
+	sink := &MockLogSink{}
+	hookClient := NewHookClient(fakeExecClient)
+
+	// Act: run the hook with the log sink
+	err := hookClient.WithLogSink(sink).Run(Hook_PreActivate, []config.DeployToVmConfigHook{{Command: "npm", Args: []string{"run", "migrate"}}}, t.TempDir(), nil)
+
+	// Assert: check if the output is streamed into the log sink
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"stdout: Migrating 1/2", "stdout: Migrating 2/2"}, sink.Lines)
+	assert.Equal(t, fakeExecClient, hookClient.ExecClient)
+}
@@ -0,0 +1,346 @@
+package manifest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"deploy-to-vm/internal/checksum"
+	file_utils "deploy-to-vm/internal/file-utils"
+)
+
+// FileName is the name of the manifest file inside the metadata directory of a
+// release directory
+const FileName = "manifest.json"
+
+// Version is the version of the manifest format
+const Version = 1
+
+// Source describes the event that triggered the deployment of a release.
+type Source struct {
+	Type       string `json:"type"`
+	Event      string `json:"event"`
+	Action     string `json:"action"`
+	DeliveryId string `json:"deliveryId,omitempty"`
+	Owner      string `json:"owner"`
+	Repo       string `json:"repo"`
+	ReleaseUrl string `json:"releaseUrl,omitempty"`
+}
+
+// Asset is a downloaded release asset.
+type Asset struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// File is a file of the release directory after extraction. Symlinks are
+// recorded with their target instead of a hash.
+type File struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size,omitempty"`
+	SHA256  string `json:"sha256,omitempty"`
+	Symlink string `json:"symlink,omitempty"`
+}
+
+// Target records which files of a release were linked to the site directory
+// of a target.
+type Target struct {
+	Name string `json:"name"`
+	// RootDir is the subdirectory of the release that is linked to the site
+	RootDir string `json:"rootDir,omitempty"`
+	// Include are the path patterns, relative to RootDir, of the linked files.
+	// All files are linked when empty.
+	Include []string `json:"include,omitempty"`
+}
+
+// Manifest records what a release directory contains and where it came from.
+type Manifest struct {
+	Version int    `json:"version"`
+	Source  Source `json:"source"`
+	Tag     string `json:"tag"`
+	// Commit is the SHA of the commit the tag of the release points to
+	Commit string `json:"commit,omitempty"`
+	// TargetCommitish is the branch or commit SHA the tag of the release was
+	// created from, as reported by GitHub
+	TargetCommitish string `json:"targetCommitish,omitempty"`
+	// RootDir is the subdirectory of the release that becomes the site
+	RootDir string `json:"rootDir,omitempty"`
+	// Targets are the targets the release is deployed to
+	Targets     []Target   `json:"targets,omitempty"`
+	Assets      []Asset    `json:"assets"`
+	Files       []File     `json:"files"`
+	PublishedAt *time.Time `json:"publishedAt,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt"`
+}
+
+// VerifyResult lists the differences between a directory and a manifest.
+// Paths are relative to the verified directory.
+type VerifyResult struct {
+	Modified []string `json:"modified"`
+	Added    []string `json:"added"`
+	Missing  []string `json:"missing"`
+}
+
+// Checks if the directory matches the manifest
+func (r *VerifyResult) IsClean() bool {
+	return len(r.Modified) == 0 && len(r.Added) == 0 && len(r.Missing) == 0
+}
+
+// Returns the path of the manifest of a release directory
+func Path(releaseDir string) string {
+	return filepath.Join(releaseDir, file_utils.MetadataDirName, FileName)
+}
+
+// Hashes the release assets downloaded into a directory. Only the top-level
+// files are assets.
+func HashAssets(dir string) ([]Asset, error) {
+	entries, readErr := os.ReadDir(dir)
+	if readErr != nil {
+		return nil, fmt.Errorf("Error while reading the release directory: %v", readErr)
+	}
+
+	assets := make([]Asset, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		info, infoErr := entry.Info()
+		if infoErr != nil {
+			return nil, fmt.Errorf("Error while reading asset %q: %v", entry.Name(), infoErr)
+		}
+		hash, hashErr := checksum.FileSHA256(filepath.Join(dir, entry.Name()))
+		if hashErr != nil {
+			return nil, fmt.Errorf("Error while hashing asset %q: %v", entry.Name(), hashErr)
+		}
+		assets = append(assets, Asset{Name: entry.Name(), Size: info.Size(), SHA256: hash})
+	}
+	return assets, nil
+}
+
+// Hashes the files of a release directory, leaving out its metadata directory
+func HashFiles(dir string) ([]File, error) {
+	files := make([]File, 0)
+	walkErr := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if file == filepath.Join(dir, file_utils.MetadataDirName) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		relPath, relErr := filepath.Rel(dir, file)
+		if relErr != nil {
+			return relErr
+		}
+		manifestFile, describeErr := describeFile(file, info)
+		if describeErr != nil {
+			return describeErr
+		}
+		manifestFile.Path = filepath.ToSlash(relPath)
+		files = append(files, manifestFile)
+		return nil
+	})
+	if walkErr != nil {
+		return nil, fmt.Errorf("Error while hashing the release files: %v", walkErr)
+	}
+	return files, nil
+}
+
+// Describes a file by its hash, or by its target for symlinks
+func describeFile(file string, info os.FileInfo) (File, error) {
+	if info.Mode()&os.ModeSymlink != 0 {
+		linkname, readlinkErr := os.Readlink(file)
+		if readlinkErr != nil {
+			return File{}, readlinkErr
+		}
+		return File{Symlink: linkname}, nil
+	}
+
+	hash, hashErr := checksum.FileSHA256(file)
+	if hashErr != nil {
+		return File{}, hashErr
+	}
+	return File{Size: info.Size(), SHA256: hash}, nil
+}
+
+// Writes the manifest into the metadata directory of a release directory
+func Write(releaseDir string, m *Manifest) error {
+	content, marshalErr := json.MarshalIndent(m, "", "  ")
+	if marshalErr != nil {
+		return fmt.Errorf("Error while encoding the manifest: %v", marshalErr)
+	}
+
+	manifestPath := Path(releaseDir)
+	if err := os.MkdirAll(filepath.Dir(manifestPath), 0755); err != nil {
+		return fmt.Errorf("Error while creating the metadata directory: %v", err)
+	}
+	if err := os.WriteFile(manifestPath, append(content, '\n'), 0644); err != nil {
+		return fmt.Errorf("Error while writing the manifest: %v", err)
+	}
+	return nil
+}
+
+// Reads the manifest of a release directory
+func Read(releaseDir string) (*Manifest, error) {
+	content, readErr := os.ReadFile(Path(releaseDir))
+	if readErr != nil {
+		return nil, fmt.Errorf("Error while reading the manifest: %w", readErr)
+	}
+
+	m := &Manifest{}
+	if err := json.Unmarshal(content, m); err != nil {
+		return nil, fmt.Errorf("Error while parsing the manifest: %v", err)
+	}
+	return m, nil
+}
+
+// Compares a directory with the given files. Paths matching one of the ignored
+// paths (see file_utils.IsProtectedPath) are not reported.
+func verifyFiles(dir string, files []File, ignoredPaths []string) (*VerifyResult, error) {
+	result := &VerifyResult{
+		Modified: make([]string, 0),
+		Added:    make([]string, 0),
+		Missing:  make([]string, 0),
+	}
+
+	expected := make(map[string]File, len(files))
+	for _, file := range files {
+		expected[file.Path] = file
+	}
+
+	// Check the files that are in the directory
+	walkErr := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, relErr := filepath.Rel(dir, file)
+		if relErr != nil {
+			return relErr
+		}
+		relPath = filepath.ToSlash(relPath)
+		if relPath == "." {
+			return nil
+		}
+		if info.IsDir() {
+			if relPath == file_utils.MetadataDirName || file_utils.IsProtectedPath(relPath, ignoredPaths) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if file_utils.IsProtectedPath(relPath, ignoredPaths) {
+			return nil
+		}
+
+		expectedFile, ok := expected[relPath]
+		if !ok {
+			result.Added = append(result.Added, relPath)
+			return nil
+		}
+		delete(expected, relPath)
+
+		if !matchesFile(file, info, expectedFile) {
+			result.Modified = append(result.Modified, relPath)
+		}
+		return nil
+	})
+	if walkErr != nil {
+		return nil, fmt.Errorf("Error while verifying %q: %v", dir, walkErr)
+	}
+
+	// The files that were not visited are missing
+	for relPath := range expected {
+		if !file_utils.IsProtectedPath(relPath, ignoredPaths) {
+			result.Missing = append(result.Missing, relPath)
+		}
+	}
+	sort.Strings(result.Missing)
+
+	return result, nil
+}
+
+// Checks if a file on disk matches a manifest file. Site files that are
+// symlinks to a release file (see file_utils.LinkStrategy_Symlink) are
+// compared by the content they point to.
+func matchesFile(file string, info os.FileInfo, expected File) bool {
+	if expected.Symlink != "" {
+		linkname, readlinkErr := os.Readlink(file)
+		if readlinkErr != nil {
+			return false
+		}
+		if linkname != expected.Symlink && filepath.IsAbs(linkname) {
+			// A symlink to the symlink in the release directory
+			linkname, readlinkErr = os.Readlink(linkname)
+		}
+		return readlinkErr == nil && linkname == expected.Symlink
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		targetInfo, statErr := os.Stat(file)
+		if statErr != nil {
+			return false
+		}
+		info = targetInfo
+	}
+	if !info.Mode().IsRegular() || info.Size() != expected.Size {
+		return false
+	}
+
+	hash, hashErr := checksum.FileSHA256(file)
+	return hashErr == nil && hash == expected.SHA256
+}
+
+// Verifies a release directory against its manifest
+func (m *Manifest) VerifyRelease(releaseDir string) (*VerifyResult, error) {
+	return verifyFiles(releaseDir, m.Files, nil)
+}
+
+// Verifies a site directory against the files of the release that were
+// deployed to it. Protected paths are not part of the release, so they are
+// ignored.
+func (m *Manifest) VerifySite(siteDir string, protectedPaths []string) (*VerifyResult, error) {
+	return m.VerifyTargetSite(Target{RootDir: m.RootDir}, siteDir, protectedPaths)
+}
+
+// Returns the target of the given name, or nil if the manifest does not
+// record it
+func (m *Manifest) GetTarget(name string) *Target {
+	for i := range m.Targets {
+		if m.Targets[i].Name == name {
+			return &m.Targets[i]
+		}
+	}
+	return nil
+}
+
+// Verifies the site directory of a target against the files of the release
+// that were linked to it, i.e. the included files of its root directory
+func (m *Manifest) VerifyTargetSite(target Target, siteDir string, protectedPaths []string) (*VerifyResult, error) {
+	rootDir := strings.Trim(filepath.ToSlash(filepath.Clean(target.RootDir)), "/")
+	if rootDir == "." {
+		rootDir = ""
+	}
+
+	siteFiles := make([]File, 0, len(m.Files))
+	for _, file := range m.Files {
+		if rootDir != "" {
+			if !strings.HasPrefix(file.Path, rootDir+"/") {
+				continue
+			}
+			file.Path = strings.TrimPrefix(file.Path, rootDir+"/")
+		}
+		if len(target.Include) > 0 && !file_utils.IsProtectedPath(file.Path, target.Include) {
+			continue
+		}
+		siteFiles = append(siteFiles, file)
+	}
+
+	return verifyFiles(siteDir, siteFiles, protectedPaths)
+}
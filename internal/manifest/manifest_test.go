@@ -0,0 +1,152 @@
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	file_utils "deploy-to-vm/internal/file-utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Helper to create a release directory with a manifest for its files
+func createTestRelease(t *testing.T, rootDir string) (string, *Manifest) {
+	releaseDir := t.TempDir()
+	os.MkdirAll(path.Join(releaseDir, "dist", "assets"), 0755)
+	os.WriteFile(path.Join(releaseDir, "dist", "index.html"), []byte("index"), 0644)
+	os.WriteFile(path.Join(releaseDir, "dist", "assets", "app.js"), []byte("app"), 0644)
+	os.WriteFile(path.Join(releaseDir, "README.md"), []byte("readme"), 0644)
+
+	files, err := HashFiles(releaseDir)
+	assert.NoError(t, err)
+
+	m := &Manifest{Version: Version, Tag: "v1", RootDir: rootDir, Files: files, CreatedAt: time.Now().UTC()}
+	assert.NoError(t, Write(releaseDir, m))
+	return releaseDir, m
+}
+
+func TestHashFiles_SkipsMetadataDir(t *testing.T) {
+	// Arrange: create a release with a manifest
+	releaseDir, _ := createTestRelease(t, "")
+
+	// Act: hash the files of the release
+	files, err := HashFiles(releaseDir)
+
+	// Assert: check if the manifest itself is not listed
+	assert.NoError(t, err)
+	paths := make([]string, 0, len(files))
+	for _, file := range files {
+		paths = append(paths, file.Path)
+	}
+	assert.ElementsMatch(t, []string{"dist/index.html", "dist/assets/app.js", "README.md"}, paths)
+}
+
+func TestHashAssets(t *testing.T) {
+	// Arrange: create a directory with an asset and a subdirectory
+	dir := t.TempDir()
+	os.WriteFile(path.Join(dir, "app.tar.gz"), []byte("asset"), 0644)
+	os.MkdirAll(path.Join(dir, "nested"), 0755)
+
+	// Act: hash the assets
+	assets, err := HashAssets(dir)
+
+	// Assert: check if only the top-level file is an asset
+	assert.NoError(t, err)
+	digest := sha256.Sum256([]byte("asset"))
+	assert.Equal(t, []Asset{{Name: "app.tar.gz", Size: 5, SHA256: hex.EncodeToString(digest[:])}}, assets)
+}
+
+func TestWriteRead_RoundTrip(t *testing.T) {
+	// Arrange: create a release with a manifest
+	releaseDir, m := createTestRelease(t, "dist")
+
+	// Act: read the manifest back
+	readManifest, err := Read(releaseDir)
+
+	// Assert: check if the manifest is stored in the metadata directory
+	assert.NoError(t, err)
+	assert.Equal(t, m.Files, readManifest.Files)
+	assert.Equal(t, "dist", readManifest.RootDir)
+	assert.Equal(t, path.Join(releaseDir, file_utils.MetadataDirName, FileName), Path(releaseDir))
+}
+
+func TestRead_NotFound(t *testing.T) {
+	// Act: read the manifest of a directory without one
+	_, err := Read(t.TempDir())
+
+	// Assert: check if a not exist error is returned
+	assert.True(t, errors.Is(err, os.ErrNotExist), "Expected a not exist error, got %v", err)
+}
+
+func TestVerifyRelease_DetectsTampering(t *testing.T) {
+	// Arrange: create a release and tamper with it
+	releaseDir, m := createTestRelease(t, "")
+	os.WriteFile(path.Join(releaseDir, "dist", "index.html"), []byte("tampered"), 0644)
+	os.Remove(path.Join(releaseDir, "README.md"))
+	os.WriteFile(path.Join(releaseDir, "dist", "backdoor.php"), []byte("evil"), 0644)
+
+	// Act: verify the release
+	result, err := m.VerifyRelease(releaseDir)
+
+	// Assert: check if every difference is reported
+	assert.NoError(t, err)
+	assert.False(t, result.IsClean())
+	assert.Equal(t, []string{"dist/index.html"}, result.Modified)
+	assert.Equal(t, []string{"dist/backdoor.php"}, result.Added)
+	assert.Equal(t, []string{"README.md"}, result.Missing)
+}
+
+func TestVerifySite_RootDirAndProtectedPaths(t *testing.T) {
+	// Arrange: deploy the "dist" directory of a release to a site
+	releaseDir, m := createTestRelease(t, "dist")
+	siteDir := t.TempDir()
+	_, linkErr := file_utils.LinkReleaseAssetsToSiteDirWithOptions(path.Join(releaseDir, "dist"), siteDir, file_utils.LinkOptions{})
+	assert.NoError(t, linkErr)
+	os.MkdirAll(path.Join(siteDir, "uploads"), 0755)
+	os.WriteFile(path.Join(siteDir, "uploads", "photo.jpg"), []byte("photo"), 0644)
+
+	// Act: verify the site
+	result, err := m.VerifySite(siteDir, []string{"uploads/"})
+
+	// Assert: check if the site matches and protected paths are ignored
+	assert.NoError(t, err)
+	assert.True(t, result.IsClean(), "Expected no drift, got %+v", result)
+}
+
+func TestVerifyTargetSite_Include(t *testing.T) {
+	// Arrange: deploy the assets of the "dist" directory of a release to a
+	// target
+	releaseDir, m := createTestRelease(t, "")
+	m.Targets = []Target{{Name: "static", RootDir: "dist", Include: []string{"assets/"}}}
+	siteDir := t.TempDir()
+	_, linkErr := file_utils.LinkReleaseAssetsToSiteDirWithOptions(path.Join(releaseDir, "dist"), siteDir, file_utils.LinkOptions{Include: []string{"assets/"}})
+	assert.NoError(t, linkErr)
+
+	// Act: verify the site of the target
+	result, err := m.VerifyTargetSite(*m.GetTarget("static"), siteDir, nil)
+
+	// Assert: check if only the included files are expected
+	assert.NoError(t, err)
+	assert.True(t, result.IsClean(), "Expected no drift, got %+v", result)
+	assert.Nil(t, m.GetTarget("api"))
+}
+
+func TestVerifySite_SymlinkStrategy(t *testing.T) {
+	// Arrange: deploy a release to a site with symlinks
+	releaseDir, m := createTestRelease(t, "")
+	siteDir := t.TempDir()
+	_, linkErr := file_utils.LinkReleaseAssetsToSiteDirWithOptions(releaseDir, siteDir, file_utils.LinkOptions{Strategy: file_utils.LinkStrategy_Symlink})
+	assert.NoError(t, linkErr)
+
+	// Act: verify the site
+	result, err := m.VerifySite(siteDir, nil)
+
+	// Assert: check if the symlinked files match
+	assert.NoError(t, err)
+	assert.True(t, result.IsClean(), "Expected no drift, got %+v", result)
+}
@@ -1,11 +1,18 @@
 package nginx
 
 import (
+	"fmt"
 	"log"
+	"strings"
+	"time"
 
 	deploy_to_vm_exec "deploy-to-vm/internal/exec"
 )
 
+// DefaultCommandTimeout is how long an nginx command may run before it is
+// killed
+const DefaultCommandTimeout = 30 * time.Second
+
 // NginxClient is a struct that represents a client for interacting with the
 // Nginx installation in the VM.
 type NginxClient struct {
@@ -18,11 +25,27 @@ type NginxClient struct {
 // that has the same methods as the NginxClient struct.
 type NginxClientInterface interface {
 	Reload() error
+	Test() error
+	WithLogSink(sink deploy_to_vm_exec.LogSink) NginxClientInterface
+}
+
+// Tests the nginx configuration. The error includes the output of "nginx -t".
+func (c *NginxClient) Test() error {
+	out, err := c.ExecClient.Command("nginx", "-t").WithTimeout(DefaultCommandTimeout).CombinedOutput()
+	if err != nil {
+		log.Printf("Error testing nginx configuration: %v", string(out))
+		return fmt.Errorf("nginx configuration test failed: %v\n%s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
 }
 
-// Reloads the nginx unit in systemctl
+// Tests the nginx configuration and reloads the nginx unit in systemctl
 func (c *NginxClient) Reload() error {
-	out, err := c.ExecClient.Command("systemctl", "reload", "nginx").CombinedOutput()
+	if testErr := c.Test(); testErr != nil {
+		return testErr
+	}
+
+	out, err := c.ExecClient.Command("systemctl", "reload", "nginx").WithTimeout(DefaultCommandTimeout).CombinedOutput()
 	if err != nil {
 		log.Printf("Error reloading nginx unit: %v", string(out))
 	} else {
@@ -31,6 +54,14 @@ func (c *NginxClient) Reload() error {
 	return err
 }
 
+// Returns a copy of the client that streams the output of its commands into
+// the log sink
+func (c *NginxClient) WithLogSink(sink deploy_to_vm_exec.LogSink) NginxClientInterface {
+	client := *c
+	client.ExecClient = deploy_to_vm_exec.WithLogSink(c.ExecClient, sink)
+	return &client
+}
+
 func NewNginxClient(execClient deploy_to_vm_exec.ExecClientInterface) *NginxClient {
 	// If execClient is nil, create a new ExecClient instance
 	if execClient == nil {
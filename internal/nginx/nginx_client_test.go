@@ -1,8 +1,11 @@
 package nginx
 
 import (
+	"context"
 	"errors"
+	"strings"
 	"testing"
+	"time"
 
 	deploy_to_vm_exec "deploy-to-vm/internal/exec"
 
@@ -13,15 +16,49 @@ type MockExecCommand struct {
 	CombinedOutputFunc func() ([]byte, error)
 }
 
+func (m *MockExecCommand) WithContext(ctx context.Context) deploy_to_vm_exec.ExecCommandInterface {
+	return m
+}
+
+func (m *MockExecCommand) WithTimeout(timeout time.Duration) deploy_to_vm_exec.ExecCommandInterface {
+	return m
+}
+
+func (m *MockExecCommand) WithDir(dir string) deploy_to_vm_exec.ExecCommandInterface {
+	return m
+}
+
+func (m *MockExecCommand) WithEnv(env ...string) deploy_to_vm_exec.ExecCommandInterface {
+	return m
+}
+
+func (m *MockExecCommand) WithLogSink(sink deploy_to_vm_exec.LogSink) deploy_to_vm_exec.ExecCommandInterface {
+	return m
+}
+
+func (m *MockExecCommand) WithCredential(credential *deploy_to_vm_exec.Credential) deploy_to_vm_exec.ExecCommandInterface {
+	return m
+}
+
 func (m *MockExecCommand) CombinedOutput() ([]byte, error) {
 	return m.CombinedOutputFunc()
 }
 
+func (m *MockExecCommand) Run() (*deploy_to_vm_exec.Result, error) {
+	output, err := m.CombinedOutputFunc()
+	return &deploy_to_vm_exec.Result{Stdout: output}, err
+}
+
 type MockExecClient struct {
+	CommandFunc        func(name string, arg ...string) deploy_to_vm_exec.ExecCommandInterface
 	CombinedOutputFunc func() ([]byte, error)
 }
 
 func (m *MockExecClient) Command(name string, arg ...string) deploy_to_vm_exec.ExecCommandInterface {
+	if m.CommandFunc != nil {
+		return m.CommandFunc(name, arg...)
+	}
+
 	return &MockExecCommand{
 		CombinedOutputFunc: m.CombinedOutputFunc,
 	}
@@ -54,10 +91,17 @@ func TestNginxClient_Reload_Success(t *testing.T) {
 }
 
 func TestNginxClient_Reload_Error(t *testing.T) {
-	// Arrange: create a mock ExecClient that simulates the command execution
+	// Arrange: create a mock ExecClient where only the reload fails
 	mockExecClient := &MockExecClient{
-		CombinedOutputFunc: func() ([]byte, error) {
-			return nil, errors.New("mock error")
+		CommandFunc: func(name string, arg ...string) deploy_to_vm_exec.ExecCommandInterface {
+			return &MockExecCommand{
+				CombinedOutputFunc: func() ([]byte, error) {
+					if name == "systemctl" {
+						return nil, errors.New("mock error")
+					}
+					return nil, nil
+				},
+			}
 		},
 	}
 
@@ -71,6 +115,54 @@ func TestNginxClient_Reload_Error(t *testing.T) {
 	assert.EqualError(t, err, "mock error", "Expected error message to match")
 }
 
+func TestNginxClient_Reload_TestError(t *testing.T) {
+	// Arrange: create a mock ExecClient where the configuration test fails
+	commands := make([]string, 0)
+	mockExecClient := &MockExecClient{
+		CommandFunc: func(name string, arg ...string) deploy_to_vm_exec.ExecCommandInterface {
+			commands = append(commands, name+" "+strings.Join(arg, " "))
+			return &MockExecCommand{
+				CombinedOutputFunc: func() ([]byte, error) {
+					return []byte("nginx: [emerg] unexpected \"}\" in /etc/nginx/sites-enabled/app.conf:12\n"), errors.New("exit status 1")
+				},
+			}
+		},
+	}
+
+	// Arrange: create an instance of NginxClient with the mock ExecClient
+	nginxClient := NewNginxClient(mockExecClient)
+
+	// Act: call the Reload method
+	err := nginxClient.Reload()
+
+	// Assert: check if the test output is part of the error and nginx is not reloaded
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unexpected \"}\" in /etc/nginx/sites-enabled/app.conf:12")
+	assert.Equal(t, []string{"nginx -t"}, commands)
+}
+
+func TestNginxClient_Test_Success(t *testing.T) {
+	// Arrange: create a mock ExecClient that records the command
+	var command string
+	mockExecClient := &MockExecClient{
+		CommandFunc: func(name string, arg ...string) deploy_to_vm_exec.ExecCommandInterface {
+			command = name + " " + strings.Join(arg, " ")
+			return &MockExecCommand{
+				CombinedOutputFunc: func() ([]byte, error) {
+					return []byte("nginx: configuration file /etc/nginx/nginx.conf test is successful"), nil
+				},
+			}
+		},
+	}
+
+	// Act: test the configuration
+	err := NewNginxClient(mockExecClient).Test()
+
+	// Assert: check if the configuration is tested
+	assert.NoError(t, err)
+	assert.Equal(t, "nginx -t", command)
+}
+
 func TestNewNginxClient_EmptyExecClient(t *testing.T) {
 	// Arrange: create a new NginxClient with nil ExecClient
 	nginxClient := NewNginxClient(nil)
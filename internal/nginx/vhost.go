@@ -0,0 +1,89 @@
+package nginx
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultSitesEnabledDir is the directory server blocks are installed into if
+// the config does not name one
+const DefaultSitesEnabledDir = "/etc/nginx/sites-enabled"
+
+// Writes a file by renaming a temporary file into place, so nginx never reads
+// a partially written server block
+func writeFileAtomic(file string, content []byte, mode os.FileMode) error {
+	tempFile := file + ".tmp"
+	if err := os.WriteFile(tempFile, content, mode); err != nil {
+		return err
+	}
+	if err := os.Rename(tempFile, file); err != nil {
+		os.Remove(tempFile)
+		return err
+	}
+	return nil
+}
+
+// Installs a server block into the sites-enabled directory under the given
+// name. It returns a function that restores the version that was installed
+// before, or removes the server block if there was none.
+func InstallVhost(vhostFile string, sitesEnabledDir string, name string) (func() error, error) {
+	if name == "" || strings.ContainsRune(name, '/') || strings.HasPrefix(name, ".") {
+		return nil, fmt.Errorf("Invalid server block name: %q", name)
+	}
+	if sitesEnabledDir == "" {
+		sitesEnabledDir = DefaultSitesEnabledDir
+	}
+
+	content, readErr := os.ReadFile(vhostFile)
+	if readErr != nil {
+		return nil, fmt.Errorf("Error while reading the server block: %v", readErr)
+	}
+
+	return replaceFile(filepath.Join(sitesEnabledDir, name), content)
+}
+
+// Replaces the content of a config file. It returns a function that restores
+// the content from before, or removes the file if there was none.
+func replaceFile(file string, content []byte) (func() error, error) {
+	previousContent, previousErr := os.ReadFile(file)
+	if previousErr != nil && !errors.Is(previousErr, os.ErrNotExist) {
+		return nil, fmt.Errorf("Error while reading %s: %v", file, previousErr)
+	}
+	hadPrevious := previousErr == nil
+
+	if err := writeFileAtomic(file, content, 0644); err != nil {
+		return nil, fmt.Errorf("Error while writing %s: %v", file, err)
+	}
+
+	restore := func() error {
+		if !hadPrevious {
+			if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("Error while removing %s: %v", file, err)
+			}
+			return nil
+		}
+		if err := writeFileAtomic(file, previousContent, 0644); err != nil {
+			return fmt.Errorf("Error while restoring %s: %v", file, err)
+		}
+		return nil
+	}
+	return restore, nil
+}
+
+// Writes an upstream block that sends the traffic of an upstream to a local
+// port, for a server block to include. It returns a function that restores
+// the upstream from before.
+func WriteUpstream(upstreamFile string, name string, port int) (func() error, error) {
+	if name == "" || strings.ContainsAny(name, " \t\n{};") {
+		return nil, fmt.Errorf("Invalid upstream name: %q", name)
+	}
+	if port <= 0 || port > 65535 {
+		return nil, fmt.Errorf("Invalid upstream port: %d", port)
+	}
+
+	content := fmt.Sprintf("# Written by deploy-to-vm\nupstream %s {\n    server 127.0.0.1:%d;\n}\n", name, port)
+	return replaceFile(upstreamFile, []byte(content))
+}
@@ -0,0 +1,78 @@
+package nginx
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstallVhost_RestoresPreviousVersion(t *testing.T) {
+	// Arrange: create a new server block and an installed one
+	releaseDir := t.TempDir()
+	sitesEnabledDir := t.TempDir()
+	os.WriteFile(path.Join(releaseDir, "nginx.conf"), []byte("server { listen 8080; }"), 0644)
+	os.WriteFile(path.Join(sitesEnabledDir, "app.conf"), []byte("server { listen 80; }"), 0644)
+
+	// Act: install the new server block, then restore the previous one
+	restore, installErr := InstallVhost(path.Join(releaseDir, "nginx.conf"), sitesEnabledDir, "app.conf")
+	installed, _ := os.ReadFile(path.Join(sitesEnabledDir, "app.conf"))
+	restoreErr := restore()
+	restored, _ := os.ReadFile(path.Join(sitesEnabledDir, "app.conf"))
+
+	// Assert: check if the server block is installed and restored
+	assert.NoError(t, installErr)
+	assert.NoError(t, restoreErr)
+	assert.Equal(t, "server { listen 8080; }", string(installed))
+	assert.Equal(t, "server { listen 80; }", string(restored))
+}
+
+func TestInstallVhost_RemovesNewServerBlock(t *testing.T) {
+	// Arrange: create a server block that was never installed
+	releaseDir := t.TempDir()
+	sitesEnabledDir := t.TempDir()
+	os.WriteFile(path.Join(releaseDir, "nginx.conf"), []byte("server { listen 8080; }"), 0644)
+
+	// Act: install the server block and restore the previous state
+	restore, installErr := InstallVhost(path.Join(releaseDir, "nginx.conf"), sitesEnabledDir, "app.conf")
+	restoreErr := restore()
+
+	// Assert: check if the server block is removed again
+	assert.NoError(t, installErr)
+	assert.NoError(t, restoreErr)
+	entries, _ := os.ReadDir(sitesEnabledDir)
+	assert.Empty(t, entries)
+}
+
+func TestInstallVhost_InvalidName(t *testing.T) {
+	// Act: install a server block with a name outside of the directory
+	_, err := InstallVhost("nginx.conf", t.TempDir(), "../nginx.conf")
+
+	// Assert: check if there was an error
+	assert.Error(t, err)
+}
+
+func TestWriteUpstream(t *testing.T) {
+	// Arrange: create an upstream that points to the blue port
+	upstreamFile := path.Join(t.TempDir(), "app-upstream.conf")
+	os.WriteFile(upstreamFile, []byte("upstream app { server 127.0.0.1:3001; }"), 0644)
+
+	// Act: switch the upstream to the green port, then restore it
+	restore, writeErr := WriteUpstream(upstreamFile, "app", 3002)
+	written, _ := os.ReadFile(upstreamFile)
+	restoreErr := restore()
+	restored, _ := os.ReadFile(upstreamFile)
+
+	// Assert: check if the upstream is written and restored
+	assert.NoError(t, writeErr)
+	assert.NoError(t, restoreErr)
+	assert.Equal(t, "# Written by deploy-to-vm\nupstream app {\n    server 127.0.0.1:3002;\n}\n", string(written))
+	assert.Equal(t, "upstream app { server 127.0.0.1:3001; }", string(restored))
+
+	// Act/Assert: check if invalid names and ports fail
+	_, err := WriteUpstream(upstreamFile, "app { }", 3002)
+	assert.Error(t, err)
+	_, err = WriteUpstream(upstreamFile, "app", 0)
+	assert.Error(t, err)
+}
@@ -2,16 +2,95 @@ package pm2
 
 import (
 	deploy_to_vm_exec "deploy-to-vm/internal/exec"
+	"encoding/json"
 	"fmt"
 	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
+// DefaultPollInterval is how often the process list is read while waiting for
+// a process to come online
+const DefaultPollInterval = 500 * time.Millisecond
+
+// DefaultCommandTimeout is how long a pm2 command may run before it is killed,
+// so a hung pm2 daemon can't block a deployment
+const DefaultCommandTimeout = 2 * time.Minute
+
 type Pm2Client struct {
-	ExecClient deploy_to_vm_exec.ExecClientInterface
+	ExecClient     deploy_to_vm_exec.ExecClientInterface
+	PollInterval   time.Duration
+	CommandTimeout time.Duration
 }
 
 type Pm2ClientInterface interface {
 	Reload(targetProcessName string) error
+	List() ([]Process, error)
+	Start(ecosystemFile string, targetProcessName string) error
+	Save() error
+	Stop(targetProcessName string) error
+	WaitForOnline(targetProcessName string, version string, timeout time.Duration) (*Process, error)
+	WithLogSink(sink deploy_to_vm_exec.LogSink) Pm2ClientInterface
+	WithCredential(credential *deploy_to_vm_exec.Credential) Pm2ClientInterface
+}
+
+// Process is a process in the output of "pm2 jlist".
+type Process struct {
+	Name   string     `json:"name"`
+	Pid    int        `json:"pid"`
+	PmId   int        `json:"pm_id"`
+	Pm2Env ProcessEnv `json:"pm2_env"`
+}
+
+// ProcessEnv holds the state of a pm2 process.
+type ProcessEnv struct {
+	// Status is e.g. "online", "launching", "stopped" or "errored"
+	Status string `json:"status"`
+	// Version is the version of the package.json of the app
+	Version string `json:"version"`
+	PmCwd   string `json:"pm_cwd"`
+}
+
+// EcosystemApp is an app of an ecosystem file, see WriteEcosystemFile.
+type EcosystemApp struct {
+	Name      string            `json:"name"`
+	Script    string            `json:"script"`
+	Cwd       string            `json:"cwd,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+	Instances int               `json:"instances,omitempty"`
+}
+
+// Writes an ecosystem file with a single app, for apps that do not ship one
+func WriteEcosystemFile(ecosystemFile string, app EcosystemApp) error {
+	content, marshalErr := json.MarshalIndent(map[string][]EcosystemApp{"apps": {app}}, "", "  ")
+	if marshalErr != nil {
+		return fmt.Errorf("Error while encoding the ecosystem file: %v", marshalErr)
+	}
+	if err := os.MkdirAll(filepath.Dir(ecosystemFile), 0755); err != nil {
+		return fmt.Errorf("Error while creating the ecosystem file directory: %v", err)
+	}
+	if err := os.WriteFile(ecosystemFile, append(content, '\n'), 0644); err != nil {
+		return fmt.Errorf("Error while writing the ecosystem file: %v", err)
+	}
+	return nil
+}
+
+// Returns the version of the package.json in a directory, or an empty string
+// if there is none
+func ReadPackageVersion(dir string) string {
+	content, readErr := os.ReadFile(filepath.Join(dir, "package.json"))
+	if readErr != nil {
+		return ""
+	}
+	packageJson := struct {
+		Version string `json:"version"`
+	}{}
+	if err := json.Unmarshal(content, &packageJson); err != nil {
+		return ""
+	}
+	return packageJson.Version
 }
 
 func (c *Pm2Client) Reload(targetProcessName string) error {
@@ -19,7 +98,7 @@ func (c *Pm2Client) Reload(targetProcessName string) error {
 		return fmt.Errorf("targetProcessName cannot be empty")
 	}
 
-	out, err := c.ExecClient.Command("pm2", "reload", targetProcessName).CombinedOutput()
+	out, err := c.command("reload", targetProcessName).CombinedOutput()
 	if err != nil {
 		log.Printf("Error reloading pm2 process \"%s\": %v", targetProcessName, string(out))
 	} else {
@@ -29,16 +108,143 @@ func (c *Pm2Client) Reload(targetProcessName string) error {
 	return err
 }
 
+// Lists the pm2 processes
+func (c *Pm2Client) List() ([]Process, error) {
+	result, err := c.command("jlist").Run()
+	if err != nil {
+		log.Printf("Error listing pm2 processes: %v", string(result.Stderr))
+		return nil, fmt.Errorf("Error listing pm2 processes: %v", err)
+	}
+	return parseProcessList(result.Stdout)
+}
+
+// Parses the output of "pm2 jlist". pm2 may print warnings and, on a fresh
+// VM, "[PM2] Spawning PM2 daemon" lines before the JSON list, so the last
+// line that is a JSON array is used.
+func parseProcessList(out []byte) ([]Process, error) {
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := strings.TrimSpace(lines[i])
+		if !strings.HasPrefix(line, "[") {
+			continue
+		}
+		processes := make([]Process, 0)
+		if err := json.Unmarshal([]byte(line), &processes); err == nil {
+			return processes, nil
+		}
+	}
+	return nil, fmt.Errorf("Error parsing the pm2 process list: %q", strings.TrimSpace(string(out)))
+}
+
+// Starts a process from an ecosystem file
+func (c *Pm2Client) Start(ecosystemFile string, targetProcessName string) error {
+	if ecosystemFile == "" || targetProcessName == "" {
+		return fmt.Errorf("ecosystemFile and targetProcessName cannot be empty")
+	}
+
+	out, err := c.command("start", ecosystemFile, "--only", targetProcessName).CombinedOutput()
+	if err != nil {
+		log.Printf("Error starting pm2 process \"%s\": %v", targetProcessName, string(out))
+		return fmt.Errorf("Error starting pm2 process %s: %v\n%s", targetProcessName, err, strings.TrimSpace(string(out)))
+	}
+
+	log.Printf("Started pm2 process \"%s\"", targetProcessName)
+	return nil
+}
+
+// Stops a process, keeping it in the process list
+func (c *Pm2Client) Stop(targetProcessName string) error {
+	if targetProcessName == "" {
+		return fmt.Errorf("targetProcessName cannot be empty")
+	}
+
+	out, err := c.command("stop", targetProcessName).CombinedOutput()
+	if err != nil {
+		log.Printf("Error stopping pm2 process \"%s\": %v", targetProcessName, string(out))
+		return fmt.Errorf("Error stopping pm2 process %s: %v", targetProcessName, err)
+	}
+
+	log.Printf("Stopped pm2 process \"%s\"", targetProcessName)
+	return nil
+}
+
+// Saves the process list, so the processes are resurrected after a reboot
+func (c *Pm2Client) Save() error {
+	out, err := c.command("save").CombinedOutput()
+	if err != nil {
+		log.Printf("Error saving pm2 process list: %v", string(out))
+		return fmt.Errorf("Error saving pm2 process list: %v", err)
+	}
+	return nil
+}
+
+// Waits until a process is online. If version is not empty, the process must
+// also run that version of the app.
+func (c *Pm2Client) WaitForOnline(targetProcessName string, version string, timeout time.Duration) (*Process, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		processes, listErr := c.List()
+		if listErr != nil {
+			return nil, listErr
+		}
+
+		state := "not found"
+		for i := range processes {
+			process := &processes[i]
+			if process.Name != targetProcessName {
+				continue
+			}
+			state = process.Pm2Env.Status
+			if process.Pm2Env.Status == "online" && (version == "" || process.Pm2Env.Version == version) {
+				return process, nil
+			}
+			if process.Pm2Env.Status == "online" {
+				state = fmt.Sprintf("online with version %s instead of %s", process.Pm2Env.Version, version)
+			}
+		}
+
+		if state == "errored" || time.Now().After(deadline) {
+			return nil, fmt.Errorf("pm2 process %s is not online (state: %s)", targetProcessName, state)
+		}
+		time.Sleep(c.PollInterval)
+	}
+}
+
+// Returns a copy of the client that streams the output of its commands into
+// the log sink
+func (c *Pm2Client) WithLogSink(sink deploy_to_vm_exec.LogSink) Pm2ClientInterface {
+	client := *c
+	client.ExecClient = deploy_to_vm_exec.WithLogSink(c.ExecClient, sink)
+	return &client
+}
+
+// Returns a copy of the client that runs its commands as the user of the
+// credential
+func (c *Pm2Client) WithCredential(credential *deploy_to_vm_exec.Credential) Pm2ClientInterface {
+	client := *c
+	client.ExecClient = deploy_to_vm_exec.WithCredential(c.ExecClient, credential)
+	return &client
+}
+
+// Returns a pm2 command that is killed after the command timeout
+func (c *Pm2Client) command(args ...string) deploy_to_vm_exec.ExecCommandInterface {
+	return c.ExecClient.Command("pm2", args...).WithTimeout(c.CommandTimeout)
+}
+
 func NewPm2Client(execClient deploy_to_vm_exec.ExecClientInterface) *Pm2Client {
 	// If execClient is nil, create a new ExecClient instance
 	if execClient == nil {
 		return &Pm2Client{
-			ExecClient: &deploy_to_vm_exec.ExecClient{},
+			ExecClient:     &deploy_to_vm_exec.ExecClient{},
+			PollInterval:   DefaultPollInterval,
+			CommandTimeout: DefaultCommandTimeout,
 		}
 	}
 
 	// If execClient is provided, use it to create the Pm2Client
 	return &Pm2Client{
-		ExecClient: execClient,
+		ExecClient:     execClient,
+		PollInterval:   DefaultPollInterval,
+		CommandTimeout: DefaultCommandTimeout,
 	}
 }
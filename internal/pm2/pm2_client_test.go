@@ -1,10 +1,14 @@
 package pm2
 
 import (
+	"context"
 	deploy_to_vm_exec "deploy-to-vm/internal/exec"
 	"errors"
+	"os"
+	"path"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -13,10 +17,39 @@ type MockExecCommand struct {
 	CombinedOutputFunc func() ([]byte, error)
 }
 
+func (m *MockExecCommand) WithContext(ctx context.Context) deploy_to_vm_exec.ExecCommandInterface {
+	return m
+}
+
+func (m *MockExecCommand) WithTimeout(timeout time.Duration) deploy_to_vm_exec.ExecCommandInterface {
+	return m
+}
+
+func (m *MockExecCommand) WithDir(dir string) deploy_to_vm_exec.ExecCommandInterface {
+	return m
+}
+
+func (m *MockExecCommand) WithEnv(env ...string) deploy_to_vm_exec.ExecCommandInterface {
+	return m
+}
+
+func (m *MockExecCommand) WithLogSink(sink deploy_to_vm_exec.LogSink) deploy_to_vm_exec.ExecCommandInterface {
+	return m
+}
+
+func (m *MockExecCommand) WithCredential(credential *deploy_to_vm_exec.Credential) deploy_to_vm_exec.ExecCommandInterface {
+	return m
+}
+
 func (m *MockExecCommand) CombinedOutput() ([]byte, error) {
 	return m.CombinedOutputFunc()
 }
 
+func (m *MockExecCommand) Run() (*deploy_to_vm_exec.Result, error) {
+	output, err := m.CombinedOutputFunc()
+	return &deploy_to_vm_exec.Result{Stdout: output}, err
+}
+
 type MockExecClient struct {
 	CommandFunc        func(name string, arg ...string) deploy_to_vm_exec.ExecCommandInterface
 	CombinedOutputFunc func() ([]byte, error)
@@ -94,6 +127,25 @@ func TestNewPm2Client_EmptyExecClient(t *testing.T) {
 	assert.IsType(t, &deploy_to_vm_exec.ExecClient{}, pm2Client.ExecClient)
 }
 
+func TestPm2Client_CommandTimeout(t *testing.T) {
+	// Arrange: create a fake ExecClient where pm2 hangs until it is killed
+	fakeExecClient := &deploy_to_vm_exec.FakeExecClient{
+		Handler: func(command *deploy_to_vm_exec.FakeCommand) deploy_to_vm_exec.FakeResult {
+			return deploy_to_vm_exec.FakeResult{Err: context.DeadlineExceeded}
+		},
+	}
+	pm2Client := NewPm2Client(fakeExecClient)
+	pm2Client.CommandTimeout = time.Minute
+
+	// Act: reload the process
+	err := pm2Client.Reload("app")
+
+	// Assert: check if the command is killed after the command timeout
+	assert.Error(t, err)
+	assert.Equal(t, []string{"pm2 reload app"}, fakeExecClient.CommandLines())
+	assert.Equal(t, time.Minute, fakeExecClient.Commands()[0].Timeout)
+}
+
 func TestNewPm2Client_OverrideExecClient(t *testing.T) {
 	// Arrange: create a mock ExecClient
 	mockExecClient := &MockExecClient{
@@ -110,3 +162,152 @@ func TestNewPm2Client_OverrideExecClient(t *testing.T) {
 	assert.Equal(t, mockExecClient, pm2Client.ExecClient)
 	assert.IsType(t, &MockExecClient{}, pm2Client.ExecClient)
 }
+
+// Helper to create a mock ExecClient that returns the output for a pm2
+// subcommand and records the commands
+func newPm2MockExecClient(commands *[]string, outputs map[string]string) *MockExecClient {
+	return &MockExecClient{
+		CommandFunc: func(name string, arg ...string) deploy_to_vm_exec.ExecCommandInterface {
+			*commands = append(*commands, name+" "+strings.Join(arg, " "))
+			return &MockExecCommand{
+				CombinedOutputFunc: func() ([]byte, error) {
+					return []byte(outputs[arg[0]]), nil
+				},
+			}
+		},
+	}
+}
+
+func TestPm2Client_List(t *testing.T) {
+	// Arrange: create a process list preceded by a warning
+	commands := make([]string, 0)
+	pm2Client := NewPm2Client(newPm2MockExecClient(&commands, map[string]string{
+		"jlist": ">>>> In-memory PM2 is out-of-date, do:\n>>>> $ pm2 update\n" +
+			`[{"name":"api","pid":1234,"pm_id":0,"pm2_env":{"status":"online","version":"1.2.0","pm_cwd":"/srv/api"}}]`,
+	}))
+
+	// Act: list the processes
+	processes, err := pm2Client.List()
+
+	// Assert: check if the process list is parsed
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"pm2 jlist"}, commands)
+	assert.Equal(t, []Process{{
+		Name:   "api",
+		Pid:    1234,
+		PmId:   0,
+		Pm2Env: ProcessEnv{Status: "online", Version: "1.2.0", PmCwd: "/srv/api"},
+	}}, processes)
+}
+
+func TestPm2Client_List_DaemonSpawned(t *testing.T) {
+	// Arrange: create a process list preceded by the output of a pm2 daemon
+	// that is started on a fresh VM
+	commands := make([]string, 0)
+	pm2Client := NewPm2Client(newPm2MockExecClient(&commands, map[string]string{
+		"jlist": "[PM2] Spawning PM2 daemon with pm2_home=/home/app/.pm2\n[PM2] PM2 Successfully daemonized\n[]\n",
+	}))
+
+	// Act: list the processes
+	processes, err := pm2Client.List()
+
+	// Assert: check if the empty process list is parsed
+	assert.NoError(t, err)
+	assert.Equal(t, []Process{}, processes)
+}
+
+func TestPm2Client_List_InvalidOutput(t *testing.T) {
+	// Arrange: create an output without a process list
+	commands := make([]string, 0)
+	pm2Client := NewPm2Client(newPm2MockExecClient(&commands, map[string]string{"jlist": "command not found"}))
+
+	// Act: list the processes
+	_, err := pm2Client.List()
+
+	// Assert: check if there was an error
+	assert.Error(t, err)
+}
+
+func TestPm2Client_StartAndSave(t *testing.T) {
+	commands := make([]string, 0)
+	pm2Client := NewPm2Client(newPm2MockExecClient(&commands, nil))
+
+	// Act: start a process and save the process list
+	startErr := pm2Client.Start("/srv/api/ecosystem.config.js", "api")
+	saveErr := pm2Client.Save()
+
+	// Assert: check if only the named app of the ecosystem file is started
+	assert.NoError(t, startErr)
+	assert.NoError(t, saveErr)
+	assert.Equal(t, []string{"pm2 start /srv/api/ecosystem.config.js --only api", "pm2 save"}, commands)
+
+	// Act/Assert: check if empty parameters fail
+	assert.Error(t, pm2Client.Start("", "api"))
+}
+
+func TestPm2Client_Stop(t *testing.T) {
+	commands := make([]string, 0)
+	pm2Client := NewPm2Client(newPm2MockExecClient(&commands, nil))
+
+	// Act: stop a process
+	err := pm2Client.Stop("api-blue")
+
+	// Assert: check if the process is stopped
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"pm2 stop api-blue"}, commands)
+}
+
+func TestPm2Client_WaitForOnline(t *testing.T) {
+	commands := make([]string, 0)
+	pm2Client := NewPm2Client(newPm2MockExecClient(&commands, map[string]string{
+		"jlist": `[{"name":"web","pm2_env":{"status":"errored"}},{"name":"api","pm2_env":{"status":"online","version":"1.2.0"}}]`,
+	}))
+	pm2Client.PollInterval = time.Millisecond
+
+	// Act/Assert: check if an online process with the expected version is returned
+	process, err := pm2Client.WaitForOnline("api", "1.2.0", time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "api", process.Name)
+
+	// Act/Assert: check if the version is ignored when it is empty
+	_, err = pm2Client.WaitForOnline("api", "", time.Second)
+	assert.NoError(t, err)
+
+	// Act/Assert: check if a process running another version times out
+	_, err = pm2Client.WaitForOnline("api", "1.3.0", 10*time.Millisecond)
+	assert.EqualError(t, err, "pm2 process api is not online (state: online with version 1.2.0 instead of 1.3.0)")
+
+	// Act/Assert: check if an errored process fails without waiting
+	commands = commands[:0]
+	_, err = pm2Client.WaitForOnline("web", "", time.Minute)
+	assert.EqualError(t, err, "pm2 process web is not online (state: errored)")
+	assert.Len(t, commands, 1)
+
+	// Act/Assert: check if a missing process times out
+	_, err = pm2Client.WaitForOnline("worker", "", 0)
+	assert.EqualError(t, err, "pm2 process worker is not online (state: not found)")
+}
+
+func TestWriteEcosystemFile(t *testing.T) {
+	ecosystemFile := path.Join(t.TempDir(), ".deploy-to-vm", "api.ecosystem.json")
+
+	// Act: write an ecosystem file
+	err := WriteEcosystemFile(ecosystemFile, EcosystemApp{Name: "api", Script: "server.js", Cwd: "/srv/api"})
+
+	// Assert: check if the app is written
+	assert.NoError(t, err)
+	data, readErr := os.ReadFile(ecosystemFile)
+	assert.NoError(t, readErr)
+	assert.JSONEq(t, `{"apps":[{"name":"api","script":"server.js","cwd":"/srv/api"}]}`, string(data))
+}
+
+func TestReadPackageVersion(t *testing.T) {
+	dir := t.TempDir()
+
+	// Act/Assert: check if a missing package.json has no version
+	assert.Equal(t, "", ReadPackageVersion(dir))
+
+	// Act/Assert: check if the version is read
+	os.WriteFile(path.Join(dir, "package.json"), []byte(`{"name":"api","version":"2.0.1"}`), 0644)
+	assert.Equal(t, "2.0.1", ReadPackageVersion(dir))
+}
@@ -1,17 +1,30 @@
 package router
 
 import (
+	"crypto/subtle"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
 	"strings"
+	"time"
 
+	"deploy-to-vm/internal/checksum"
 	"deploy-to-vm/internal/config"
+	"deploy-to-vm/internal/drift"
+	deploy_to_vm_exec "deploy-to-vm/internal/exec"
 	file_utils "deploy-to-vm/internal/file-utils"
 	deploy_to_vm_github "deploy-to-vm/internal/github"
-	"deploy-to-vm/internal/nginx"
+	"deploy-to-vm/internal/healthcheck"
+	"deploy-to-vm/internal/history"
+	"deploy-to-vm/internal/hooks"
+	"deploy-to-vm/internal/manifest"
 	"deploy-to-vm/internal/notification"
-	"deploy-to-vm/internal/pm2"
+	"deploy-to-vm/internal/signature"
+	"deploy-to-vm/internal/store"
+	"deploy-to-vm/internal/target"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/go-github/v71/github"
@@ -21,10 +34,57 @@ type RouterOptions struct {
 	AssetsDir          string
 	ConfigClient       config.ConfigClientInterface
 	GithubClient       deploy_to_vm_github.GithubClientInterface
-	NginxClient        nginx.NginxClientInterface
 	NotificationClient notification.NotificationClientInterface
-	Pm2Client          pm2.Pm2ClientInterface
 	SecretToken        string
+	// Targets are the deployment targets by their type
+	Targets *target.Registry
+	// HealthCheckClient runs the HTTP health checks of the targets
+	HealthCheckClient healthcheck.HealthCheckClientInterface
+	// HookClient runs the lifecycle hooks of the targets
+	HookClient hooks.HookClientInterface
+}
+
+// Removes a staging directory that was only partially prepared, so it does not
+// keep using disk space
+func removeStagingDir(stagingDir string) {
+	if removeErr := os.RemoveAll(stagingDir); removeErr != nil {
+		log.Printf("Failed to remove staging directory \"%s\": %v", stagingDir, removeErr)
+	}
+}
+
+// Checks if an API request carries the secret token as a bearer token. In
+// development mode every request is authorized.
+func isAuthorized(c *gin.Context, routerOptions RouterOptions) bool {
+	if routerOptions.ConfigClient.IsDevelopment() {
+		return true
+	}
+	if routerOptions.SecretToken == "" {
+		return false
+	}
+
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(routerOptions.SecretToken)) == 1
+}
+
+// Returns the error message of a failed deployment for the response
+func deployErrorMessage(runErr error) string {
+	var stepErr *target.StepError
+	if !errors.As(runErr, &stepErr) {
+		return runErr.Error()
+	}
+
+	switch stepErr.Phase {
+	case target.Phase_Prepare:
+		return fmt.Sprintf("Failed to prepare the %s target: %v", stepErr.Target, stepErr.Err)
+	case target.Phase_Activate:
+		return "Failed to move release assets to site directory"
+	case target.Phase_Reload:
+		return fmt.Sprintf("Failed to reload the %s target", stepErr.Target)
+	case target.Phase_Hook:
+		return fmt.Sprintf("A hook of the %s target failed: %v", stepErr.Target, stepErr.Err)
+	default:
+		return fmt.Sprintf("Health check of the %s target failed", stepErr.Target)
+	}
 }
 
 func SetupRouter(routerOptions RouterOptions) *gin.Engine {
@@ -64,20 +124,95 @@ func SetupRouter(routerOptions RouterOptions) *gin.Engine {
 				return
 			}
 
-			// Create release directory if it doesn't exist
-			releaseDir, createReleaseDirErr := file_utils.CreateReleaseDirIfIsNotExist(
+			// Get repository config
+			repositoryConfig := routerOptions.ConfigClient.GetRepository(*event.Repo.Name, *event.Repo.Owner.Login)
+			if repositoryConfig == nil {
+				log.Printf("Repository not found in config: %s/%s", *event.Repo.Owner.Login, *event.Repo.Name)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Repository not found in config"})
+				return
+			}
+
+			// Resolve the user the commands of the repository run as
+			runAs, runAsErr := deploy_to_vm_exec.LookupCredential(repositoryConfig.RunAs.User, repositoryConfig.RunAs.Group)
+			if runAsErr == nil {
+				runAsErr = runAs.Check()
+			}
+			if runAsErr != nil {
+				log.Printf("Invalid runAs config: \"%v\"", runAsErr)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Invalid runAs config: %v", runAsErr)})
+				return
+			}
+
+			if _, linkStrategyErr := target.GetLinkStrategy(repositoryConfig); linkStrategyErr != nil {
+				log.Printf("Invalid storage config: \"%v\"", linkStrategyErr)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Invalid storage config: %v", linkStrategyErr)})
+				return
+			}
+
+			// Check the site directory for changes made since the active release
+			driftPolicy, driftPolicyErr := drift.GetPolicy(repositoryConfig.Drift)
+			if driftPolicyErr != nil {
+				log.Printf("Invalid drift config: \"%v\"", driftPolicyErr)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Invalid drift config: %v", driftPolicyErr)})
+				return
+			}
+			var driftReport *drift.Report
+			if driftPolicy != drift.Policy_Allow {
+				report, driftErr := drift.Check(routerOptions.AssetsDir, repositoryConfig)
+				if driftErr != nil {
+					log.Printf("Failed to check the site directory for drift: \"%v\"", driftErr)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to check the site directory for drift: %v", driftErr)})
+					return
+				}
+				if report != nil && report.IsDrifted() {
+					log.Printf("Site directory drifted from the active release %s:\n%s", report.Tag, report.Details())
+					if driftPolicy == drift.Policy_Block {
+						c.JSON(http.StatusConflict, gin.H{"error": "Site directory drifted from the active release, refusing to deploy", "drift": report})
+						return
+					}
+					driftReport = report
+				}
+			}
+
+			// Check that the assets fit the download limit and the free disk space
+			totalAssetSize := deploy_to_vm_github.TotalAssetSize(event.Release.Assets)
+			maxDownloadSize := repositoryConfig.Limits.MaxDownloadSize
+			if maxDownloadSize > 0 && totalAssetSize > maxDownloadSize {
+				log.Printf("Release assets exceed the download size limit: %d bytes > %d bytes", totalAssetSize, maxDownloadSize)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Release assets exceed the download size limit: %d bytes > %d bytes", totalAssetSize, maxDownloadSize)})
+				return
+			}
+			availableDiskSpace, diskSpaceErr := file_utils.GetAvailableDiskSpace(routerOptions.AssetsDir)
+			if diskSpaceErr != nil {
+				log.Printf("Failed to check free disk space: \"%v\"", diskSpaceErr)
+			} else if uint64(totalAssetSize) > availableDiskSpace {
+				log.Printf("Not enough disk space for release assets: %d bytes > %d bytes", totalAssetSize, availableDiskSpace)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Not enough disk space for release assets: %d bytes > %d bytes", totalAssetSize, availableDiskSpace)})
+				return
+			}
+
+			// Prepare the release in a staging directory, which is only moved into
+			// place once every step succeeded
+			stagingDir, createStagingDirErr := file_utils.CreateStagingDir(
 				routerOptions.AssetsDir,
 				*event.Repo.Owner.Login,
 				*event.Repo.Name,
 				*event.Release.TagName,
 			)
-			if createReleaseDirErr != nil {
+			if createStagingDirErr != nil {
+				log.Printf("Failed to create staging directory: \"%v\"", createStagingDirErr)
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create release directory"})
 				return
 			}
+			promoted := false
+			defer func() {
+				if !promoted {
+					removeStagingDir(stagingDir)
+				}
+			}()
 
 			// Download assets
-			code, downloadErr := routerOptions.GithubClient.DownloadAssets(event.Release.Assets, releaseDir)
+			code, downloadErr := routerOptions.GithubClient.DownloadAssets(event.Release.Assets, stagingDir, maxDownloadSize)
 			if downloadErr != nil {
 				// TODO(cemreyavuz): return a different error code depending on the error
 				switch code {
@@ -85,77 +220,371 @@ func SetupRouter(routerOptions RouterOptions) *gin.Engine {
 					log.Printf("No assets found for release: \"%s\", will skip the request.", *event.Release.TagName)
 					c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("No assets found for release \"%s\", will skip the request.", *event.Release.TagName)})
 					return
+				case deploy_to_vm_github.DownloadAsset_SizeLimitExceeded:
+					log.Printf("Release assets exceed the download size limit: \"%v\"", downloadErr)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Release assets exceed the download size limit: %v", downloadErr)})
 				default:
 					log.Printf("Failed to download assets: \"%v\"", downloadErr)
 					c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to download assets: %v", downloadErr)})
 				}
-				// TODO(cemreyavuz): remove the release directory if download fails
 				return
 			}
 
-			// Untar files in the release directory
-			files, untarErr := file_utils.UntarGzFilesInDir(releaseDir)
+			// Record the downloaded assets before the verification removes the
+			// checksum and signature files and archives are extracted
+			manifestAssets, hashAssetsErr := manifest.HashAssets(stagingDir)
+			if hashAssetsErr != nil {
+				log.Printf("Failed to hash release assets: \"%v\"", hashAssetsErr)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to hash release assets: %v", hashAssetsErr)})
+				return
+			}
+
+			// Verify signatures of downloaded assets
+			_, verifySignaturesErr := signature.VerifyReleaseDir(stagingDir, signature.Options{
+				Mode:        repositoryConfig.Verification.Signature,
+				KeyringFile: repositoryConfig.Verification.KeyringFile,
+				PublicKeys:  repositoryConfig.Verification.PublicKeys,
+			})
+			if verifySignaturesErr != nil {
+				log.Printf("Failed to verify signatures of release assets: \"%v\"", verifySignaturesErr)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to verify signatures of release assets: %v", verifySignaturesErr)})
+				return
+			}
+
+			// Verify checksums of downloaded assets
+			assetDigests, parseDigestsErr := deploy_to_vm_github.ParseReleaseAssetDigests(payload)
+			if parseDigestsErr != nil {
+				log.Printf("Failed to read asset digests from payload: \"%v\"", parseDigestsErr)
+			}
+			_, verifyErr := checksum.VerifyReleaseDir(stagingDir, assetDigests, repositoryConfig.Verification.Checksum)
+			if verifyErr != nil {
+				log.Printf("Failed to verify checksums of release assets: \"%v\"", verifyErr)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to verify checksums of release assets: %v", verifyErr)})
+				return
+			}
+
+			// Untar files in the staging directory
+			umask, umaskErr := repositoryConfig.Extraction.GetUmask()
+			if umaskErr != nil {
+				log.Printf("Invalid extraction config: \"%v\"", umaskErr)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Invalid extraction config: %v", umaskErr)})
+				return
+			}
+			// Keep the assets the target needs as they are
+			skipArchives := append([]string{}, repositoryConfig.Extraction.SkipArchives...)
+			for _, targetConfig := range repositoryConfig.GetTargets() {
+				if deploymentTarget, targetErr := routerOptions.Targets.Get(targetConfig.Type); targetErr == nil {
+					if archiveSkipper, ok := deploymentTarget.(target.ArchiveSkipper); ok {
+						skipArchives = append(skipArchives, archiveSkipper.SkipArchives(&targetConfig)...)
+					}
+				}
+			}
+			// The extracted files belong to the user the commands run as, unless
+			// the extraction sets an owner
+			owner, group := repositoryConfig.Extraction.Owner, repositoryConfig.Extraction.Group
+			if runAs != nil && owner == "" && group == "" {
+				owner, group = strconv.FormatUint(uint64(runAs.Uid), 10), strconv.FormatUint(uint64(runAs.Gid), 10)
+			}
+			extractResult, untarErr := file_utils.ExtractArchivesInDir(stagingDir, file_utils.ExtractOptions{
+				MaxExtractedSize: repositoryConfig.Limits.MaxExtractedSize,
+				MaxFileCount:     repositoryConfig.Limits.MaxFileCount,
+				Umask:            umask,
+				Owner:            owner,
+				Group:            group,
+				SkipArchives:     skipArchives,
+				StripComponents:  repositoryConfig.Extraction.StripComponents,
+			})
+			if errors.Is(untarErr, file_utils.ErrLimitExceeded) {
+				log.Printf("Release archives exceed the extraction limits: \"%v\"", untarErr)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Release archives exceed the extraction limits: %v", untarErr)})
+				return
+			}
 			if untarErr != nil {
 				log.Printf("Failed to untar files in release directory: \"%v\"", untarErr)
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to untar files in release directory"})
 				return
 			}
 
-			// Link release assets to site directory
-			repositoryConfig := routerOptions.ConfigClient.GetRepository(*event.Repo.Name, *event.Repo.Owner.Login)
-			if repositoryConfig == nil {
-				log.Printf("Repository not found in config: %s/%s", *event.Repo.Owner.Login, *event.Repo.Name)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Repository not found in config"})
-				return
+			// Deduplicate release files against the previous releases
+			releaseStore := store.NewStore(routerOptions.AssetsDir)
+			if repositoryConfig.Storage.Deduplicate {
+				if _, dedupErr := releaseStore.DeduplicateDir(stagingDir); dedupErr != nil {
+					log.Printf("Failed to deduplicate release files: \"%v\"", dedupErr)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to deduplicate release files: %v", dedupErr)})
+					return
+				}
 			}
 
-			siteDir := repositoryConfig.TargetDir
-			if siteDir == "" {
-				log.Printf("Site directory not found for repository: %s/%s", *event.Repo.Owner.Login, *event.Repo.Name)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Site directory not found for repository"})
+			// Write the manifest of the release
+			manifestFiles, hashFilesErr := manifest.HashFiles(stagingDir)
+			if hashFilesErr != nil {
+				log.Printf("Failed to hash release files: \"%v\"", hashFilesErr)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to hash release files: %v", hashFilesErr)})
+				return
+			}
+			manifestTargets := make([]manifest.Target, 0)
+			for _, targetConfig := range repositoryConfig.GetTargets() {
+				manifestTargets = append(manifestTargets, manifest.Target{Name: targetConfig.Name, RootDir: targetConfig.RootDir, Include: targetConfig.Include})
+			}
+			tagCommit, tagCommitErr := routerOptions.GithubClient.GetTagCommit(*event.Repo.Owner.Login, *event.Repo.Name, *event.Release.TagName)
+			if tagCommitErr != nil {
+				log.Printf("Failed to resolve the commit of the release tag: \"%v\"", tagCommitErr)
+			}
+			releaseManifest := &manifest.Manifest{
+				Version: manifest.Version,
+				Source: manifest.Source{
+					Type:       "github",
+					Event:      github.WebHookType(c.Request),
+					Action:     *event.Action,
+					DeliveryId: github.DeliveryID(c.Request),
+					Owner:      *event.Repo.Owner.Login,
+					Repo:       *event.Repo.Name,
+					ReleaseUrl: event.Release.GetHTMLURL(),
+				},
+				Tag:             *event.Release.TagName,
+				Commit:          tagCommit,
+				TargetCommitish: event.Release.GetTargetCommitish(),
+				RootDir:         repositoryConfig.Extraction.RootDir,
+				Targets:         manifestTargets,
+				Assets:          manifestAssets,
+				Files:           manifestFiles,
+				CreatedAt:       time.Now().UTC(),
+			}
+			if event.Release.PublishedAt != nil {
+				publishedAt := event.Release.PublishedAt.Time
+				releaseManifest.PublishedAt = &publishedAt
+			}
+			if writeManifestErr := manifest.Write(stagingDir, releaseManifest); writeManifestErr != nil {
+				log.Printf("Failed to write the release manifest: \"%v\"", writeManifestErr)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to write the release manifest: %v", writeManifestErr)})
 				return
 			}
 
-			moveErr := file_utils.LinkReleaseAssetsToSiteDir(releaseDir, siteDir)
-			if moveErr != nil {
-				log.Printf("Failed to move release assets to site directory: \"%v\"", moveErr)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move release assets to site directory"})
+			// Move the prepared release into place
+			releaseDir := file_utils.ReleaseDirPath(
+				routerOptions.AssetsDir,
+				*event.Repo.Owner.Login,
+				*event.Repo.Name,
+				*event.Release.TagName,
+			)
+			if promoteErr := file_utils.PromoteStagingDir(stagingDir, releaseDir); promoteErr != nil {
+				log.Printf("Failed to move the release into place: \"%v\"", promoteErr)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create release directory"})
 				return
 			}
+			promoted = true
 
-			// Reload the target service (nginx or pm2)
-			reloadFn := func() error {
-				return fmt.Errorf("reload function is not defined for targetType: %s", repositoryConfig.TargetType)
+			// Plan the deployment to every target of the repository, together with
+			// the deployment of the previous release to roll back to
+			previousTag, previousErr := file_utils.GetActiveRelease(routerOptions.AssetsDir, *event.Repo.Owner.Login, *event.Repo.Name)
+			if previousErr != nil {
+				log.Printf("Failed to read the active release, rollbacks are disabled: \"%v\"", previousErr)
+			}
+			if previousTag == *event.Release.TagName {
+				// The release directory of the tag was replaced, there is nothing to roll back to
+				previousTag = ""
 			}
+			previousReleaseDir := file_utils.ReleaseDirPath(routerOptions.AssetsDir, *event.Repo.Owner.Login, *event.Repo.Name, previousTag)
+
+			// Record the output of the commands of the deployment for its history
+			deploymentLog := history.NewLog(*event.Repo.Owner.Login, *event.Repo.Name, *event.Release.TagName)
+			startedAt := time.Now().UTC()
+
+			targetConfigs := repositoryConfig.GetTargets()
+			steps := make([]*target.Step, 0, len(targetConfigs))
+			for i := range targetConfigs {
+				targetConfig := &targetConfigs[i]
+				if targetConfig.Dir == "" {
+					log.Printf("Site directory not found for repository: %s/%s", *event.Repo.Owner.Login, *event.Repo.Name)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Site directory not found for repository"})
+					return
+				}
+
+				rootDir, rootDirErr := file_utils.ResolveReleaseRootDir(releaseDir, targetConfig.RootDir)
+				if rootDirErr != nil {
+					log.Printf("Failed to resolve the root directory of the release: \"%v\"", rootDirErr)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to resolve the root directory of the release: %v", rootDirErr)})
+					return
+				}
 
-			if repositoryConfig.TargetType == "nginx" {
-				reloadFn = routerOptions.NginxClient.Reload
-			} else if repositoryConfig.TargetType == "pm2" {
-				reloadFn = func() error {
-					return routerOptions.Pm2Client.Reload(repositoryConfig.TargetProcessName)
+				deploymentTarget, targetErr := routerOptions.Targets.Get(targetConfig.Type)
+				if targetErr != nil {
+					log.Printf("Failed to find the deployment target: \"%v\"", targetErr)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to find the deployment target: %v", targetErr)})
+					return
+				}
+
+				step := &target.Step{
+					Target:      deploymentTarget,
+					HealthCheck: routerOptions.HealthCheckClient,
+					Hooks:       routerOptions.HookClient,
+					Deployment: &target.Deployment{
+						Owner:      *event.Repo.Owner.Login,
+						Repo:       *event.Repo.Name,
+						Tag:        *event.Release.TagName,
+						ReleaseDir: releaseDir,
+						RootDir:    rootDir,
+						SiteDir:    targetConfig.Dir,
+						Config:     repositoryConfig,
+						Target:     targetConfig,
+						Log:        deploymentLog,
+						RunAs:      runAs,
+					},
+				}
+				if previousTag != "" {
+					if previousRootDir, previousRootDirErr := file_utils.ResolveReleaseRootDir(previousReleaseDir, targetConfig.RootDir); previousRootDirErr == nil {
+						previous := *step.Deployment
+						previous.Tag = previousTag
+						previous.ReleaseDir = previousReleaseDir
+						previous.RootDir = previousRootDir
+						step.Previous = &previous
+					}
 				}
+				steps = append(steps, step)
 			}
 
-			reloadErr := reloadFn()
-			if reloadErr != nil {
-				log.Printf("Failed to reload nginx unit: \"%v\"", reloadErr)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reload nginx unit"})
+			// Activate, reload and health check the targets in order
+			deploymentLog.Printf("Deploying release %s", *event.Release.TagName)
+			activations, runErr := target.Run(steps)
+			record := &history.Record{
+				Owner:       *event.Repo.Owner.Login,
+				Repo:        *event.Repo.Name,
+				Tag:         *event.Release.TagName,
+				PreviousTag: previousTag,
+				Status:      history.Status_Succeeded,
+				StartedAt:   startedAt,
+			}
+			if runErr != nil {
+				deploymentLog.Printf("Failed to deploy the release: %v", runErr)
+				record.Status = history.Status_Failed
+				record.Error = runErr.Error()
+			} else {
+				deploymentLog.Printf("Deployed release %s", *event.Release.TagName)
+			}
+			record.FinishedAt = time.Now().UTC()
+			record.Log = deploymentLog.Lines()
+			if _, historyErr := history.Write(routerOptions.AssetsDir, record); historyErr != nil {
+				log.Printf("Failed to record the deployment in the history: \"%v\"", historyErr)
+			}
+			if runErr != nil {
+				log.Printf("Failed to deploy the release: \"%v\"", runErr)
+				response := gin.H{"error": deployErrorMessage(runErr)}
+				var stepErr *target.StepError
+				if errors.As(runErr, &stepErr) && len(stepErr.RolledBack) > 0 {
+					response["rolledBack"] = stepErr.RolledBack
+				}
+				c.JSON(http.StatusInternalServerError, response)
 				return
 			}
 
+			// Record the release that is now linked to the site directories
+			if activeErr := file_utils.SetActiveRelease(routerOptions.AssetsDir, *event.Repo.Owner.Login, *event.Repo.Name, *event.Release.TagName); activeErr != nil {
+				log.Printf("Failed to record the active release: \"%v\"", activeErr)
+			}
+
+			// Prune old releases and the blobs they no longer reference
+			if repositoryConfig.Storage.KeepReleases > 0 {
+				prunedReleaseDirs, pruneErr := file_utils.PruneReleaseDirs(
+					routerOptions.AssetsDir,
+					*event.Repo.Owner.Login,
+					*event.Repo.Name,
+					repositoryConfig.Storage.KeepReleases,
+					*event.Release.TagName,
+				)
+				if pruneErr != nil {
+					log.Printf("Failed to prune old releases: \"%v\"", pruneErr)
+				}
+				if len(prunedReleaseDirs) > 0 {
+					if _, gcErr := releaseStore.GC(); gcErr != nil {
+						log.Printf("Failed to garbage collect the release store: \"%v\"", gcErr)
+					}
+				}
+			}
+
 			// Send notification
-			notificationMessage := fmt.Sprintf("New release deployed for: `repo:%s` `tag:%s`\\n\\nFiles:\\n```\\n- %s\\n```", *event.Repo.Name, *event.Release.TagName, strings.Join(files, "\\n- "))
+			notificationMessage := fmt.Sprintf("New release deployed for: `repo:%s` `tag:%s`\\n\\nFiles:\\n```\\n- %s\\n```", *event.Repo.Name, *event.Release.TagName, strings.Join(extractResult.Files, "\\n- "))
+			if len(extractResult.Rejected) > 0 {
+				rejectedEntries := make([]string, 0, len(extractResult.Rejected))
+				for _, rejected := range extractResult.Rejected {
+					rejectedEntries = append(rejectedEntries, fmt.Sprintf("%s (%s)", rejected.Name, rejected.Reason))
+				}
+				notificationMessage += fmt.Sprintf("\\n\\nRejected archive entries:\\n```\\n- %s\\n```", strings.Join(rejectedEntries, "\\n- "))
+			}
+			if driftReport != nil {
+				notificationMessage += fmt.Sprintf("\\n\\nOverwritten drift from release `%s`:\\n```\\n%s\\n```", driftReport.Tag, strings.ReplaceAll(driftReport.Details(), "\n", "\\n"))
+			}
 			notificationErr := routerOptions.NotificationClient.Notify(notificationMessage)
 			if notificationErr != nil {
 				log.Printf("Failed to send notification: \"%v\"", notificationErr)
 			}
 
-			c.JSON(http.StatusOK, gin.H{"action": *event.Action})
+			response := gin.H{"action": *event.Action, "linkStrategy": activations[0].LinkStrategy}
+			if len(extractResult.Rejected) > 0 {
+				response["rejectedEntries"] = extractResult.Rejected
+			}
+			if len(activations) > 1 {
+				response["targets"] = activations
+			} else if activations[0].Sync != nil {
+				response["sync"] = activations[0].Sync
+			}
+			if driftReport != nil {
+				response["drift"] = driftReport
+			}
+			c.JSON(http.StatusOK, response)
 		default:
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported event type"})
 		}
 	})
 
+	r.GET("/releases/:owner/:repo/:tag/verify", func(c *gin.Context) {
+		if !isAuthorized(c, routerOptions) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		owner, repo, tag := c.Param("owner"), c.Param("repo"), c.Param("tag")
+		repositoryConfig := routerOptions.ConfigClient.GetRepository(repo, owner)
+		if repositoryConfig == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Repository not found in config"})
+			return
+		}
+
+		releaseDir := file_utils.ReleaseDirPath(routerOptions.AssetsDir, owner, repo, tag)
+		releaseManifest, readErr := manifest.Read(releaseDir)
+		if errors.Is(readErr, os.ErrNotExist) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Release manifest not found"})
+			return
+		}
+		if readErr != nil {
+			log.Printf("Failed to read the release manifest: \"%v\"", readErr)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to read the release manifest: %v", readErr)})
+			return
+		}
+
+		releaseResult, verifyReleaseErr := releaseManifest.VerifyRelease(releaseDir)
+		if verifyReleaseErr != nil {
+			log.Printf("Failed to verify the release: \"%v\"", verifyReleaseErr)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to verify the release: %v", verifyReleaseErr)})
+			return
+		}
+		response := gin.H{"tag": tag, "release": releaseResult, "clean": releaseResult.IsClean()}
+
+		sites, verifySitesErr := drift.VerifySites(releaseManifest, repositoryConfig)
+		if verifySitesErr != nil {
+			log.Printf("Failed to verify the site directories: \"%v\"", verifySitesErr)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to verify the site directories: %v", verifySitesErr)})
+			return
+		}
+		clean := releaseResult.IsClean()
+		for _, site := range sites {
+			clean = clean && site.Site.IsClean()
+		}
+		response["sites"] = sites
+		response["clean"] = clean
+
+		c.JSON(http.StatusOK, response)
+	})
+
 	// Ping test
 	r.GET("/ping", func(c *gin.Context) {
 		c.String(http.StatusOK, "pong")
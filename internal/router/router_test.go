@@ -1,20 +1,35 @@
 package router
 
 import (
+	"archive/tar"
 	"bytes"
+	"compress/gzip"
 	"crypto/hmac"
 	"crypto/sha256"
 	"deploy-to-vm/internal/config"
+	"deploy-to-vm/internal/docker"
+	deploy_to_vm_exec "deploy-to-vm/internal/exec"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"os/user"
 	"path"
 	"testing"
+	"time"
 
+	file_utils "deploy-to-vm/internal/file-utils"
 	deploy_to_vm_github "deploy-to-vm/internal/github"
+	"deploy-to-vm/internal/healthcheck"
+	"deploy-to-vm/internal/history"
+	"deploy-to-vm/internal/hooks"
+	"deploy-to-vm/internal/manifest"
+	"deploy-to-vm/internal/nginx"
+	"deploy-to-vm/internal/pm2"
+	"deploy-to-vm/internal/systemd"
+	"deploy-to-vm/internal/target"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/go-github/v71/github"
@@ -22,28 +37,38 @@ import (
 )
 
 type MockGithubClient struct {
-	DownloadAssetFunc  func(url string, outputPath string) error
-	DownloadAssetsFunc func(assets []*github.ReleaseAsset, releaseDir string) (deploy_to_vm_github.DownloadAssetStatusCode, error)
+	DownloadAssetFunc  func(url string, outputPath string, maxSize int64) error
+	DownloadAssetsFunc func(assets []*github.ReleaseAsset, releaseDir string, maxTotalSize int64) (deploy_to_vm_github.DownloadAssetStatusCode, error)
+	GetTagCommitFunc   func(owner string, repo string, tag string) (string, error)
 }
 
-func (m *MockGithubClient) DownloadAsset(url string, outputPath string) error {
+func (m *MockGithubClient) DownloadAsset(url string, outputPath string, maxSize int64) error {
 	if m.DownloadAssetFunc != nil {
-		return m.DownloadAssetFunc(url, outputPath)
+		return m.DownloadAssetFunc(url, outputPath, maxSize)
 	}
 
 	return nil
 }
 
-func (m *MockGithubClient) DownloadAssets(assets []*github.ReleaseAsset, releaseDir string) (deploy_to_vm_github.DownloadAssetStatusCode, error) {
+func (m *MockGithubClient) DownloadAssets(assets []*github.ReleaseAsset, releaseDir string, maxTotalSize int64) (deploy_to_vm_github.DownloadAssetStatusCode, error) {
 	if m.DownloadAssetsFunc != nil {
-		return m.DownloadAssetsFunc(assets, releaseDir)
+		return m.DownloadAssetsFunc(assets, releaseDir, maxTotalSize)
 	}
 
 	return deploy_to_vm_github.DownloadAsset_Success, nil
 }
 
+func (m *MockGithubClient) GetTagCommit(owner string, repo string, tag string) (string, error) {
+	if m.GetTagCommitFunc != nil {
+		return m.GetTagCommitFunc(owner, repo, tag)
+	}
+
+	return "", nil
+}
+
 type MockNginxClient struct {
 	ReloadFunc func() error
+	TestFunc   func() error
 }
 
 func (m *MockNginxClient) Reload() error {
@@ -54,8 +79,22 @@ func (m *MockNginxClient) Reload() error {
 	return nil
 }
 
+func (m *MockNginxClient) Test() error {
+	if m.TestFunc != nil {
+		return m.TestFunc()
+	}
+
+	return nil
+}
+
+func (m *MockNginxClient) WithLogSink(sink deploy_to_vm_exec.LogSink) nginx.NginxClientInterface {
+	return m
+}
+
 type MockPm2Client struct {
 	ReloadFunc func() error
+	// Processes are the running processes
+	Processes []pm2.Process
 }
 
 func (m *MockPm2Client) Reload(targetProcessName string) error {
@@ -66,6 +105,74 @@ func (m *MockPm2Client) Reload(targetProcessName string) error {
 	return nil
 }
 
+func (m *MockPm2Client) List() ([]pm2.Process, error) {
+	return m.Processes, nil
+}
+
+func (m *MockPm2Client) Start(ecosystemFile string, targetProcessName string) error {
+	return nil
+}
+
+func (m *MockPm2Client) Stop(targetProcessName string) error {
+	return nil
+}
+
+func (m *MockPm2Client) Save() error {
+	return nil
+}
+
+func (m *MockPm2Client) WaitForOnline(targetProcessName string, version string, timeout time.Duration) (*pm2.Process, error) {
+	return &pm2.Process{Name: targetProcessName}, nil
+}
+
+func (m *MockPm2Client) WithLogSink(sink deploy_to_vm_exec.LogSink) pm2.Pm2ClientInterface {
+	return m
+}
+
+func (m *MockPm2Client) WithCredential(credential *deploy_to_vm_exec.Credential) pm2.Pm2ClientInterface {
+	return m
+}
+
+type MockSystemdClient struct {
+	ReloadFunc func(unit string, action string, timeout time.Duration) error
+}
+
+func (m *MockSystemdClient) Reload(unit string, action string, timeout time.Duration) error {
+	if m.ReloadFunc != nil {
+		return m.ReloadFunc(unit, action, timeout)
+	}
+
+	return nil
+}
+
+func (m *MockSystemdClient) Stop(unit string) error {
+	return nil
+}
+
+func (m *MockSystemdClient) WithLogSink(sink deploy_to_vm_exec.LogSink) systemd.SystemdClientInterface {
+	return m
+}
+
+type MockDockerClient struct {
+	ComposeUpFunc func(options docker.ComposeUpOptions) error
+}
+
+func (m *MockDockerClient) ComposeUp(options docker.ComposeUpOptions) error {
+	if m.ComposeUpFunc != nil {
+		return m.ComposeUpFunc(options)
+	}
+
+	return nil
+}
+
+func (m *MockDockerClient) WithLogSink(sink deploy_to_vm_exec.LogSink) docker.DockerClientInterface {
+	return m
+}
+
+func (m *MockDockerClient) WithCredential(credential *deploy_to_vm_exec.Credential) docker.DockerClientInterface {
+	return m
+}
+
 type MockNotificationClient struct {
 	NotifyFunc func(message string) error
 }
@@ -183,7 +290,7 @@ func TestDeployWithGH_WithSignature_Success(t *testing.T) {
 		AssetsDir:          tempDir,
 		ConfigClient:       configClient,
 		GithubClient:       mockGithubClient,
-		NginxClient:        mockNginxClient,
+		Targets:            target.NewDefaultRegistry(target.Clients{Nginx: mockNginxClient}),
 		NotificationClient: mockNotificationClient,
 		SecretToken:        "test",
 	})
@@ -202,7 +309,7 @@ func TestDeployWithGH_WithSignature_Success(t *testing.T) {
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code)
-	assert.Contains(t, w.Body.String(), `{"action":"released"}`)
+	assert.Contains(t, w.Body.String(), `{"action":"released","linkStrategy":"hardlink"}`)
 }
 
 func TestDeployWithGH_WithoutSignature_Success(t *testing.T) {
@@ -228,7 +335,7 @@ func TestDeployWithGH_WithoutSignature_Success(t *testing.T) {
 		AssetsDir:          tempDir,
 		ConfigClient:       configClient,
 		GithubClient:       mockGithubClient,
-		NginxClient:        mockNginxClient,
+		Targets:            target.NewDefaultRegistry(target.Clients{Nginx: mockNginxClient}),
 		NotificationClient: mockNotificationClient,
 	})
 
@@ -240,13 +347,13 @@ func TestDeployWithGH_WithoutSignature_Success(t *testing.T) {
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code)
-	assert.Contains(t, w.Body.String(), `{"action":"released"}`)
+	assert.Contains(t, w.Body.String(), `{"action":"released","linkStrategy":"hardlink"}`)
 }
 
 func TestDeployWithGH_Pm2Process_Success(t *testing.T) {
 	tempDir := t.TempDir()
 	mockGithubClient := &MockGithubClient{}
-	mockPm2Client := &MockPm2Client{}
+	mockPm2Client := &MockPm2Client{Processes: []pm2.Process{{Name: "deploy-to-vm"}}}
 	mockNotificationClient := &MockNotificationClient{}
 
 	configClient := &config.ConfigClient{}
@@ -267,7 +374,7 @@ func TestDeployWithGH_Pm2Process_Success(t *testing.T) {
 		AssetsDir:          tempDir,
 		ConfigClient:       configClient,
 		GithubClient:       mockGithubClient,
-		Pm2Client:          mockPm2Client,
+		Targets:            target.NewDefaultRegistry(target.Clients{Pm2: mockPm2Client}),
 		NotificationClient: mockNotificationClient,
 	})
 
@@ -279,14 +386,17 @@ func TestDeployWithGH_Pm2Process_Success(t *testing.T) {
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code)
-	assert.Contains(t, w.Body.String(), `{"action":"released"}`)
+	assert.Contains(t, w.Body.String(), `{"action":"released","linkStrategy":"hardlink"}`)
 }
 
-func TestDeployWithGH_NoAssetsFound(t *testing.T) {
+func TestDeployWithGH_SystemdUnit_Success(t *testing.T) {
 	tempDir := t.TempDir()
-	mockGithubClient := &MockGithubClient{
-		DownloadAssetsFunc: func(assets []*github.ReleaseAsset, releaseDir string) (deploy_to_vm_github.DownloadAssetStatusCode, error) {
-			return deploy_to_vm_github.DownloadAsset_NoAssetsFound, errors.New("mock error")
+	var reloadedUnit, reloadedAction string
+	var reloadedTimeout time.Duration
+	mockSystemdClient := &MockSystemdClient{
+		ReloadFunc: func(unit string, action string, timeout time.Duration) error {
+			reloadedUnit, reloadedAction, reloadedTimeout = unit, action, timeout
+			return nil
 		},
 	}
 
@@ -298,40 +408,67 @@ func TestDeployWithGH_NoAssetsFound(t *testing.T) {
 				Owner:      "cemreyavuz",
 				SourceType: "github",
 				TargetDir:  t.TempDir(),
-				TargetType: "nginx",
+				TargetType: "systemd",
+				Systemd: config.DeployToVmConfigSystemd{
+					Unit:    "deploy-to-vm.service",
+					Action:  "reload",
+					Timeout: "10s",
+				},
 			},
 		},
 	}
 
 	router := SetupRouter(RouterOptions{
-		AssetsDir:    tempDir,
-		ConfigClient: configClient,
-		GithubClient: mockGithubClient,
+		AssetsDir:          tempDir,
+		ConfigClient:       configClient,
+		GithubClient:       &MockGithubClient{},
+		NotificationClient: &MockNotificationClient{},
+		Targets:            target.NewDefaultRegistry(target.Clients{Systemd: mockSystemdClient}),
 	})
 
 	w := httptest.NewRecorder()
-	payload := `{"action":"released","release":{"assets":[],"tag_name":"dev.0"},"repository":{"id":973821242,"name":"deploy-to-vm","owner":{"login":"cemreyavuz"}}}`
+	payload := `{"action":"released","release":{"assets":[{"url":"https://example.com/asset","name":"example-asset"}],"tag_name":"dev.0"},"repository":{"id":973821242,"name":"deploy-to-vm","owner":{"login":"cemreyavuz"}}}`
 	req, _ := http.NewRequest("POST", "/deploy-with-gh", bytes.NewBuffer(([]byte(payload))))
 	req.Header.Set("X-GitHub-Event", "release")
 	req.Header.Set("Content-Type", "application/json")
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code)
-	assert.Contains(t, w.Body.String(), `{"message":"No assets found for release \"dev.0\", will skip the request."}`)
+	assert.Equal(t, "deploy-to-vm.service", reloadedUnit)
+	assert.Equal(t, "reload", reloadedAction)
+	assert.Equal(t, 10*time.Second, reloadedTimeout)
 }
 
-func TestDeployWithGH_DownloadAssets_Error(t *testing.T) {
+func TestDeployWithGH_HealthCheckFailed(t *testing.T) {
 	tempDir := t.TempDir()
-	mockGithubClient := &MockGithubClient{
-		DownloadAssetsFunc: func(assets []*github.ReleaseAsset, releaseDir string) (deploy_to_vm_github.DownloadAssetStatusCode, error) {
-			return deploy_to_vm_github.DownloadAsset_UnknownError, errors.New("Failed to download assets")
+	healthServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer healthServer.Close()
+
+	configClient := &config.ConfigClient{}
+	configClient.Config = &config.DeployToVmConfig{
+		Repositories: []config.DeployToVmConfigRepository{
+			{
+				Name:       "deploy-to-vm",
+				Owner:      "cemreyavuz",
+				SourceType: "github",
+				TargetDir:  t.TempDir(),
+				TargetType: "nginx",
+				HealthCheck: config.DeployToVmConfigHealthCheck{
+					URL: healthServer.URL + "/health",
+				},
+			},
 		},
 	}
 
 	router := SetupRouter(RouterOptions{
-		AssetsDir:    tempDir,
-		ConfigClient: &config.ConfigClient{},
-		GithubClient: mockGithubClient,
+		AssetsDir:          tempDir,
+		ConfigClient:       configClient,
+		GithubClient:       &MockGithubClient{},
+		NotificationClient: &MockNotificationClient{},
+		Targets:            target.NewDefaultRegistry(target.Clients{Nginx: &MockNginxClient{}}),
+		HealthCheckClient:  healthcheck.NewHealthCheckClient(nil),
 	})
 
 	w := httptest.NewRecorder()
@@ -341,17 +478,21 @@ func TestDeployWithGH_DownloadAssets_Error(t *testing.T) {
 	req.Header.Set("Content-Type", "application/json")
 	router.ServeHTTP(w, req)
 
+	// Assert: check if the failed check fails the deployment
 	assert.Equal(t, http.StatusInternalServerError, w.Code)
-	assert.Contains(t, w.Body.String(), "Failed to download assets")
+	assert.Contains(t, w.Body.String(), "Health check of the nginx target failed")
+	activeTag, _ := file_utils.GetActiveRelease(tempDir, "cemreyavuz", "deploy-to-vm")
+	assert.Equal(t, "", activeTag, "Expected the release not to be recorded as active")
 }
 
-func TestDeployWithGH_Untar_Error(t *testing.T) {
+func TestDeployWithGH_PreActivateHookFailed(t *testing.T) {
 	tempDir := t.TempDir()
-	mockGithubClient := &MockGithubClient{
-		DownloadAssetsFunc: func(assets []*github.ReleaseAsset, releaseDir string) (deploy_to_vm_github.DownloadAssetStatusCode, error) {
-			corruptedTarFilePath := path.Join(releaseDir, "corrupted.tar.gz")
-			os.WriteFile(corruptedTarFilePath, []byte("dummy content"), 0644)
-			return deploy_to_vm_github.DownloadAsset_Success, nil
+	siteDir := t.TempDir()
+	reloaded := false
+	mockNginxClient := &MockNginxClient{
+		ReloadFunc: func() error {
+			reloaded = true
+			return nil
 		},
 	}
 
@@ -362,16 +503,22 @@ func TestDeployWithGH_Untar_Error(t *testing.T) {
 				Name:       "deploy-to-vm",
 				Owner:      "cemreyavuz",
 				SourceType: "github",
-				TargetDir:  t.TempDir(),
+				TargetDir:  siteDir,
 				TargetType: "nginx",
+				Hooks: config.DeployToVmConfigHooks{
+					PreActivate: []config.DeployToVmConfigHook{{Command: "false"}},
+				},
 			},
 		},
 	}
 
 	router := SetupRouter(RouterOptions{
-		AssetsDir:    tempDir,
-		ConfigClient: configClient,
-		GithubClient: mockGithubClient,
+		AssetsDir:          tempDir,
+		ConfigClient:       configClient,
+		GithubClient:       &MockGithubClient{},
+		NotificationClient: &MockNotificationClient{},
+		Targets:            target.NewDefaultRegistry(target.Clients{Nginx: mockNginxClient}),
+		HookClient:         hooks.NewHookClient(nil),
 	})
 
 	w := httptest.NewRecorder()
@@ -381,31 +528,51 @@ func TestDeployWithGH_Untar_Error(t *testing.T) {
 	req.Header.Set("Content-Type", "application/json")
 	router.ServeHTTP(w, req)
 
+	// Assert: check if the deployment is aborted before the site changes
 	assert.Equal(t, http.StatusInternalServerError, w.Code)
-	assert.Contains(t, w.Body.String(), "Failed to untar files in release directory")
+	assert.Contains(t, w.Body.String(), "A hook of the nginx target failed")
+	entries, _ := os.ReadDir(siteDir)
+	assert.Empty(t, entries)
+	assert.False(t, reloaded)
 }
 
-func TestDeployWithGH_GetRepository_Error(t *testing.T) {
+// Returns the stdout lines of the commands of a deployment
+func stdoutLines(record *history.Record) []string {
+	stdout := make([]string, 0)
+	for _, line := range record.Log {
+		if line.Stream == deploy_to_vm_exec.Stream_Stdout {
+			stdout = append(stdout, line.Text)
+		}
+	}
+	return stdout
+}
+
+func TestDeployWithGH_RecordsHistory(t *testing.T) {
 	tempDir := t.TempDir()
-	mockGithubClient := &MockGithubClient{}
 
 	configClient := &config.ConfigClient{}
 	configClient.Config = &config.DeployToVmConfig{
 		Repositories: []config.DeployToVmConfigRepository{
 			{
-				Name:       "non-existent-repo",
-				Owner:      "non-existent-owner",
+				Name:       "deploy-to-vm",
+				Owner:      "cemreyavuz",
 				SourceType: "github",
 				TargetDir:  t.TempDir(),
 				TargetType: "nginx",
+				Hooks: config.DeployToVmConfigHooks{
+					PreActivate: []config.DeployToVmConfigHook{{Command: "echo", Args: []string{"migrating"}}},
+				},
 			},
 		},
 	}
 
 	router := SetupRouter(RouterOptions{
-		AssetsDir:    tempDir,
-		ConfigClient: configClient,
-		GithubClient: mockGithubClient,
+		AssetsDir:          tempDir,
+		ConfigClient:       configClient,
+		GithubClient:       &MockGithubClient{},
+		NotificationClient: &MockNotificationClient{},
+		Targets:            target.NewDefaultRegistry(target.Clients{Nginx: &MockNginxClient{}}),
+		HookClient:         hooks.NewHookClient(nil),
 	})
 
 	w := httptest.NewRecorder()
@@ -415,13 +582,33 @@ func TestDeployWithGH_GetRepository_Error(t *testing.T) {
 	req.Header.Set("Content-Type", "application/json")
 	router.ServeHTTP(w, req)
 
-	assert.Equal(t, http.StatusInternalServerError, w.Code)
-	assert.Contains(t, w.Body.String(), "Repository not found in config")
+	// Assert: check if the deployment is recorded with the output of the hook
+	assert.Equal(t, http.StatusOK, w.Code)
+	records, listErr := history.List(tempDir, "cemreyavuz", "deploy-to-vm")
+	assert.NoError(t, listErr)
+	assert.Len(t, records, 1)
+	assert.Equal(t, "dev.0", records[0].Tag)
+	assert.Equal(t, history.Status_Succeeded, records[0].Status)
+	assert.Equal(t, []string{"migrating"}, stdoutLines(records[0]))
 }
 
-func TestDeployWithGH_MissingTargetDir(t *testing.T) {
+func TestDeployWithGH_DockerCompose_Success(t *testing.T) {
 	tempDir := t.TempDir()
-	mockGithubClient := &MockGithubClient{}
+	siteDir := t.TempDir()
+	mockGithubClient := &MockGithubClient{
+		DownloadAssetsFunc: func(assets []*github.ReleaseAsset, releaseDir string, maxTotalSize int64) (deploy_to_vm_github.DownloadAssetStatusCode, error) {
+			os.WriteFile(path.Join(releaseDir, "compose.yaml"), []byte("services: {}"), 0644)
+			os.WriteFile(path.Join(releaseDir, "app.image.tar"), []byte("not a site archive"), 0644)
+			return deploy_to_vm_github.DownloadAsset_Success, nil
+		},
+	}
+	var composeUpOptions docker.ComposeUpOptions
+	mockDockerClient := &MockDockerClient{
+		ComposeUpFunc: func(options docker.ComposeUpOptions) error {
+			composeUpOptions = options
+			return nil
+		},
+	}
 
 	configClient := &config.ConfigClient{}
 	configClient.Config = &config.DeployToVmConfig{
@@ -430,8 +617,59 @@ func TestDeployWithGH_MissingTargetDir(t *testing.T) {
 				Name:       "deploy-to-vm",
 				Owner:      "cemreyavuz",
 				SourceType: "github",
-				TargetDir:  "", // Missing target directory
-				TargetType: "nginx",
+				TargetDir:  siteDir,
+				TargetType: "docker-compose",
+				DockerCompose: config.DeployToVmConfigDockerCompose{
+					ImageArchives: []string{"*.image.tar"},
+				},
+			},
+		},
+	}
+
+	router := SetupRouter(RouterOptions{
+		AssetsDir:          tempDir,
+		ConfigClient:       configClient,
+		Targets:            target.NewDefaultRegistry(target.Clients{Docker: mockDockerClient}),
+		GithubClient:       mockGithubClient,
+		NotificationClient: &MockNotificationClient{},
+	})
+
+	w := httptest.NewRecorder()
+	payload := `{"action":"released","release":{"assets":[{"url":"https://example.com/asset","name":"compose.yaml"}],"tag_name":"dev.0"},"repository":{"id":973821242,"name":"deploy-to-vm","owner":{"login":"cemreyavuz"}}}`
+	req, _ := http.NewRequest("POST", "/deploy-with-gh", bytes.NewBuffer(([]byte(payload))))
+	req.Header.Set("X-GitHub-Event", "release")
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	releaseDir := path.Join(tempDir, "cemreyavuz", "deploy-to-vm", "dev.0")
+	assert.Equal(t, "deploy-to-vm", composeUpOptions.Project)
+	assert.Equal(t, path.Join(siteDir, "compose.yaml"), composeUpOptions.ComposeFile)
+	assert.Equal(t, []string{path.Join(releaseDir, "app.image.tar")}, composeUpOptions.ImageArchives)
+	envFile, readErr := os.ReadFile(composeUpOptions.EnvFile)
+	assert.NoError(t, readErr)
+	assert.Equal(t, "IMAGE_TAG='dev.0'\n", string(envFile))
+}
+
+func TestDeployWithGH_UnknownTargetType(t *testing.T) {
+	tempDir := t.TempDir()
+	siteDir := t.TempDir()
+	mockGithubClient := &MockGithubClient{
+		DownloadAssetsFunc: func(assets []*github.ReleaseAsset, releaseDir string, maxTotalSize int64) (deploy_to_vm_github.DownloadAssetStatusCode, error) {
+			os.WriteFile(path.Join(releaseDir, "index.html"), []byte("index"), 0644)
+			return deploy_to_vm_github.DownloadAsset_Success, nil
+		},
+	}
+
+	configClient := &config.ConfigClient{}
+	configClient.Config = &config.DeployToVmConfig{
+		Repositories: []config.DeployToVmConfigRepository{
+			{
+				Name:       "deploy-to-vm",
+				Owner:      "cemreyavuz",
+				SourceType: "github",
+				TargetDir:  siteDir,
+				TargetType: "apache",
 			},
 		},
 	}
@@ -440,22 +678,29 @@ func TestDeployWithGH_MissingTargetDir(t *testing.T) {
 		AssetsDir:    tempDir,
 		ConfigClient: configClient,
 		GithubClient: mockGithubClient,
+		Targets:      target.NewDefaultRegistry(target.Clients{}),
 	})
 
 	w := httptest.NewRecorder()
-	payload := `{"action":"released","release":{"assets":[{"url":"https://example.com/asset","name":"example-asset"}],"tag_name":"dev.0"},"repository":{"id":973821242,"name":"deploy-to-vm","owner":{"login":"cemreyavuz"}}}`
+	payload := `{"action":"released","release":{"assets":[{"url":"https://example.com/asset","name":"index.html"}],"tag_name":"dev.0"},"repository":{"id":973821242,"name":"deploy-to-vm","owner":{"login":"cemreyavuz"}}}`
 	req, _ := http.NewRequest("POST", "/deploy-with-gh", bytes.NewBuffer(([]byte(payload))))
 	req.Header.Set("X-GitHub-Event", "release")
 	req.Header.Set("Content-Type", "application/json")
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusInternalServerError, w.Code)
-	assert.Contains(t, w.Body.String(), "Site directory not found for repository")
+	assert.Contains(t, w.Body.String(), `Unknown target type: \"apache\"`)
+	_, statErr := os.Stat(path.Join(siteDir, "index.html"))
+	assert.True(t, os.IsNotExist(statErr), "Expected the release not to be linked")
 }
 
-func TestDeployWithGH_NonExistentSiteDir(t *testing.T) {
+func TestDeployWithGH_NoAssetsFound(t *testing.T) {
 	tempDir := t.TempDir()
-	mockGithubClient := &MockGithubClient{}
+	mockGithubClient := &MockGithubClient{
+		DownloadAssetsFunc: func(assets []*github.ReleaseAsset, releaseDir string, maxTotalSize int64) (deploy_to_vm_github.DownloadAssetStatusCode, error) {
+			return deploy_to_vm_github.DownloadAsset_NoAssetsFound, errors.New("mock error")
+		},
+	}
 
 	configClient := &config.ConfigClient{}
 	configClient.Config = &config.DeployToVmConfig{
@@ -464,7 +709,7 @@ func TestDeployWithGH_NonExistentSiteDir(t *testing.T) {
 				Name:       "deploy-to-vm",
 				Owner:      "cemreyavuz",
 				SourceType: "github",
-				TargetDir:  "/non/existent/dir",
+				TargetDir:  t.TempDir(),
 				TargetType: "nginx",
 			},
 		},
@@ -477,22 +722,21 @@ func TestDeployWithGH_NonExistentSiteDir(t *testing.T) {
 	})
 
 	w := httptest.NewRecorder()
-	payload := `{"action":"released","release":{"assets":[{"url":"https://example.com/asset","name":"example-asset"}],"tag_name":"dev.0"},"repository":{"id":973821242,"name":"deploy-to-vm","owner":{"login":"cemreyavuz"}}}`
+	payload := `{"action":"released","release":{"assets":[],"tag_name":"dev.0"},"repository":{"id":973821242,"name":"deploy-to-vm","owner":{"login":"cemreyavuz"}}}`
 	req, _ := http.NewRequest("POST", "/deploy-with-gh", bytes.NewBuffer(([]byte(payload))))
 	req.Header.Set("X-GitHub-Event", "release")
 	req.Header.Set("Content-Type", "application/json")
 	router.ServeHTTP(w, req)
 
-	assert.Equal(t, http.StatusInternalServerError, w.Code)
-	assert.Contains(t, w.Body.String(), "Failed to move release assets to site directory")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `{"message":"No assets found for release \"dev.0\", will skip the request."}`)
 }
 
-func TestDeployWithGH_Reload_Error(t *testing.T) {
+func TestDeployWithGH_DownloadAssets_Error(t *testing.T) {
 	tempDir := t.TempDir()
-	mockGithubClient := &MockGithubClient{}
-	mockNginxClient := &MockNginxClient{
-		ReloadFunc: func() error {
-			return fmt.Errorf("Failed to reload nginx")
+	mockGithubClient := &MockGithubClient{
+		DownloadAssetsFunc: func(assets []*github.ReleaseAsset, releaseDir string, maxTotalSize int64) (deploy_to_vm_github.DownloadAssetStatusCode, error) {
+			return deploy_to_vm_github.DownloadAsset_UnknownError, errors.New("Failed to download assets")
 		},
 	}
 
@@ -513,7 +757,6 @@ func TestDeployWithGH_Reload_Error(t *testing.T) {
 		AssetsDir:    tempDir,
 		ConfigClient: configClient,
 		GithubClient: mockGithubClient,
-		NginxClient:  mockNginxClient,
 	})
 
 	w := httptest.NewRecorder()
@@ -524,16 +767,16 @@ func TestDeployWithGH_Reload_Error(t *testing.T) {
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusInternalServerError, w.Code)
-	assert.Contains(t, w.Body.String(), "Failed to reload nginx unit")
+	assert.Contains(t, w.Body.String(), "Failed to download assets")
 }
 
-func TestDeployWithGH_Notify_Error(t *testing.T) {
+func TestDeployWithGH_Untar_Error(t *testing.T) {
 	tempDir := t.TempDir()
-	mockGithubClient := &MockGithubClient{}
-	mockNginxClient := &MockNginxClient{}
-	mockNotificationClient := &MockNotificationClient{
-		NotifyFunc: func(message string) error {
-			return fmt.Errorf("Failed to send notification")
+	mockGithubClient := &MockGithubClient{
+		DownloadAssetsFunc: func(assets []*github.ReleaseAsset, releaseDir string, maxTotalSize int64) (deploy_to_vm_github.DownloadAssetStatusCode, error) {
+			corruptedTarFilePath := path.Join(releaseDir, "corrupted.tar.gz")
+			os.WriteFile(corruptedTarFilePath, []byte("dummy content"), 0644)
+			return deploy_to_vm_github.DownloadAsset_Success, nil
 		},
 	}
 
@@ -551,11 +794,9 @@ func TestDeployWithGH_Notify_Error(t *testing.T) {
 	}
 
 	router := SetupRouter(RouterOptions{
-		AssetsDir:          tempDir,
-		ConfigClient:       configClient,
-		GithubClient:       mockGithubClient,
-		NginxClient:        mockNginxClient,
-		NotificationClient: mockNotificationClient,
+		AssetsDir:    tempDir,
+		ConfigClient: configClient,
+		GithubClient: mockGithubClient,
 	})
 
 	w := httptest.NewRecorder()
@@ -565,16 +806,827 @@ func TestDeployWithGH_Notify_Error(t *testing.T) {
 	req.Header.Set("Content-Type", "application/json")
 	router.ServeHTTP(w, req)
 
-	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), "Failed to untar files in release directory")
 }
 
-func TestPingRoute(t *testing.T) {
-	router := setupTestRouter()
+func TestDeployWithGH_GetRepository_Error(t *testing.T) {
+	tempDir := t.TempDir()
+	mockGithubClient := &MockGithubClient{}
+
+	configClient := &config.ConfigClient{}
+	configClient.Config = &config.DeployToVmConfig{
+		Repositories: []config.DeployToVmConfigRepository{
+			{
+				Name:       "non-existent-repo",
+				Owner:      "non-existent-owner",
+				SourceType: "github",
+				TargetDir:  t.TempDir(),
+				TargetType: "nginx",
+			},
+		},
+	}
+
+	router := SetupRouter(RouterOptions{
+		AssetsDir:    tempDir,
+		ConfigClient: configClient,
+		GithubClient: mockGithubClient,
+	})
 
 	w := httptest.NewRecorder()
-	req, _ := http.NewRequest("GET", "/ping", nil)
+	payload := `{"action":"released","release":{"assets":[{"url":"https://example.com/asset","name":"example-asset"}],"tag_name":"dev.0"},"repository":{"id":973821242,"name":"deploy-to-vm","owner":{"login":"cemreyavuz"}}}`
+	req, _ := http.NewRequest("POST", "/deploy-with-gh", bytes.NewBuffer(([]byte(payload))))
+	req.Header.Set("X-GitHub-Event", "release")
+	req.Header.Set("Content-Type", "application/json")
 	router.ServeHTTP(w, req)
 
-	assert.Equal(t, http.StatusOK, w.Code)
-	assert.Equal(t, "pong", w.Body.String())
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), "Repository not found in config")
+}
+
+func TestDeployWithGH_InvalidRunAs(t *testing.T) {
+	tempDir := t.TempDir()
+
+	configClient := &config.ConfigClient{}
+	configClient.Config = &config.DeployToVmConfig{
+		Repositories: []config.DeployToVmConfigRepository{
+			{
+				Name:       "deploy-to-vm",
+				Owner:      "cemreyavuz",
+				SourceType: "github",
+				TargetDir:  t.TempDir(),
+				TargetType: "pm2",
+				RunAs:      config.DeployToVmConfigRunAs{User: "deploy-to-vm-missing-user"},
+			},
+		},
+	}
+
+	router := SetupRouter(RouterOptions{
+		AssetsDir:    tempDir,
+		ConfigClient: configClient,
+		GithubClient: &MockGithubClient{},
+	})
+
+	w := httptest.NewRecorder()
+	payload := `{"action":"released","release":{"assets":[{"url":"https://example.com/asset","name":"example-asset"}],"tag_name":"dev.0"},"repository":{"id":973821242,"name":"deploy-to-vm","owner":{"login":"cemreyavuz"}}}`
+	req, _ := http.NewRequest("POST", "/deploy-with-gh", bytes.NewBuffer(([]byte(payload))))
+	req.Header.Set("X-GitHub-Event", "release")
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	// Assert: check if the deployment is refused before anything is downloaded
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), "Invalid runAs config")
+	entries, _ := os.ReadDir(tempDir)
+	assert.Empty(t, entries)
+}
+
+func TestDeployWithGH_RunAsCurrentUser(t *testing.T) {
+	tempDir := t.TempDir()
+	current, _ := user.Current()
+
+	configClient := &config.ConfigClient{}
+	configClient.Config = &config.DeployToVmConfig{
+		Repositories: []config.DeployToVmConfigRepository{
+			{
+				Name:       "deploy-to-vm",
+				Owner:      "cemreyavuz",
+				SourceType: "github",
+				TargetDir:  t.TempDir(),
+				TargetType: "nginx",
+				RunAs:      config.DeployToVmConfigRunAs{User: current.Username},
+				Hooks: config.DeployToVmConfigHooks{
+					PreActivate: []config.DeployToVmConfigHook{{Command: "id", Args: []string{"-u"}}},
+				},
+			},
+		},
+	}
+
+	router := SetupRouter(RouterOptions{
+		AssetsDir:          tempDir,
+		ConfigClient:       configClient,
+		GithubClient:       &MockGithubClient{},
+		NotificationClient: &MockNotificationClient{},
+		Targets:            target.NewDefaultRegistry(target.Clients{Nginx: &MockNginxClient{}}),
+		HookClient:         hooks.NewHookClient(nil),
+	})
+
+	w := httptest.NewRecorder()
+	payload := `{"action":"released","release":{"assets":[{"url":"https://example.com/asset","name":"example-asset"}],"tag_name":"dev.0"},"repository":{"id":973821242,"name":"deploy-to-vm","owner":{"login":"cemreyavuz"}}}`
+	req, _ := http.NewRequest("POST", "/deploy-with-gh", bytes.NewBuffer(([]byte(payload))))
+	req.Header.Set("X-GitHub-Event", "release")
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	// Assert: check if the hook runs as the user without switching
+	assert.Equal(t, http.StatusOK, w.Code)
+	records, _ := history.List(tempDir, "cemreyavuz", "deploy-to-vm")
+	assert.Equal(t, []string{current.Uid}, stdoutLines(records[0]))
+}
+
+func TestDeployWithGH_MissingTargetDir(t *testing.T) {
+	tempDir := t.TempDir()
+	mockGithubClient := &MockGithubClient{}
+
+	configClient := &config.ConfigClient{}
+	configClient.Config = &config.DeployToVmConfig{
+		Repositories: []config.DeployToVmConfigRepository{
+			{
+				Name:       "deploy-to-vm",
+				Owner:      "cemreyavuz",
+				SourceType: "github",
+				TargetDir:  "", // Missing target directory
+				TargetType: "nginx",
+			},
+		},
+	}
+
+	router := SetupRouter(RouterOptions{
+		AssetsDir:    tempDir,
+		ConfigClient: configClient,
+		GithubClient: mockGithubClient,
+	})
+
+	w := httptest.NewRecorder()
+	payload := `{"action":"released","release":{"assets":[{"url":"https://example.com/asset","name":"example-asset"}],"tag_name":"dev.0"},"repository":{"id":973821242,"name":"deploy-to-vm","owner":{"login":"cemreyavuz"}}}`
+	req, _ := http.NewRequest("POST", "/deploy-with-gh", bytes.NewBuffer(([]byte(payload))))
+	req.Header.Set("X-GitHub-Event", "release")
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), "Site directory not found for repository")
+}
+
+func TestDeployWithGH_NonExistentSiteDir(t *testing.T) {
+	tempDir := t.TempDir()
+	mockGithubClient := &MockGithubClient{}
+
+	configClient := &config.ConfigClient{}
+	configClient.Config = &config.DeployToVmConfig{
+		Repositories: []config.DeployToVmConfigRepository{
+			{
+				Name:       "deploy-to-vm",
+				Owner:      "cemreyavuz",
+				SourceType: "github",
+				TargetDir:  "/non/existent/dir",
+				TargetType: "nginx",
+			},
+		},
+	}
+
+	router := SetupRouter(RouterOptions{
+		AssetsDir:    tempDir,
+		ConfigClient: configClient,
+		GithubClient: mockGithubClient,
+		Targets:      target.NewDefaultRegistry(target.Clients{}),
+	})
+
+	w := httptest.NewRecorder()
+	payload := `{"action":"released","release":{"assets":[{"url":"https://example.com/asset","name":"example-asset"}],"tag_name":"dev.0"},"repository":{"id":973821242,"name":"deploy-to-vm","owner":{"login":"cemreyavuz"}}}`
+	req, _ := http.NewRequest("POST", "/deploy-with-gh", bytes.NewBuffer(([]byte(payload))))
+	req.Header.Set("X-GitHub-Event", "release")
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), "Failed to move release assets to site directory")
+}
+
+func TestDeployWithGH_Reload_Error(t *testing.T) {
+	tempDir := t.TempDir()
+	mockGithubClient := &MockGithubClient{}
+	mockNginxClient := &MockNginxClient{
+		ReloadFunc: func() error {
+			return fmt.Errorf("Failed to reload nginx")
+		},
+	}
+
+	configClient := &config.ConfigClient{}
+	configClient.Config = &config.DeployToVmConfig{
+		Repositories: []config.DeployToVmConfigRepository{
+			{
+				Name:       "deploy-to-vm",
+				Owner:      "cemreyavuz",
+				SourceType: "github",
+				TargetDir:  t.TempDir(),
+				TargetType: "nginx",
+			},
+		},
+	}
+
+	router := SetupRouter(RouterOptions{
+		AssetsDir:    tempDir,
+		ConfigClient: configClient,
+		GithubClient: mockGithubClient,
+		Targets:      target.NewDefaultRegistry(target.Clients{Nginx: mockNginxClient}),
+	})
+
+	w := httptest.NewRecorder()
+	payload := `{"action":"released","release":{"assets":[{"url":"https://example.com/asset","name":"example-asset"}],"tag_name":"dev.0"},"repository":{"id":973821242,"name":"deploy-to-vm","owner":{"login":"cemreyavuz"}}}`
+	req, _ := http.NewRequest("POST", "/deploy-with-gh", bytes.NewBuffer(([]byte(payload))))
+	req.Header.Set("X-GitHub-Event", "release")
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), "Failed to reload the nginx target")
+}
+
+func TestDeployWithGH_Notify_Error(t *testing.T) {
+	tempDir := t.TempDir()
+	mockGithubClient := &MockGithubClient{}
+	mockNginxClient := &MockNginxClient{}
+	mockNotificationClient := &MockNotificationClient{
+		NotifyFunc: func(message string) error {
+			return fmt.Errorf("Failed to send notification")
+		},
+	}
+
+	configClient := &config.ConfigClient{}
+	configClient.Config = &config.DeployToVmConfig{
+		Repositories: []config.DeployToVmConfigRepository{
+			{
+				Name:       "deploy-to-vm",
+				Owner:      "cemreyavuz",
+				SourceType: "github",
+				TargetDir:  t.TempDir(),
+				TargetType: "nginx",
+			},
+		},
+	}
+
+	router := SetupRouter(RouterOptions{
+		AssetsDir:          tempDir,
+		ConfigClient:       configClient,
+		GithubClient:       mockGithubClient,
+		Targets:            target.NewDefaultRegistry(target.Clients{Nginx: mockNginxClient}),
+		NotificationClient: mockNotificationClient,
+	})
+
+	w := httptest.NewRecorder()
+	payload := `{"action":"released","release":{"assets":[{"url":"https://example.com/asset","name":"example-asset"}],"tag_name":"dev.0"},"repository":{"id":973821242,"name":"deploy-to-vm","owner":{"login":"cemreyavuz"}}}`
+	req, _ := http.NewRequest("POST", "/deploy-with-gh", bytes.NewBuffer(([]byte(payload))))
+	req.Header.Set("X-GitHub-Event", "release")
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestPingRoute(t *testing.T) {
+	router := setupTestRouter()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/ping", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "pong", w.Body.String())
+}
+
+func TestDeployWithGH_ChecksumMismatch_Error(t *testing.T) {
+	tempDir := t.TempDir()
+	mockGithubClient := &MockGithubClient{
+		DownloadAssetsFunc: func(assets []*github.ReleaseAsset, releaseDir string, maxTotalSize int64) (deploy_to_vm_github.DownloadAssetStatusCode, error) {
+			os.WriteFile(path.Join(releaseDir, "example-asset"), []byte("dummy content"), 0644)
+			return deploy_to_vm_github.DownloadAsset_Success, nil
+		},
+	}
+	mockNginxClient := &MockNginxClient{
+		ReloadFunc: func() error {
+			t.Fatal("Expected reload not to be called")
+			return nil
+		},
+	}
+
+	siteDir := t.TempDir()
+	configClient := &config.ConfigClient{}
+	configClient.Config = &config.DeployToVmConfig{
+		Repositories: []config.DeployToVmConfigRepository{
+			{
+				Name:       "deploy-to-vm",
+				Owner:      "cemreyavuz",
+				SourceType: "github",
+				TargetDir:  siteDir,
+				TargetType: "nginx",
+			},
+		},
+	}
+
+	router := SetupRouter(RouterOptions{
+		AssetsDir:    tempDir,
+		ConfigClient: configClient,
+		GithubClient: mockGithubClient,
+		Targets:      target.NewDefaultRegistry(target.Clients{Nginx: mockNginxClient}),
+	})
+
+	w := httptest.NewRecorder()
+	payload := `{"action":"released","release":{"assets":[{"url":"https://example.com/asset","name":"example-asset","digest":"sha256:0000000000000000000000000000000000000000000000000000000000000000"}],"tag_name":"dev.0"},"repository":{"id":973821242,"name":"deploy-to-vm","owner":{"login":"cemreyavuz"}}}`
+	req, _ := http.NewRequest("POST", "/deploy-with-gh", bytes.NewBuffer(([]byte(payload))))
+	req.Header.Set("X-GitHub-Event", "release")
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), "Failed to verify checksums of release assets")
+
+	// nothing should reach the site directory
+	entries, _ := os.ReadDir(siteDir)
+	assert.Empty(t, entries)
+}
+
+func TestDeployWithGH_DownloadSizeLimit_Error(t *testing.T) {
+	tempDir := t.TempDir()
+	mockGithubClient := &MockGithubClient{
+		DownloadAssetsFunc: func(assets []*github.ReleaseAsset, releaseDir string, maxTotalSize int64) (deploy_to_vm_github.DownloadAssetStatusCode, error) {
+			t.Fatal("Expected assets not to be downloaded")
+			return deploy_to_vm_github.DownloadAsset_Success, nil
+		},
+	}
+
+	configClient := &config.ConfigClient{}
+	configClient.Config = &config.DeployToVmConfig{
+		Repositories: []config.DeployToVmConfigRepository{
+			{
+				Name:       "deploy-to-vm",
+				Owner:      "cemreyavuz",
+				SourceType: "github",
+				TargetDir:  t.TempDir(),
+				TargetType: "nginx",
+				Limits: config.DeployToVmConfigLimits{
+					MaxDownloadSize: 1024,
+				},
+			},
+		},
+	}
+
+	router := SetupRouter(RouterOptions{
+		AssetsDir:    tempDir,
+		ConfigClient: configClient,
+		GithubClient: mockGithubClient,
+	})
+
+	w := httptest.NewRecorder()
+	payload := `{"action":"released","release":{"assets":[{"url":"https://example.com/asset","name":"example-asset","size":4096}],"tag_name":"dev.0"},"repository":{"id":973821242,"name":"deploy-to-vm","owner":{"login":"cemreyavuz"}}}`
+	req, _ := http.NewRequest("POST", "/deploy-with-gh", bytes.NewBuffer(([]byte(payload))))
+	req.Header.Set("X-GitHub-Event", "release")
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), "Release assets exceed the download size limit")
+}
+
+func TestDeployWithGH_RejectedArchiveEntries(t *testing.T) {
+	tempDir := t.TempDir()
+	mockGithubClient := &MockGithubClient{
+		DownloadAssetsFunc: func(assets []*github.ReleaseAsset, releaseDir string, maxTotalSize int64) (deploy_to_vm_github.DownloadAssetStatusCode, error) {
+			f, _ := os.Create(path.Join(releaseDir, "example-asset.tar.gz"))
+			defer f.Close()
+			gw := gzip.NewWriter(f)
+			defer gw.Close()
+			tw := tar.NewWriter(gw)
+			defer tw.Close()
+			tw.WriteHeader(&tar.Header{Name: "../escaped.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 4})
+			tw.Write([]byte("evil"))
+			return deploy_to_vm_github.DownloadAsset_Success, nil
+		},
+	}
+	mockNginxClient := &MockNginxClient{}
+
+	configClient := &config.ConfigClient{}
+	configClient.Config = &config.DeployToVmConfig{
+		Repositories: []config.DeployToVmConfigRepository{
+			{
+				Name:       "deploy-to-vm",
+				Owner:      "cemreyavuz",
+				SourceType: "github",
+				TargetDir:  t.TempDir(),
+				TargetType: "nginx",
+			},
+		},
+	}
+
+	router := SetupRouter(RouterOptions{
+		AssetsDir:          tempDir,
+		ConfigClient:       configClient,
+		GithubClient:       mockGithubClient,
+		Targets:            target.NewDefaultRegistry(target.Clients{Nginx: mockNginxClient}),
+		NotificationClient: &MockNotificationClient{},
+	})
+
+	w := httptest.NewRecorder()
+	payload := `{"action":"released","release":{"assets":[{"url":"https://example.com/asset","name":"example-asset.tar.gz"}],"tag_name":"dev.0"},"repository":{"id":973821242,"name":"deploy-to-vm","owner":{"login":"cemreyavuz"}}}`
+	req, _ := http.NewRequest("POST", "/deploy-with-gh", bytes.NewBuffer(([]byte(payload))))
+	req.Header.Set("X-GitHub-Event", "release")
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"rejectedEntries":[{"archive":"example-asset.tar.gz","name":"../escaped.txt","reason":"path escapes the release directory"}]`)
+}
+
+func TestDeployWithGH_RootDir(t *testing.T) {
+	tempDir := t.TempDir()
+	siteDir := t.TempDir()
+	mockGithubClient := &MockGithubClient{
+		DownloadAssetsFunc: func(assets []*github.ReleaseAsset, releaseDir string, maxTotalSize int64) (deploy_to_vm_github.DownloadAssetStatusCode, error) {
+			f, _ := os.Create(path.Join(releaseDir, "example-asset.tar.gz"))
+			defer f.Close()
+			gw := gzip.NewWriter(f)
+			defer gw.Close()
+			tw := tar.NewWriter(gw)
+			defer tw.Close()
+			tw.WriteHeader(&tar.Header{Name: "app-v1.2.3/dist/index.html", Typeflag: tar.TypeReg, Mode: 0644, Size: 4})
+			tw.Write([]byte("site"))
+			tw.WriteHeader(&tar.Header{Name: "app-v1.2.3/README.md", Typeflag: tar.TypeReg, Mode: 0644, Size: 6})
+			tw.Write([]byte("readme"))
+			return deploy_to_vm_github.DownloadAsset_Success, nil
+		},
+	}
+	mockNginxClient := &MockNginxClient{}
+
+	configClient := &config.ConfigClient{}
+	configClient.Config = &config.DeployToVmConfig{
+		Repositories: []config.DeployToVmConfigRepository{
+			{
+				Name:       "deploy-to-vm",
+				Owner:      "cemreyavuz",
+				SourceType: "github",
+				TargetDir:  siteDir,
+				TargetType: "nginx",
+				Extraction: config.DeployToVmConfigExtraction{
+					StripComponents: 1,
+					RootDir:         "dist",
+				},
+			},
+		},
+	}
+
+	router := SetupRouter(RouterOptions{
+		AssetsDir:          tempDir,
+		ConfigClient:       configClient,
+		GithubClient:       mockGithubClient,
+		Targets:            target.NewDefaultRegistry(target.Clients{Nginx: mockNginxClient}),
+		NotificationClient: &MockNotificationClient{},
+	})
+
+	w := httptest.NewRecorder()
+	payload := `{"action":"released","release":{"assets":[{"url":"https://example.com/asset","name":"example-asset.tar.gz"}],"tag_name":"dev.0"},"repository":{"id":973821242,"name":"deploy-to-vm","owner":{"login":"cemreyavuz"}}}`
+	req, _ := http.NewRequest("POST", "/deploy-with-gh", bytes.NewBuffer(([]byte(payload))))
+	req.Header.Set("X-GitHub-Event", "release")
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	data, readErr := os.ReadFile(path.Join(siteDir, "index.html"))
+	assert.NoError(t, readErr)
+	assert.Equal(t, []byte("site"), data)
+	_, statErr := os.Stat(path.Join(siteDir, "README.md"))
+	assert.True(t, os.IsNotExist(statErr), "Expected files outside of the root directory not to be linked")
+}
+
+func TestDeployWithGH_FailedRedeployKeepsRelease(t *testing.T) {
+	tempDir := t.TempDir()
+	mockGithubClient := &MockGithubClient{
+		DownloadAssetsFunc: func(assets []*github.ReleaseAsset, releaseDir string, maxTotalSize int64) (deploy_to_vm_github.DownloadAssetStatusCode, error) {
+			os.WriteFile(path.Join(releaseDir, "partial"), []byte("partial"), 0644)
+			return deploy_to_vm_github.DownloadAsset_UnknownError, errors.New("connection reset")
+		},
+	}
+
+	// Arrange: create the release directory of a previous deployment of the same tag
+	releaseDir := path.Join(tempDir, "cemreyavuz", "deploy-to-vm", "dev.0")
+	os.MkdirAll(releaseDir, 0755)
+	os.WriteFile(path.Join(releaseDir, "index.html"), []byte("active"), 0644)
+
+	configClient := &config.ConfigClient{}
+	configClient.Config = &config.DeployToVmConfig{
+		Repositories: []config.DeployToVmConfigRepository{
+			{
+				Name:       "deploy-to-vm",
+				Owner:      "cemreyavuz",
+				SourceType: "github",
+				TargetDir:  t.TempDir(),
+				TargetType: "nginx",
+			},
+		},
+	}
+
+	router := SetupRouter(RouterOptions{
+		AssetsDir:    tempDir,
+		ConfigClient: configClient,
+		GithubClient: mockGithubClient,
+	})
+
+	w := httptest.NewRecorder()
+	payload := `{"action":"released","release":{"assets":[{"url":"https://example.com/asset","name":"example-asset"}],"tag_name":"dev.0"},"repository":{"id":973821242,"name":"deploy-to-vm","owner":{"login":"cemreyavuz"}}}`
+	req, _ := http.NewRequest("POST", "/deploy-with-gh", bytes.NewBuffer(([]byte(payload))))
+	req.Header.Set("X-GitHub-Event", "release")
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	data, readErr := os.ReadFile(path.Join(releaseDir, "index.html"))
+	assert.NoError(t, readErr)
+	assert.Equal(t, []byte("active"), data)
+	entries, _ := os.ReadDir(path.Join(tempDir, file_utils.StagingDirName))
+	assert.Empty(t, entries, "Expected the staging directory to be removed")
+}
+
+func TestDeployWithGH_WritesManifestAndVerify(t *testing.T) {
+	tempDir := t.TempDir()
+	siteDir := t.TempDir()
+	mockGithubClient := &MockGithubClient{
+		DownloadAssetsFunc: func(assets []*github.ReleaseAsset, releaseDir string, maxTotalSize int64) (deploy_to_vm_github.DownloadAssetStatusCode, error) {
+			os.WriteFile(path.Join(releaseDir, "index.html"), []byte("index"), 0644)
+			os.WriteFile(path.Join(releaseDir, "SHA256SUMS"), []byte("1bc04b5291c26a46d918139138b992d2de976d6851d0893b0476b85bfbdfc6e6  index.html\n"), 0644)
+			return deploy_to_vm_github.DownloadAsset_Success, nil
+		},
+		GetTagCommitFunc: func(owner string, repo string, tag string) (string, error) {
+			assert.Equal(t, "cemreyavuz", owner)
+			assert.Equal(t, "deploy-to-vm", repo)
+			assert.Equal(t, "dev.0", tag)
+			return "89abcdef0123", nil
+		},
+	}
+
+	configClient := &config.ConfigClient{}
+	configClient.Config = &config.DeployToVmConfig{
+		Repositories: []config.DeployToVmConfigRepository{
+			{
+				Name:       "deploy-to-vm",
+				Owner:      "cemreyavuz",
+				SourceType: "github",
+				TargetDir:  siteDir,
+				TargetType: "nginx",
+			},
+		},
+	}
+
+	router := SetupRouter(RouterOptions{
+		AssetsDir:          tempDir,
+		ConfigClient:       configClient,
+		GithubClient:       mockGithubClient,
+		Targets:            target.NewDefaultRegistry(target.Clients{Nginx: &MockNginxClient{}}),
+		NotificationClient: &MockNotificationClient{},
+		SecretToken:        "test",
+	})
+
+	// Deploy a release
+	w := httptest.NewRecorder()
+	payload := `{"action":"released","release":{"assets":[{"url":"https://example.com/asset","name":"index.html"}],"tag_name":"dev.0","target_commitish":"0123abcd"},"repository":{"id":973821242,"name":"deploy-to-vm","owner":{"login":"cemreyavuz"}}}`
+	mac := hmac.New(sha256.New, []byte("test"))
+	mac.Write([]byte(payload))
+	req, _ := http.NewRequest("POST", "/deploy-with-gh", bytes.NewBuffer(([]byte(payload))))
+	req.Header.Set("X-GitHub-Event", "release")
+	req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// The manifest is written outside of the site
+	manifestContent, readErr := os.ReadFile(path.Join(tempDir, "cemreyavuz", "deploy-to-vm", "dev.0", file_utils.MetadataDirName, "manifest.json"))
+	assert.NoError(t, readErr)
+	assert.Contains(t, string(manifestContent), `"commit": "89abcdef0123"`)
+	assert.Contains(t, string(manifestContent), `"targetCommitish": "0123abcd"`)
+	assert.Contains(t, string(manifestContent), `"name": "index.html"`)
+	assert.Contains(t, string(manifestContent), `"name": "SHA256SUMS"`)
+	_, statErr := os.Stat(path.Join(siteDir, file_utils.MetadataDirName))
+	assert.True(t, os.IsNotExist(statErr), "Expected the metadata directory not to be linked")
+
+	// Verifying without a token is rejected
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/releases/cemreyavuz/deploy-to-vm/dev.0/verify", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Hotfix the site and verify it
+	os.Remove(path.Join(siteDir, "index.html"))
+	os.WriteFile(path.Join(siteDir, "index.html"), []byte("hotfix"), 0644)
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/releases/cemreyavuz/deploy-to-vm/dev.0/verify", nil)
+	req.Header.Set("Authorization", "Bearer test")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"clean":false`)
+	assert.Contains(t, w.Body.String(), `"site":{"modified":["index.html"],"added":[],"missing":[]}`)
+}
+
+// Helper to create an active release whose site was edited by hand, and a
+// router that deploys over it with the given drift policy
+func setupDriftTest(t *testing.T, policy string) (*gin.Engine, string, *[]string) {
+	tempDir := t.TempDir()
+	siteDir := t.TempDir()
+
+	// Arrange: deploy a release and edit the site by hand
+	releaseDir := file_utils.ReleaseDirPath(tempDir, "cemreyavuz", "deploy-to-vm", "dev.0")
+	os.MkdirAll(releaseDir, 0755)
+	os.WriteFile(path.Join(releaseDir, "index.html"), []byte("index"), 0644)
+	files, _ := manifest.HashFiles(releaseDir)
+	manifest.Write(releaseDir, &manifest.Manifest{Version: manifest.Version, Tag: "dev.0", Files: files, CreatedAt: time.Now()})
+	file_utils.LinkReleaseAssetsToSiteDir(releaseDir, siteDir)
+	file_utils.SetActiveRelease(tempDir, "cemreyavuz", "deploy-to-vm", "dev.0")
+	os.WriteFile(path.Join(siteDir, "debug.php"), []byte("debug"), 0644)
+
+	mockGithubClient := &MockGithubClient{
+		DownloadAssetsFunc: func(assets []*github.ReleaseAsset, releaseDir string, maxTotalSize int64) (deploy_to_vm_github.DownloadAssetStatusCode, error) {
+			os.WriteFile(path.Join(releaseDir, "index.html"), []byte("new index"), 0644)
+			return deploy_to_vm_github.DownloadAsset_Success, nil
+		},
+	}
+
+	configClient := &config.ConfigClient{}
+	configClient.Config = &config.DeployToVmConfig{
+		Repositories: []config.DeployToVmConfigRepository{
+			{
+				Name:       "deploy-to-vm",
+				Owner:      "cemreyavuz",
+				SourceType: "github",
+				TargetDir:  siteDir,
+				TargetType: "nginx",
+				Drift:      config.DeployToVmConfigDrift{Policy: policy},
+			},
+		},
+	}
+
+	messages := make([]string, 0)
+	router := SetupRouter(RouterOptions{
+		AssetsDir:    tempDir,
+		ConfigClient: configClient,
+		GithubClient: mockGithubClient,
+		Targets:      target.NewDefaultRegistry(target.Clients{Nginx: &MockNginxClient{}}),
+		NotificationClient: &MockNotificationClient{
+			NotifyFunc: func(message string) error {
+				messages = append(messages, message)
+				return nil
+			},
+		},
+	})
+	return router, tempDir, &messages
+}
+
+func TestDeployWithGH_DriftPolicyBlock(t *testing.T) {
+	router, tempDir, _ := setupDriftTest(t, "block")
+
+	w := httptest.NewRecorder()
+	payload := `{"action":"released","release":{"assets":[{"url":"https://example.com/asset","name":"index.html"}],"tag_name":"dev.1"},"repository":{"id":973821242,"name":"deploy-to-vm","owner":{"login":"cemreyavuz"}}}`
+	req, _ := http.NewRequest("POST", "/deploy-with-gh", bytes.NewBuffer(([]byte(payload))))
+	req.Header.Set("X-GitHub-Event", "release")
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), `"added":["debug.php"]`)
+	tag, _ := file_utils.GetActiveRelease(tempDir, "cemreyavuz", "deploy-to-vm")
+	assert.Equal(t, "dev.0", tag)
+}
+
+func TestDeployWithGH_DriftPolicyWarn(t *testing.T) {
+	router, tempDir, messages := setupDriftTest(t, "warn")
+
+	w := httptest.NewRecorder()
+	payload := `{"action":"released","release":{"assets":[{"url":"https://example.com/asset","name":"index.html"}],"tag_name":"dev.1"},"repository":{"id":973821242,"name":"deploy-to-vm","owner":{"login":"cemreyavuz"}}}`
+	req, _ := http.NewRequest("POST", "/deploy-with-gh", bytes.NewBuffer(([]byte(payload))))
+	req.Header.Set("X-GitHub-Event", "release")
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"drift":{"owner":"cemreyavuz"`)
+	assert.Contains(t, (*messages)[0], "Overwritten drift from release `dev.0`")
+	tag, _ := file_utils.GetActiveRelease(tempDir, "cemreyavuz", "deploy-to-vm")
+	assert.Equal(t, "dev.1", tag)
+}
+
+// Helper to create a router for a repository with a frontend and an API target,
+// where release dev.0 is active
+func setupMultiTargetTest(t *testing.T, mockPm2Client *MockPm2Client, driftPolicy string) (*gin.Engine, string, string) {
+	tempDir := t.TempDir()
+	mockPm2Client.Processes = []pm2.Process{{Name: "api"}}
+	webDir := t.TempDir()
+	apiDir := t.TempDir()
+
+	// Arrange: deploy release dev.0 to both targets
+	previousReleaseDir := file_utils.ReleaseDirPath(tempDir, "cemreyavuz", "deploy-to-vm", "dev.0")
+	os.MkdirAll(path.Join(previousReleaseDir, "web"), 0755)
+	os.MkdirAll(path.Join(previousReleaseDir, "api"), 0755)
+	os.WriteFile(path.Join(previousReleaseDir, "web", "index.html"), []byte("old index"), 0644)
+	os.WriteFile(path.Join(previousReleaseDir, "api", "server.js"), []byte("old server"), 0644)
+	file_utils.LinkReleaseAssetsToSiteDir(path.Join(previousReleaseDir, "web"), webDir)
+	file_utils.LinkReleaseAssetsToSiteDir(path.Join(previousReleaseDir, "api"), apiDir)
+	files, _ := manifest.HashFiles(previousReleaseDir)
+	manifest.Write(previousReleaseDir, &manifest.Manifest{
+		Version:   manifest.Version,
+		Tag:       "dev.0",
+		Targets:   []manifest.Target{{Name: "frontend", RootDir: "web"}, {Name: "api", RootDir: "api"}},
+		Files:     files,
+		CreatedAt: time.Now(),
+	})
+	file_utils.SetActiveRelease(tempDir, "cemreyavuz", "deploy-to-vm", "dev.0")
+
+	mockGithubClient := &MockGithubClient{
+		DownloadAssetsFunc: func(assets []*github.ReleaseAsset, releaseDir string, maxTotalSize int64) (deploy_to_vm_github.DownloadAssetStatusCode, error) {
+			os.MkdirAll(path.Join(releaseDir, "web"), 0755)
+			os.MkdirAll(path.Join(releaseDir, "api"), 0755)
+			os.WriteFile(path.Join(releaseDir, "web", "index.html"), []byte("new index"), 0644)
+			os.WriteFile(path.Join(releaseDir, "api", "server.js"), []byte("new server"), 0644)
+			return deploy_to_vm_github.DownloadAsset_Success, nil
+		},
+	}
+
+	configClient := &config.ConfigClient{}
+	configClient.Config = &config.DeployToVmConfig{
+		Repositories: []config.DeployToVmConfigRepository{
+			{
+				Name:       "deploy-to-vm",
+				Owner:      "cemreyavuz",
+				SourceType: "github",
+				Targets: []config.DeployToVmConfigTarget{
+					{Name: "frontend", Type: "nginx", Dir: webDir, RootDir: "web"},
+					{Name: "api", Type: "pm2", Dir: apiDir, RootDir: "api", Pm2: config.DeployToVmConfigPm2{ProcessName: "api"}},
+				},
+				Drift: config.DeployToVmConfigDrift{Policy: driftPolicy},
+			},
+		},
+	}
+
+	router := SetupRouter(RouterOptions{
+		AssetsDir:          tempDir,
+		ConfigClient:       configClient,
+		GithubClient:       mockGithubClient,
+		NotificationClient: &MockNotificationClient{},
+		Targets:            target.NewDefaultRegistry(target.Clients{Nginx: &MockNginxClient{}, Pm2: mockPm2Client}),
+	})
+	return router, tempDir, webDir
+}
+
+func TestDeployWithGH_MultipleTargets_Success(t *testing.T) {
+	router, tempDir, webDir := setupMultiTargetTest(t, &MockPm2Client{}, "")
+
+	w := httptest.NewRecorder()
+	payload := `{"action":"released","release":{"assets":[{"url":"https://example.com/asset","name":"index.html"}],"tag_name":"dev.1"},"repository":{"id":973821242,"name":"deploy-to-vm","owner":{"login":"cemreyavuz"}}}`
+	req, _ := http.NewRequest("POST", "/deploy-with-gh", bytes.NewBuffer(([]byte(payload))))
+	req.Header.Set("X-GitHub-Event", "release")
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"targets":[{"target":"frontend","linkStrategy":"hardlink"},{"target":"api","linkStrategy":"hardlink"}]`)
+	data, _ := os.ReadFile(path.Join(webDir, "index.html"))
+	assert.Equal(t, "new index", string(data))
+	tag, _ := file_utils.GetActiveRelease(tempDir, "cemreyavuz", "deploy-to-vm")
+	assert.Equal(t, "dev.1", tag)
+}
+
+func TestDeployWithGH_MultipleTargets_Rollback(t *testing.T) {
+	var reloads int
+	router, tempDir, webDir := setupMultiTargetTest(t, &MockPm2Client{
+		ReloadFunc: func() error {
+			// Fail the deployment of the new release, but not the rollback
+			reloads++
+			if reloads == 1 {
+				return errors.New("process crashed")
+			}
+			return nil
+		},
+	}, "")
+
+	w := httptest.NewRecorder()
+	payload := `{"action":"released","release":{"assets":[{"url":"https://example.com/asset","name":"index.html"}],"tag_name":"dev.1"},"repository":{"id":973821242,"name":"deploy-to-vm","owner":{"login":"cemreyavuz"}}}`
+	req, _ := http.NewRequest("POST", "/deploy-with-gh", bytes.NewBuffer(([]byte(payload))))
+	req.Header.Set("X-GitHub-Event", "release")
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), `"error":"Failed to reload the api target"`)
+	assert.Contains(t, w.Body.String(), `"rolledBack":["api","frontend"]`)
+	data, _ := os.ReadFile(path.Join(webDir, "index.html"))
+	assert.Equal(t, "old index", string(data), "Expected the frontend to be rolled back")
+	tag, _ := file_utils.GetActiveRelease(tempDir, "cemreyavuz", "deploy-to-vm")
+	assert.Equal(t, "dev.0", tag)
+}
+
+func TestDeployWithGH_MultipleTargets_DriftPolicyBlock(t *testing.T) {
+	router, tempDir, webDir := setupMultiTargetTest(t, &MockPm2Client{}, "block")
+	os.Remove(path.Join(webDir, "index.html"))
+	os.WriteFile(path.Join(webDir, "index.html"), []byte("hotfix"), 0644)
+
+	// Deploy over the drifted frontend
+	w := httptest.NewRecorder()
+	payload := `{"action":"released","release":{"assets":[{"url":"https://example.com/asset","name":"index.html"}],"tag_name":"dev.1"},"repository":{"id":973821242,"name":"deploy-to-vm","owner":{"login":"cemreyavuz"}}}`
+	req, _ := http.NewRequest("POST", "/deploy-with-gh", bytes.NewBuffer(([]byte(payload))))
+	req.Header.Set("X-GitHub-Event", "release")
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), `{"target":"frontend","dir":"`+webDir+`","site":{"modified":["index.html"],"added":[],"missing":[]}}`)
+	assert.Contains(t, w.Body.String(), `"target":"api"`)
+	data, _ := os.ReadFile(path.Join(webDir, "index.html"))
+	assert.Equal(t, "hotfix", string(data))
+	tag, _ := file_utils.GetActiveRelease(tempDir, "cemreyavuz", "deploy-to-vm")
+	assert.Equal(t, "dev.0", tag)
 }
@@ -0,0 +1,327 @@
+package signature
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"deploy-to-vm/internal/checksum"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"golang.org/x/crypto/blake2b"
+)
+
+const (
+	// VerificationMode_Optional verifies the signatures that are present and
+	// lets unsigned assets pass. This is the default mode.
+	VerificationMode_Optional = "optional"
+	// VerificationMode_Required rejects any asset that is not signed.
+	VerificationMode_Required = "required"
+)
+
+// Options configures how the signatures of release assets are verified.
+type Options struct {
+	// Mode is either "optional" (default) or "required"
+	Mode string
+	// KeyringFile is the path of an OpenPGP keyring (armored or binary) used
+	// to verify ".asc" signatures
+	KeyringFile string
+	// PublicKeys are minisign public keys or base64 encoded raw ed25519 public
+	// keys used to verify ".sig" signatures
+	PublicKeys []string
+}
+
+// VerifyResult describes which assets were signed.
+type VerifyResult struct {
+	Signed   []string
+	Unsigned []string
+}
+
+// minisignPublicKey is an ed25519 public key with an optional minisign key id.
+// Raw ed25519 keys have no key id.
+type minisignPublicKey struct {
+	keyId     []byte
+	publicKey ed25519.PublicKey
+}
+
+// Checks if the given file name is a detached signature
+func IsSignatureFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".asc" || ext == ".sig" || ext == ".minisig"
+}
+
+// Parses configured public keys. Each key is either the base64 line of a
+// minisign public key (optionally with its "untrusted comment" line) or a
+// base64 encoded raw ed25519 public key.
+func parsePublicKeys(keys []string) ([]minisignPublicKey, error) {
+	publicKeys := make([]minisignPublicKey, 0, len(keys))
+	for _, key := range keys {
+		lines := strings.Split(strings.TrimSpace(key), "\n")
+		encoded := strings.TrimSpace(lines[len(lines)-1])
+
+		decoded, decodeErr := base64.StdEncoding.DecodeString(encoded)
+		if decodeErr != nil {
+			return nil, fmt.Errorf("Invalid public key %q: %v", encoded, decodeErr)
+		}
+
+		switch len(decoded) {
+		case ed25519.PublicKeySize:
+			publicKeys = append(publicKeys, minisignPublicKey{publicKey: decoded})
+		case 2 + 8 + ed25519.PublicKeySize:
+			if string(decoded[:2]) != "Ed" {
+				return nil, fmt.Errorf("Unsupported minisign public key algorithm: %q", decoded[:2])
+			}
+			publicKeys = append(publicKeys, minisignPublicKey{keyId: decoded[2:10], publicKey: decoded[10:]})
+		default:
+			return nil, fmt.Errorf("Invalid public key length for %q: %d", encoded, len(decoded))
+		}
+	}
+
+	return publicKeys, nil
+}
+
+// Verifies a ".sig" signature, which is either a minisign signature file or a
+// raw (binary or base64 encoded) ed25519 signature.
+func verifyEd25519Signature(publicKeys []minisignPublicKey, content []byte, signature []byte) error {
+	if len(publicKeys) == 0 {
+		return errors.New("No public keys configured")
+	}
+
+	// Raw ed25519 signature
+	if !bytes.HasPrefix(signature, []byte("untrusted comment:")) {
+		raw := signature
+		if len(raw) != ed25519.SignatureSize {
+			decoded, decodeErr := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+			if decodeErr != nil || len(decoded) != ed25519.SignatureSize {
+				return errors.New("Invalid ed25519 signature")
+			}
+			raw = decoded
+		}
+
+		for _, key := range publicKeys {
+			if ed25519.Verify(key.publicKey, content, raw) {
+				return nil
+			}
+		}
+		return errors.New("Signature does not match any configured public key")
+	}
+
+	// Minisign signature: untrusted comment, signature, trusted comment and
+	// global signature, one per line
+	lines := make([]string, 0, 4)
+	scanner := bufio.NewScanner(bytes.NewReader(signature))
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimRight(scanner.Text(), "\r"))
+	}
+	if len(lines) < 4 || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		return errors.New("Invalid minisign signature file")
+	}
+
+	decoded, decodeErr := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if decodeErr != nil || len(decoded) != 2+8+ed25519.SignatureSize {
+		return errors.New("Invalid minisign signature")
+	}
+	algorithm, keyId, sig := string(decoded[:2]), decoded[2:10], decoded[10:]
+
+	globalSig, decodeGlobalErr := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if decodeGlobalErr != nil || len(globalSig) != ed25519.SignatureSize {
+		return errors.New("Invalid minisign global signature")
+	}
+	trustedComment := strings.TrimPrefix(lines[2], "trusted comment: ")
+
+	message := content
+	switch algorithm {
+	case "Ed":
+	case "ED":
+		prehashed := blake2b.Sum512(content)
+		message = prehashed[:]
+	default:
+		return fmt.Errorf("Unsupported minisign signature algorithm: %q", algorithm)
+	}
+
+	for _, key := range publicKeys {
+		if key.keyId != nil && !bytes.Equal(key.keyId, keyId) {
+			continue
+		}
+		if !ed25519.Verify(key.publicKey, message, sig) {
+			continue
+		}
+		if !ed25519.Verify(key.publicKey, append(append([]byte{}, sig...), trustedComment...), globalSig) {
+			return errors.New("Invalid minisign trusted comment signature")
+		}
+		return nil
+	}
+
+	return errors.New("Signature does not match any configured public key")
+}
+
+// Reads an OpenPGP keyring which can be either armored or binary
+func readKeyring(keyringFile string) (openpgp.EntityList, error) {
+	content, readErr := os.ReadFile(keyringFile)
+	if readErr != nil {
+		return nil, fmt.Errorf("Error while reading keyring file: %v", readErr)
+	}
+
+	keyring, armoredErr := openpgp.ReadArmoredKeyRing(bytes.NewReader(content))
+	if armoredErr == nil {
+		return keyring, nil
+	}
+
+	keyring, binaryErr := openpgp.ReadKeyRing(bytes.NewReader(content))
+	if binaryErr != nil {
+		return nil, fmt.Errorf("Error while parsing keyring file: %v", binaryErr)
+	}
+	return keyring, nil
+}
+
+// Verifies an OpenPGP detached signature, which can be either armored or
+// binary
+func verifyOpenPGPSignature(keyring openpgp.EntityList, content []byte, signature []byte) error {
+	if keyring == nil {
+		return errors.New("No OpenPGP keyring configured")
+	}
+
+	var checkErr error
+	if bytes.Contains(signature, []byte("-----BEGIN PGP SIGNATURE-----")) {
+		_, checkErr = openpgp.CheckArmoredDetachedSignature(keyring, bytes.NewReader(content), bytes.NewReader(signature), nil)
+	} else {
+		_, checkErr = openpgp.CheckDetachedSignature(keyring, bytes.NewReader(content), bytes.NewReader(signature), nil)
+	}
+	if checkErr == io.EOF {
+		return errors.New("No signature found")
+	}
+	return checkErr
+}
+
+// Verifies the detached signatures of the assets downloaded into the release
+// directory. An asset "<name>" is signed by "<name>.asc" (OpenPGP) or
+// "<name>.sig" / "<name>.minisig" (minisign or raw ed25519). An asset listed in
+// a signed checksums file counts as signed, as long as the checksums are
+// verified afterwards. In optional mode, signature files without a configured
+// key of their kind are skipped. Signature files are removed after a
+// successful verification so they never reach the site directory.
+func VerifyReleaseDir(releaseDir string, options Options) (*VerifyResult, error) {
+	mode := options.Mode
+	if mode == "" {
+		mode = VerificationMode_Optional
+	}
+	if mode != VerificationMode_Optional && mode != VerificationMode_Required {
+		return nil, fmt.Errorf("Unknown signature verification mode: %q", mode)
+	}
+
+	var keyring openpgp.EntityList
+	if options.KeyringFile != "" {
+		var keyringErr error
+		keyring, keyringErr = readKeyring(options.KeyringFile)
+		if keyringErr != nil {
+			return nil, keyringErr
+		}
+	}
+
+	publicKeys, publicKeysErr := parsePublicKeys(options.PublicKeys)
+	if publicKeysErr != nil {
+		return nil, publicKeysErr
+	}
+
+	entries, readErr := os.ReadDir(releaseDir)
+	if readErr != nil {
+		return nil, fmt.Errorf("Error while reading the release directory: %v", readErr)
+	}
+
+	signatureFiles := make([]string, 0)
+	signedByChecksums := make(map[string]bool)
+	result := &VerifyResult{
+		Signed:   make([]string, 0),
+		Unsigned: make([]string, 0),
+	}
+
+	// Verify the signature files that are present
+	signed := make(map[string]bool)
+	for _, entry := range entries {
+		if entry.IsDir() || !IsSignatureFile(entry.Name()) {
+			continue
+		}
+
+		signatureFilePath := filepath.Join(releaseDir, entry.Name())
+		signatureFiles = append(signatureFiles, signatureFilePath)
+
+		openPGP := strings.ToLower(filepath.Ext(entry.Name())) == ".asc"
+		if mode == VerificationMode_Optional && ((openPGP && len(keyring) == 0) || (!openPGP && len(publicKeys) == 0)) {
+			log.Printf("No key configured for signature file, skipping verification: %s", entry.Name())
+			continue
+		}
+
+		assetName := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		content, readAssetErr := os.ReadFile(filepath.Join(releaseDir, assetName))
+		if readAssetErr != nil {
+			if errors.Is(readAssetErr, os.ErrNotExist) {
+				log.Printf("Signature file has no matching asset, ignoring: %s", entry.Name())
+				continue
+			}
+			return nil, fmt.Errorf("Error while reading signed asset %q: %v", assetName, readAssetErr)
+		}
+
+		signatureContent, readSignatureErr := os.ReadFile(signatureFilePath)
+		if readSignatureErr != nil {
+			return nil, fmt.Errorf("Error while reading signature file %q: %v", entry.Name(), readSignatureErr)
+		}
+
+		var verifyErr error
+		if openPGP {
+			verifyErr = verifyOpenPGPSignature(keyring, content, signatureContent)
+		} else {
+			verifyErr = verifyEd25519Signature(publicKeys, content, signatureContent)
+		}
+		if verifyErr != nil {
+			return nil, fmt.Errorf("Invalid signature for asset %q: %v", assetName, verifyErr)
+		}
+
+		log.Printf("Signature verified for asset: %s", assetName)
+		signed[assetName] = true
+
+		// Assets listed in a signed checksums file are covered by its signature
+		if checksum.IsChecksumsFile(assetName) {
+			checksums, parseErr := checksum.ParseChecksums(content)
+			if parseErr != nil {
+				return nil, fmt.Errorf("Error while parsing checksums file %q: %v", assetName, parseErr)
+			}
+			for name := range checksums {
+				signedByChecksums[name] = true
+			}
+		}
+	}
+
+	// Check that every asset is signed
+	for _, entry := range entries {
+		if entry.IsDir() || IsSignatureFile(entry.Name()) {
+			continue
+		}
+
+		if signed[entry.Name()] || signedByChecksums[entry.Name()] {
+			result.Signed = append(result.Signed, entry.Name())
+			continue
+		}
+
+		if mode == VerificationMode_Required {
+			return nil, fmt.Errorf("No signature found for asset %q", entry.Name())
+		}
+		result.Unsigned = append(result.Unsigned, entry.Name())
+	}
+
+	// Signature files are not part of the site
+	for _, signatureFilePath := range signatureFiles {
+		if removeErr := os.Remove(signatureFilePath); removeErr != nil && !errors.Is(removeErr, os.ErrNotExist) {
+			return nil, fmt.Errorf("Error while removing signature file: %v", removeErr)
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,195 @@
+package signature
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Helper to create a minisign key pair and return the public key line
+func createMinisignKey(t *testing.T) (string, ed25519.PrivateKey, []byte) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	keyId := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	encoded := base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), keyId...), publicKey...))
+	return "untrusted comment: minisign public key\n" + encoded, privateKey, keyId
+}
+
+// Helper to create a prehashed minisign signature file for the content
+func createMinisignSignature(privateKey ed25519.PrivateKey, keyId []byte, content []byte) []byte {
+	prehashed := blake2b.Sum512(content)
+	sig := ed25519.Sign(privateKey, prehashed[:])
+	trustedComment := "timestamp:1700000000"
+	globalSig := ed25519.Sign(privateKey, append(append([]byte{}, sig...), trustedComment...))
+
+	return []byte("untrusted comment: signature from minisign secret key\n" +
+		base64.StdEncoding.EncodeToString(append(append([]byte("ED"), keyId...), sig...)) + "\n" +
+		"trusted comment: " + trustedComment + "\n" +
+		base64.StdEncoding.EncodeToString(globalSig) + "\n")
+}
+
+func TestVerifyReleaseDir_Minisign_Success(t *testing.T) {
+	// Arrange: create a signed asset
+	releaseDir := t.TempDir()
+	publicKey, privateKey, keyId := createMinisignKey(t)
+	content := []byte("asset content")
+	os.WriteFile(path.Join(releaseDir, "app.tar.gz"), content, 0644)
+	os.WriteFile(path.Join(releaseDir, "app.tar.gz.sig"), createMinisignSignature(privateKey, keyId, content), 0644)
+
+	// Act: verify the release directory
+	result, err := VerifyReleaseDir(releaseDir, Options{Mode: VerificationMode_Required, PublicKeys: []string{publicKey}})
+
+	// Assert: check if the asset is signed and the signature file is removed
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"app.tar.gz"}, result.Signed)
+	_, statErr := os.Stat(path.Join(releaseDir, "app.tar.gz.sig"))
+	assert.True(t, os.IsNotExist(statErr), "Expected signature file to be removed")
+}
+
+func TestVerifyReleaseDir_Minisign_BadSignature(t *testing.T) {
+	// Arrange: create an asset that was modified after signing
+	releaseDir := t.TempDir()
+	publicKey, privateKey, keyId := createMinisignKey(t)
+	os.WriteFile(path.Join(releaseDir, "app.tar.gz"), []byte("tampered content"), 0644)
+	os.WriteFile(path.Join(releaseDir, "app.tar.gz.sig"), createMinisignSignature(privateKey, keyId, []byte("asset content")), 0644)
+
+	// Act: verify the release directory
+	_, err := VerifyReleaseDir(releaseDir, Options{PublicKeys: []string{publicKey}})
+
+	// Assert: check if the asset is rejected even in optional mode
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Invalid signature for asset \"app.tar.gz\"")
+}
+
+func TestVerifyReleaseDir_RawEd25519_Success(t *testing.T) {
+	// Arrange: create an asset signed with a raw ed25519 key
+	releaseDir := t.TempDir()
+	publicKey, privateKey, _ := ed25519.GenerateKey(rand.Reader)
+	content := []byte("asset content")
+	os.WriteFile(path.Join(releaseDir, "app.tar.gz"), content, 0644)
+	os.WriteFile(path.Join(releaseDir, "app.tar.gz.sig"), ed25519.Sign(privateKey, content), 0644)
+
+	// Act: verify the release directory
+	result, err := VerifyReleaseDir(releaseDir, Options{
+		Mode:       VerificationMode_Required,
+		PublicKeys: []string{base64.StdEncoding.EncodeToString(publicKey)},
+	})
+
+	// Assert: check if the asset is signed
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"app.tar.gz"}, result.Signed)
+}
+
+func TestVerifyReleaseDir_OpenPGP_Success(t *testing.T) {
+	// Arrange: create an OpenPGP key and store its public keyring
+	releaseDir := t.TempDir()
+	entity, err := openpgp.NewEntity("deploy-to-vm", "test", "ci@example.com", nil)
+	assert.NoError(t, err)
+	keyringFile := path.Join(t.TempDir(), "keyring.gpg")
+	keyringBuffer := &bytes.Buffer{}
+	assert.NoError(t, entity.Serialize(keyringBuffer))
+	os.WriteFile(keyringFile, keyringBuffer.Bytes(), 0644)
+
+	// Arrange: create an asset with an armored detached signature
+	content := []byte("asset content")
+	signatureBuffer := &bytes.Buffer{}
+	assert.NoError(t, openpgp.ArmoredDetachSign(signatureBuffer, entity, bytes.NewReader(content), nil))
+	os.WriteFile(path.Join(releaseDir, "app.tar.gz"), content, 0644)
+	os.WriteFile(path.Join(releaseDir, "app.tar.gz.asc"), signatureBuffer.Bytes(), 0644)
+
+	// Act: verify the release directory
+	result, verifyErr := VerifyReleaseDir(releaseDir, Options{Mode: VerificationMode_Required, KeyringFile: keyringFile})
+
+	// Assert: check if the asset is signed
+	assert.NoError(t, verifyErr)
+	assert.Equal(t, []string{"app.tar.gz"}, result.Signed)
+}
+
+func TestVerifyReleaseDir_SignedChecksumsFile_Success(t *testing.T) {
+	// Arrange: create an asset listed in a signed checksums file
+	releaseDir := t.TempDir()
+	publicKey, privateKey, keyId := createMinisignKey(t)
+	content := []byte("asset content")
+	digest := sha256.Sum256(content)
+	checksums := []byte(hex.EncodeToString(digest[:]) + "  app.tar.gz\n")
+	os.WriteFile(path.Join(releaseDir, "app.tar.gz"), content, 0644)
+	os.WriteFile(path.Join(releaseDir, "checksums.txt"), checksums, 0644)
+	os.WriteFile(path.Join(releaseDir, "checksums.txt.sig"), createMinisignSignature(privateKey, keyId, checksums), 0644)
+
+	// Act: verify the release directory
+	result, err := VerifyReleaseDir(releaseDir, Options{Mode: VerificationMode_Required, PublicKeys: []string{publicKey}})
+
+	// Assert: check if both the asset and the checksums file count as signed
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"app.tar.gz", "checksums.txt"}, result.Signed)
+}
+
+func TestVerifyReleaseDir_Required_Unsigned(t *testing.T) {
+	// Arrange: create an unsigned asset
+	releaseDir := t.TempDir()
+	os.WriteFile(path.Join(releaseDir, "app.tar.gz"), []byte("asset content"), 0644)
+
+	// Act: verify the release directory in required mode
+	_, err := VerifyReleaseDir(releaseDir, Options{Mode: VerificationMode_Required})
+
+	// Assert: check if the error is returned
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "No signature found for asset \"app.tar.gz\"")
+}
+
+func TestVerifyReleaseDir_Optional_Unsigned(t *testing.T) {
+	// Arrange: create an unsigned asset
+	releaseDir := t.TempDir()
+	os.WriteFile(path.Join(releaseDir, "app.tar.gz"), []byte("asset content"), 0644)
+
+	// Act: verify the release directory in optional mode
+	result, err := VerifyReleaseDir(releaseDir, Options{})
+
+	// Assert: check if the asset is reported as unsigned
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"app.tar.gz"}, result.Unsigned)
+}
+
+func TestVerifyReleaseDir_Optional_NoKeys(t *testing.T) {
+	// Arrange: create an asset with signature files but configure no keys
+	releaseDir := t.TempDir()
+	os.WriteFile(path.Join(releaseDir, "app.tar.gz"), []byte("asset content"), 0644)
+	os.WriteFile(path.Join(releaseDir, "app.tar.gz.asc"), []byte("-----BEGIN PGP SIGNATURE-----"), 0644)
+	os.WriteFile(path.Join(releaseDir, "app.tar.gz.minisig"), []byte("untrusted comment: signature"), 0644)
+
+	// Act: verify the release directory in optional mode
+	result, err := VerifyReleaseDir(releaseDir, Options{})
+
+	// Assert: check if the signatures are skipped and the signature files
+	// are removed
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"app.tar.gz"}, result.Unsigned)
+	_, ascStatErr := os.Stat(path.Join(releaseDir, "app.tar.gz.asc"))
+	_, minisigStatErr := os.Stat(path.Join(releaseDir, "app.tar.gz.minisig"))
+	assert.True(t, os.IsNotExist(ascStatErr), "Expected signature file to be removed")
+	assert.True(t, os.IsNotExist(minisigStatErr), "Expected signature file to be removed")
+}
+
+func TestVerifyReleaseDir_Required_NoKeys(t *testing.T) {
+	// Arrange: create an asset with a signature file but configure no keys
+	releaseDir := t.TempDir()
+	os.WriteFile(path.Join(releaseDir, "app.tar.gz"), []byte("asset content"), 0644)
+	os.WriteFile(path.Join(releaseDir, "app.tar.gz.sig"), []byte("signature"), 0644)
+
+	// Act: verify the release directory in required mode
+	_, err := VerifyReleaseDir(releaseDir, Options{Mode: VerificationMode_Required})
+
+	// Assert: check if the signature is rejected
+	assert.Error(t, err)
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package store
+
+import (
+	"os"
+)
+
+// File owners are only known on unix
+func fileOwner(info os.FileInfo) (int, int) {
+	return -1, -1
+}
+
+// Hard links can only be counted on unix, so blobs are never removed
+func hasOtherLinks(info os.FileInfo) bool {
+	return true
+}
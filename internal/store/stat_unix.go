@@ -0,0 +1,24 @@
+//go:build unix
+
+package store
+
+import (
+	"os"
+	"syscall"
+)
+
+// Returns the owner and group of a file, or -1 if they are unknown
+func fileOwner(info os.FileInfo) (int, int) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return -1, -1
+	}
+	return int(stat.Uid), int(stat.Gid)
+}
+
+// Returns whether a file has other hard links than the given one. Files whose
+// links can't be counted are treated as linked.
+func hasOtherLinks(info os.FileInfo) bool {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return !ok || stat.Nlink > 1
+}
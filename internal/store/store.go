@@ -0,0 +1,151 @@
+package store
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"deploy-to-vm/internal/checksum"
+)
+
+// DirName is the name of the content-addressed store inside the assets
+// directory. GitHub owners can't start with a dot, so it never collides with a
+// release directory.
+const DirName = ".store"
+
+// Store is a content-addressed store for release files. Blobs are keyed by
+// the SHA-256 of their content and the metadata a hard link shares, i.e. the
+// permission bits, owner, group and mtime. Release directories are made of
+// hard links into the store, so identical files of different releases share
+// the same data on disk without changing the ownership or mtime of each
+// other.
+type Store struct {
+	Dir string
+}
+
+// DeduplicateResult describes the outcome of deduplicating a release
+// directory.
+type DeduplicateResult struct {
+	// Stored is the number of files whose content was added to the store
+	Stored int
+	// Deduplicated is the number of files replaced by an existing blob
+	Deduplicated int
+	// SavedBytes is the disk space saved by the deduplicated files
+	SavedBytes int64
+}
+
+// GCResult describes the blobs removed by a garbage collection.
+type GCResult struct {
+	RemovedBlobs int
+	FreedBytes   int64
+}
+
+func NewStore(assetsDir string) *Store {
+	return &Store{Dir: filepath.Join(assetsDir, DirName)}
+}
+
+// Returns the path of the blob for the given hash and file metadata
+func (s *Store) blobPath(hash string, info os.FileInfo) string {
+	uid, gid := fileOwner(info)
+	return filepath.Join(s.Dir, hash[:2], fmt.Sprintf("%s-%04o-%d-%d-%d", hash, info.Mode().Perm(), uid, gid, info.ModTime().UnixNano()))
+}
+
+// Replaces every regular file in the release directory with a hard link into
+// the store. Files whose content and metadata are not in the store yet are
+// added to it.
+func (s *Store) DeduplicateDir(releaseDir string) (*DeduplicateResult, error) {
+	result := &DeduplicateResult{}
+
+	walkErr := filepath.Walk(releaseDir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		hash, hashErr := checksum.FileSHA256(file)
+		if hashErr != nil {
+			return fmt.Errorf("Error while hashing %q: %v", file, hashErr)
+		}
+		blob := s.blobPath(hash, info)
+
+		blobInfo, statErr := os.Stat(blob)
+		if os.IsNotExist(statErr) {
+			if err := os.MkdirAll(filepath.Dir(blob), 0755); err != nil {
+				return fmt.Errorf("Error while creating the store directory: %v", err)
+			}
+			if err := os.Link(file, blob); err != nil {
+				return fmt.Errorf("Error while adding %q to the store: %v", file, err)
+			}
+			result.Stored++
+			return nil
+		}
+		if statErr != nil {
+			return fmt.Errorf("Error while reading blob: %v", statErr)
+		}
+		if os.SameFile(info, blobInfo) {
+			return nil
+		}
+
+		// Replace the file with a link to the blob atomically
+		tempFile := file + ".deploy-to-vm-tmp"
+		os.Remove(tempFile)
+		if err := os.Link(blob, tempFile); err != nil {
+			return fmt.Errorf("Error while linking blob for %q: %v", file, err)
+		}
+		if err := os.Rename(tempFile, file); err != nil {
+			os.Remove(tempFile)
+			return fmt.Errorf("Error while replacing %q with blob: %v", file, err)
+		}
+		result.Deduplicated++
+		result.SavedBytes += info.Size()
+		return nil
+	})
+	if walkErr != nil {
+		return nil, walkErr
+	}
+
+	log.Printf("Deduplicated release directory %q: %d files stored, %d files deduplicated, %d bytes saved", releaseDir, result.Stored, result.Deduplicated, result.SavedBytes)
+	return result, nil
+}
+
+// Removes the blobs that are no longer referenced by any release directory,
+// i.e. blobs whose only link is the one in the store
+func (s *Store) GC() (*GCResult, error) {
+	result := &GCResult{}
+
+	walkErr := filepath.Walk(s.Dir, func(blob string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && blob == s.Dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if strings.HasSuffix(blob, ".deploy-to-vm-tmp") {
+			return nil
+		}
+
+		if hasOtherLinks(info) {
+			return nil
+		}
+
+		if removeErr := os.Remove(blob); removeErr != nil {
+			return fmt.Errorf("Error while removing blob: %v", removeErr)
+		}
+		result.RemovedBlobs++
+		result.FreedBytes += info.Size()
+		return nil
+	})
+	if walkErr != nil {
+		return nil, walkErr
+	}
+
+	log.Printf("Store garbage collection removed %d blobs, freed %d bytes", result.RemovedBlobs, result.FreedBytes)
+	return result, nil
+}
@@ -0,0 +1,169 @@
+package store
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mtime is the modification time of the files that are extracted from the
+// same archive entry in different releases
+var mtime = time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func TestDeduplicateDir_SharesIdenticalFiles(t *testing.T) {
+	assetsDir := t.TempDir()
+	s := NewStore(assetsDir)
+
+	// Arrange: create two releases with one shared and one changed file
+	firstReleaseDir := path.Join(assetsDir, "owner", "repo", "v1")
+	secondReleaseDir := path.Join(assetsDir, "owner", "repo", "v2")
+	os.MkdirAll(firstReleaseDir, 0755)
+	os.MkdirAll(secondReleaseDir, 0755)
+	os.WriteFile(path.Join(firstReleaseDir, "vendor.js"), []byte("vendor"), 0644)
+	os.WriteFile(path.Join(firstReleaseDir, "app.js"), []byte("app v1"), 0644)
+	os.WriteFile(path.Join(secondReleaseDir, "vendor.js"), []byte("vendor"), 0644)
+	os.WriteFile(path.Join(secondReleaseDir, "app.js"), []byte("app v2"), 0644)
+	os.Chtimes(path.Join(firstReleaseDir, "vendor.js"), mtime, mtime)
+	os.Chtimes(path.Join(secondReleaseDir, "vendor.js"), mtime, mtime)
+
+	// Act: deduplicate both releases
+	firstResult, firstErr := s.DeduplicateDir(firstReleaseDir)
+	secondResult, secondErr := s.DeduplicateDir(secondReleaseDir)
+
+	// Assert: check if the shared file is stored once
+	assert.NoError(t, firstErr)
+	assert.NoError(t, secondErr)
+	assert.Equal(t, 2, firstResult.Stored)
+	assert.Equal(t, 1, secondResult.Stored)
+	assert.Equal(t, 1, secondResult.Deduplicated)
+	assert.Equal(t, int64(len("vendor")), secondResult.SavedBytes)
+
+	firstInfo, _ := os.Stat(path.Join(firstReleaseDir, "vendor.js"))
+	secondInfo, _ := os.Stat(path.Join(secondReleaseDir, "vendor.js"))
+	assert.True(t, os.SameFile(firstInfo, secondInfo), "Expected identical files to share a blob")
+}
+
+func TestDeduplicateDir_KeepsModesApart(t *testing.T) {
+	assetsDir := t.TempDir()
+	s := NewStore(assetsDir)
+
+	// Arrange: create two files with the same content and different modes
+	releaseDir := path.Join(assetsDir, "owner", "repo", "v1")
+	os.MkdirAll(releaseDir, 0755)
+	os.WriteFile(path.Join(releaseDir, "run.sh"), []byte("echo"), 0755)
+	os.WriteFile(path.Join(releaseDir, "run.txt"), []byte("echo"), 0644)
+	os.Chmod(path.Join(releaseDir, "run.sh"), 0755)
+
+	// Act: deduplicate the release
+	result, err := s.DeduplicateDir(releaseDir)
+
+	// Assert: check if both files keep their modes
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Stored)
+	scriptInfo, _ := os.Stat(path.Join(releaseDir, "run.sh"))
+	assert.Equal(t, os.FileMode(0755), scriptInfo.Mode().Perm())
+}
+
+func TestDeduplicateDir_KeepsMtimesApart(t *testing.T) {
+	assetsDir := t.TempDir()
+	s := NewStore(assetsDir)
+
+	// Arrange: create two releases with the same content and different mtimes
+	firstReleaseDir := path.Join(assetsDir, "owner", "repo", "v1")
+	secondReleaseDir := path.Join(assetsDir, "owner", "repo", "v2")
+	os.MkdirAll(firstReleaseDir, 0755)
+	os.MkdirAll(secondReleaseDir, 0755)
+	os.WriteFile(path.Join(firstReleaseDir, "app.js"), []byte("app"), 0644)
+	os.WriteFile(path.Join(secondReleaseDir, "app.js"), []byte("app"), 0644)
+	os.Chtimes(path.Join(firstReleaseDir, "app.js"), mtime, mtime)
+	os.Chtimes(path.Join(secondReleaseDir, "app.js"), mtime.Add(time.Hour), mtime.Add(time.Hour))
+
+	// Act: deduplicate both releases
+	s.DeduplicateDir(firstReleaseDir)
+	result, err := s.DeduplicateDir(secondReleaseDir)
+
+	// Assert: check if both files keep their mtimes
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Stored)
+	firstInfo, _ := os.Stat(path.Join(firstReleaseDir, "app.js"))
+	secondInfo, _ := os.Stat(path.Join(secondReleaseDir, "app.js"))
+	assert.True(t, firstInfo.ModTime().Equal(mtime))
+	assert.True(t, secondInfo.ModTime().Equal(mtime.Add(time.Hour)))
+}
+
+func TestDeduplicateDir_KeepsOwnersApart(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Changing the owner of a file requires root")
+	}
+	assetsDir := t.TempDir()
+	s := NewStore(assetsDir)
+
+	// Arrange: create releases of two repositories whose files are owned by
+	// different users
+	firstReleaseDir := path.Join(assetsDir, "owner", "api", "v1")
+	secondReleaseDir := path.Join(assetsDir, "owner", "web", "v1")
+	os.MkdirAll(firstReleaseDir, 0755)
+	os.MkdirAll(secondReleaseDir, 0755)
+	os.WriteFile(path.Join(firstReleaseDir, "vendor.js"), []byte("vendor"), 0644)
+	os.WriteFile(path.Join(secondReleaseDir, "vendor.js"), []byte("vendor"), 0644)
+	os.Chtimes(path.Join(firstReleaseDir, "vendor.js"), mtime, mtime)
+	os.Chtimes(path.Join(secondReleaseDir, "vendor.js"), mtime, mtime)
+	os.Lchown(path.Join(firstReleaseDir, "vendor.js"), 1001, 1001)
+	os.Lchown(path.Join(secondReleaseDir, "vendor.js"), 1002, 1002)
+
+	// Act: deduplicate both releases
+	s.DeduplicateDir(firstReleaseDir)
+	result, err := s.DeduplicateDir(secondReleaseDir)
+
+	// Assert: check if each repository keeps its owner
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Stored)
+	firstInfo, _ := os.Stat(path.Join(firstReleaseDir, "vendor.js"))
+	secondInfo, _ := os.Stat(path.Join(secondReleaseDir, "vendor.js"))
+	firstUid, _ := fileOwner(firstInfo)
+	secondUid, _ := fileOwner(secondInfo)
+	assert.Equal(t, 1001, firstUid)
+	assert.Equal(t, 1002, secondUid)
+}
+
+func TestGC_RemovesUnreferencedBlobs(t *testing.T) {
+	assetsDir := t.TempDir()
+	s := NewStore(assetsDir)
+
+	// Arrange: store two releases and remove the first one
+	firstReleaseDir := path.Join(assetsDir, "owner", "repo", "v1")
+	secondReleaseDir := path.Join(assetsDir, "owner", "repo", "v2")
+	os.MkdirAll(firstReleaseDir, 0755)
+	os.MkdirAll(secondReleaseDir, 0755)
+	os.WriteFile(path.Join(firstReleaseDir, "vendor.js"), []byte("vendor"), 0644)
+	os.WriteFile(path.Join(firstReleaseDir, "app.js"), []byte("app v1"), 0644)
+	os.WriteFile(path.Join(secondReleaseDir, "vendor.js"), []byte("vendor"), 0644)
+	os.Chtimes(path.Join(firstReleaseDir, "vendor.js"), mtime, mtime)
+	os.Chtimes(path.Join(secondReleaseDir, "vendor.js"), mtime, mtime)
+	s.DeduplicateDir(firstReleaseDir)
+	s.DeduplicateDir(secondReleaseDir)
+	os.RemoveAll(firstReleaseDir)
+
+	// Act: garbage collect the store
+	result, err := s.GC()
+
+	// Assert: check if only the blob of the removed release is deleted
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.RemovedBlobs)
+	assert.Equal(t, int64(len("app v1")), result.FreedBytes)
+	data, readErr := os.ReadFile(path.Join(secondReleaseDir, "vendor.js"))
+	assert.NoError(t, readErr)
+	assert.Equal(t, []byte("vendor"), data)
+}
+
+func TestGC_MissingStore(t *testing.T) {
+	// Act: garbage collect a store that was never created
+	result, err := NewStore(t.TempDir()).GC()
+
+	// Assert: check if nothing is removed
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.RemovedBlobs)
+}
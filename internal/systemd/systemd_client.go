@@ -0,0 +1,142 @@
+package systemd
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	deploy_to_vm_exec "deploy-to-vm/internal/exec"
+)
+
+const (
+	Action_Reload             = "reload"
+	Action_Restart            = "restart"
+	Action_TryReloadOrRestart = "try-reload-or-restart"
+)
+
+// DefaultPollInterval is how often the state of a unit is checked while
+// waiting for it to become active
+const DefaultPollInterval = 500 * time.Millisecond
+
+// DefaultCommandTimeout is how long a systemctl command that does not wait for
+// a unit may run before it is killed
+const DefaultCommandTimeout = 30 * time.Second
+
+// SystemdClient is a struct that represents a client for reloading and
+// restarting systemd units in the VM.
+type SystemdClient struct {
+	ExecClient   deploy_to_vm_exec.ExecClientInterface
+	PollInterval time.Duration
+}
+
+// SystemdClientInterface is an interface that defines the methods for the
+// SystemdClient struct, so it can be mocked in unit tests.
+type SystemdClientInterface interface {
+	Reload(unit string, action string, timeout time.Duration) error
+	Stop(unit string) error
+	WithLogSink(sink deploy_to_vm_exec.LogSink) SystemdClientInterface
+}
+
+// Returns the action to run on a unit, defaulting to Action_Restart
+func GetAction(action string) (string, error) {
+	switch action {
+	case "":
+		return Action_Restart, nil
+	case Action_Reload, Action_Restart, Action_TryReloadOrRestart:
+		return action, nil
+	default:
+		return "", fmt.Errorf("Unknown systemd action: %q", action)
+	}
+}
+
+// Returns the output of "systemctl status" for a unit, to explain why it is
+// not active
+func (c *SystemdClient) status(unit string) string {
+	// "systemctl status" exits with a non-zero code for units that are not
+	// running, so only the output is of interest
+	out, _ := c.ExecClient.Command("systemctl", "status", "--no-pager", "--lines=20", unit).WithTimeout(DefaultCommandTimeout).CombinedOutput()
+	return strings.TrimSpace(string(out))
+}
+
+// Waits until a unit is active. It gives up when the unit failed or the
+// timeout is reached.
+func (c *SystemdClient) waitForActive(unit string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		// "systemctl is-active" prints the state and exits with a non-zero
+		// code unless the unit is active. The polls are kept out of the log.
+		out, _ := c.ExecClient.Command("systemctl", "is-active", unit).WithTimeout(DefaultCommandTimeout).WithLogSink(nil).CombinedOutput()
+		state := strings.TrimSpace(string(out))
+		if state == "active" {
+			return nil
+		}
+		if state == "failed" || time.Now().After(deadline) {
+			return fmt.Errorf("Unit %s is not active (state: %s)", unit, state)
+		}
+		time.Sleep(c.PollInterval)
+	}
+}
+
+// Reloads or restarts a unit and waits until it is active again. On failure
+// the error includes the output of "systemctl status".
+func (c *SystemdClient) Reload(unit string, action string, timeout time.Duration) error {
+	if unit == "" || strings.HasPrefix(unit, "-") {
+		return fmt.Errorf("Invalid systemd unit: %q", unit)
+	}
+	action, actionErr := GetAction(action)
+	if actionErr != nil {
+		return actionErr
+	}
+
+	// systemctl waits for the job of the unit, so it gets the whole timeout
+	out, err := c.ExecClient.Command("systemctl", action, unit).WithTimeout(timeout).CombinedOutput()
+	if err != nil {
+		log.Printf("Error running %s on systemd unit \"%s\": %v", action, unit, string(out))
+		return fmt.Errorf("Error running %s on systemd unit %s: %v\n%s", action, unit, err, c.status(unit))
+	}
+
+	if waitErr := c.waitForActive(unit, timeout); waitErr != nil {
+		log.Printf("Error waiting for systemd unit \"%s\": %v", unit, waitErr)
+		return fmt.Errorf("%v\n%s", waitErr, c.status(unit))
+	}
+
+	log.Printf("Ran %s on systemd unit \"%s\"", action, unit)
+	return nil
+}
+
+// Stops a unit
+func (c *SystemdClient) Stop(unit string) error {
+	if unit == "" || strings.HasPrefix(unit, "-") {
+		return fmt.Errorf("Invalid systemd unit: %q", unit)
+	}
+
+	out, err := c.ExecClient.Command("systemctl", "stop", unit).WithTimeout(DefaultCommandTimeout).CombinedOutput()
+	if err != nil {
+		log.Printf("Error stopping systemd unit \"%s\": %v", unit, string(out))
+		return fmt.Errorf("Error stopping systemd unit %s: %v", unit, err)
+	}
+
+	log.Printf("Stopped systemd unit \"%s\"", unit)
+	return nil
+}
+
+// Returns a copy of the client that streams the output of its commands into
+// the log sink
+func (c *SystemdClient) WithLogSink(sink deploy_to_vm_exec.LogSink) SystemdClientInterface {
+	client := *c
+	client.ExecClient = deploy_to_vm_exec.WithLogSink(c.ExecClient, sink)
+	return &client
+}
+
+func NewSystemdClient(execClient deploy_to_vm_exec.ExecClientInterface) *SystemdClient {
+	// If execClient is nil, create a new ExecClient instance
+	if execClient == nil {
+		execClient = &deploy_to_vm_exec.ExecClient{}
+	}
+
+	return &SystemdClient{
+		ExecClient:   execClient,
+		PollInterval: DefaultPollInterval,
+	}
+}
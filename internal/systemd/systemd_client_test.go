@@ -0,0 +1,214 @@
+package systemd
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	deploy_to_vm_exec "deploy-to-vm/internal/exec"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type MockExecCommand struct {
+	CombinedOutputFunc func() ([]byte, error)
+}
+
+func (m *MockExecCommand) WithContext(ctx context.Context) deploy_to_vm_exec.ExecCommandInterface {
+	return m
+}
+
+func (m *MockExecCommand) WithTimeout(timeout time.Duration) deploy_to_vm_exec.ExecCommandInterface {
+	return m
+}
+
+func (m *MockExecCommand) WithDir(dir string) deploy_to_vm_exec.ExecCommandInterface {
+	return m
+}
+
+func (m *MockExecCommand) WithEnv(env ...string) deploy_to_vm_exec.ExecCommandInterface {
+	return m
+}
+
+func (m *MockExecCommand) WithLogSink(sink deploy_to_vm_exec.LogSink) deploy_to_vm_exec.ExecCommandInterface {
+	return m
+}
+
+func (m *MockExecCommand) WithCredential(credential *deploy_to_vm_exec.Credential) deploy_to_vm_exec.ExecCommandInterface {
+	return m
+}
+
+func (m *MockExecCommand) CombinedOutput() ([]byte, error) {
+	return m.CombinedOutputFunc()
+}
+
+func (m *MockExecCommand) Run() (*deploy_to_vm_exec.Result, error) {
+	output, err := m.CombinedOutputFunc()
+	return &deploy_to_vm_exec.Result{Stdout: output}, err
+}
+
+// MockExecClient answers systemctl commands by their subcommand and records
+// every command it runs
+type MockExecClient struct {
+	Commands []string
+	Outputs  map[string]func() ([]byte, error)
+}
+
+func (m *MockExecClient) Command(name string, arg ...string) deploy_to_vm_exec.ExecCommandInterface {
+	m.Commands = append(m.Commands, name+" "+strings.Join(arg, " "))
+	output, ok := m.Outputs[arg[0]]
+	if !ok {
+		output = func() ([]byte, error) { return nil, nil }
+	}
+	return &MockExecCommand{CombinedOutputFunc: output}
+}
+
+func setupSystemdTest(outputs map[string]func() ([]byte, error)) (*SystemdClient, *MockExecClient) {
+	mockExecClient := &MockExecClient{Outputs: outputs}
+	systemdClient := NewSystemdClient(mockExecClient)
+	systemdClient.PollInterval = time.Millisecond
+	return systemdClient, mockExecClient
+}
+
+func TestSystemdClient_Reload_Success(t *testing.T) {
+	// Arrange: create a unit that is activating once before it is active
+	states := []string{"activating", "active"}
+	systemdClient, mockExecClient := setupSystemdTest(map[string]func() ([]byte, error){
+		"is-active": func() ([]byte, error) {
+			state := states[0]
+			states = states[1:]
+			if state != "active" {
+				return []byte(state + "\n"), errors.New("exit status 3")
+			}
+			return []byte(state + "\n"), nil
+		},
+	})
+
+	// Act: reload the unit
+	err := systemdClient.Reload("api.service", Action_TryReloadOrRestart, time.Second)
+
+	// Assert: check if the unit is reloaded and waited for
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"systemctl try-reload-or-restart api.service",
+		"systemctl is-active api.service",
+		"systemctl is-active api.service",
+	}, mockExecClient.Commands)
+}
+
+func TestSystemdClient_Reload_DefaultAction(t *testing.T) {
+	// Arrange: create an active unit
+	systemdClient, mockExecClient := setupSystemdTest(map[string]func() ([]byte, error){
+		"is-active": func() ([]byte, error) { return []byte("active\n"), nil },
+	})
+
+	// Act: reload the unit without an action
+	err := systemdClient.Reload("api.service", "", time.Second)
+
+	// Assert: check if the unit is restarted
+	assert.NoError(t, err)
+	assert.Equal(t, "systemctl restart api.service", mockExecClient.Commands[0])
+}
+
+func TestSystemdClient_Reload_CommandError(t *testing.T) {
+	// Arrange: create a unit that fails to restart
+	systemdClient, _ := setupSystemdTest(map[string]func() ([]byte, error){
+		"restart": func() ([]byte, error) {
+			return []byte("Job for api.service failed."), errors.New("exit status 1")
+		},
+		"status": func() ([]byte, error) {
+			return []byte("Active: failed (Result: exit-code)"), errors.New("exit status 3")
+		},
+	})
+
+	// Act: restart the unit
+	err := systemdClient.Reload("api.service", Action_Restart, time.Second)
+
+	// Assert: check if the status is part of the error
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Active: failed (Result: exit-code)")
+}
+
+func TestSystemdClient_Reload_UnitFailed(t *testing.T) {
+	// Arrange: create a unit that fails after the restart
+	systemdClient, _ := setupSystemdTest(map[string]func() ([]byte, error){
+		"is-active": func() ([]byte, error) { return []byte("failed\n"), errors.New("exit status 3") },
+		"status":    func() ([]byte, error) { return []byte("panic: missing config"), errors.New("exit status 3") },
+	})
+
+	// Act: restart the unit
+	err := systemdClient.Reload("api.service", Action_Restart, time.Minute)
+
+	// Assert: check if the failure is reported without waiting for the timeout
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Unit api.service is not active (state: failed)")
+	assert.Contains(t, err.Error(), "panic: missing config")
+}
+
+func TestSystemdClient_Reload_Timeout(t *testing.T) {
+	// Arrange: create a unit that never becomes active
+	systemdClient, _ := setupSystemdTest(map[string]func() ([]byte, error){
+		"is-active": func() ([]byte, error) { return []byte("activating\n"), errors.New("exit status 3") },
+	})
+
+	// Act: restart the unit
+	err := systemdClient.Reload("api.service", Action_Restart, 10*time.Millisecond)
+
+	// Assert: check if the wait times out
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "(state: activating)")
+}
+
+func TestSystemdClient_Reload_CommandTimeout(t *testing.T) {
+	// Arrange: create a fake ExecClient where the unit is active
+	fakeExecClient := &deploy_to_vm_exec.FakeExecClient{
+		Handler: func(command *deploy_to_vm_exec.FakeCommand) deploy_to_vm_exec.FakeResult {
+			return deploy_to_vm_exec.FakeResult{Stdout: "active\n"}
+		},
+	}
+	systemdClient := NewSystemdClient(fakeExecClient)
+
+	// Act: restart the unit
+	err := systemdClient.Reload("api.service", Action_Restart, time.Minute)
+
+	// Assert: check if systemctl may wait for the unit as long as the timeout
+	assert.NoError(t, err)
+	commands := fakeExecClient.Commands()
+	assert.Equal(t, "systemctl restart api.service", commands[0].String())
+	assert.Equal(t, time.Minute, commands[0].Timeout)
+	assert.Equal(t, DefaultCommandTimeout, commands[1].Timeout)
+}
+
+func TestSystemdClient_Reload_InvalidParams(t *testing.T) {
+	systemdClient, mockExecClient := setupSystemdTest(nil)
+
+	assert.Error(t, systemdClient.Reload("", Action_Restart, time.Second))
+	assert.Error(t, systemdClient.Reload("--now", Action_Restart, time.Second))
+	assert.Error(t, systemdClient.Reload("api.service", "stop", time.Second))
+	assert.Empty(t, mockExecClient.Commands, "Expected no command to run")
+}
+
+func TestSystemdClient_Stop(t *testing.T) {
+	systemdClient, mockExecClient := setupSystemdTest(nil)
+
+	// Act: stop a unit
+	err := systemdClient.Stop("app@blue.service")
+
+	// Assert: check if the unit is stopped
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"systemctl stop app@blue.service"}, mockExecClient.Commands)
+
+	// Act/Assert: check if an option as unit fails
+	assert.Error(t, systemdClient.Stop("--all"))
+}
+
+func TestNewSystemdClient_EmptyExecClient(t *testing.T) {
+	// Arrange: create a new SystemdClient with nil ExecClient
+	systemdClient := NewSystemdClient(nil)
+
+	// Assert: check if the ExecClient is initialized
+	assert.IsType(t, &deploy_to_vm_exec.ExecClient{}, systemdClient.ExecClient)
+	assert.Equal(t, DefaultPollInterval, systemdClient.PollInterval)
+}
@@ -0,0 +1,260 @@
+package target
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"deploy-to-vm/internal/bluegreen"
+	"deploy-to-vm/internal/healthcheck"
+	"deploy-to-vm/internal/nginx"
+)
+
+// ColorService starts and stops one color of a blue/green target. The
+// deployment it is given has the site directory of the color.
+type ColorService interface {
+	StartColor(deployment *Deployment, color string, port int) error
+	StopColor(deployment *Deployment, color string) error
+}
+
+// BlueGreen deploys targets that run as two colors. The release is linked to
+// the site directory of the color that does not receive traffic and started
+// on its port. Once it is healthy, the nginx upstream is switched to it and
+// the previous color is stopped after the drain period.
+type BlueGreen struct {
+	Nginx       nginx.NginxClientInterface
+	HealthCheck healthcheck.HealthCheckClientInterface
+	mutex       sync.Mutex
+	// drains are the pending stops of the previous colors by site directory
+	drains map[string]*time.Timer
+	// switches are the colors that received the traffic before the current
+	// deployment switched it, by target directory
+	switches map[string]string
+}
+
+func NewBlueGreen(nginxClient nginx.NginxClientInterface, healthCheckClient healthcheck.HealthCheckClientInterface) *BlueGreen {
+	return &BlueGreen{
+		Nginx:       nginxClient,
+		HealthCheck: healthCheckClient,
+		drains:      make(map[string]*time.Timer),
+		switches:    make(map[string]string),
+	}
+}
+
+// Returns the deployment of the color that does not receive traffic
+func inactiveColorDeployment(deployment *Deployment) (*Deployment, string, error) {
+	activeColor, colorErr := bluegreen.GetActiveColor(deployment.Target.Dir)
+	if colorErr != nil {
+		return nil, "", colorErr
+	}
+
+	color := bluegreen.OtherColor(activeColor)
+	colorDeployment := *deployment
+	colorDeployment.SiteDir = bluegreen.SiteDir(deployment.Target.Dir, color)
+	return &colorDeployment, activeColor, nil
+}
+
+// Cancels the pending stop of a color, as it is about to run a release again.
+// Returns false if no stop was pending.
+func (b *BlueGreen) cancelDrain(siteDir string) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	drain, ok := b.drains[siteDir]
+	if ok {
+		drain.Stop()
+		delete(b.drains, siteDir)
+	}
+	return ok
+}
+
+// Records the color that received the traffic before the deployment of a
+// target switched it
+func (b *BlueGreen) recordSwitch(dir string, previousColor string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.switches[dir] = previousColor
+}
+
+// Returns the color that received the traffic before the current deployment
+// of a target switched it, and false if the traffic was not switched
+func (b *BlueGreen) takeSwitch(dir string) (string, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	previousColor, switched := b.switches[dir]
+	delete(b.switches, dir)
+	return previousColor, switched
+}
+
+// Stops a color once the drain period is over
+func (b *BlueGreen) scheduleDrain(service ColorService, deployment *Deployment, color string, drainPeriod time.Duration) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if drain, ok := b.drains[deployment.SiteDir]; ok {
+		drain.Stop()
+	}
+
+	log.Printf("Stopping the %s color of the %s target in %s", color, deployment.Target.Name, drainPeriod)
+	var drain *time.Timer
+	drain = time.AfterFunc(drainPeriod, func() {
+		b.mutex.Lock()
+		if b.drains[deployment.SiteDir] != drain {
+			// The color was started again in the meantime
+			b.mutex.Unlock()
+			return
+		}
+		delete(b.drains, deployment.SiteDir)
+		b.mutex.Unlock()
+
+		if stopErr := service.StopColor(deployment, color); stopErr != nil {
+			log.Printf("Failed to stop the %s color of the %s target: \"%v\"", color, deployment.Target.Name, stopErr)
+		}
+	})
+	b.drains[deployment.SiteDir] = drain
+}
+
+func (b *BlueGreen) Prepare(deployment *Deployment) error {
+	blueGreenConfig := deployment.Target.BlueGreen
+	if portsErr := bluegreen.ValidatePorts(blueGreenConfig); portsErr != nil {
+		return portsErr
+	}
+	if blueGreenConfig.UpstreamFile == "" {
+		return errors.New("Blue/green upstream file is not configured")
+	}
+	if b.Nginx == nil {
+		return errors.New("No nginx client to switch the blue/green upstream")
+	}
+	if blueGreenConfig.HealthPath != "" && b.HealthCheck == nil {
+		return errors.New("No client to run the blue/green health check")
+	}
+	if _, drainErr := blueGreenConfig.GetDrainPeriod(); drainErr != nil {
+		return drainErr
+	}
+	_, colorErr := bluegreen.GetActiveColor(deployment.Target.Dir)
+	return colorErr
+}
+
+// Links the release to the site directory of the inactive color
+func (b *BlueGreen) Activate(deployment *Deployment) (*Activation, error) {
+	colorDeployment, _, colorErr := inactiveColorDeployment(deployment)
+	if colorErr != nil {
+		return nil, colorErr
+	}
+	b.takeSwitch(deployment.Target.Dir)
+	b.cancelDrain(colorDeployment.SiteDir)
+	if err := os.MkdirAll(colorDeployment.SiteDir, 0755); err != nil {
+		return nil, fmt.Errorf("Error while creating the site directory of the color: %v", err)
+	}
+	return LinkSite(colorDeployment)
+}
+
+// Starts the release as the inactive color, checks its health and switches
+// the traffic to it
+func (b *BlueGreen) Reload(deployment *Deployment, service ColorService) error {
+	blueGreenConfig := deployment.Target.BlueGreen
+	colorDeployment, activeColor, colorErr := inactiveColorDeployment(deployment)
+	if colorErr != nil {
+		return colorErr
+	}
+	color := bluegreen.OtherColor(activeColor)
+	port := bluegreen.Port(blueGreenConfig, color)
+
+	if startErr := service.StartColor(colorDeployment, color, port); startErr != nil {
+		return startErr
+	}
+	if blueGreenConfig.HealthPath != "" {
+		checkConfig := deployment.Target.HealthCheck
+		checkConfig.URL = fmt.Sprintf("http://127.0.0.1:%d%s", port, blueGreenConfig.HealthPath)
+		if checkErr := b.HealthCheck.Check(checkConfig); checkErr != nil {
+			return fmt.Errorf("The %s color is not healthy: %v", color, checkErr)
+		}
+	}
+
+	if switchErr := b.switchTraffic(deployment, color, port); switchErr != nil {
+		return switchErr
+	}
+	b.recordSwitch(deployment.Target.Dir, activeColor)
+
+	if activeColor != "" {
+		drainPeriod, _ := blueGreenConfig.GetDrainPeriod()
+		previousDeployment := *deployment
+		previousDeployment.SiteDir = bluegreen.SiteDir(deployment.Target.Dir, activeColor)
+		b.scheduleDrain(service, &previousDeployment, activeColor, drainPeriod)
+	}
+	return nil
+}
+
+// Points the upstream to the port of a color and records it as the active
+// color, restoring the upstream if nginx rejects it
+func (b *BlueGreen) switchTraffic(deployment *Deployment, color string, port int) error {
+	blueGreenConfig := deployment.Target.BlueGreen
+	upstreamName := blueGreenConfig.UpstreamName
+	if upstreamName == "" {
+		upstreamName = deployment.Repo
+	}
+	restore, upstreamErr := nginx.WriteUpstream(blueGreenConfig.UpstreamFile, upstreamName, port)
+	if upstreamErr != nil {
+		return upstreamErr
+	}
+	switchErr := b.Nginx.WithLogSink(deployment.Log).Reload()
+	if switchErr != nil {
+		if restoreErr := restore(); restoreErr != nil {
+			log.Printf("Failed to restore the previous upstream: \"%v\"", restoreErr)
+		}
+		return switchErr
+	}
+	if err := bluegreen.SetActiveColor(deployment.Target.Dir, color); err != nil {
+		return err
+	}
+	log.Printf("Switched the %s target to the %s color on port %d", deployment.Target.Name, color, port)
+	return nil
+}
+
+// Rolls a failed deployment back. If the traffic was not switched yet, the
+// color of the failed release is stopped and the active color is left alone.
+// Otherwise the previous color is started again if it was already stopped,
+// the traffic is switched back to it and the failed color is stopped. Without
+// a previous release, e.g. on the first deployment, the failed color is only
+// stopped.
+func (b *BlueGreen) Rollback(deployment *Deployment, previous *Deployment, service ColorService) error {
+	previousColor, switched := b.takeSwitch(deployment.Target.Dir)
+	if !switched {
+		colorDeployment, activeColor, colorErr := inactiveColorDeployment(deployment)
+		if colorErr != nil {
+			return colorErr
+		}
+		color := bluegreen.OtherColor(activeColor)
+		log.Printf("Stopping the failed %s color of the %s target", color, deployment.Target.Name)
+		return service.StopColor(colorDeployment, color)
+	}
+
+	failedColor := bluegreen.OtherColor(previousColor)
+	failedDeployment := *deployment
+	failedDeployment.SiteDir = bluegreen.SiteDir(deployment.Target.Dir, failedColor)
+	if previous == nil {
+		log.Printf("Stopping the failed %s color of the %s target", failedColor, deployment.Target.Name)
+		return service.StopColor(&failedDeployment, failedColor)
+	}
+	if previousColor == "" {
+		return fmt.Errorf("No previous color to switch the %s target back to", deployment.Target.Name)
+	}
+
+	previousDeployment := *previous
+	previousDeployment.SiteDir = bluegreen.SiteDir(deployment.Target.Dir, previousColor)
+	port := bluegreen.Port(deployment.Target.BlueGreen, previousColor)
+	if !b.cancelDrain(previousDeployment.SiteDir) {
+		if startErr := service.StartColor(&previousDeployment, previousColor, port); startErr != nil {
+			return startErr
+		}
+	}
+	if switchErr := b.switchTraffic(deployment, previousColor, port); switchErr != nil {
+		return switchErr
+	}
+
+	if stopErr := service.StopColor(&failedDeployment, failedColor); stopErr != nil {
+		log.Printf("Failed to stop the %s color of the %s target: \"%v\"", failedColor, deployment.Target.Name, stopErr)
+	}
+	return nil
+}
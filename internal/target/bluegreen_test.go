@@ -0,0 +1,324 @@
+package target
+
+import (
+	"errors"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"deploy-to-vm/internal/bluegreen"
+	"deploy-to-vm/internal/config"
+	deploy_to_vm_exec "deploy-to-vm/internal/exec"
+	"deploy-to-vm/internal/nginx"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// MockNginxReloadClient records the upstream file content it reloads with and
+// fails with ReloadErr
+type MockNginxReloadClient struct {
+	UpstreamFile string
+	Upstreams    []string
+	ReloadErr    error
+}
+
+func (m *MockNginxReloadClient) Reload() error {
+	content, _ := os.ReadFile(m.UpstreamFile)
+	m.Upstreams = append(m.Upstreams, string(content))
+	return m.ReloadErr
+}
+
+func (m *MockNginxReloadClient) Test() error {
+	return nil
+}
+
+func (m *MockNginxReloadClient) WithLogSink(sink deploy_to_vm_exec.LogSink) nginx.NginxClientInterface {
+	return m
+}
+
+// Helper to create a blue/green deployment of a pm2 app
+func setupBlueGreenTest(t *testing.T, drainPeriod string) (*Deployment, *MockNginxReloadClient) {
+	upstreamFile := path.Join(t.TempDir(), "app-upstream.conf")
+	deployment := setupTargetTest(t, &config.DeployToVmConfigRepository{
+		TargetType: "pm2",
+		Pm2:        config.DeployToVmConfigPm2{ProcessName: "app", Script: "server.js"},
+		BlueGreen: config.DeployToVmConfigBlueGreen{
+			Enabled:      true,
+			BluePort:     3001,
+			GreenPort:    3002,
+			UpstreamFile: upstreamFile,
+			HealthPath:   "/health",
+			DrainPeriod:  drainPeriod,
+		},
+	})
+	deployment.Target.Dir = deployment.SiteDir
+	return deployment, &MockNginxReloadClient{UpstreamFile: upstreamFile}
+}
+
+// Helper to deploy a release like Run does
+func deployBlueGreen(t *testing.T, target Target, deployment *Deployment) error {
+	if err := target.Prepare(deployment); err != nil {
+		return err
+	}
+	if _, err := target.Activate(deployment); err != nil {
+		return err
+	}
+	if err := target.Reload(deployment); err != nil {
+		return err
+	}
+	return target.Health(deployment)
+}
+
+func TestBlueGreen_Pm2_FirstAndSecondDeployment(t *testing.T) {
+	// Arrange: create a pm2 target with blue/green enabled
+	deployment, mockNginxClient := setupBlueGreenTest(t, "0s")
+	mockPm2Client := &MockPm2Client{}
+	healthCalls := make([]string, 0)
+	pm2Target := NewPm2Target(mockPm2Client)
+	pm2Target.BlueGreen = NewBlueGreen(mockNginxClient, &MockHealthCheckClient{Calls: &healthCalls})
+
+	// Act: deploy the first release
+	err := deployBlueGreen(t, pm2Target, deployment)
+
+	// Assert: check if the release is started as blue and receives the traffic
+	assert.NoError(t, err)
+	assert.FileExists(t, path.Join(deployment.SiteDir, "blue", "index.html"))
+	assert.Equal(t, "start "+path.Join(deployment.ReleaseDir, ".deploy-to-vm", "pm2.ecosystem.json")+" app-blue", mockPm2Client.GetCalls()[0])
+	assert.Equal(t, []string{"check http://127.0.0.1:3001/health"}, healthCalls)
+	assert.Equal(t, []string{"# Written by deploy-to-vm\nupstream repo {\n    server 127.0.0.1:3001;\n}\n"}, mockNginxClient.Upstreams)
+	activeColor, _ := bluegreen.GetActiveColor(deployment.Target.Dir)
+	assert.Equal(t, bluegreen.Color_Blue, activeColor)
+	ecosystem, _ := os.ReadFile(path.Join(deployment.ReleaseDir, ".deploy-to-vm", "pm2.ecosystem.json"))
+	assert.Contains(t, string(ecosystem), `"PORT": "3001"`)
+
+	// Act: deploy the next release
+	err = deployBlueGreen(t, pm2Target, deployment)
+
+	// Assert: check if the release is started as green and blue is stopped
+	// after the drain period
+	assert.NoError(t, err)
+	assert.Contains(t, mockNginxClient.Upstreams[1], "server 127.0.0.1:3002;")
+	activeColor, _ = bluegreen.GetActiveColor(deployment.Target.Dir)
+	assert.Equal(t, bluegreen.Color_Green, activeColor)
+	assert.Eventually(t, func() bool {
+		calls := mockPm2Client.GetCalls()
+		return len(calls) > 0 && calls[len(calls)-1] == "save" && calls[len(calls)-2] == "stop app-blue"
+	}, time.Second, time.Millisecond)
+}
+
+func TestBlueGreen_UnhealthyColorKeepsTraffic(t *testing.T) {
+	// Arrange: create a target whose new color fails its health check
+	deployment, mockNginxClient := setupBlueGreenTest(t, "")
+	healthCalls := make([]string, 0)
+	pm2Target := NewPm2Target(&MockPm2Client{})
+	pm2Target.BlueGreen = NewBlueGreen(mockNginxClient, &MockHealthCheckClient{Calls: &healthCalls, Err: errors.New("502")})
+
+	// Act: deploy the release
+	err := deployBlueGreen(t, pm2Target, deployment)
+
+	// Assert: check if the traffic is not switched
+	assert.EqualError(t, err, "The blue color is not healthy: 502")
+	assert.Empty(t, mockNginxClient.Upstreams)
+	activeColor, _ := bluegreen.GetActiveColor(deployment.Target.Dir)
+	assert.Equal(t, "", activeColor)
+}
+
+func TestBlueGreen_RollbackBeforeSwitch(t *testing.T) {
+	// Arrange: create a target with blue active whose new color fails its
+	// health check
+	deployment, mockNginxClient := setupBlueGreenTest(t, "")
+	os.WriteFile(mockNginxClient.UpstreamFile, []byte("upstream repo { server 127.0.0.1:3001; }"), 0644)
+	bluegreen.SetActiveColor(deployment.Target.Dir, bluegreen.Color_Blue)
+	mockPm2Client := &MockPm2Client{}
+	pm2Target := NewPm2Target(mockPm2Client)
+	pm2Target.BlueGreen = NewBlueGreen(mockNginxClient, &MockHealthCheckClient{Calls: &[]string{}, Err: errors.New("502")})
+	previous := *deployment
+	previous.Tag = "v1"
+
+	// Act: deploy the release
+	_, err := Run([]*Step{{Target: pm2Target, Deployment: deployment, Previous: &previous}})
+
+	// Assert: check if only the failed color is stopped and blue keeps the
+	// traffic
+	var stepErr *StepError
+	assert.True(t, errors.As(err, &stepErr))
+	assert.Equal(t, []string{deployment.Target.Name}, stepErr.RolledBack)
+	assert.Empty(t, mockNginxClient.Upstreams)
+	upstream, _ := os.ReadFile(mockNginxClient.UpstreamFile)
+	assert.Equal(t, "upstream repo { server 127.0.0.1:3001; }", string(upstream))
+	activeColor, _ := bluegreen.GetActiveColor(deployment.Target.Dir)
+	assert.Equal(t, bluegreen.Color_Blue, activeColor)
+	calls := mockPm2Client.GetCalls()
+	assert.Equal(t, []string{"stop app-green", "save"}, calls[len(calls)-2:])
+	for _, call := range calls {
+		assert.NotContains(t, call, "app-blue")
+	}
+}
+
+func TestBlueGreen_RollbackAfterSwitch(t *testing.T) {
+	// Arrange: create a target with blue active whose HTTP health check fails
+	// once the traffic is switched to green
+	deployment, mockNginxClient := setupBlueGreenTest(t, "1h")
+	bluegreen.SetActiveColor(deployment.Target.Dir, bluegreen.Color_Blue)
+	deployment.Target.HealthCheck = config.DeployToVmConfigHealthCheck{URL: "http://localhost/health"}
+	mockPm2Client := &MockPm2Client{}
+	pm2Target := NewPm2Target(mockPm2Client)
+	pm2Target.BlueGreen = NewBlueGreen(mockNginxClient, &MockHealthCheckClient{Calls: &[]string{}})
+	previous := *deployment
+	previous.Tag = "v1"
+	step := &Step{
+		Target:      pm2Target,
+		Deployment:  deployment,
+		Previous:    &previous,
+		HealthCheck: &MockHealthCheckClient{Calls: &[]string{}, Err: errors.New("502")},
+	}
+
+	// Act: deploy the release
+	_, err := Run([]*Step{step})
+
+	// Assert: check if the traffic is switched back to the draining blue
+	// color and green is stopped
+	var stepErr *StepError
+	assert.True(t, errors.As(err, &stepErr))
+	assert.Equal(t, Phase_Health, stepErr.Phase)
+	assert.Equal(t, []string{deployment.Target.Name}, stepErr.RolledBack)
+	assert.Len(t, mockNginxClient.Upstreams, 2)
+	assert.Contains(t, mockNginxClient.Upstreams[0], "server 127.0.0.1:3002;")
+	assert.Contains(t, mockNginxClient.Upstreams[1], "server 127.0.0.1:3001;")
+	activeColor, _ := bluegreen.GetActiveColor(deployment.Target.Dir)
+	assert.Equal(t, bluegreen.Color_Blue, activeColor)
+	calls := mockPm2Client.GetCalls()
+	assert.Equal(t, []string{"stop app-green", "save"}, calls[len(calls)-2:])
+	for _, call := range calls {
+		assert.NotContains(t, call, "app-blue")
+	}
+	pm2Target.BlueGreen.mutex.Lock()
+	assert.Empty(t, pm2Target.BlueGreen.drains)
+	pm2Target.BlueGreen.mutex.Unlock()
+}
+
+func TestBlueGreen_RollbackFirstDeployment(t *testing.T) {
+	// Arrange: create a target without a previous release whose new color
+	// fails its health check
+	deployment, mockNginxClient := setupBlueGreenTest(t, "")
+	mockPm2Client := &MockPm2Client{}
+	pm2Target := NewPm2Target(mockPm2Client)
+	pm2Target.BlueGreen = NewBlueGreen(mockNginxClient, &MockHealthCheckClient{Calls: &[]string{}, Err: errors.New("502")})
+
+	// Act: deploy the first release
+	_, err := Run([]*Step{{Target: pm2Target, Deployment: deployment}})
+
+	// Assert: check if the failed color is stopped without a previous release
+	var stepErr *StepError
+	assert.True(t, errors.As(err, &stepErr))
+	assert.Equal(t, []string{deployment.Target.Name}, stepErr.RolledBack)
+	assert.Empty(t, stepErr.RollbackErrors)
+	assert.Empty(t, mockNginxClient.Upstreams)
+	calls := mockPm2Client.GetCalls()
+	assert.Equal(t, []string{"stop app-blue", "save"}, calls[len(calls)-2:])
+}
+
+func TestBlueGreen_RollbackFirstDeploymentAfterSwitch(t *testing.T) {
+	// Arrange: create a target without a previous release whose HTTP health
+	// check fails once the traffic is switched to blue
+	deployment, mockNginxClient := setupBlueGreenTest(t, "")
+	deployment.Target.HealthCheck = config.DeployToVmConfigHealthCheck{URL: "http://localhost/health"}
+	mockPm2Client := &MockPm2Client{}
+	pm2Target := NewPm2Target(mockPm2Client)
+	pm2Target.BlueGreen = NewBlueGreen(mockNginxClient, &MockHealthCheckClient{Calls: &[]string{}})
+	step := &Step{
+		Target:      pm2Target,
+		Deployment:  deployment,
+		HealthCheck: &MockHealthCheckClient{Calls: &[]string{}, Err: errors.New("502")},
+	}
+
+	// Act: deploy the first release
+	_, err := Run([]*Step{step})
+
+	// Assert: check if the failed color is stopped as there is no color to
+	// switch back to
+	var stepErr *StepError
+	assert.True(t, errors.As(err, &stepErr))
+	assert.Equal(t, Phase_Health, stepErr.Phase)
+	assert.Equal(t, []string{deployment.Target.Name}, stepErr.RolledBack)
+	assert.Empty(t, stepErr.RollbackErrors)
+	assert.Len(t, mockNginxClient.Upstreams, 1)
+	calls := mockPm2Client.GetCalls()
+	assert.Equal(t, []string{"stop app-blue", "save"}, calls[len(calls)-2:])
+}
+
+func TestBlueGreen_NginxRejectsUpstream(t *testing.T) {
+	// Arrange: create an nginx client that fails to reload and an upstream
+	// that points to blue
+	deployment, mockNginxClient := setupBlueGreenTest(t, "")
+	mockNginxClient.ReloadErr = errors.New("nginx configuration test failed")
+	os.WriteFile(mockNginxClient.UpstreamFile, []byte("upstream repo { server 127.0.0.1:3001; }"), 0644)
+	bluegreen.SetActiveColor(deployment.Target.Dir, bluegreen.Color_Blue)
+	pm2Target := NewPm2Target(&MockPm2Client{})
+	pm2Target.BlueGreen = NewBlueGreen(mockNginxClient, &MockHealthCheckClient{Calls: &[]string{}})
+
+	// Act: deploy the release
+	err := deployBlueGreen(t, pm2Target, deployment)
+
+	// Assert: check if the previous upstream and color are kept
+	assert.Error(t, err)
+	upstream, _ := os.ReadFile(mockNginxClient.UpstreamFile)
+	assert.Equal(t, "upstream repo { server 127.0.0.1:3001; }", string(upstream))
+	activeColor, _ := bluegreen.GetActiveColor(deployment.Target.Dir)
+	assert.Equal(t, bluegreen.Color_Blue, activeColor)
+}
+
+func TestBlueGreen_Systemd(t *testing.T) {
+	// Arrange: create a systemd target with a template unit and blue active
+	deployment, mockNginxClient := setupBlueGreenTest(t, "1h")
+	deployment.Target.Type = "systemd"
+	deployment.Target.Systemd = config.DeployToVmConfigSystemd{Unit: "app@.service"}
+	bluegreen.SetActiveColor(deployment.Target.Dir, bluegreen.Color_Blue)
+	mockSystemdClient := &MockSystemdClient{}
+	systemdTarget := NewSystemdTarget(mockSystemdClient)
+	systemdTarget.BlueGreen = NewBlueGreen(mockNginxClient, &MockHealthCheckClient{Calls: &[]string{}})
+
+	// Act: deploy the release
+	err := deployBlueGreen(t, systemdTarget, deployment)
+
+	// Assert: check if the green instance is restarted with its port
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"restart app@green.service"}, mockSystemdClient.Calls)
+	env, _ := os.ReadFile(path.Join(deployment.Target.Dir, "green.env"))
+	assert.Equal(t, "PORT=3002\n", string(env))
+	assert.Contains(t, mockNginxClient.Upstreams[0], "server 127.0.0.1:3002;")
+
+	// Act: roll back to the previous release while blue is still draining
+	err = deployBlueGreen(t, systemdTarget, deployment)
+
+	// Assert: check if blue is restarted and not stopped by the drain
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"restart app@green.service", "restart app@blue.service"}, mockSystemdClient.Calls)
+	systemdTarget.BlueGreen.mutex.Lock()
+	_, blueDraining := systemdTarget.BlueGreen.drains[path.Join(deployment.Target.Dir, "blue")]
+	_, greenDraining := systemdTarget.BlueGreen.drains[path.Join(deployment.Target.Dir, "green")]
+	systemdTarget.BlueGreen.mutex.Unlock()
+	assert.False(t, blueDraining)
+	assert.True(t, greenDraining)
+}
+
+func TestBlueGreen_Prepare(t *testing.T) {
+	deployment, mockNginxClient := setupBlueGreenTest(t, "")
+	systemdTarget := NewSystemdTarget(&MockSystemdClient{})
+	systemdTarget.BlueGreen = NewBlueGreen(mockNginxClient, &MockHealthCheckClient{Calls: &[]string{}})
+
+	// Act/Assert: check if a unit that is not a template fails
+	deployment.Target.Systemd = config.DeployToVmConfigSystemd{Unit: "app.service"}
+	assert.Error(t, systemdTarget.Prepare(deployment))
+
+	// Act/Assert: check if equal ports fail
+	deployment.Target.Systemd = config.DeployToVmConfigSystemd{Unit: "app@.service"}
+	deployment.Target.BlueGreen.GreenPort = deployment.Target.BlueGreen.BluePort
+	assert.Error(t, systemdTarget.Prepare(deployment))
+
+	// Act/Assert: check if a missing nginx client fails
+	deployment.Target.BlueGreen.GreenPort = 3002
+	systemdTarget.BlueGreen.Nginx = nil
+	assert.Error(t, systemdTarget.Prepare(deployment))
+}
@@ -0,0 +1,73 @@
+package target
+
+import (
+	"path/filepath"
+
+	"deploy-to-vm/internal/config"
+	"deploy-to-vm/internal/docker"
+	file_utils "deploy-to-vm/internal/file-utils"
+)
+
+// DockerComposeTarget deploys container based apps. The release, which holds
+// the compose file, is linked to the site directory, its image tarballs are
+// loaded and the compose project is started with the release tag.
+type DockerComposeTarget struct {
+	SiteTarget
+	Client docker.DockerClientInterface
+}
+
+func NewDockerComposeTarget(client docker.DockerClientInterface) *DockerComposeTarget {
+	return &DockerComposeTarget{Client: client}
+}
+
+// Image tarballs are loaded, not extracted
+func (t *DockerComposeTarget) SkipArchives(targetConfig *config.DeployToVmConfigTarget) []string {
+	return targetConfig.DockerCompose.ImageArchives
+}
+
+func (t *DockerComposeTarget) Prepare(deployment *Deployment) error {
+	composeConfig := deployment.Target.DockerCompose
+	if tagErr := docker.ValidateTagVariable(composeConfig.TagVariable, deployment.Tag); tagErr != nil {
+		return tagErr
+	}
+	if _, composeFileErr := docker.FindComposeFile(deployment.RootDir, composeConfig.ComposeFile); composeFileErr != nil {
+		return composeFileErr
+	}
+	if _, timeoutErr := composeConfig.GetTimeout(); timeoutErr != nil {
+		return timeoutErr
+	}
+	_, imageArchivesErr := docker.FindImageArchives(deployment.ReleaseDir, composeConfig.ImageArchives)
+	return imageArchivesErr
+}
+
+func (t *DockerComposeTarget) Reload(deployment *Deployment) error {
+	composeConfig := deployment.Target.DockerCompose
+	composeFile, composeFileErr := docker.FindComposeFile(deployment.SiteDir, composeConfig.ComposeFile)
+	if composeFileErr != nil {
+		return composeFileErr
+	}
+	imageArchives, imageArchivesErr := docker.FindImageArchives(deployment.ReleaseDir, composeConfig.ImageArchives)
+	if imageArchivesErr != nil {
+		return imageArchivesErr
+	}
+	timeout, timeoutErr := composeConfig.GetTimeout()
+	if timeoutErr != nil {
+		return timeoutErr
+	}
+	envFile := filepath.Join(deployment.ReleaseDir, file_utils.MetadataDirName, "compose.env")
+	if envFileErr := docker.WriteEnvFile(envFile, composeConfig.TagVariable, deployment.Tag); envFileErr != nil {
+		return envFileErr
+	}
+
+	project := composeConfig.Project
+	if project == "" {
+		project = deployment.Repo
+	}
+	return t.Client.WithLogSink(deployment.Log).WithCredential(deployment.RunAs).ComposeUp(docker.ComposeUpOptions{
+		Project:       project,
+		ComposeFile:   composeFile,
+		EnvFile:       envFile,
+		ImageArchives: imageArchives,
+		Timeout:       timeout,
+	})
+}
@@ -0,0 +1,134 @@
+package target
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"deploy-to-vm/internal/nginx"
+)
+
+// NginxTarget deploys static sites served by nginx. The release is linked to
+// the site directory, its server block is installed if it ships one, and nginx
+// is tested and reloaded.
+type NginxTarget struct {
+	SiteTarget
+	Client nginx.NginxClientInterface
+	mutex  sync.Mutex
+	// restores put back the server blocks that were installed before the
+	// current deployment, by target directory
+	restores map[string]func() error
+}
+
+func NewNginxTarget(client nginx.NginxClientInterface) *NginxTarget {
+	return &NginxTarget{Client: client, restores: make(map[string]func() error)}
+}
+
+// Records how to restore the server block a deployment replaced, or forgets it
+// if restore is nil
+func (t *NginxTarget) recordRestore(dir string, restore func() error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if restore == nil {
+		delete(t.restores, dir)
+		return
+	}
+	t.restores[dir] = restore
+}
+
+// Returns and forgets how to restore the server block the current deployment
+// of a target replaced
+func (t *NginxTarget) takeRestore(dir string) func() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	restore := t.restores[dir]
+	delete(t.restores, dir)
+	return restore
+}
+
+// Returns the path of the server block in the release, or an empty string if
+// the release does not ship one
+func vhostFile(deployment *Deployment) (string, error) {
+	if deployment.Target.Nginx.VhostFile == "" {
+		return "", nil
+	}
+
+	file := filepath.Join(deployment.ReleaseDir, deployment.Target.Nginx.VhostFile)
+	if !strings.HasPrefix(file, filepath.Clean(deployment.ReleaseDir)+string(os.PathSeparator)) {
+		return "", fmt.Errorf("Server block is outside of the release: %q", deployment.Target.Nginx.VhostFile)
+	}
+	return file, nil
+}
+
+func (t *NginxTarget) Prepare(deployment *Deployment) error {
+	file, fileErr := vhostFile(deployment)
+	if fileErr != nil || file == "" {
+		return fileErr
+	}
+	if info, statErr := os.Stat(file); statErr != nil || !info.Mode().IsRegular() {
+		return fmt.Errorf("Server block not found in the release: %q", deployment.Target.Nginx.VhostFile)
+	}
+	return nil
+}
+
+// Installs the server block of the release and tests the configuration. The
+// previous server block is restored if the test fails.
+func (t *NginxTarget) installVhost(deployment *Deployment) error {
+	file, fileErr := vhostFile(deployment)
+	if fileErr != nil || file == "" {
+		return fileErr
+	}
+
+	name := deployment.Target.Nginx.VhostName
+	if name == "" {
+		name = deployment.Repo + ".conf"
+	}
+	restore, installErr := nginx.InstallVhost(file, deployment.Target.Nginx.SitesEnabledDir, name)
+	if installErr != nil {
+		return installErr
+	}
+
+	if testErr := t.Client.WithLogSink(deployment.Log).Test(); testErr != nil {
+		if restoreErr := restore(); restoreErr != nil {
+			log.Printf("Failed to restore the previous server block: \"%v\"", restoreErr)
+		} else {
+			log.Printf("Restored the previous server block %s", name)
+		}
+		return testErr
+	}
+	t.recordRestore(deployment.Target.Dir, restore)
+	log.Printf("Installed server block %s", name)
+	return nil
+}
+
+func (t *NginxTarget) Reload(deployment *Deployment) error {
+	t.recordRestore(deployment.Target.Dir, nil)
+	if installErr := t.installVhost(deployment); installErr != nil {
+		return installErr
+	}
+	return t.Client.WithLogSink(deployment.Log).Reload()
+}
+
+// Rolls a failed deployment back to the previous release. The server block
+// that was installed before the deployment is restored instead of the one of
+// the previous release, which may not ship one.
+func (t *NginxTarget) Rollback(deployment *Deployment, previous *Deployment) error {
+	if previous == nil {
+		t.takeRestore(deployment.Target.Dir)
+		return ErrNoPreviousRelease
+	}
+	if _, activateErr := t.Activate(previous); activateErr != nil {
+		return activateErr
+	}
+
+	if restore := t.takeRestore(deployment.Target.Dir); restore != nil {
+		if restoreErr := restore(); restoreErr != nil {
+			return restoreErr
+		}
+		log.Printf("Restored the previous server block of the %s target", deployment.Target.Name)
+	}
+	return t.Client.WithLogSink(previous.Log).Reload()
+}
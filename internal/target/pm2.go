@@ -0,0 +1,200 @@
+package target
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	file_utils "deploy-to-vm/internal/file-utils"
+	"deploy-to-vm/internal/pm2"
+)
+
+// Pm2Target deploys node apps managed by pm2. The release is linked to the
+// site directory and the pm2 process is reloaded, or started if it does not
+// exist yet. With blue/green enabled, each color is a process named
+// "<processName>-<color>".
+type Pm2Target struct {
+	SiteTarget
+	Client    pm2.Pm2ClientInterface
+	BlueGreen *BlueGreen
+}
+
+func NewPm2Target(client pm2.Pm2ClientInterface) *Pm2Target {
+	return &Pm2Target{Client: client}
+}
+
+// Returns the client that runs pm2 as the user of the deployment, so it
+// manages the pm2 daemon of that user
+func (t *Pm2Target) client(deployment *Deployment) pm2.Pm2ClientInterface {
+	return t.Client.WithCredential(deployment.RunAs)
+}
+
+// Returns the path of a file in the given directory, rejecting paths outside
+// of it
+func pathInDir(dir string, file string) (string, error) {
+	filePath := filepath.Join(dir, file)
+	if !strings.HasPrefix(filePath, filepath.Clean(dir)+string(os.PathSeparator)) {
+		return "", fmt.Errorf("Path is outside of the release: %q", file)
+	}
+	return filePath, nil
+}
+
+func (t *Pm2Target) Prepare(deployment *Deployment) error {
+	pm2Config := deployment.Target.Pm2
+	if pm2Config.ProcessName == "" {
+		return errors.New("pm2 process name is not configured")
+	}
+	if _, timeoutErr := pm2Config.GetTimeout(); timeoutErr != nil {
+		return timeoutErr
+	}
+	if pm2Config.EcosystemFile != "" {
+		ecosystemFile, pathErr := pathInDir(deployment.RootDir, pm2Config.EcosystemFile)
+		if pathErr != nil {
+			return pathErr
+		}
+		if _, statErr := os.Stat(ecosystemFile); statErr != nil {
+			return fmt.Errorf("Ecosystem file not found in the release: %q", pm2Config.EcosystemFile)
+		}
+	}
+	if deployment.Target.BlueGreen.Enabled {
+		return t.BlueGreen.Prepare(deployment)
+	}
+	return nil
+}
+
+func (t *Pm2Target) Activate(deployment *Deployment) (*Activation, error) {
+	if deployment.Target.BlueGreen.Enabled {
+		return t.BlueGreen.Activate(deployment)
+	}
+	return LinkSite(deployment)
+}
+
+// Returns the ecosystem file to start a process from. Without an ecosystem
+// file in the release, one is generated from the configured script.
+func (t *Pm2Target) ecosystemFile(deployment *Deployment, processName string, env map[string]string) (string, error) {
+	pm2Config := deployment.Target.Pm2
+	if pm2Config.EcosystemFile != "" {
+		return pathInDir(deployment.SiteDir, pm2Config.EcosystemFile)
+	}
+	if pm2Config.Script == "" {
+		return "", fmt.Errorf("pm2 process %s is not running and neither an ecosystem file nor a script is configured", processName)
+	}
+
+	cwd := pm2Config.Cwd
+	if cwd == "" {
+		cwd = deployment.SiteDir
+	}
+	ecosystemFile := filepath.Join(deployment.ReleaseDir, file_utils.MetadataDirName, deployment.Target.Name+".ecosystem.json")
+	writeErr := pm2.WriteEcosystemFile(ecosystemFile, pm2.EcosystemApp{
+		Name:      processName,
+		Script:    pm2Config.Script,
+		Cwd:       cwd,
+		Env:       env,
+		Instances: pm2Config.Instances,
+	})
+	return ecosystemFile, writeErr
+}
+
+// Reloads a process, or starts it if it is not running, and saves the process
+// list
+func (t *Pm2Target) reloadProcess(deployment *Deployment, processName string, env map[string]string) error {
+	client := t.client(deployment)
+	processes, listErr := client.List()
+	if listErr != nil {
+		return listErr
+	}
+
+	// The output of the commands that change processes goes to the log
+	client = client.WithLogSink(deployment.Log)
+	running := false
+	for _, process := range processes {
+		if process.Name == processName {
+			running = true
+			break
+		}
+	}
+
+	if running {
+		if reloadErr := client.Reload(processName); reloadErr != nil {
+			return reloadErr
+		}
+	} else {
+		ecosystemFile, ecosystemErr := t.ecosystemFile(deployment, processName, env)
+		if ecosystemErr != nil {
+			return ecosystemErr
+		}
+		if startErr := client.Start(ecosystemFile, processName); startErr != nil {
+			return startErr
+		}
+	}
+
+	return client.Save()
+}
+
+func (t *Pm2Target) Reload(deployment *Deployment) error {
+	if deployment.Target.BlueGreen.Enabled {
+		return t.BlueGreen.Reload(deployment, t)
+	}
+	return t.reloadProcess(deployment, deployment.Target.Pm2.ProcessName, deployment.Target.Pm2.Env)
+}
+
+// Returns the name of the process of a color
+func colorProcessName(deployment *Deployment, color string) string {
+	return deployment.Target.Pm2.ProcessName + "-" + color
+}
+
+// Starts the process of a color with the port in the PORT variable and waits
+// until it is online
+func (t *Pm2Target) StartColor(deployment *Deployment, color string, port int) error {
+	env := map[string]string{"PORT": strconv.Itoa(port)}
+	for key, value := range deployment.Target.Pm2.Env {
+		if key != "PORT" {
+			env[key] = value
+		}
+	}
+
+	processName := colorProcessName(deployment, color)
+	if reloadErr := t.reloadProcess(deployment, processName, env); reloadErr != nil {
+		return reloadErr
+	}
+	timeout, _ := deployment.Target.Pm2.GetTimeout()
+	_, waitErr := t.client(deployment).WaitForOnline(processName, pm2.ReadPackageVersion(deployment.RootDir), timeout)
+	return waitErr
+}
+
+// Rolls a failed deployment back, leaving the active color alone if the
+// traffic was not switched to the failed release yet
+func (t *Pm2Target) Rollback(deployment *Deployment, previous *Deployment) error {
+	if deployment.Target.BlueGreen.Enabled {
+		return t.BlueGreen.Rollback(deployment, previous, t)
+	}
+	if previous == nil {
+		return ErrNoPreviousRelease
+	}
+	return reactivate(t, previous)
+}
+
+func (t *Pm2Target) StopColor(deployment *Deployment, color string) error {
+	client := t.client(deployment)
+	if stopErr := client.Stop(colorProcessName(deployment, color)); stopErr != nil {
+		return stopErr
+	}
+	return client.Save()
+}
+
+// Checks that the process is online with the version of the package.json in
+// the release. Blue/green colors are checked before the traffic is switched.
+func (t *Pm2Target) Health(deployment *Deployment) error {
+	if deployment.Target.BlueGreen.Enabled {
+		return nil
+	}
+	timeout, timeoutErr := deployment.Target.Pm2.GetTimeout()
+	if timeoutErr != nil {
+		return timeoutErr
+	}
+	_, waitErr := t.client(deployment).WaitForOnline(deployment.Target.Pm2.ProcessName, pm2.ReadPackageVersion(deployment.RootDir), timeout)
+	return waitErr
+}
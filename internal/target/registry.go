@@ -0,0 +1,34 @@
+package target
+
+import (
+	"deploy-to-vm/internal/docker"
+	"deploy-to-vm/internal/healthcheck"
+	"deploy-to-vm/internal/nginx"
+	"deploy-to-vm/internal/pm2"
+	"deploy-to-vm/internal/systemd"
+)
+
+// Clients are the clients the built-in targets use.
+type Clients struct {
+	Docker  docker.DockerClientInterface
+	Nginx   nginx.NginxClientInterface
+	Pm2     pm2.Pm2ClientInterface
+	Systemd systemd.SystemdClientInterface
+	// HealthCheck checks blue/green colors before the traffic is switched
+	HealthCheck healthcheck.HealthCheckClientInterface
+}
+
+// Creates a registry with the built-in targets
+func NewDefaultRegistry(clients Clients) *Registry {
+	blueGreen := NewBlueGreen(clients.Nginx, clients.HealthCheck)
+	pm2Target := NewPm2Target(clients.Pm2)
+	pm2Target.BlueGreen = blueGreen
+	systemdTarget := NewSystemdTarget(clients.Systemd)
+	systemdTarget.BlueGreen = blueGreen
+
+	return NewRegistry().
+		Register("docker-compose", NewDockerComposeTarget(clients.Docker)).
+		Register("nginx", NewNginxTarget(clients.Nginx)).
+		Register("pm2", pm2Target).
+		Register("systemd", systemdTarget)
+}
@@ -0,0 +1,252 @@
+package target
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"deploy-to-vm/internal/config"
+	"deploy-to-vm/internal/healthcheck"
+	"deploy-to-vm/internal/hooks"
+)
+
+const (
+	Phase_Prepare  = "prepare"
+	Phase_Activate = "activate"
+	Phase_Reload   = "reload"
+	Phase_Health   = "health"
+	// Phase_Hook is the phase of a preActivate hook that failed
+	Phase_Hook = "hook"
+)
+
+// Step deploys a release to one target.
+type Step struct {
+	Target     Target
+	Deployment *Deployment
+	// Previous is the deployment of the release that was active before. It is
+	// nil if there is no release to roll back to.
+	Previous *Deployment
+	// HealthCheck runs the HTTP health check of the target config after the
+	// health check of the target
+	HealthCheck healthcheck.HealthCheckClientInterface
+	// Hooks runs the lifecycle hooks of the target config
+	Hooks hooks.HookClientInterface
+}
+
+// Checks that the HTTP health check of a step can run
+func prepareHealthCheck(step *Step) error {
+	checkConfig := step.Deployment.Target.HealthCheck
+	if checkConfig.URL == "" {
+		return nil
+	}
+	if step.HealthCheck == nil {
+		return fmt.Errorf("No client to run the health check of %s", checkConfig.URL)
+	}
+	if _, err := checkConfig.GetTimeout(); err != nil {
+		return err
+	}
+	if _, err := checkConfig.GetInterval(); err != nil {
+		return err
+	}
+	if _, err := checkConfig.GetRetries(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// StepError describes the step of a deployment that failed and the rollback
+// that followed.
+type StepError struct {
+	// Target is the name of the target that failed
+	Target string
+	// Phase is the phase that failed, e.g. Phase_Reload
+	Phase string
+	Err   error
+	// RolledBack are the names of the targets that were rolled back to the
+	// previous release
+	RolledBack []string
+	// RollbackErrors are the errors of the rollbacks that failed by target name
+	RollbackErrors map[string]error
+}
+
+func (e *StepError) Error() string {
+	if e.Phase == Phase_Hook {
+		return fmt.Sprintf("Failed to run a hook of the %s target: %v", e.Target, e.Err)
+	}
+	return fmt.Sprintf("Failed to %s the %s target: %v", e.Phase, e.Target, e.Err)
+}
+
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
+
+// Checks that the hooks of a step can run
+func prepareHooks(step *Step) error {
+	hookConfigs := step.Deployment.Target.Hooks.All()
+	if len(hookConfigs) == 0 {
+		return nil
+	}
+	if step.Hooks == nil {
+		return fmt.Errorf("No client to run the hooks")
+	}
+	for _, hookConfig := range hookConfigs {
+		if err := hooks.Validate(hookConfig, step.Deployment.RootDir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the variables that describe a deployment to its hooks
+func hookEnv(step *Step) map[string]string {
+	deployment := step.Deployment
+	env := map[string]string{
+		"DEPLOY_TO_VM_OWNER":       deployment.Owner,
+		"DEPLOY_TO_VM_REPO":        deployment.Repo,
+		"DEPLOY_TO_VM_TAG":         deployment.Tag,
+		"DEPLOY_TO_VM_TARGET":      deployment.Target.Name,
+		"DEPLOY_TO_VM_RELEASE_DIR": deployment.ReleaseDir,
+		"DEPLOY_TO_VM_ROOT_DIR":    deployment.RootDir,
+		"DEPLOY_TO_VM_SITE_DIR":    deployment.SiteDir,
+	}
+	if step.Previous != nil {
+		env["DEPLOY_TO_VM_PREVIOUS_TAG"] = step.Previous.Tag
+	}
+	return env
+}
+
+// Runs the hooks of a step with the given name
+func runHooks(step *Step, name string, hookConfigs []config.DeployToVmConfigHook, env map[string]string) error {
+	if len(hookConfigs) == 0 {
+		return nil
+	}
+	return step.Hooks.WithLogSink(step.Deployment.Log).WithCredential(step.Deployment.RunAs).Run(name, hookConfigs, step.Deployment.RootDir, env)
+}
+
+// Runs hooks whose failure does not fail the deployment
+func runOptionalHooks(step *Step, name string, hookConfigs []config.DeployToVmConfigHook, env map[string]string) {
+	if hookErr := runHooks(step, name, hookConfigs, env); hookErr != nil {
+		log.Printf("Failed to run the %s hooks of the %s target: \"%v\"", name, step.Deployment.Target.Name, hookErr)
+	}
+}
+
+// Activates, reloads and health checks a single target, returning the phase
+// that failed
+func runStep(step *Step) (*Activation, string, error) {
+	targetHooks := step.Deployment.Target.Hooks
+	env := hookEnv(step)
+	if hookErr := runHooks(step, hooks.Hook_PreActivate, targetHooks.PreActivate, env); hookErr != nil {
+		return nil, Phase_Hook, hookErr
+	}
+
+	activation, activateErr := step.Target.Activate(step.Deployment)
+	if activateErr != nil {
+		return nil, Phase_Activate, activateErr
+	}
+	runOptionalHooks(step, hooks.Hook_PostActivate, targetHooks.PostActivate, env)
+
+	if reloadErr := step.Target.Reload(step.Deployment); reloadErr != nil {
+		return activation, Phase_Reload, reloadErr
+	}
+	if healthErr := step.Target.Health(step.Deployment); healthErr != nil {
+		return activation, Phase_Health, healthErr
+	}
+	if checkConfig := step.Deployment.Target.HealthCheck; checkConfig.URL != "" {
+		if checkErr := step.HealthCheck.Check(checkConfig); checkErr != nil {
+			return activation, Phase_Health, checkErr
+		}
+	}
+
+	runOptionalHooks(step, hooks.Hook_PostReload, targetHooks.PostReload, env)
+	return activation, "", nil
+}
+
+// Activates and reloads the previous release of a target
+func reactivate(target Target, previous *Deployment) error {
+	if _, activateErr := target.Activate(previous); activateErr != nil {
+		return activateErr
+	}
+	return target.Reload(previous)
+}
+
+// Rolls the targets of the steps back to their previous deployment in reverse
+// order. Rollbackers are also called without a previous deployment, so they
+// can stop what the failed deployment started.
+func rollback(steps []*Step, stepErr *StepError) {
+	for i := len(steps) - 1; i >= 0; i-- {
+		step := steps[i]
+		name := step.Deployment.Target.Name
+		rollbacker, isRollbacker := step.Target.(Rollbacker)
+		if step.Previous == nil && !isRollbacker {
+			log.Printf("No previous release to roll the %s target back to", name)
+			continue
+		}
+
+		if step.Previous != nil {
+			log.Printf("Rolling the %s target back to release %s", name, step.Previous.Tag)
+		}
+		var rollbackErr error
+		if isRollbacker {
+			rollbackErr = rollbacker.Rollback(step.Deployment, step.Previous)
+		} else {
+			rollbackErr = reactivate(step.Target, step.Previous)
+		}
+		if errors.Is(rollbackErr, ErrNoPreviousRelease) {
+			log.Printf("No previous release to roll the %s target back to", name)
+			continue
+		}
+		if rollbackErr != nil {
+			log.Printf("Failed to roll the %s target back: \"%v\"", name, rollbackErr)
+			if stepErr.RollbackErrors == nil {
+				stepErr.RollbackErrors = make(map[string]error)
+			}
+			stepErr.RollbackErrors[name] = rollbackErr
+			continue
+		}
+		stepErr.RolledBack = append(stepErr.RolledBack, name)
+	}
+}
+
+// Deploys a release to the targets of the steps in order. Every target is
+// prepared before any is activated. Then each target is activated, reloaded
+// and health checked, including its HTTP health check, before the next one.
+// The hooks of a target run around these steps, and only a failing
+// preActivate hook fails the deployment. If a target fails, it and the
+// targets before it are rolled back to their previous deployment, so all
+// targets keep serving the same release. Errors are of type *StepError.
+func Run(steps []*Step) ([]*Activation, error) {
+	for _, step := range steps {
+		prepareErr := step.Target.Prepare(step.Deployment)
+		if prepareErr == nil {
+			prepareErr = prepareHealthCheck(step)
+		}
+		if prepareErr == nil {
+			prepareErr = prepareHooks(step)
+		}
+		if prepareErr != nil {
+			return nil, &StepError{Target: step.Deployment.Target.Name, Phase: Phase_Prepare, Err: prepareErr}
+		}
+	}
+
+	activations := make([]*Activation, 0, len(steps))
+	for i, step := range steps {
+		activation, phase, stepErr := runStep(step)
+		if stepErr != nil {
+			runErr := &StepError{Target: step.Deployment.Target.Name, Phase: phase, Err: stepErr}
+			if phase == Phase_Hook {
+				// The target itself was not changed yet
+				rollback(steps[:i], runErr)
+			} else {
+				rollback(steps[:i+1], runErr)
+			}
+
+			env := hookEnv(step)
+			env["DEPLOY_TO_VM_FAILED_PHASE"] = phase
+			env["DEPLOY_TO_VM_ERROR"] = stepErr.Error()
+			runOptionalHooks(step, hooks.Hook_OnFailure, step.Deployment.Target.Hooks.OnFailure, env)
+			return nil, runErr
+		}
+		activations = append(activations, activation)
+	}
+	return activations, nil
+}
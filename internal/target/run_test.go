@@ -0,0 +1,368 @@
+package target
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"deploy-to-vm/internal/config"
+	deploy_to_vm_exec "deploy-to-vm/internal/exec"
+	"deploy-to-vm/internal/healthcheck"
+	"deploy-to-vm/internal/hooks"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// MockTarget records the steps it runs as "<phase> <target> <tag>" and fails
+// the phases in Errors
+type MockTarget struct {
+	Calls  *[]string
+	Errors map[string]error
+}
+
+func (m *MockTarget) call(phase string, deployment *Deployment) error {
+	*m.Calls = append(*m.Calls, phase+" "+deployment.Target.Name+" "+deployment.Tag)
+	return m.Errors[phase+" "+deployment.Tag]
+}
+
+func (m *MockTarget) Prepare(deployment *Deployment) error {
+	return m.call(Phase_Prepare, deployment)
+}
+
+func (m *MockTarget) Activate(deployment *Deployment) (*Activation, error) {
+	if err := m.call(Phase_Activate, deployment); err != nil {
+		return nil, err
+	}
+	return &Activation{Target: deployment.Target.Name}, nil
+}
+
+func (m *MockTarget) Reload(deployment *Deployment) error {
+	return m.call(Phase_Reload, deployment)
+}
+
+func (m *MockTarget) Health(deployment *Deployment) error {
+	return m.call(Phase_Health, deployment)
+}
+
+// MockHealthCheckClient records the URLs it checks and fails with Err
+type MockHealthCheckClient struct {
+	Calls *[]string
+	Err   error
+}
+
+func (m *MockHealthCheckClient) Check(checkConfig config.DeployToVmConfigHealthCheck) error {
+	*m.Calls = append(*m.Calls, "check "+checkConfig.URL)
+	return m.Err
+}
+
+var _ healthcheck.HealthCheckClientInterface = &MockHealthCheckClient{}
+
+// MockHookClient records the hooks it runs as "<name> <target> <tag>" and
+// fails the hooks in Errors
+type MockHookClient struct {
+	Calls  *[]string
+	Errors map[string]error
+	Env    map[string]map[string]string
+}
+
+func (m *MockHookClient) Run(name string, hookConfigs []config.DeployToVmConfigHook, rootDir string, env map[string]string) error {
+	*m.Calls = append(*m.Calls, name+" "+env["DEPLOY_TO_VM_TARGET"]+" "+env["DEPLOY_TO_VM_TAG"])
+	if m.Env == nil {
+		m.Env = make(map[string]map[string]string)
+	}
+	m.Env[name] = env
+	return m.Errors[name]
+}
+
+func (m *MockHookClient) WithLogSink(sink deploy_to_vm_exec.LogSink) hooks.HookClientInterface {
+	return m
+}
+
+func (m *MockHookClient) WithCredential(credential *deploy_to_vm_exec.Credential) hooks.HookClientInterface {
+	return m
+}
+
+// Helper to create a step with a hook for every lifecycle event
+func newHookedStep(name string, calls *[]string, hookErrs map[string]error, errs map[string]error) (*Step, *MockHookClient) {
+	step := newMockStep(name, calls, errs, true)
+	hook := []config.DeployToVmConfigHook{{Command: "true"}}
+	step.Deployment.Target.Hooks = config.DeployToVmConfigHooks{PreActivate: hook, PostActivate: hook, PostReload: hook, OnFailure: hook}
+	step.Deployment.RootDir = os.TempDir()
+	mockHookClient := &MockHookClient{Calls: calls, Errors: hookErrs}
+	step.Hooks = mockHookClient
+	return step, mockHookClient
+}
+
+// Helper to create a step that deploys v2 to a target, with v1 as the
+// previous release if withPrevious is set
+func newMockStep(name string, calls *[]string, errs map[string]error, withPrevious bool) *Step {
+	step := &Step{
+		Target:     &MockTarget{Calls: calls, Errors: errs},
+		Deployment: &Deployment{Tag: "v2", Target: &config.DeployToVmConfigTarget{Name: name}},
+	}
+	if withPrevious {
+		step.Previous = &Deployment{Tag: "v1", Target: step.Deployment.Target}
+	}
+	return step
+}
+
+func TestRun_Success(t *testing.T) {
+	// Arrange: create two targets
+	calls := make([]string, 0)
+	steps := []*Step{
+		newMockStep("web", &calls, nil, true),
+		newMockStep("api", &calls, nil, true),
+	}
+
+	// Act: deploy the release
+	activations, err := Run(steps)
+
+	// Assert: check if all targets are prepared before they are deployed in order
+	assert.NoError(t, err)
+	assert.Len(t, activations, 2)
+	assert.Equal(t, []string{
+		"prepare web v2", "prepare api v2",
+		"activate web v2", "reload web v2", "health web v2",
+		"activate api v2", "reload api v2", "health api v2",
+	}, calls)
+}
+
+func TestRun_PrepareError(t *testing.T) {
+	// Arrange: create two targets where the second fails to prepare
+	calls := make([]string, 0)
+	steps := []*Step{
+		newMockStep("web", &calls, nil, true),
+		newMockStep("api", &calls, map[string]error{"prepare v2": errors.New("missing config")}, true),
+	}
+
+	// Act: deploy the release
+	_, err := Run(steps)
+
+	// Assert: check if nothing is activated
+	var stepErr *StepError
+	assert.True(t, errors.As(err, &stepErr))
+	assert.Equal(t, "api", stepErr.Target)
+	assert.Equal(t, Phase_Prepare, stepErr.Phase)
+	assert.Equal(t, []string{"prepare web v2", "prepare api v2"}, calls)
+}
+
+func TestRun_RollsBackOnFailure(t *testing.T) {
+	// Arrange: create two targets where the second fails to reload
+	calls := make([]string, 0)
+	steps := []*Step{
+		newMockStep("web", &calls, nil, true),
+		newMockStep("api", &calls, map[string]error{"reload v2": errors.New("process crashed")}, true),
+	}
+
+	// Act: deploy the release
+	_, err := Run(steps)
+
+	// Assert: check if both targets are rolled back in reverse order
+	var stepErr *StepError
+	assert.True(t, errors.As(err, &stepErr))
+	assert.Equal(t, "Failed to reload the api target: process crashed", err.Error())
+	assert.Equal(t, []string{"api", "web"}, stepErr.RolledBack)
+	assert.Equal(t, []string{
+		"prepare web v2", "prepare api v2",
+		"activate web v2", "reload web v2", "health web v2",
+		"activate api v2", "reload api v2",
+		"activate api v1", "reload api v1",
+		"activate web v1", "reload web v1",
+	}, calls)
+}
+
+func TestRun_NoPreviousRelease(t *testing.T) {
+	// Arrange: create a first deployment that fails its health check
+	calls := make([]string, 0)
+	steps := []*Step{
+		newMockStep("web", &calls, map[string]error{"health v2": errors.New("502 Bad Gateway")}, false),
+	}
+
+	// Act: deploy the release
+	_, err := Run(steps)
+
+	// Assert: check if there is nothing to roll back
+	var stepErr *StepError
+	assert.True(t, errors.As(err, &stepErr))
+	assert.Equal(t, Phase_Health, stepErr.Phase)
+	assert.Empty(t, stepErr.RolledBack)
+	assert.Empty(t, stepErr.RollbackErrors)
+}
+
+func TestRun_RollbackError(t *testing.T) {
+	// Arrange: create a target that fails to activate both releases
+	calls := make([]string, 0)
+	steps := []*Step{
+		newMockStep("web", &calls, map[string]error{
+			"activate v2": errors.New("disk full"),
+			"activate v1": errors.New("disk full"),
+		}, true),
+	}
+
+	// Act: deploy the release
+	_, err := Run(steps)
+
+	// Assert: check if the failed rollback is reported
+	var stepErr *StepError
+	assert.True(t, errors.As(err, &stepErr))
+	assert.Empty(t, stepErr.RolledBack)
+	assert.EqualError(t, stepErr.RollbackErrors["web"], "disk full")
+}
+
+func TestRun_HealthCheck(t *testing.T) {
+	// Arrange: create a target with an HTTP health check
+	calls := make([]string, 0)
+	step := newMockStep("web", &calls, nil, true)
+	step.Deployment.Target.HealthCheck = config.DeployToVmConfigHealthCheck{URL: "http://localhost:8080/health"}
+	step.HealthCheck = &MockHealthCheckClient{Calls: &calls}
+
+	// Act: deploy the release
+	_, err := Run([]*Step{step})
+
+	// Assert: check if the URL is checked after the health check of the target
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"prepare web v2", "activate web v2", "reload web v2", "health web v2", "check http://localhost:8080/health"}, calls)
+}
+
+func TestRun_HealthCheckError(t *testing.T) {
+	// Arrange: create a target whose HTTP health check fails
+	calls := make([]string, 0)
+	step := newMockStep("web", &calls, nil, true)
+	step.Deployment.Target.HealthCheck = config.DeployToVmConfigHealthCheck{URL: "http://localhost:8080/health"}
+	step.HealthCheck = &MockHealthCheckClient{Calls: &calls, Err: errors.New("responded with status 502 instead of 200")}
+
+	// Act: deploy the release
+	_, err := Run([]*Step{step})
+
+	// Assert: check if the failed check rolls the target back like a failed reload
+	var stepErr *StepError
+	assert.True(t, errors.As(err, &stepErr))
+	assert.Equal(t, Phase_Health, stepErr.Phase)
+	assert.Equal(t, []string{"web"}, stepErr.RolledBack)
+	assert.Equal(t, []string{"activate web v1", "reload web v1"}, calls[len(calls)-2:])
+}
+
+func TestRun_HealthCheckInvalidConfig(t *testing.T) {
+	// Arrange: create a target with an invalid health check timeout
+	calls := make([]string, 0)
+	step := newMockStep("web", &calls, nil, true)
+	step.Deployment.Target.HealthCheck = config.DeployToVmConfigHealthCheck{URL: "http://localhost:8080/health", Timeout: "soon"}
+	step.HealthCheck = &MockHealthCheckClient{Calls: &calls}
+
+	// Act: deploy the release
+	_, err := Run([]*Step{step})
+
+	// Assert: check if the deployment fails before anything is activated
+	var stepErr *StepError
+	assert.True(t, errors.As(err, &stepErr))
+	assert.Equal(t, Phase_Prepare, stepErr.Phase)
+	assert.Equal(t, []string{"prepare web v2"}, calls)
+}
+
+func TestRun_HealthCheckNegativeRetries(t *testing.T) {
+	// Arrange: create a target with negative health check retries
+	calls := make([]string, 0)
+	step := newMockStep("web", &calls, nil, true)
+	step.Deployment.Target.HealthCheck = config.DeployToVmConfigHealthCheck{URL: "http://localhost:8080/health", Retries: -1}
+	step.HealthCheck = &MockHealthCheckClient{Calls: &calls}
+
+	// Act: deploy the release
+	_, err := Run([]*Step{step})
+
+	// Assert: check if the deployment fails before anything is activated
+	var stepErr *StepError
+	assert.True(t, errors.As(err, &stepErr))
+	assert.Equal(t, Phase_Prepare, stepErr.Phase)
+	assert.Contains(t, err.Error(), "Invalid health check retries")
+	assert.Equal(t, []string{"prepare web v2"}, calls)
+}
+
+func TestRun_Hooks(t *testing.T) {
+	// Arrange: create a target with hooks
+	calls := make([]string, 0)
+	step, mockHookClient := newHookedStep("web", &calls, nil, nil)
+
+	// Act: deploy the release
+	_, err := Run([]*Step{step})
+
+	// Assert: check if the hooks run around the steps
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"prepare web v2",
+		"preActivate web v2", "activate web v2", "postActivate web v2",
+		"reload web v2", "health web v2", "postReload web v2",
+	}, calls)
+	assert.Equal(t, "v1", mockHookClient.Env["preActivate"]["DEPLOY_TO_VM_PREVIOUS_TAG"])
+}
+
+func TestRun_PreActivateHookError(t *testing.T) {
+	// Arrange: create two targets where the pre-hook of the second fails
+	calls := make([]string, 0)
+	web := newMockStep("web", &calls, nil, true)
+	api, mockHookClient := newHookedStep("api", &calls, map[string]error{"preActivate": errors.New("migration failed")}, nil)
+
+	// Act: deploy the release
+	_, err := Run([]*Step{web, api})
+
+	// Assert: check if the deployment is aborted before the target changes and
+	// only the first target is rolled back
+	var stepErr *StepError
+	assert.True(t, errors.As(err, &stepErr))
+	assert.Equal(t, Phase_Hook, stepErr.Phase)
+	assert.Equal(t, "Failed to run a hook of the api target: migration failed", err.Error())
+	assert.Equal(t, []string{"web"}, stepErr.RolledBack)
+	assert.Equal(t, []string{
+		"prepare web v2", "prepare api v2",
+		"activate web v2", "reload web v2", "health web v2",
+		"preActivate api v2",
+		"activate web v1", "reload web v1",
+		"onFailure api v2",
+	}, calls)
+	assert.Equal(t, Phase_Hook, mockHookClient.Env["onFailure"]["DEPLOY_TO_VM_FAILED_PHASE"])
+	assert.Equal(t, "migration failed", mockHookClient.Env["onFailure"]["DEPLOY_TO_VM_ERROR"])
+}
+
+func TestRun_PostHookErrorsDoNotFail(t *testing.T) {
+	// Arrange: create a target whose post-hooks fail
+	calls := make([]string, 0)
+	step, _ := newHookedStep("web", &calls, map[string]error{
+		"postActivate": errors.New("cache warmup failed"),
+		"postReload":   errors.New("cleanup failed"),
+	}, nil)
+
+	// Act: deploy the release
+	_, err := Run([]*Step{step})
+
+	// Assert: check if the deployment succeeds
+	assert.NoError(t, err)
+	assert.Contains(t, calls, "postReload web v2")
+}
+
+func TestRun_OnFailureHook(t *testing.T) {
+	// Arrange: create a target that fails to reload
+	calls := make([]string, 0)
+	step, mockHookClient := newHookedStep("web", &calls, nil, map[string]error{"reload v2": errors.New("process crashed")})
+
+	// Act: deploy the release
+	_, err := Run([]*Step{step})
+
+	// Assert: check if the failure hook runs after the rollback
+	assert.Error(t, err)
+	assert.Equal(t, []string{"reload web v1", "onFailure web v2"}, calls[len(calls)-2:])
+	assert.Equal(t, Phase_Reload, mockHookClient.Env["onFailure"]["DEPLOY_TO_VM_FAILED_PHASE"])
+}
+
+func TestRun_HooksWithoutClient(t *testing.T) {
+	// Arrange: create a target with hooks and no client to run them
+	calls := make([]string, 0)
+	step, _ := newHookedStep("web", &calls, nil, nil)
+	step.Hooks = nil
+
+	// Act: deploy the release
+	_, err := Run([]*Step{step})
+
+	// Assert: check if the deployment fails before anything changes
+	var stepErr *StepError
+	assert.True(t, errors.As(err, &stepErr))
+	assert.Equal(t, Phase_Prepare, stepErr.Phase)
+}
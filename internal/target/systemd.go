@@ -0,0 +1,102 @@
+package target
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"deploy-to-vm/internal/systemd"
+)
+
+// SystemdTarget deploys services that run as systemd units. The release is
+// linked to the site directory and the unit is reloaded or restarted. With
+// blue/green enabled, the unit is a template and each color is an instance of
+// it, e.g. "api@blue.service". systemctl manages system units, so it runs as
+// the user of the server even if the repository sets runAs. The unit selects
+// the user of the service with "User=".
+type SystemdTarget struct {
+	SiteTarget
+	Client    systemd.SystemdClientInterface
+	BlueGreen *BlueGreen
+}
+
+func NewSystemdTarget(client systemd.SystemdClientInterface) *SystemdTarget {
+	return &SystemdTarget{Client: client}
+}
+
+func (t *SystemdTarget) Prepare(deployment *Deployment) error {
+	systemdConfig := deployment.Target.Systemd
+	if systemdConfig.Unit == "" {
+		return errors.New("systemd unit is not configured")
+	}
+	if _, actionErr := systemd.GetAction(systemdConfig.Action); actionErr != nil {
+		return actionErr
+	}
+	if _, timeoutErr := systemdConfig.GetTimeout(); timeoutErr != nil {
+		return timeoutErr
+	}
+	if deployment.Target.BlueGreen.Enabled {
+		if !strings.Contains(systemdConfig.Unit, "@.") {
+			return fmt.Errorf("Blue/green needs a template unit like \"app@.service\", got %q", systemdConfig.Unit)
+		}
+		return t.BlueGreen.Prepare(deployment)
+	}
+	return nil
+}
+
+func (t *SystemdTarget) Activate(deployment *Deployment) (*Activation, error) {
+	if deployment.Target.BlueGreen.Enabled {
+		return t.BlueGreen.Activate(deployment)
+	}
+	return LinkSite(deployment)
+}
+
+func (t *SystemdTarget) Reload(deployment *Deployment) error {
+	if deployment.Target.BlueGreen.Enabled {
+		return t.BlueGreen.Reload(deployment, t)
+	}
+	systemdConfig := deployment.Target.Systemd
+	timeout, timeoutErr := systemdConfig.GetTimeout()
+	if timeoutErr != nil {
+		return timeoutErr
+	}
+	return t.Client.WithLogSink(deployment.Log).Reload(systemdConfig.Unit, systemdConfig.Action, timeout)
+}
+
+// Returns the instance of the template unit for a color
+func colorUnit(deployment *Deployment, color string) string {
+	return strings.Replace(deployment.Target.Systemd.Unit, "@.", "@"+color+".", 1)
+}
+
+// Returns the file with the environment of a color, for the template unit to
+// read with "EnvironmentFile=<dir>/%i.env"
+func colorEnvFile(deployment *Deployment, color string) string {
+	return filepath.Join(deployment.Target.Dir, color+".env")
+}
+
+// Writes the port of the color to its environment file and restarts its unit
+func (t *SystemdTarget) StartColor(deployment *Deployment, color string, port int) error {
+	if err := os.WriteFile(colorEnvFile(deployment, color), []byte(fmt.Sprintf("PORT=%d\n", port)), 0644); err != nil {
+		return fmt.Errorf("Error while writing the environment of the %s color: %v", color, err)
+	}
+	timeout, _ := deployment.Target.Systemd.GetTimeout()
+	return t.Client.WithLogSink(deployment.Log).Reload(colorUnit(deployment, color), systemd.Action_Restart, timeout)
+}
+
+// Rolls a failed deployment back, leaving the active color alone if the
+// traffic was not switched to the failed release yet
+func (t *SystemdTarget) Rollback(deployment *Deployment, previous *Deployment) error {
+	if deployment.Target.BlueGreen.Enabled {
+		return t.BlueGreen.Rollback(deployment, previous, t)
+	}
+	if previous == nil {
+		return ErrNoPreviousRelease
+	}
+	return reactivate(t, previous)
+}
+
+func (t *SystemdTarget) StopColor(deployment *Deployment, color string) error {
+	return t.Client.Stop(colorUnit(deployment, color))
+}
@@ -0,0 +1,193 @@
+package target
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"deploy-to-vm/internal/config"
+	deploy_to_vm_exec "deploy-to-vm/internal/exec"
+	file_utils "deploy-to-vm/internal/file-utils"
+)
+
+// Deployment describes a release that is deployed to a target.
+type Deployment struct {
+	Owner string
+	Repo  string
+	Tag   string
+	// ReleaseDir is the release directory the release was prepared in
+	ReleaseDir string
+	// RootDir is the directory of the release that becomes the site, either
+	// ReleaseDir or one of its subdirectories
+	RootDir string
+	// SiteDir is the directory the release is served from
+	SiteDir string
+	Config  *config.DeployToVmConfigRepository
+	// Target is the config of the target the release is deployed to
+	Target *config.DeployToVmConfigTarget
+	// Log receives the output of the commands of the deployment while they
+	// run. It is nil if the output is not recorded.
+	Log deploy_to_vm_exec.LogSink
+	// RunAs is the user the commands of the target and the hooks run as. It
+	// is nil if they run as the user of the server.
+	RunAs *deploy_to_vm_exec.Credential
+}
+
+// Activation describes how a release was made live on a target.
+type Activation struct {
+	// Target is the name of the target
+	Target string `json:"target"`
+	// LinkStrategy is the strategy the release files were linked with
+	LinkStrategy string `json:"linkStrategy"`
+	// Sync lists the changes of an incremental sync, nil when the site
+	// directory was replaced
+	Sync *file_utils.SyncResult `json:"sync,omitempty"`
+}
+
+// Target is a kind of service a release is deployed to. A deployment runs the
+// steps in order and stops at the first error:
+//
+//   - Prepare checks the release and the config before anything changes
+//   - Activate makes the release live, usually by linking it to the site
+//     directory
+//   - Reload makes the service pick up the release
+//   - Health checks that the service works with the release
+type Target interface {
+	Prepare(deployment *Deployment) error
+	Activate(deployment *Deployment) (*Activation, error)
+	Reload(deployment *Deployment) error
+	Health(deployment *Deployment) error
+}
+
+// ErrNoPreviousRelease is returned by a Rollbacker that has nothing to roll
+// back to.
+var ErrNoPreviousRelease = errors.New("No previous release to roll back to")
+
+// Rollbacker is implemented by targets that roll a failed deployment back
+// themselves instead of activating and reloading the previous release.
+// previous is nil if there is no release to roll back to, so the target can
+// still clean up what the failed deployment started.
+type Rollbacker interface {
+	Rollback(deployment *Deployment, previous *Deployment) error
+}
+
+// ArchiveSkipper is implemented by targets that need some release assets to
+// stay as they are instead of being extracted.
+type ArchiveSkipper interface {
+	SkipArchives(targetConfig *config.DeployToVmConfigTarget) []string
+}
+
+// Returns the strategy release files are linked to the site directory with.
+// Releases in the store share their files with the other releases, so they
+// are copied instead of hard linked, unless shared site files are allowed.
+func GetLinkStrategy(repositoryConfig *config.DeployToVmConfigRepository) (string, error) {
+	linkStrategy := repositoryConfig.Sync.LinkStrategy
+	if !repositoryConfig.Storage.Deduplicate || repositoryConfig.Storage.AllowSharedSiteFiles {
+		return linkStrategy, nil
+	}
+
+	switch linkStrategy {
+	case "", file_utils.LinkStrategy_Auto:
+		return file_utils.LinkStrategy_Copy, nil
+	case file_utils.LinkStrategy_Hardlink, file_utils.LinkStrategy_Symlink:
+		return "", fmt.Errorf("Link strategy %q shares the site files with every release in the store, set storage.allowSharedSiteFiles to allow it", linkStrategy)
+	default:
+		return linkStrategy, nil
+	}
+}
+
+// Links the root directory of a release to the site directory with the
+// configured sync mode, link strategy and included files
+func LinkSite(deployment *Deployment) (*Activation, error) {
+	repositoryConfig := deployment.Config
+	linkStrategy, linkStrategyErr := GetLinkStrategy(repositoryConfig)
+	if linkStrategyErr != nil {
+		return nil, linkStrategyErr
+	}
+	linkOptions := file_utils.LinkOptions{
+		Strategy: linkStrategy,
+		Fallback: repositoryConfig.Sync.LinkFallback,
+		Include:  deployment.Target.Include,
+	}
+	if deployment.RunAs != nil {
+		linkOptions.Owner = &file_utils.FileOwner{Uid: int(deployment.RunAs.Uid), Gid: int(deployment.RunAs.Gid)}
+	}
+
+	switch repositoryConfig.Sync.Mode {
+	case "", file_utils.SyncMode_Replace:
+		linkStrategy, linkErr := file_utils.LinkReleaseAssetsToSiteDirWithOptions(deployment.RootDir, deployment.SiteDir, linkOptions)
+		if linkErr != nil {
+			return nil, linkErr
+		}
+		return &Activation{Target: deployment.Target.Name, LinkStrategy: linkStrategy}, nil
+	case file_utils.SyncMode_Sync:
+		syncResult, syncErr := file_utils.SyncReleaseAssetsToSiteDir(deployment.RootDir, deployment.SiteDir, file_utils.SyncOptions{
+			ProtectedPaths: repositoryConfig.Sync.ProtectedPaths,
+			Link:           linkOptions,
+		})
+		if syncErr != nil {
+			return nil, syncErr
+		}
+		return &Activation{Target: deployment.Target.Name, LinkStrategy: syncResult.Strategy, Sync: syncResult}, nil
+	default:
+		return nil, fmt.Errorf("Unknown sync mode: %q", repositoryConfig.Sync.Mode)
+	}
+}
+
+// SiteTarget implements the steps most targets share: nothing to prepare, the
+// release is linked to the site directory and there is no health check.
+// Targets embed it and override the steps they need.
+type SiteTarget struct{}
+
+func (SiteTarget) Prepare(deployment *Deployment) error {
+	return nil
+}
+
+func (SiteTarget) Activate(deployment *Deployment) (*Activation, error) {
+	return LinkSite(deployment)
+}
+
+func (SiteTarget) Health(deployment *Deployment) error {
+	return nil
+}
+
+// Registry holds the targets by their type, the "targetType" of a repository.
+type Registry struct {
+	targets map[string]Target
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		targets: make(map[string]Target),
+	}
+}
+
+// Registers a target for a type, replacing any target registered before
+func (r *Registry) Register(targetType string, target Target) *Registry {
+	r.targets[targetType] = target
+	return r
+}
+
+// Returns the target of a type
+func (r *Registry) Get(targetType string) (Target, error) {
+	if r != nil {
+		if target, ok := r.targets[targetType]; ok {
+			return target, nil
+		}
+	}
+	return nil, fmt.Errorf("Unknown target type: %q (supported: %s)", targetType, strings.Join(r.Types(), ", "))
+}
+
+// Returns the registered target types in alphabetical order
+func (r *Registry) Types() []string {
+	types := make([]string, 0)
+	if r == nil {
+		return types
+	}
+	for targetType := range r.targets {
+		types = append(types, targetType)
+	}
+	sort.Strings(types)
+	return types
+}
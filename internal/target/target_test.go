@@ -0,0 +1,510 @@
+package target
+
+import (
+	"errors"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"deploy-to-vm/internal/config"
+	deploy_to_vm_exec "deploy-to-vm/internal/exec"
+	file_utils "deploy-to-vm/internal/file-utils"
+	"deploy-to-vm/internal/nginx"
+	"deploy-to-vm/internal/pm2"
+	"deploy-to-vm/internal/systemd"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type MockNginxClient struct {
+	TestErr  error
+	Reloaded bool
+}
+
+func (m *MockNginxClient) Reload() error {
+	m.Reloaded = true
+	return nil
+}
+
+func (m *MockNginxClient) Test() error {
+	return m.TestErr
+}
+
+func (m *MockNginxClient) WithLogSink(sink deploy_to_vm_exec.LogSink) nginx.NginxClientInterface {
+	return m
+}
+
+type MockPm2Client struct {
+	Processes []pm2.Process
+	// Calls are the calls in the format "<method> <args>"
+	Calls []string
+	// mutex guards Calls, as blue/green targets stop processes in the background
+	mutex sync.Mutex
+}
+
+func (m *MockPm2Client) record(call string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.Calls = append(m.Calls, call)
+}
+
+// Returns a copy of the calls
+func (m *MockPm2Client) GetCalls() []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]string{}, m.Calls...)
+}
+
+func (m *MockPm2Client) Reload(targetProcessName string) error {
+	m.record("reload " + targetProcessName)
+	return nil
+}
+
+func (m *MockPm2Client) List() ([]pm2.Process, error) {
+	return m.Processes, nil
+}
+
+func (m *MockPm2Client) Start(ecosystemFile string, targetProcessName string) error {
+	m.record("start " + ecosystemFile + " " + targetProcessName)
+	return nil
+}
+
+func (m *MockPm2Client) Stop(targetProcessName string) error {
+	m.record("stop " + targetProcessName)
+	return nil
+}
+
+func (m *MockPm2Client) Save() error {
+	m.record("save")
+	return nil
+}
+
+func (m *MockPm2Client) WaitForOnline(targetProcessName string, version string, timeout time.Duration) (*pm2.Process, error) {
+	m.record("wait " + targetProcessName + " " + version)
+	return &pm2.Process{Name: targetProcessName}, nil
+}
+
+func (m *MockPm2Client) WithLogSink(sink deploy_to_vm_exec.LogSink) pm2.Pm2ClientInterface {
+	return m
+}
+
+func (m *MockPm2Client) WithCredential(credential *deploy_to_vm_exec.Credential) pm2.Pm2ClientInterface {
+	if credential != nil {
+		m.record("as " + credential.Username)
+	}
+	return m
+}
+
+// MockSystemdClient records the calls in the format "<action> <unit>"
+type MockSystemdClient struct {
+	Calls []string
+}
+
+func (m *MockSystemdClient) Reload(unit string, action string, timeout time.Duration) error {
+	m.Calls = append(m.Calls, action+" "+unit)
+	return nil
+}
+
+func (m *MockSystemdClient) Stop(unit string) error {
+	m.Calls = append(m.Calls, "stop "+unit)
+	return nil
+}
+
+func (m *MockSystemdClient) WithLogSink(sink deploy_to_vm_exec.LogSink) systemd.SystemdClientInterface {
+	return m
+}
+
+// Helper to create a deployment of a release with an index.html file
+func setupTargetTest(t *testing.T, repositoryConfig *config.DeployToVmConfigRepository) *Deployment {
+	releaseDir := t.TempDir()
+	os.WriteFile(path.Join(releaseDir, "index.html"), []byte("index"), 0644)
+	targetConfigs := repositoryConfig.GetTargets()
+
+	return &Deployment{
+		Owner:      "owner",
+		Repo:       "repo",
+		Tag:        "v1",
+		ReleaseDir: releaseDir,
+		RootDir:    releaseDir,
+		SiteDir:    t.TempDir(),
+		Config:     repositoryConfig,
+		Target:     &targetConfigs[0],
+	}
+}
+
+func TestRegistry_Get(t *testing.T) {
+	// Arrange: create the default registry
+	registry := NewDefaultRegistry(Clients{})
+
+	// Act: get a built-in and an unknown target
+	nginxTarget, nginxErr := registry.Get("nginx")
+	_, unknownErr := registry.Get("apache")
+
+	// Assert: check if only the built-in target is found
+	assert.NoError(t, nginxErr)
+	assert.IsType(t, &NginxTarget{}, nginxTarget)
+	assert.EqualError(t, unknownErr, `Unknown target type: "apache" (supported: docker-compose, nginx, pm2, systemd)`)
+}
+
+func TestRegistry_Get_NilRegistry(t *testing.T) {
+	var registry *Registry
+
+	// Act: get a target from a nil registry
+	_, err := registry.Get("nginx")
+
+	// Assert: check if there was an error
+	assert.Error(t, err)
+}
+
+func TestLinkSite_Replace(t *testing.T) {
+	// Arrange: create a deployment with the default sync mode
+	deployment := setupTargetTest(t, &config.DeployToVmConfigRepository{})
+
+	// Act: link the release to the site
+	activation, err := LinkSite(deployment)
+
+	// Assert: check if the release is linked
+	assert.NoError(t, err)
+	assert.Equal(t, file_utils.LinkStrategy_Hardlink, activation.LinkStrategy)
+	assert.Nil(t, activation.Sync)
+	_, statErr := os.Stat(path.Join(deployment.SiteDir, "index.html"))
+	assert.NoError(t, statErr)
+}
+
+func TestLinkSite_Sync(t *testing.T) {
+	// Arrange: create a deployment with the sync mode
+	deployment := setupTargetTest(t, &config.DeployToVmConfigRepository{
+		Sync: config.DeployToVmConfigSync{Mode: file_utils.SyncMode_Sync},
+	})
+
+	// Act: link the release to the site
+	activation, err := LinkSite(deployment)
+
+	// Assert: check if the changes are reported
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"index.html"}, activation.Sync.Added)
+}
+
+func TestLinkSite_UnknownSyncMode(t *testing.T) {
+	// Arrange: create a deployment with an unknown sync mode
+	deployment := setupTargetTest(t, &config.DeployToVmConfigRepository{
+		Sync: config.DeployToVmConfigSync{Mode: "mirror"},
+	})
+
+	// Act: link the release to the site
+	_, err := LinkSite(deployment)
+
+	// Assert: check if there was an error
+	assert.Error(t, err)
+}
+
+func TestLinkSite_DeduplicateCopies(t *testing.T) {
+	// Arrange: create a deployment of a release in the store
+	deployment := setupTargetTest(t, &config.DeployToVmConfigRepository{
+		Storage: config.DeployToVmConfigStorage{Deduplicate: true},
+	})
+
+	// Act: link the release to the site
+	activation, err := LinkSite(deployment)
+
+	// Assert: check if the site files are copied instead of sharing the
+	// files of the store
+	assert.NoError(t, err)
+	assert.Contains(t, []string{file_utils.LinkStrategy_Copy, file_utils.LinkStrategy_Reflink}, activation.LinkStrategy)
+	releaseInfo, _ := os.Stat(path.Join(deployment.RootDir, "index.html"))
+	siteInfo, _ := os.Stat(path.Join(deployment.SiteDir, "index.html"))
+	assert.False(t, os.SameFile(releaseInfo, siteInfo), "Expected the file to be copied")
+}
+
+func TestGetLinkStrategy_Deduplicate(t *testing.T) {
+	// Arrange: create repositories that keep their releases in the store
+	storage := config.DeployToVmConfigStorage{Deduplicate: true}
+	hardlinkConfig := &config.DeployToVmConfigRepository{Storage: storage, Sync: config.DeployToVmConfigSync{LinkStrategy: file_utils.LinkStrategy_Hardlink}}
+	symlinkConfig := &config.DeployToVmConfigRepository{Storage: storage, Sync: config.DeployToVmConfigSync{LinkStrategy: file_utils.LinkStrategy_Symlink}}
+	allowedConfig := &config.DeployToVmConfigRepository{
+		Storage: config.DeployToVmConfigStorage{Deduplicate: true, AllowSharedSiteFiles: true},
+		Sync:    config.DeployToVmConfigSync{LinkStrategy: file_utils.LinkStrategy_Hardlink},
+	}
+
+	// Act: get the link strategies
+	_, hardlinkErr := GetLinkStrategy(hardlinkConfig)
+	_, symlinkErr := GetLinkStrategy(symlinkConfig)
+	allowedStrategy, allowedErr := GetLinkStrategy(allowedConfig)
+
+	// Assert: check if sharing the store with the site needs to be allowed
+	assert.Error(t, hardlinkErr)
+	assert.Contains(t, hardlinkErr.Error(), "storage.allowSharedSiteFiles")
+	assert.Error(t, symlinkErr)
+	assert.NoError(t, allowedErr)
+	assert.Equal(t, file_utils.LinkStrategy_Hardlink, allowedStrategy)
+}
+
+func TestPm2Target_ProcessName(t *testing.T) {
+	mockPm2Client := &MockPm2Client{Processes: []pm2.Process{{Name: "legacy"}, {Name: "api"}}}
+	pm2Target := NewPm2Target(mockPm2Client)
+
+	// Act/Assert: check if a missing process name fails the preparation
+	assert.Error(t, pm2Target.Prepare(setupTargetTest(t, &config.DeployToVmConfigRepository{})))
+
+	// Act/Assert: check if the legacy process name is used
+	legacyDeployment := setupTargetTest(t, &config.DeployToVmConfigRepository{TargetProcessName: "legacy"})
+	assert.NoError(t, pm2Target.Prepare(legacyDeployment))
+	assert.NoError(t, pm2Target.Reload(legacyDeployment))
+	assert.Equal(t, "reload legacy", mockPm2Client.Calls[0])
+
+	// Act/Assert: check if the pm2 section takes precedence
+	deployment := setupTargetTest(t, &config.DeployToVmConfigRepository{
+		TargetProcessName: "legacy",
+		Pm2:               config.DeployToVmConfigPm2{ProcessName: "api"},
+	})
+	assert.NoError(t, pm2Target.Reload(deployment))
+	assert.Equal(t, "reload api", mockPm2Client.Calls[2])
+}
+
+func TestPm2Target_Reload_RunningProcess(t *testing.T) {
+	// Arrange: create a running process
+	mockPm2Client := &MockPm2Client{Processes: []pm2.Process{{Name: "api"}}}
+	deployment := setupTargetTest(t, &config.DeployToVmConfigRepository{
+		Pm2: config.DeployToVmConfigPm2{ProcessName: "api", Script: "server.js"},
+	})
+
+	// Act: reload the target
+	err := NewPm2Target(mockPm2Client).Reload(deployment)
+
+	// Assert: check if the process is reloaded and saved
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"reload api", "save"}, mockPm2Client.Calls)
+}
+
+func TestPm2Target_Reload_RunAs(t *testing.T) {
+	// Arrange: create a deployment whose commands run as the app user
+	mockPm2Client := &MockPm2Client{Processes: []pm2.Process{{Name: "api"}}}
+	deployment := setupTargetTest(t, &config.DeployToVmConfigRepository{
+		Pm2: config.DeployToVmConfigPm2{ProcessName: "api"},
+	})
+	deployment.RunAs = &deploy_to_vm_exec.Credential{Uid: 1001, Gid: 1001, Username: "app", HomeDir: "/home/app"}
+
+	// Act: reload the target
+	err := NewPm2Target(mockPm2Client).Reload(deployment)
+
+	// Assert: check if pm2 runs as the app user
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"as app", "reload api", "save"}, mockPm2Client.Calls)
+}
+
+func TestPm2Target_Reload_StartsFromEcosystemFile(t *testing.T) {
+	// Arrange: create a release with an ecosystem file and no running process
+	mockPm2Client := &MockPm2Client{}
+	deployment := setupTargetTest(t, &config.DeployToVmConfigRepository{
+		Pm2: config.DeployToVmConfigPm2{ProcessName: "api", EcosystemFile: "ecosystem.config.js"},
+	})
+	os.WriteFile(path.Join(deployment.RootDir, "ecosystem.config.js"), []byte("module.exports = {}"), 0644)
+	pm2Target := NewPm2Target(mockPm2Client)
+
+	// Act: prepare and reload the target
+	prepareErr := pm2Target.Prepare(deployment)
+	reloadErr := pm2Target.Reload(deployment)
+
+	// Assert: check if the process is started from the ecosystem file in the site
+	assert.NoError(t, prepareErr)
+	assert.NoError(t, reloadErr)
+	assert.Equal(t, []string{"start " + path.Join(deployment.SiteDir, "ecosystem.config.js") + " api", "save"}, mockPm2Client.Calls)
+}
+
+func TestPm2Target_Reload_StartsFromScript(t *testing.T) {
+	// Arrange: configure a script and no running process
+	mockPm2Client := &MockPm2Client{}
+	deployment := setupTargetTest(t, &config.DeployToVmConfigRepository{
+		TargetType: "pm2",
+		Pm2: config.DeployToVmConfigPm2{
+			ProcessName: "api",
+			Script:      "server.js",
+			Env:         map[string]string{"PORT": "3000"},
+			Instances:   2,
+		},
+	})
+
+	// Act: reload the target
+	err := NewPm2Target(mockPm2Client).Reload(deployment)
+
+	// Assert: check if an ecosystem file is generated to start the process from
+	assert.NoError(t, err)
+	ecosystemFile := path.Join(deployment.ReleaseDir, file_utils.MetadataDirName, "pm2.ecosystem.json")
+	assert.Equal(t, []string{"start " + ecosystemFile + " api", "save"}, mockPm2Client.Calls)
+	data, readErr := os.ReadFile(ecosystemFile)
+	assert.NoError(t, readErr)
+	assert.JSONEq(t, `{"apps":[{"name":"api","script":"server.js","cwd":"`+deployment.SiteDir+`","env":{"PORT":"3000"},"instances":2}]}`, string(data))
+}
+
+func TestPm2Target_Reload_NothingToStart(t *testing.T) {
+	// Arrange: configure neither an ecosystem file nor a script
+	deployment := setupTargetTest(t, &config.DeployToVmConfigRepository{
+		Pm2: config.DeployToVmConfigPm2{ProcessName: "api"},
+	})
+
+	// Act: reload the target
+	err := NewPm2Target(&MockPm2Client{}).Reload(deployment)
+
+	// Assert: check if there was an error
+	assert.Error(t, err)
+}
+
+func TestPm2Target_Health_ExpectsPackageVersion(t *testing.T) {
+	// Arrange: create a release with a package.json
+	mockPm2Client := &MockPm2Client{}
+	deployment := setupTargetTest(t, &config.DeployToVmConfigRepository{
+		Pm2: config.DeployToVmConfigPm2{ProcessName: "api"},
+	})
+	os.WriteFile(path.Join(deployment.RootDir, "package.json"), []byte(`{"name":"api","version":"1.4.0"}`), 0644)
+
+	// Act: check the health of the target
+	err := NewPm2Target(mockPm2Client).Health(deployment)
+
+	// Assert: check if the version of the release is expected
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"wait api 1.4.0"}, mockPm2Client.Calls)
+}
+
+func TestSystemdTarget_Prepare(t *testing.T) {
+	systemdTarget := NewSystemdTarget(&MockSystemdClient{})
+
+	// Act/Assert: check if the unit config is validated
+	assert.Error(t, systemdTarget.Prepare(setupTargetTest(t, &config.DeployToVmConfigRepository{})))
+	assert.Error(t, systemdTarget.Prepare(setupTargetTest(t, &config.DeployToVmConfigRepository{
+		Systemd: config.DeployToVmConfigSystemd{Unit: "api.service", Action: "stop"},
+	})))
+	assert.NoError(t, systemdTarget.Prepare(setupTargetTest(t, &config.DeployToVmConfigRepository{
+		Systemd: config.DeployToVmConfigSystemd{Unit: "api.service", Action: "reload", Timeout: "5s"},
+	})))
+}
+
+func TestDockerComposeTarget_Prepare_MissingComposeFile(t *testing.T) {
+	// Arrange: create a release without a compose file
+	deployment := setupTargetTest(t, &config.DeployToVmConfigRepository{})
+
+	// Act: prepare the deployment
+	err := NewDockerComposeTarget(nil).Prepare(deployment)
+
+	// Assert: check if the missing compose file is reported before activation
+	assert.Error(t, err)
+	_, statErr := os.Stat(path.Join(deployment.SiteDir, "index.html"))
+	assert.True(t, os.IsNotExist(statErr), "Expected the release not to be linked")
+}
+
+func TestDockerComposeTarget_Prepare_InvalidTagVariable(t *testing.T) {
+	// Arrange: create a release with an invalid tag variable
+	deployment := setupTargetTest(t, &config.DeployToVmConfigRepository{})
+	deployment.Target.DockerCompose.TagVariable = "IMAGE-TAG"
+
+	// Act: prepare the deployment
+	err := NewDockerComposeTarget(nil).Prepare(deployment)
+
+	// Assert: check if the tag variable is rejected before activation
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Invalid compose tag variable")
+}
+
+func TestDockerComposeTarget_Prepare_InvalidTimeout(t *testing.T) {
+	// Arrange: create a release with a compose file and an invalid timeout
+	deployment := setupTargetTest(t, &config.DeployToVmConfigRepository{})
+	os.WriteFile(path.Join(deployment.RootDir, "compose.yaml"), []byte("services: {}"), 0644)
+	deployment.Target.DockerCompose.Timeout = "forever"
+
+	// Act: prepare the deployment
+	err := NewDockerComposeTarget(nil).Prepare(deployment)
+
+	// Assert: check if the timeout is rejected before activation
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Invalid docker compose timeout")
+}
+
+// Helper to create a deployment of a release that ships a server block, with
+// an older server block already installed
+func setupNginxVhostTest(t *testing.T) (*Deployment, string) {
+	sitesEnabledDir := t.TempDir()
+	os.WriteFile(path.Join(sitesEnabledDir, "repo.conf"), []byte("old"), 0644)
+	deployment := setupTargetTest(t, &config.DeployToVmConfigRepository{
+		Nginx: config.DeployToVmConfigNginx{VhostFile: "deploy/nginx.conf", SitesEnabledDir: sitesEnabledDir},
+	})
+	os.MkdirAll(path.Join(deployment.ReleaseDir, "deploy"), 0755)
+	os.WriteFile(path.Join(deployment.ReleaseDir, "deploy", "nginx.conf"), []byte("new"), 0644)
+	return deployment, path.Join(sitesEnabledDir, "repo.conf")
+}
+
+func TestNginxTarget_InstallsVhost(t *testing.T) {
+	// Arrange: create a release with a server block
+	deployment, installedFile := setupNginxVhostTest(t)
+	mockNginxClient := &MockNginxClient{}
+	nginxTarget := NewNginxTarget(mockNginxClient)
+
+	// Act: prepare and reload the target
+	prepareErr := nginxTarget.Prepare(deployment)
+	reloadErr := nginxTarget.Reload(deployment)
+
+	// Assert: check if the server block is installed before nginx is reloaded
+	assert.NoError(t, prepareErr)
+	assert.NoError(t, reloadErr)
+	assert.True(t, mockNginxClient.Reloaded)
+	data, _ := os.ReadFile(installedFile)
+	assert.Equal(t, "new", string(data))
+}
+
+func TestNginxTarget_RestoresVhostOnTestError(t *testing.T) {
+	// Arrange: create a release with a broken server block
+	deployment, installedFile := setupNginxVhostTest(t)
+	mockNginxClient := &MockNginxClient{TestErr: errors.New("nginx configuration test failed")}
+
+	// Act: reload the target
+	err := NewNginxTarget(mockNginxClient).Reload(deployment)
+
+	// Assert: check if the previous server block is restored and nginx is not reloaded
+	assert.EqualError(t, err, "nginx configuration test failed")
+	assert.False(t, mockNginxClient.Reloaded)
+	data, _ := os.ReadFile(installedFile)
+	assert.Equal(t, "old", string(data))
+}
+
+func TestNginxTarget_Rollback_PreviousWithoutVhost(t *testing.T) {
+	// Arrange: install the server block of a release whose previous release
+	// does not ship one
+	deployment, installedFile := setupNginxVhostTest(t)
+	previousReleaseDir := t.TempDir()
+	os.WriteFile(path.Join(previousReleaseDir, "index.html"), []byte("previous"), 0644)
+	previous := *deployment
+	previous.Tag = "v0"
+	previous.ReleaseDir = previousReleaseDir
+	previous.RootDir = previousReleaseDir
+	mockNginxClient := &MockNginxClient{}
+	nginxTarget := NewNginxTarget(mockNginxClient)
+	nginxTarget.Activate(deployment)
+	assert.NoError(t, nginxTarget.Reload(deployment))
+	mockNginxClient.Reloaded = false
+
+	// Act: roll the target back to the previous release
+	err := nginxTarget.Rollback(deployment, &previous)
+
+	// Assert: check if the server block from before the deployment is
+	// restored with the site of the previous release
+	assert.NoError(t, err)
+	assert.True(t, mockNginxClient.Reloaded)
+	data, _ := os.ReadFile(installedFile)
+	assert.Equal(t, "old", string(data))
+	siteData, _ := os.ReadFile(path.Join(deployment.SiteDir, "index.html"))
+	assert.Equal(t, "previous", string(siteData))
+}
+
+func TestNginxTarget_Prepare_MissingVhost(t *testing.T) {
+	// Arrange: create a release without the configured server block
+	deployment := setupTargetTest(t, &config.DeployToVmConfigRepository{
+		Nginx: config.DeployToVmConfigNginx{VhostFile: "deploy/nginx.conf"},
+	})
+
+	// Act/Assert: check if the missing server block is reported
+	assert.Error(t, NewNginxTarget(&MockNginxClient{}).Prepare(deployment))
+
+	// Act/Assert: check if server blocks outside of the release are rejected
+	deployment.Target.Nginx.VhostFile = "../nginx.conf"
+	assert.Error(t, NewNginxTarget(&MockNginxClient{}).Prepare(deployment))
+}